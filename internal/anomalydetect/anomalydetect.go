@@ -0,0 +1,37 @@
+// Package anomalydetect flags prediction rows whose anomaly score column
+// exceeds a threshold.
+package anomalydetect
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Alert is the JSON document published for an anomalous prediction.
+type Alert struct {
+	BuoyID       string  `json:"buoy_id"`
+	AnomalyScore float64 `json:"anomaly_score"`
+	TS           string  `json:"ts"`
+	Filename     string  `json:"filename"`
+}
+
+// Check reads field from the CSV row (header and data split on commas) and
+// reports whether its value is strictly greater than threshold. It fails if
+// the column is missing or does not hold a number.
+func Check(header, data []string, field string, threshold float64) (float64, bool, error) {
+	for i, h := range header {
+		if strings.TrimSpace(h) != field {
+			continue
+		}
+		if i >= len(data) {
+			return 0, false, fmt.Errorf("anomalydetect: row has no value for %q", field)
+		}
+		score, err := strconv.ParseFloat(strings.TrimSpace(data[i]), 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("anomalydetect: %q: %w", field, err)
+		}
+		return score, score > threshold, nil
+	}
+	return 0, false, fmt.Errorf("anomalydetect: column %q not found", field)
+}
@@ -0,0 +1,40 @@
+package anomalydetect
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	header := strings.Split("Hs,Tp, anomaly_score", ",")
+	tests := []struct {
+		name      string
+		row       string
+		threshold float64
+		score     float64
+		anomalous bool
+		wantErr   bool
+	}{
+		{"above", "1.5,8,0.91", 0.9, 0.91, true, false},
+		{"at", "1.5,8,0.9", 0.9, 0.9, false, false},
+		{"below", "1.5,8,0.2", 0.9, 0.2, false, false},
+		{"negative threshold", "1.5,8,0", -1, 0, true, false},
+		{"spaces", "1.5,8, 3 ", 2, 3, true, false},
+		{"not a number", "1.5,8,high", 0.9, 0, false, true},
+		{"short row", "1.5,8", 0.9, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, anomalous, err := Check(header, strings.Split(tt.row, ","), "anomaly_score", tt.threshold)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if score != tt.score || anomalous != tt.anomalous {
+				t.Errorf("Check = %v, %v, want %v, %v", score, anomalous, tt.score, tt.anomalous)
+			}
+		})
+	}
+	if _, _, err := Check(header, []string{"1", "2", "3"}, "missing", 0); err == nil {
+		t.Error("missing column accepted")
+	}
+}
@@ -0,0 +1,85 @@
+// Package backoff computes capped exponential delays for retry loops.
+package backoff
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Backoff doubles its delay on every call to Next, starting at Initial and
+// never exceeding Max. Reset returns it to Initial.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	// Jitter shortens each delay by a random fraction of up to Jitter
+	// (0 to 1), so clients that failed together do not retry together.
+	Jitter float64
+
+	mu      sync.Mutex
+	current time.Duration
+}
+
+func New(initial, max time.Duration) *Backoff {
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if max < initial {
+		max = initial
+	}
+	return &Backoff{Initial: initial, Max: max}
+}
+
+// Next returns the delay to wait before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current == 0 {
+		b.current = b.Initial
+	} else {
+		b.current *= 2
+		if b.current > b.Max {
+			b.current = b.Max
+		}
+	}
+	if b.Jitter > 0 {
+		return time.Duration(float64(b.current) * (1 - b.Jitter*rand.Float64()))
+	}
+	return b.current
+}
+
+// Reset makes the following Next return Initial again.
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	b.current = 0
+	b.mu.Unlock()
+}
+
+// Policy holds the settings of a Backoff, as set by flags.
+type Policy struct {
+	Initial time.Duration
+	Max     time.Duration
+	Jitter  float64
+}
+
+// DefaultPolicy is the reconnect policy used where no flags set one.
+var DefaultPolicy = Policy{Initial: time.Second, Max: 30 * time.Second, Jitter: 0.5}
+
+// Validate checks the policy's values.
+func (p Policy) Validate() error {
+	if p.Initial <= 0 || p.Max < p.Initial {
+		return fmt.Errorf("backoff: want 0 < initial (%s) <= max (%s)", p.Initial, p.Max)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("backoff: jitter %g is outside [0, 1]", p.Jitter)
+	}
+	return nil
+}
+
+// New returns a Backoff following p.
+func (p Policy) New() *Backoff {
+	b := New(p.Initial, p.Max)
+	b.Jitter = p.Jitter
+	return b
+}
@@ -0,0 +1,71 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	b := New(time.Second, 10*time.Second)
+	want := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i, w := range want {
+		if got := b.Next(); got != w*time.Second {
+			t.Fatalf("delay %d = %v, want %v", i, got, w*time.Second)
+		}
+	}
+	b.Reset()
+	if got := b.Next(); got != time.Second {
+		t.Errorf("after Reset = %v, want 1s", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name             string
+		initial, max     time.Duration
+		wantInit, wantMx time.Duration
+	}{
+		{"as given", time.Second, time.Minute, time.Second, time.Minute},
+		{"zero initial", 0, time.Minute, time.Second, time.Minute},
+		{"max below initial", 5 * time.Second, time.Second, 5 * time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(tt.initial, tt.max)
+			if b.Initial != tt.wantInit || b.Max != tt.wantMx {
+				t.Errorf("New = %v..%v, want %v..%v", b.Initial, b.Max, tt.wantInit, tt.wantMx)
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	b := Policy{Initial: time.Second, Max: time.Second, Jitter: 0.5}.New()
+	for range 1000 {
+		if d := b.Next(); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("jittered delay %v outside [500ms, 1s]", d)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       Policy
+		wantErr bool
+	}{
+		{"default", DefaultPolicy, false},
+		{"no jitter", Policy{Initial: time.Second, Max: time.Second}, false},
+		{"zero initial", Policy{Max: time.Second}, true},
+		{"max below initial", Policy{Initial: time.Minute, Max: time.Second}, true},
+		{"negative jitter", Policy{Initial: time.Second, Max: time.Second, Jitter: -0.1}, true},
+		{"jitter above 1", Policy{Initial: time.Second, Max: time.Second, Jitter: 1.5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Package batchwriter buffers rows per station and hands them to a write
+// function in batches, either when a station's buffer is full or on a
+// periodic flush.
+package batchwriter
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// WriteFunc persists rows for one station, in order.
+type WriteFunc func(stationID string, rows []string) error
+
+type BatchWriter struct {
+	size  int
+	write WriteFunc
+
+	mu   sync.Mutex
+	bufs map[string][]string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a writer that flushes a station after size rows (size <= 1
+// writes every row immediately) and, if interval > 0, flushes everything
+// on that interval.
+func New(size int, interval time.Duration, write WriteFunc) *BatchWriter {
+	if size < 1 {
+		size = 1
+	}
+	b := &BatchWriter{size: size, write: write, bufs: make(map[string][]string)}
+	if interval > 0 {
+		b.stop = make(chan struct{})
+		b.done = make(chan struct{})
+		go b.loop(interval)
+	}
+	return b
+}
+
+func (b *BatchWriter) loop(interval time.Duration) {
+	defer close(b.done)
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-tk.C:
+			_ = b.Flush()
+		}
+	}
+}
+
+// Add buffers row for stationID, writing the station's batch once full.
+func (b *BatchWriter) Add(stationID, row string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bufs[stationID] = append(b.bufs[stationID], row)
+	if len(b.bufs[stationID]) < b.size {
+		return nil
+	}
+	return b.flushLocked(stationID)
+}
+
+// Flush writes every buffered row.
+func (b *BatchWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var errs []error
+	for id := range b.bufs {
+		if err := b.flushLocked(id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *BatchWriter) flushLocked(stationID string) error {
+	rows := b.bufs[stationID]
+	if len(rows) == 0 {
+		return nil
+	}
+	delete(b.bufs, stationID)
+	return b.write(stationID, rows)
+}
+
+// Close stops the periodic flush and writes whatever is left.
+func (b *BatchWriter) Close() error {
+	if b.stop != nil {
+		close(b.stop)
+		<-b.done
+	}
+	return b.Flush()
+}
@@ -0,0 +1,136 @@
+package batchwriter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type batch struct {
+	station string
+	rows    []string
+}
+
+// recorder collects the batches handed to the write function.
+type recorder struct {
+	mu      sync.Mutex
+	batches []batch
+	err     error
+}
+
+func (r *recorder) write(stationID string, rows []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, batch{stationID, rows})
+	return r.err
+}
+
+func (r *recorder) get() []batch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]batch(nil), r.batches...)
+}
+
+func TestFlushOnSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		rows int
+		want [][]string
+	}{
+		{"unbatched", 1, 2, [][]string{{"r0"}, {"r1"}}},
+		{"zero means unbatched", 0, 1, [][]string{{"r0"}}},
+		{"full batches", 2, 4, [][]string{{"r0", "r1"}, {"r2", "r3"}}},
+		{"partial batch held", 3, 4, [][]string{{"r0", "r1", "r2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r recorder
+			b := New(tt.size, 0, r.write)
+			for i := range tt.rows {
+				if err := b.Add("s1", fmt.Sprint("r", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got [][]string
+			for _, bt := range r.get() {
+				got = append(got, bt.rows)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batches %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlushOnInterval(t *testing.T) {
+	var r recorder
+	b := New(100, 20*time.Millisecond, r.write)
+	defer b.Close()
+	b.Add("s1", "a")
+	b.Add("s1", "b")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.get()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("rows never flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := r.get(); !reflect.DeepEqual(got, []batch{{"s1", []string{"a", "b"}}}) {
+		t.Errorf("batches %v", got)
+	}
+}
+
+func TestStationsNotInterleaved(t *testing.T) {
+	var r recorder
+	b := New(5, 0, r.write)
+	var wg sync.WaitGroup
+	for s := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 23 {
+				b.Add(fmt.Sprint("s", s), fmt.Sprintf("s%d,%d", s, i))
+			}
+		}()
+	}
+	wg.Wait()
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	next := map[string]int{}
+	for _, bt := range r.get() {
+		if len(bt.rows) > 5 {
+			t.Errorf("batch of %d rows", len(bt.rows))
+		}
+		for _, row := range bt.rows {
+			want := fmt.Sprintf("%s,%d", bt.station, next[bt.station])
+			if row != want {
+				t.Fatalf("station %s got row %q, want %q", bt.station, row, want)
+			}
+			next[bt.station]++
+		}
+	}
+	for s := range 4 {
+		if n := next[fmt.Sprint("s", s)]; n != 23 {
+			t.Errorf("station s%d: %d rows written, want 23", s, n)
+		}
+	}
+}
+
+func TestCloseFlushesAndReportsErrors(t *testing.T) {
+	r := recorder{err: errors.New("disk full")}
+	b := New(10, time.Hour, r.write)
+	b.Add("s1", "a")
+	b.Add("s2", "b")
+	if err := b.Close(); err == nil {
+		t.Error("Close hid the write error")
+	}
+	if got := r.get(); len(got) != 2 {
+		t.Errorf("Close wrote %v, want both stations", got)
+	}
+}
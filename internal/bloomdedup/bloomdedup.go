@@ -0,0 +1,74 @@
+// Package bloomdedup is a time-windowed duplicate detector backed by Bloom
+// filters, trading a small false-positive rate for constant memory.
+//
+// Bloom filters cannot forget single entries, so two generations are kept:
+// keys are added to the current one and looked up in both, and Rotate
+// drops the older generation once the current one has been live for a
+// full window. A key is therefore remembered for between one and two
+// windows.
+package bloomdedup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+type Filter struct {
+	expectedItems uint
+	fpRate        float64
+	window        time.Duration
+
+	mu        sync.Mutex
+	cur, prev *bloom.BloomFilter
+	curStart  time.Time
+	curAdded  int
+	prevAdded int
+}
+
+// New sizes each generation for expectedItems keys at fpRate.
+func New(expectedItems uint, fpRate float64, window time.Duration) *Filter {
+	return &Filter{
+		expectedItems: expectedItems,
+		fpRate:        fpRate,
+		window:        window,
+		cur:           bloom.NewWithEstimates(expectedItems, fpRate),
+		prev:          bloom.NewWithEstimates(expectedItems, fpRate),
+		curStart:      time.Now(),
+	}
+}
+
+// Seen reports whether key was (probably) added within the window.
+func (f *Filter) Seen(key []byte) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cur.Test(key) || f.prev.Test(key)
+}
+
+func (f *Filter) Add(key []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cur.Add(key)
+	f.curAdded++
+}
+
+// Rotate starts a new generation if the current one is at least a window
+// old. Call it periodically.
+func (f *Filter) Rotate(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if now.Sub(f.curStart) < f.window {
+		return
+	}
+	f.prev, f.prevAdded = f.cur, f.curAdded
+	f.cur, f.curAdded = bloom.NewWithEstimates(f.expectedItems, f.fpRate), 0
+	f.curStart = now
+}
+
+// Len returns the number of keys added to the live generations.
+func (f *Filter) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.curAdded + f.prevAdded
+}
@@ -0,0 +1,104 @@
+package bloomdedup
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func key(i int) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(i))
+	h := sha256.Sum256(b[:])
+	return h[:]
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// TestMemory compares the filter to the satellite's hash index: a map of
+// SHA-256 keys to LRU list elements.
+func TestMemory(t *testing.T) {
+	const n = 100_000
+	type entry struct {
+		key [32]byte
+		ts  time.Time
+	}
+
+	before := heapAlloc()
+	index := make(map[[32]byte]*list.Element)
+	order := list.New()
+	for i := range n {
+		k := [32]byte(key(i))
+		index[k] = order.PushBack(entry{key: k, ts: time.Now()})
+	}
+	mapBytes := heapAlloc() - before
+	runtime.KeepAlive(index)
+	runtime.KeepAlive(order)
+	index, order = nil, nil
+
+	before = heapAlloc()
+	f := New(n, 0.01, time.Hour)
+	for i := range n {
+		f.Add(key(i))
+	}
+	bloomBytes := heapAlloc() - before
+	runtime.KeepAlive(f)
+
+	t.Logf("map %d KB, bloom %d KB", mapBytes>>10, bloomBytes>>10)
+	if bloomBytes*10 > mapBytes {
+		t.Errorf("bloom filter uses %d bytes, want under a tenth of the map's %d", bloomBytes, mapBytes)
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	const n = 100_000
+	for _, rate := range []float64{0.01, 0.001} {
+		f := New(n, rate, time.Hour)
+		for i := range n {
+			f.Add(key(i))
+		}
+		for i := range n {
+			if !f.Seen(key(i)) {
+				t.Fatalf("rate %v: added key %d not seen", rate, i)
+			}
+		}
+		fp := 0
+		for i := n; i < 2*n; i++ {
+			if f.Seen(key(i)) {
+				fp++
+			}
+		}
+		// the estimate is for a full filter; allow for sampling noise
+		if got := float64(fp) / n; got > rate*1.5 {
+			t.Errorf("false-positive rate %v, declared %v", got, rate)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	f := New(1000, 0.01, time.Minute)
+	start := f.curStart
+	f.Add([]byte("a"))
+
+	f.Rotate(start.Add(30 * time.Second))
+	if !f.Seen([]byte("a")) {
+		t.Fatal("forgotten before the window elapsed")
+	}
+	f.Rotate(start.Add(time.Minute))
+	f.Add([]byte("b"))
+	if !f.Seen([]byte("a")) || f.Len() != 2 {
+		t.Fatalf("after one rotation: seen %v, len %d", f.Seen([]byte("a")), f.Len())
+	}
+	f.Rotate(start.Add(2 * time.Minute))
+	if f.Seen([]byte("a")) || !f.Seen([]byte("b")) || f.Len() != 1 {
+		t.Errorf("after two rotations: a %v, b %v, len %d", f.Seen([]byte("a")), f.Seen([]byte("b")), f.Len())
+	}
+}
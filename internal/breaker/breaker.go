@@ -0,0 +1,125 @@
+// Package breaker is a circuit breaker for calls to a backend that can
+// fail slowly. After Threshold failures in a row it opens and callers fail
+// fast; once Cooldown has passed it lets a single call through as a probe,
+// which closes it again on success or reopens it on failure.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Allow while the breaker is open, or half-open
+// with the probe still running.
+var ErrOpen = errors.New("breaker: circuit open")
+
+// State of a breaker.
+type State int
+
+const (
+	Closed   State = iota // calls go through
+	HalfOpen              // one probe call goes through
+	Open                  // calls fail fast
+)
+
+func (s State) String() string {
+	switch s {
+	case HalfOpen:
+		return "half_open"
+	case Open:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+type Breaker struct {
+	Threshold int           // failures in a row that open the breaker
+	Cooldown  time.Duration // open time before the next probe
+	// OnChange, when set, is called on every state change, with the
+	// breaker's lock held.
+	OnChange func(from, to State)
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow reports whether a call may go through. Every allowed call must be
+// followed by Success, Failure or Cancel; until then a probe keeps the
+// breaker half-open.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return ErrOpen
+		}
+		b.set(HalfOpen)
+		return nil
+	case HalfOpen:
+		return ErrOpen
+	}
+	return nil
+}
+
+// Success records a call that worked and closes the breaker.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.set(Closed)
+}
+
+// Failure records a call that failed. A failed probe, or the Threshold-th
+// failure in a row, opens the breaker.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.Threshold {
+		b.openedAt = time.Now()
+		b.set(Open)
+	}
+}
+
+// Cancel records a call that ended without telling anything about the
+// backend, e.g. because the caller gave up. A canceled probe reopens the
+// breaker with its cooldown already over, so the next call probes.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == HalfOpen {
+		b.openedAt = time.Now().Add(-b.Cooldown)
+		b.set(Open)
+	}
+}
+
+// State returns the current state. An open breaker whose cooldown has
+// passed still reports Open until the next Allow.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) set(s State) {
+	if s == b.state {
+		return
+	}
+	from := b.state
+	b.state = s
+	if b.OnChange != nil {
+		b.OnChange(from, s)
+	}
+}
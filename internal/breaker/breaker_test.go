@@ -0,0 +1,90 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+	type step struct {
+		do    string // allow, deny, success, failure, cancel, wait
+		state State  // after the step
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"opens after threshold", []step{
+			{"allow", Closed}, {"failure", Closed},
+			{"allow", Closed}, {"failure", Open},
+			{"deny", Open},
+		}},
+		{"success resets the count", []step{
+			{"allow", Closed}, {"failure", Closed},
+			{"allow", Closed}, {"success", Closed},
+			{"allow", Closed}, {"failure", Closed},
+		}},
+		{"probe closes", []step{
+			{"allow", Closed}, {"failure", Closed}, {"allow", Closed}, {"failure", Open},
+			{"wait", Open}, {"allow", HalfOpen}, {"deny", HalfOpen},
+			{"success", Closed}, {"allow", Closed},
+		}},
+		{"failed probe reopens", []step{
+			{"allow", Closed}, {"failure", Closed}, {"allow", Closed}, {"failure", Open},
+			{"wait", Open}, {"allow", HalfOpen}, {"failure", Open},
+			{"deny", Open},
+		}},
+		{"canceled probe lets the next call probe", []step{
+			{"allow", Closed}, {"failure", Closed}, {"allow", Closed}, {"failure", Open},
+			{"wait", Open}, {"allow", HalfOpen}, {"cancel", Open},
+			{"allow", HalfOpen}, {"success", Closed},
+		}},
+		{"cancel while closed changes nothing", []step{
+			{"allow", Closed}, {"failure", Closed}, {"allow", Closed}, {"cancel", Closed},
+			{"allow", Closed}, {"failure", Open},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(2, cooldown)
+			for i, s := range tt.steps {
+				switch s.do {
+				case "allow":
+					if err := b.Allow(); err != nil {
+						t.Fatalf("step %d: Allow = %v, want nil", i, err)
+					}
+				case "deny":
+					if err := b.Allow(); err != ErrOpen {
+						t.Fatalf("step %d: Allow = %v, want ErrOpen", i, err)
+					}
+				case "success":
+					b.Success()
+				case "failure":
+					b.Failure()
+				case "cancel":
+					b.Cancel()
+				case "wait":
+					time.Sleep(cooldown + 5*time.Millisecond)
+				}
+				if got := b.State(); got != s.state {
+					t.Fatalf("step %d (%s): state %s, want %s", i, s.do, got, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	b := New(1, time.Hour)
+	var got []string
+	b.OnChange = func(from, to State) { got = append(got, from.String()+">"+to.String()) }
+	b.Allow()
+	b.Failure()
+	b.Failure()
+	b.Success()
+	want := []string{"closed>open", "open>closed"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("changes %v, want %v", got, want)
+	}
+}
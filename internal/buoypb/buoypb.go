@@ -0,0 +1,293 @@
+// Package buoypb encodes buoy observations and prediction results in the
+// binary formats: the protobuf messages in buoy.proto (-format proto) and,
+// for observations, CBOR (-format cbor). Both carry the NPZ bytes as they
+// are, where JSON needs base64 and adds about a third on the uplink.
+package buoypb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"cloudletsapps/internal/predictout"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Message formats (-format).
+const (
+	FormatJSON  = "json"  // JSON payloads with base64 data; CSV text results
+	FormatProto = "proto" // Observation and PredictionResult
+	FormatCBOR  = "cbor"  // observations only
+)
+
+// ValidPayloadFormat reports whether f names a format for observations.
+func ValidPayloadFormat(f string) bool {
+	return f == FormatJSON || f == FormatProto || f == FormatCBOR
+}
+
+// ValidResultFormat reports whether f names a format for prediction
+// results.
+func ValidResultFormat(f string) bool {
+	return f == FormatJSON || f == FormatProto
+}
+
+// IsJSON reports whether a payload is a JSON object rather than
+// protobuf. JSON payloads start with '{', which as a protobuf tag would be
+// a group start (field 15), so no message here can start with it.
+func IsJSON(b []byte) bool {
+	return len(b) > 0 && b[0] == '{'
+}
+
+// Observation is one NPZ file published by a buoy.
+type Observation struct {
+	BuoyID      string
+	Filename    string
+	Data        []byte
+	Compression string
+	SendTime    float64
+	MessageID   string
+	Encryption  string // "" or psk.Algorithm
+	KeyID       string // the pre-shared key Data is sealed with
+}
+
+// Marshal encodes o.
+func (o *Observation) Marshal() []byte {
+	b := make([]byte, 0, len(o.Data)+128)
+	b = appendString(b, 1, o.BuoyID)
+	b = appendString(b, 2, o.Filename)
+	b = appendBytes(b, 3, o.Data)
+	b = appendString(b, 4, o.Compression)
+	b = appendDouble(b, 5, o.SendTime)
+	b = appendString(b, 6, o.MessageID)
+	b = appendString(b, 7, o.Encryption)
+	b = appendString(b, 8, o.KeyID)
+	return b
+}
+
+// Unmarshal decodes b into o. Data aliases b.
+func (o *Observation) Unmarshal(b []byte) error {
+	*o = Observation{}
+	return parse(b, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			o.BuoyID = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			o.Filename = string(v)
+		case num == 3 && typ == protowire.BytesType:
+			o.Data = v
+		case num == 4 && typ == protowire.BytesType:
+			o.Compression = string(v)
+		case num == 5 && typ == protowire.Fixed64Type:
+			o.SendTime = math.Float64frombits(x)
+		case num == 6 && typ == protowire.BytesType:
+			o.MessageID = string(v)
+		case num == 7 && typ == protowire.BytesType:
+			o.Encryption = string(v)
+		case num == 8 && typ == protowire.BytesType:
+			o.KeyID = string(v)
+		}
+		return nil
+	})
+}
+
+// Column is one value of the model output.
+type Column struct {
+	Name  string
+	Value string
+}
+
+// PredictionResult is the satellite's prediction for one observation.
+type PredictionResult struct {
+	BuoyID             string
+	Outputs            []Column
+	LatencyReceptionMs int64
+	LatencyInferenceMs int64
+	SendTime           float64
+	NodeID             string
+	ModelVersion       string
+	ClockOffsetMs      int64
+	HasClockOffset     bool // ClockOffsetMs was measured, even if 0
+}
+
+// NewResult converts a result row, splitting the model's CSV header and
+// data line into columns.
+func NewResult(r predictout.Row) *PredictionResult {
+	names, values := strings.Split(r.Header, ","), strings.Split(r.Data, ",")
+	res := &PredictionResult{
+		BuoyID:             r.BuoyID,
+		LatencyReceptionMs: r.LatencyReception,
+		LatencyInferenceMs: r.LatencyInference,
+		SendTime:           r.SendTime,
+		NodeID:             r.NodeID,
+		ModelVersion:       r.ModelVersion,
+		ClockOffsetMs:      r.ClockOffset,
+		HasClockOffset:     r.HasClockOffset,
+	}
+	for i, name := range names {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		res.Outputs = append(res.Outputs, Column{Name: name, Value: v})
+	}
+	return res
+}
+
+// Row converts r back, so r.Row().Lines() gives the same CSV lines as the
+// text result.
+func (r *PredictionResult) Row() predictout.Row {
+	names := make([]string, len(r.Outputs))
+	values := make([]string, len(r.Outputs))
+	for i, c := range r.Outputs {
+		names[i], values[i] = c.Name, c.Value
+	}
+	return predictout.Row{
+		BuoyID:           r.BuoyID,
+		Header:           strings.Join(names, ","),
+		Data:             strings.Join(values, ","),
+		LatencyReception: r.LatencyReceptionMs,
+		LatencyInference: r.LatencyInferenceMs,
+		SendTime:         r.SendTime,
+		NodeID:           r.NodeID,
+		ModelVersion:     r.ModelVersion,
+		ClockOffset:      r.ClockOffsetMs,
+		HasClockOffset:   r.HasClockOffset,
+	}
+}
+
+// Marshal encodes r.
+func (r *PredictionResult) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, r.BuoyID)
+	for _, c := range r.Outputs {
+		var col []byte
+		col = appendString(col, 1, c.Name)
+		col = appendString(col, 2, c.Value)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, col)
+	}
+	b = appendInt(b, 3, r.LatencyReceptionMs)
+	b = appendInt(b, 4, r.LatencyInferenceMs)
+	b = appendDouble(b, 5, r.SendTime)
+	b = appendString(b, 6, r.NodeID)
+	b = appendString(b, 7, r.ModelVersion)
+	if r.HasClockOffset {
+		// optional field: written even when 0, so presence survives
+		b = protowire.AppendTag(b, 8, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(r.ClockOffsetMs))
+	}
+	return b
+}
+
+// Unmarshal decodes b into r.
+func (r *PredictionResult) Unmarshal(b []byte) error {
+	*r = PredictionResult{}
+	return parse(b, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			r.BuoyID = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			var c Column
+			err := parse(v, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					c.Name = string(v)
+				case num == 2 && typ == protowire.BytesType:
+					c.Value = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("outputs: %w", err)
+			}
+			r.Outputs = append(r.Outputs, c)
+		case num == 3 && typ == protowire.VarintType:
+			r.LatencyReceptionMs = int64(x)
+		case num == 4 && typ == protowire.VarintType:
+			r.LatencyInferenceMs = int64(x)
+		case num == 5 && typ == protowire.Fixed64Type:
+			r.SendTime = math.Float64frombits(x)
+		case num == 6 && typ == protowire.BytesType:
+			r.NodeID = string(v)
+		case num == 7 && typ == protowire.BytesType:
+			r.ModelVersion = string(v)
+		case num == 8 && typ == protowire.VarintType:
+			r.ClockOffsetMs, r.HasClockOffset = int64(x), true
+		}
+		return nil
+	})
+}
+
+var errMalformed = errors.New("buoypb: malformed message")
+
+// parse walks the fields of a message, passing length-delimited values as
+// v and varint/fixed values as x. Unknown fields are skipped.
+func parse(b []byte, field func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			x, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var x32 uint32
+			x32, n = protowire.ConsumeFixed32(b)
+			x = uint64(x32)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		if err := field(num, typ, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// proto3 leaves fields at their zero value off the wire.
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
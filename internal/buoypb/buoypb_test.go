@@ -0,0 +1,142 @@
+package buoypb
+
+import (
+	"reflect"
+	"testing"
+
+	"cloudletsapps/internal/predictout"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestResultRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		r    PredictionResult
+	}{
+		{"empty", PredictionResult{}},
+		{"full", PredictionResult{
+			BuoyID:             "b1",
+			Outputs:            []Column{{"hs", "1.5"}, {"tp", "9"}, {"empty", ""}},
+			LatencyReceptionMs: 1200,
+			LatencyInferenceMs: 3400,
+			SendTime:           1700000000.25,
+			NodeID:             "sat-2",
+			ModelVersion:       "v3",
+			ClockOffsetMs:      -42,
+			HasClockOffset:     true,
+		}},
+		{"zero clock offset", PredictionResult{BuoyID: "b1", HasClockOffset: true}},
+		{"negative latency", PredictionResult{BuoyID: "b1", LatencyReceptionMs: -5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got PredictionResult
+			if err := got.Unmarshal(tt.r.Marshal()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.r) {
+				t.Errorf("got %+v, want %+v", got, tt.r)
+			}
+		})
+	}
+}
+
+func TestResultRow(t *testing.T) {
+	row := predictout.Row{BuoyID: "b1", Header: "hs,tp", Data: "1.5,9", LatencyReception: 10, LatencyInference: 20, SendTime: 5, NodeID: "n", ModelVersion: "v1", ClockOffset: 3, HasClockOffset: true}
+	if got := NewResult(row).Row(); got != row {
+		t.Errorf("Row() = %+v, want %+v", got, row)
+	}
+	// a data line shorter than the header leaves the missing values empty
+	r := NewResult(predictout.Row{Header: "a,b,c", Data: "1"})
+	if want := []Column{{"a", "1"}, {"b", ""}, {"c", ""}}; !reflect.DeepEqual(r.Outputs, want) {
+		t.Errorf("Outputs = %v, want %v", r.Outputs, want)
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	r := PredictionResult{BuoyID: "b1", Outputs: []Column{{"hs", "1.5"}}, NodeID: "n"}
+	b := r.Marshal()
+	// fields a newer writer might add, of every wire type
+	b = protowire.AppendTag(b, 20, protowire.VarintType)
+	b = protowire.AppendVarint(b, 7)
+	b = protowire.AppendTag(b, 21, protowire.BytesType)
+	b = protowire.AppendString(b, "future")
+	b = protowire.AppendTag(b, 22, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, 1)
+	b = protowire.AppendTag(b, 23, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, 1)
+	// a known number with an unexpected wire type is skipped too
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1)
+
+	var got PredictionResult
+	if err := got.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("got %+v, want %+v", got, r)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	full := (&PredictionResult{BuoyID: "b1", Outputs: []Column{{"hs", "1.5"}}, SendTime: 1}).Marshal()
+	badColumn := protowire.AppendTag(nil, 2, protowire.BytesType)
+	badColumn = protowire.AppendBytes(badColumn, []byte{0x0a, 0x05, 'h'})
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"truncated string", full[:3]},
+		{"truncated double", full[:len(full)-2]},
+		{"tag only", []byte{0x0a}},
+		{"length past end", []byte{0x0a, 0x7f, 'b'}},
+		{"unterminated varint", []byte{0x18, 0x80, 0x80}},
+		{"field number 0", []byte{0x00, 0x01}},
+		{"truncated column", badColumn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r PredictionResult
+			if err := r.Unmarshal(tt.b); err == nil {
+				t.Errorf("Unmarshal(%x) = %+v, want error", tt.b, r)
+			}
+			var o Observation
+			if tt.name != "truncated column" && o.Unmarshal(tt.b) == nil {
+				t.Errorf("Observation.Unmarshal(%x) succeeded", tt.b)
+			}
+		})
+	}
+}
+
+func TestObservationRoundTrip(t *testing.T) {
+	o := Observation{BuoyID: "b1", Filename: "a.npz", Data: []byte("PK\x03\x04\x00"), Compression: "zstd", SendTime: 1700000000.5, MessageID: "m1", Encryption: "aes-256-gcm", KeyID: "k1"}
+	var got Observation
+	if err := got.Unmarshal(o.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, o) {
+		t.Errorf("proto: got %+v, want %+v", got, o)
+	}
+
+	b, err := o.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsCBOR(b) || IsJSON(b) {
+		t.Errorf("CBOR payload %x not detected", b[:4])
+	}
+	got = Observation{}
+	if err := got.UnmarshalCBOR(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, o) {
+		t.Errorf("cbor: got %+v, want %+v", got, o)
+	}
+	if IsJSON(o.Marshal()) || IsCBOR(o.Marshal()) {
+		t.Error("protobuf payload taken for JSON or CBOR")
+	}
+	if !IsJSON([]byte(`{"buoy_id":"b1"}`)) {
+		t.Error("JSON payload not detected")
+	}
+}
@@ -0,0 +1,45 @@
+package buoypb
+
+import (
+	"bytes"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborObservation is the CBOR form of an Observation: a map with the keys
+// of the JSON payload, data as a native byte string.
+type cborObservation struct {
+	BuoyID      string  `cbor:"buoy_id"`
+	Filename    string  `cbor:"filename"`
+	Data        []byte  `cbor:"data"`
+	Compression string  `cbor:"compression,omitempty"`
+	SendTime    float64 `cbor:"send_time"`
+	MessageID   string  `cbor:"message_id,omitempty"`
+	Encryption  string  `cbor:"encryption,omitempty"`
+	KeyID       string  `cbor:"key_id,omitempty"`
+}
+
+// cborSelfDescribe is the optional tag 55799 some encoders put in front.
+var cborSelfDescribe = []byte{0xd9, 0xd9, 0xf7}
+
+// IsCBOR reports whether a payload is a CBOR map. Its first byte has major
+// type 5, which no protobuf message here starts with, or it carries the
+// self-describe tag.
+func IsCBOR(b []byte) bool {
+	return len(b) > 0 && (b[0]>>5 == 5 || bytes.HasPrefix(b, cborSelfDescribe))
+}
+
+// MarshalCBOR encodes o as CBOR.
+func (o *Observation) MarshalCBOR() ([]byte, error) {
+	return cbor.Marshal(cborObservation(*o))
+}
+
+// UnmarshalCBOR decodes the CBOR payload b into o.
+func (o *Observation) UnmarshalCBOR(b []byte) error {
+	var c cborObservation
+	if err := cbor.Unmarshal(b, &c); err != nil {
+		return err
+	}
+	*o = Observation(c)
+	return nil
+}
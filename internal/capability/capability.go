@@ -0,0 +1,42 @@
+// Package capability announces which optional features a satellite build
+// supports, as a retained MQTT message other components can read at any time.
+package capability
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Version is stamped at build time:
+//
+//	go build -ldflags "-X cloudletsapps/internal/capability.Version=1.2.3"
+var Version = "dev"
+
+// MaxPayloadBytes is advertised as the largest message the sender accepts.
+var MaxPayloadBytes int64
+
+// Doc is the retained capabilities document.
+type Doc struct {
+	Version         string   `json:"version"`
+	Features        []string `json:"features"`
+	MaxPayloadBytes int64    `json:"max_payload_bytes"`
+}
+
+// Announce publishes the capabilities document to topic as a retained QoS 1 message.
+func Announce(client MQTT.Client, topic string, features []string) error {
+	if features == nil {
+		features = []string{}
+	}
+	body, err := json.Marshal(Doc{Version: Version, Features: features, MaxPayloadBytes: MaxPayloadBytes})
+	if err != nil {
+		return err
+	}
+	token := client.Publish(topic, 1, true, body)
+	if !token.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("capability: publish to %s timed out", topic)
+	}
+	return token.Error()
+}
@@ -0,0 +1,98 @@
+package capability
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// recordingClient captures publishes; the rest of MQTT.Client is unused.
+type recordingClient struct {
+	MQTT.Client
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+	token    *fakeToken
+}
+
+func (c *recordingClient) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	c.topic, c.qos, c.retained, c.payload = topic, qos, retained, payload.([]byte)
+	return c.token
+}
+
+type fakeToken struct {
+	done chan struct{}
+	err  error
+}
+
+func (t *fakeToken) Wait() bool { <-t.done; return true }
+func (t *fakeToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-t.done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+func (t *fakeToken) Done() <-chan struct{} { return t.done }
+func (t *fakeToken) Error() error          { return t.err }
+
+func completed() *fakeToken {
+	t := &fakeToken{done: make(chan struct{})}
+	close(t.done)
+	return t
+}
+
+func TestAnnounce(t *testing.T) {
+	Version, MaxPayloadBytes = "1.2.3", 64<<20
+	t.Cleanup(func() { Version, MaxPayloadBytes = "dev", 0 })
+
+	tests := []struct {
+		name     string
+		features []string
+		want     []string
+	}{
+		{"features", []string{"chunked_upload", "onnx", "grpc_input"}, []string{"chunked_upload", "onnx", "grpc_input"}},
+		{"none", nil, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &recordingClient{token: completed()}
+			if err := Announce(c, "satellite/sat-1/capabilities", tt.features); err != nil {
+				t.Fatal(err)
+			}
+			if c.topic != "satellite/sat-1/capabilities" || c.qos != 1 || !c.retained {
+				t.Errorf("published to %q qos %d retained %v, want a retained QoS 1 message", c.topic, c.qos, c.retained)
+			}
+			var doc map[string]any
+			if err := json.Unmarshal(c.payload, &doc); err != nil {
+				t.Fatalf("payload %s: %v", c.payload, err)
+			}
+			var got []string
+			for _, f := range doc["features"].([]any) {
+				got = append(got, f.(string))
+			}
+			if got == nil {
+				got = []string{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("features = %v, want %v", got, tt.want)
+			}
+			if doc["version"] != "1.2.3" || doc["max_payload_bytes"] != float64(64<<20) {
+				t.Errorf("payload %s", c.payload)
+			}
+		})
+	}
+}
+
+func TestAnnounceErrors(t *testing.T) {
+	failed := completed()
+	failed.err = MQTT.ErrNotConnected
+	if err := Announce(&recordingClient{token: failed}, "t", nil); err != MQTT.ErrNotConnected {
+		t.Errorf("Announce = %v, want the publish error", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Package clocksync estimates how far the local clock is from an NTP
+// server, so latencies computed from timestamps taken on different hosts
+// can be measured against one reference clock instead of two wall clocks
+// that drift apart (and make latencies go negative).
+//
+// Only the SNTP client side of RFC 4330 is implemented: the offset is read
+// from one request/response exchange, keeping the sample with the
+// shortest round trip out of a few.
+package clocksync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds from 1900 (NTP) to 1970 (Unix).
+const ntpEpochOffset = 2208988800
+
+const packetSize = 48
+
+// Probe asks server ("host" or "host:port") for the time once and returns
+// the local clock's offset (add it to local time to get server time) and
+// the round trip of the exchange.
+func Probe(server string, timeout time.Duration) (offset, rtt time.Duration, err error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "123")
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	req := make([]byte, packetSize)
+	req[0] = 0x23 // LI 0, version 4, mode 3 (client)
+	t1 := time.Now()
+	putTime(req[40:], t1)
+	if _, err := conn.Write(req); err != nil {
+		return 0, 0, err
+	}
+	resp := make([]byte, packetSize)
+	n, err := conn.Read(resp)
+	t4 := time.Now()
+	if err != nil {
+		return 0, 0, err
+	}
+	if n < packetSize {
+		return 0, 0, errors.New("clocksync: short NTP response")
+	}
+	if mode := resp[0] & 0x7; mode != 4 {
+		return 0, 0, fmt.Errorf("clocksync: NTP response has mode %d, want 4", mode)
+	}
+	if resp[1] == 0 {
+		return 0, 0, fmt.Errorf("clocksync: server refused the request (kiss code %q)", resp[12:16])
+	}
+	if !getTime(resp[24:]).Equal(getTime(req[40:])) {
+		return 0, 0, errors.New("clocksync: NTP response does not answer our request")
+	}
+	t2, t3 := getTime(resp[32:]), getTime(resp[40:])
+	offset = (t2.Sub(t1) + t3.Sub(t4)) / 2
+	rtt = t4.Sub(t1) - t3.Sub(t2)
+	return offset, rtt, nil
+}
+
+// putTime writes t as a 64-bit NTP timestamp.
+func putTime(b []byte, t time.Time) {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	binary.BigEndian.PutUint64(b, secs<<32|frac)
+}
+
+// getTime reads a 64-bit NTP timestamp.
+func getTime(b []byte) time.Time {
+	v := binary.BigEndian.Uint64(b)
+	secs, frac := int64(v>>32), (v&0xffffffff)*1e9>>32
+	return time.Unix(secs-ntpEpochOffset, int64(frac))
+}
+
+// Clock tracks the offset to Server, re-probing every Interval. Until the
+// first probe succeeds it reports the local clock unchanged.
+type Clock struct {
+	Server   string
+	Interval time.Duration // default 5m
+	Samples  int           // probes per round, default 4
+	Timeout  time.Duration // per probe, default 2s
+
+	offset atomic.Int64 // ns
+	known  atomic.Bool
+}
+
+// Now returns the local time corrected by the estimated offset.
+func (c *Clock) Now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return time.Now().Add(time.Duration(c.offset.Load()))
+}
+
+// Offset returns the estimated offset and whether a probe has succeeded.
+func (c *Clock) Offset() (time.Duration, bool) {
+	if c == nil {
+		return 0, false
+	}
+	return time.Duration(c.offset.Load()), c.known.Load()
+}
+
+// Sync runs one round of probes and keeps the offset of the one with the
+// shortest round trip, the least skewed by network delay.
+func (c *Clock) Sync() (offset, rtt time.Duration, err error) {
+	samples, timeout := c.Samples, c.Timeout
+	if samples <= 0 {
+		samples = 4
+	}
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	var best, bestRTT time.Duration
+	var lastErr error
+	ok := false
+	for i := 0; i < samples; i++ {
+		offset, rtt, err := Probe(c.Server, timeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !ok || rtt < bestRTT {
+			best, bestRTT, ok = offset, rtt, true
+		}
+	}
+	if !ok {
+		return 0, 0, lastErr
+	}
+	c.offset.Store(int64(best))
+	c.known.Store(true)
+	return best, bestRTT, nil
+}
+
+// Start syncs once before returning, so the first timestamps are already
+// corrected, then again every Interval in the background. The outcome of
+// each round goes to report; failed rounds keep the previous offset.
+func (c *Clock) Start(report func(offset, rtt time.Duration, err error)) {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	report(c.Sync())
+	go func() {
+		for range time.Tick(interval) {
+			report(c.Sync())
+		}
+	}()
+}
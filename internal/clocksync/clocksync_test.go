@@ -0,0 +1,182 @@
+package clocksync
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNTP answers SNTP requests with a clock skew ahead of the local
+// one. Before stamping its receive time it waits the next of delays, as
+// if the request had spent that long on the way; reply may rewrite the
+// response.
+type fakeNTP struct {
+	skew   time.Duration
+	hold   time.Duration // between receive and transmit time
+	reply  func(resp []byte) []byte
+	mu     sync.Mutex
+	delays []time.Duration
+}
+
+func (f *fakeNTP) start(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n != packetSize || buf[0]&0x7 != 3 {
+				continue
+			}
+			f.mu.Lock()
+			if len(f.delays) > 0 {
+				time.Sleep(f.delays[0])
+				f.delays = f.delays[1:]
+			}
+			reply := f.reply
+			f.mu.Unlock()
+			resp := make([]byte, packetSize)
+			resp[0] = 0x24 // version 4, mode 4 (server)
+			resp[1] = 2    // stratum
+			copy(resp[24:32], buf[40:48])
+			putTime(resp[32:], time.Now().Add(f.skew))
+			time.Sleep(f.hold)
+			putTime(resp[40:], time.Now().Add(f.skew))
+			if reply != nil {
+				resp = reply(resp)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func near(d, want, tolerance time.Duration) bool {
+	return d >= want-tolerance && d <= want+tolerance
+}
+
+func TestProbe(t *testing.T) {
+	for _, skew := range []time.Duration{0, time.Hour, -3 * time.Second, 250 * time.Millisecond} {
+		f := &fakeNTP{skew: skew, hold: 30 * time.Millisecond}
+		offset, rtt, err := Probe(f.start(t), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !near(offset, skew, 10*time.Millisecond) {
+			t.Errorf("skew %v: offset %v", skew, offset)
+		}
+		// the server's processing time is not part of the round trip
+		if rtt < 0 || rtt >= f.hold {
+			t.Errorf("skew %v: rtt %v, want under the %v hold", skew, rtt, f.hold)
+		}
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply func([]byte) []byte
+		want  string
+	}{
+		{"kiss of death", func(b []byte) []byte { b[1] = 0; copy(b[12:], "RATE"); return b }, "RATE"},
+		{"wrong mode", func(b []byte) []byte { b[0] = 0x23; return b }, "mode 3"},
+		{"other request", func(b []byte) []byte { b[31]++; return b }, "does not answer"},
+		{"short", func(b []byte) []byte { return b[:40] }, "short"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeNTP{reply: tt.reply}
+			if _, _, err := Probe(f.start(t), time.Second); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Probe = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err := Probe(conn.LocalAddr().String(), 50*time.Millisecond); err == nil {
+		t.Error("Probe of a silent server succeeded")
+	}
+}
+
+// TestSyncShortestRoundTrip delays the requests of all but one sample on
+// the way in, which skews their offsets by half the delay; Sync must keep
+// the undelayed one.
+func TestSyncShortestRoundTrip(t *testing.T) {
+	skew := 2 * time.Second
+	f := &fakeNTP{skew: skew, delays: []time.Duration{200 * time.Millisecond, 120 * time.Millisecond, 0, 80 * time.Millisecond}}
+	c := &Clock{Server: f.start(t), Samples: 4}
+	if _, ok := c.Offset(); ok {
+		t.Error("offset known before Sync")
+	}
+	offset, rtt, err := c.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(offset, skew, 20*time.Millisecond) || rtt > 40*time.Millisecond {
+		t.Errorf("Sync = offset %v, rtt %v; want the undelayed sample", offset, rtt)
+	}
+	if got, ok := c.Offset(); !ok || got != offset {
+		t.Errorf("Offset() = %v, %v", got, ok)
+	}
+	if d := c.Now().Sub(time.Now()); !near(d, skew, 20*time.Millisecond) {
+		t.Errorf("Now() is %v ahead, want %v", d, skew)
+	}
+}
+
+func TestSyncFailureKeepsOffset(t *testing.T) {
+	f := &fakeNTP{skew: time.Minute}
+	c := &Clock{Server: f.start(t), Samples: 1, Timeout: 50 * time.Millisecond}
+	if _, _, err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	f.reply = func(b []byte) []byte { b[1] = 0; return b }
+	f.mu.Unlock()
+	if _, _, err := c.Sync(); err == nil {
+		t.Fatal("Sync succeeded against a refusing server")
+	}
+	if got, ok := c.Offset(); !ok || !near(got, time.Minute, 10*time.Millisecond) {
+		t.Errorf("Offset() = %v, %v after a failed round", got, ok)
+	}
+
+	var nilClock *Clock
+	if _, ok := nilClock.Offset(); ok {
+		t.Error("nil clock has an offset")
+	}
+	if d := time.Since(nilClock.Now()); d < 0 || d > time.Second {
+		t.Errorf("nil clock Now() off by %v", d)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	for _, ts := range []time.Time{
+		time.Unix(0, 0),
+		time.Date(2024, 5, 1, 12, 30, 15, 123456789, time.UTC),
+		time.Date(2035, 12, 31, 23, 59, 59, 999999999, time.UTC),
+	} {
+		b := make([]byte, 8)
+		putTime(b, ts)
+		if got := getTime(b); !near(got.Sub(ts), 0, time.Nanosecond) {
+			t.Errorf("%v came back as %v", ts, got)
+		}
+	}
+	// the NTP epoch is 1900
+	b := make([]byte, 8)
+	putTime(b, time.Unix(0, 0))
+	if secs := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]); secs != ntpEpochOffset {
+		t.Errorf("Unix epoch is NTP second %d", secs)
+	}
+}
@@ -0,0 +1,179 @@
+package coap
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transmission parameters (RFC 7252, 4.8).
+const (
+	ackRandom      = 1.5
+	maxRetransmit  = 4
+	defaultBlockSz = 1024
+)
+
+// ackTimeout is the wait before the first retransmission; tests shorten it.
+var ackTimeout = 2 * time.Second
+
+// ErrTimeout is returned when the server did not answer in time.
+var ErrTimeout = errors.New("coap: no response")
+
+// ResponseError is a non-2.xx response.
+type ResponseError struct {
+	Code    Code
+	Message string // diagnostic payload, if any
+}
+
+func (e *ResponseError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("coap: %s %s", e.Code, e.Message)
+	}
+	return "coap: " + e.Code.String()
+}
+
+// Client sends requests to one server. Requests are serialized.
+type Client struct {
+	// BlockSize is the Block1 size for large bodies: a power of two from
+	// 16 to 1024.
+	BlockSize int
+
+	mu   sync.Mutex
+	conn *net.UDPConn
+	mid  uint16
+}
+
+// Dial returns a client for the server at addr (host:port).
+func Dial(addr string) (*Client, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{BlockSize: defaultBlockSz, conn: conn, mid: uint16(rand.N(1 << 16))}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Post sends payload to path as a confirmable POST, block-wise when it
+// does not fit in one block, and returns the final response code. timeout
+// bounds the whole transfer.
+func (c *Client) Post(path string, format int, payload []byte, timeout time.Duration) (Code, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline := time.Now().Add(timeout)
+	szx := uint32(6)
+	for szx > 0 && 1<<(szx+4) > c.BlockSize {
+		szx--
+	}
+	size := 1 << (szx + 4)
+	token := newToken()
+	for num := 0; ; num++ {
+		req := &message{
+			typ:     Confirmable,
+			code:    POST,
+			token:   token,
+			options: append(pathOptions(path), uintOption(optContentFormat, uint32(format))),
+		}
+		body := payload
+		multi := len(payload) > size
+		if multi {
+			start := num * size
+			end := min(start+size, len(payload))
+			body = payload[start:end]
+			req.options = append(req.options, block{num: uint32(num), more: end < len(payload), szx: szx}.option(optBlock1))
+			if num == 0 {
+				req.options = append(req.options, uintOption(optSize1, uint32(len(payload))))
+			}
+		}
+		req.payload = body
+		resp, err := c.exchange(req, deadline)
+		if err != nil {
+			return 0, err
+		}
+		if !resp.code.Success() {
+			return resp.code, &ResponseError{Code: resp.code, Message: string(resp.payload)}
+		}
+		if !multi || (num+1)*size >= len(payload) {
+			return resp.code, nil
+		}
+		if resp.code != Continue {
+			return resp.code, fmt.Errorf("coap: expected 2.31 Continue for block %d, got %s", num, resp.code)
+		}
+		// the server may ask for smaller blocks
+		if v, ok := resp.option(optBlock1); ok {
+			if b, ok := parseBlock(v); ok && b.szx < szx {
+				num = (num+1)*size/b.size() - 1
+				szx, size = b.szx, b.size()
+			}
+		}
+	}
+}
+
+// exchange sends a confirmable request and returns the response, either
+// piggybacked on the ACK or sent separately after an empty ACK.
+func (c *Client) exchange(req *message, deadline time.Time) (*message, error) {
+	c.mid++
+	req.id = c.mid
+	out := req.marshal()
+	wait := time.Duration(float64(ackTimeout) * (1 + rand.Float64()*(ackRandom-1)))
+	acked := false
+	buf := make([]byte, 64*1024)
+	for attempt := 0; ; {
+		if !acked {
+			if _, err := c.conn.Write(out); err != nil {
+				return nil, err
+			}
+		}
+		retry := time.Now().Add(wait)
+		if acked || retry.After(deadline) {
+			retry = deadline
+		}
+		c.conn.SetReadDeadline(retry)
+		for {
+			n, err := c.conn.Read(buf)
+			if err != nil {
+				var ne net.Error
+				if !errors.As(err, &ne) || !ne.Timeout() {
+					return nil, err
+				}
+				break
+			}
+			m, err := unmarshal(buf[:n])
+			if err != nil {
+				continue
+			}
+			switch {
+			case m.typ == Reset && m.id == req.id:
+				return nil, errors.New("coap: request reset by server")
+			case m.typ == Acknowledgement && m.id == req.id && m.code == Empty:
+				// separate response follows
+				acked = true
+				c.conn.SetReadDeadline(deadline)
+			case m.typ == Acknowledgement && m.id == req.id:
+				return m, nil
+			case acked && string(m.token) == string(req.token) && m.code != Empty:
+				if m.typ == Confirmable {
+					ack := &message{typ: Acknowledgement, id: m.id}
+					c.conn.Write(ack.marshal())
+				}
+				return m, nil
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return nil, ErrTimeout
+		}
+		if attempt++; attempt > maxRetransmit {
+			return nil, ErrTimeout
+		}
+		wait *= 2
+	}
+}
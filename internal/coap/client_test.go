@@ -0,0 +1,164 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeServer hands every request it receives to reply, which returns the
+// datagrams to send back.
+func fakeServer(t *testing.T, reply func(req *message) []*message) (addr string, received func() []*message) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	reqs := make(chan *message, 100)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := unmarshal(buf[:n])
+			if err != nil {
+				continue
+			}
+			reqs <- req
+			for _, m := range reply(req) {
+				conn.WriteTo(m.marshal(), from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), func() []*message {
+		var all []*message
+		for {
+			select {
+			case m := <-reqs:
+				all = append(all, m)
+			default:
+				return all
+			}
+		}
+	}
+}
+
+func shortAckTimeout(t *testing.T) {
+	old := ackTimeout
+	ackTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { ackTimeout = old })
+}
+
+func dial(t *testing.T, addr string) *Client {
+	t.Helper()
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestRetransmit(t *testing.T) {
+	shortAckTimeout(t)
+	attempts := 0
+	addr, received := fakeServer(t, func(req *message) []*message {
+		if attempts++; attempts < 3 {
+			return nil // lost
+		}
+		return []*message{{typ: Acknowledgement, code: Changed, id: req.id, token: req.token}}
+	})
+	code, err := dial(t, addr).Post("up", FormatJSON, []byte("{}"), 5*time.Second)
+	if err != nil || code != Changed {
+		t.Fatalf("Post = %s, %v", code, err)
+	}
+	reqs := received()
+	if len(reqs) != 3 {
+		t.Fatalf("%d transmissions, want 3", len(reqs))
+	}
+	for _, r := range reqs {
+		if r.typ != Confirmable || r.id != reqs[0].id || !bytes.Equal(r.token, reqs[0].token) {
+			t.Errorf("retransmission %+v differs from %+v", r, reqs[0])
+		}
+	}
+}
+
+func TestRetransmitGivesUp(t *testing.T) {
+	shortAckTimeout(t)
+	addr, received := fakeServer(t, func(*message) []*message { return nil })
+	_, err := dial(t, addr).Post("up", FormatJSON, []byte("{}"), 10*time.Second)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Post = %v, want ErrTimeout", err)
+	}
+	if n := len(received()); n != 1+maxRetransmit {
+		t.Errorf("%d transmissions, want %d", n, 1+maxRetransmit)
+	}
+}
+
+func TestOverallTimeout(t *testing.T) {
+	addr, _ := fakeServer(t, func(*message) []*message { return nil })
+	start := time.Now()
+	_, err := dial(t, addr).Post("up", FormatJSON, []byte("{}"), 100*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Post = %v, want ErrTimeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("gave up after %v", d)
+	}
+}
+
+// TestMatching answers with stray messages before the real response: an
+// ACK for another message ID, then an empty ACK and a separate response
+// with another token, then the separate response itself.
+func TestMatching(t *testing.T) {
+	acks := make(chan *message, 1)
+	addr, _ := fakeServer(t, func(req *message) []*message {
+		if req.typ == Acknowledgement {
+			acks <- req
+			return nil
+		}
+		return []*message{
+			{typ: Acknowledgement, code: BadRequest, id: req.id + 1, token: req.token},
+			{typ: Acknowledgement, code: Empty, id: req.id},
+			{typ: Confirmable, code: BadRequest, id: 900, token: []byte("other")},
+			{typ: Confirmable, code: Created, id: 901, token: req.token},
+		}
+	})
+	code, err := dial(t, addr).Post("up", FormatJSON, []byte("{}"), 5*time.Second)
+	if err != nil || code != Created {
+		t.Fatalf("Post = %s, %v; want 2.01 from the separate response", code, err)
+	}
+	select {
+	case ack := <-acks:
+		if ack.id != 901 || ack.code != Empty {
+			t.Errorf("separate response acknowledged with %+v", ack)
+		}
+	case <-time.After(time.Second):
+		t.Error("separate response not acknowledged")
+	}
+}
+
+func TestReset(t *testing.T) {
+	addr, _ := fakeServer(t, func(req *message) []*message {
+		return []*message{{typ: Reset, id: req.id}}
+	})
+	if _, err := dial(t, addr).Post("up", FormatJSON, []byte("{}"), time.Second); err == nil {
+		t.Error("Post succeeded after a reset")
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	addr, _ := fakeServer(t, func(req *message) []*message {
+		return []*message{{typ: Acknowledgement, code: ServiceUnavailable, id: req.id, token: req.token, payload: []byte("queue full")}}
+	})
+	code, err := dial(t, addr).Post("up", FormatJSON, []byte("{}"), time.Second)
+	var re *ResponseError
+	if !errors.As(err, &re) || code != ServiceUnavailable || re.Error() != "coap: 5.03 queue full" {
+		t.Errorf("Post = %s, %v", code, err)
+	}
+}
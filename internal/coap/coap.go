@@ -0,0 +1,249 @@
+// Package coap is a small CoAP (RFC 7252) client and server over UDP,
+// covering what the buoy uplink needs: confirmable POSTs with
+// retransmission, piggybacked responses and block-wise transfer of large
+// request bodies (Block1, RFC 7959). There is no observe, no proxying and
+// no DTLS.
+package coap
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Message types.
+const (
+	Confirmable     = 0
+	NonConfirmable  = 1
+	Acknowledgement = 2
+	Reset           = 3
+)
+
+// Code is a request method or response code, class<<5 | detail.
+type Code byte
+
+const (
+	Empty                   Code = 0
+	POST                    Code = 2
+	Created                 Code = 2<<5 | 1
+	Changed                 Code = 2<<5 | 4
+	Continue                Code = 2<<5 | 31
+	BadRequest              Code = 4<<5 | 0
+	NotFound                Code = 4<<5 | 4
+	MethodNotAllowed        Code = 4<<5 | 5
+	RequestEntityIncomplete Code = 4<<5 | 8
+	RequestEntityTooLarge   Code = 4<<5 | 13
+	InternalServerError     Code = 5<<5 | 0
+	ServiceUnavailable      Code = 5<<5 | 3
+)
+
+func (c Code) String() string {
+	return fmt.Sprintf("%d.%02d", c>>5, c&31)
+}
+
+// Success reports whether c is a 2.xx response.
+func (c Code) Success() bool { return c>>5 == 2 }
+
+// Option numbers.
+const (
+	optURIPath       = 11
+	optContentFormat = 12
+	optBlock1        = 27
+	optSize1         = 60
+)
+
+// Content formats.
+const (
+	FormatOctetStream = 42
+	FormatJSON        = 50
+	FormatCBOR        = 60
+)
+
+type option struct {
+	num   int
+	value []byte
+}
+
+type message struct {
+	typ     byte
+	code    Code
+	id      uint16
+	token   []byte
+	options []option
+	payload []byte
+}
+
+var errMalformed = errors.New("coap: malformed message")
+
+func (m *message) marshal() []byte {
+	b := []byte{1<<6 | m.typ<<4 | byte(len(m.token)), byte(m.code), 0, 0}
+	binary.BigEndian.PutUint16(b[2:], m.id)
+	b = append(b, m.token...)
+	sort.SliceStable(m.options, func(i, j int) bool { return m.options[i].num < m.options[j].num })
+	prev := 0
+	for _, o := range m.options {
+		delta, length := o.num-prev, len(o.value)
+		prev = o.num
+		dn, dext := optionNibble(delta)
+		ln, lext := optionNibble(length)
+		b = append(b, dn<<4|ln)
+		b = append(b, dext...)
+		b = append(b, lext...)
+		b = append(b, o.value...)
+	}
+	if len(m.payload) > 0 {
+		b = append(b, 0xFF)
+		b = append(b, m.payload...)
+	}
+	return b
+}
+
+func optionNibble(v int) (byte, []byte) {
+	switch {
+	case v < 13:
+		return byte(v), nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		return 14, binary.BigEndian.AppendUint16(nil, uint16(v-269))
+	}
+}
+
+func unmarshal(b []byte) (*message, error) {
+	if len(b) < 4 || b[0]>>6 != 1 {
+		return nil, errMalformed
+	}
+	tkl := int(b[0] & 0x0F)
+	if tkl > 8 || len(b) < 4+tkl {
+		return nil, errMalformed
+	}
+	m := &message{
+		typ:   b[0] >> 4 & 3,
+		code:  Code(b[1]),
+		id:    binary.BigEndian.Uint16(b[2:]),
+		token: append([]byte(nil), b[4:4+tkl]...),
+	}
+	b = b[4+tkl:]
+	num := 0
+	for len(b) > 0 {
+		if b[0] == 0xFF {
+			if len(b) == 1 {
+				return nil, errMalformed
+			}
+			m.payload = append([]byte(nil), b[1:]...)
+			break
+		}
+		dn, ln := int(b[0]>>4), int(b[0]&0x0F)
+		b = b[1:]
+		var delta, length int
+		var ok bool
+		if delta, b, ok = optionValue(dn, b); !ok {
+			return nil, errMalformed
+		}
+		if length, b, ok = optionValue(ln, b); !ok || len(b) < length {
+			return nil, errMalformed
+		}
+		num += delta
+		m.options = append(m.options, option{num: num, value: b[:length]})
+		b = b[length:]
+	}
+	return m, nil
+}
+
+func optionValue(nibble int, b []byte) (int, []byte, bool) {
+	switch nibble {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, false
+		}
+		return int(b[0]) + 13, b[1:], true
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, false
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], true
+	case 15:
+		return 0, nil, false
+	}
+	return nibble, b, true
+}
+
+func (m *message) option(num int) ([]byte, bool) {
+	for _, o := range m.options {
+		if o.num == num {
+			return o.value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *message) path() string {
+	var segs []string
+	for _, o := range m.options {
+		if o.num == optURIPath {
+			segs = append(segs, string(o.value))
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+func pathOptions(path string) []option {
+	var opts []option
+	for _, seg := range strings.Split(strings.Trim(path, "/"), "/") {
+		if seg != "" {
+			opts = append(opts, option{num: optURIPath, value: []byte(seg)})
+		}
+	}
+	return opts
+}
+
+func uintOption(num int, v uint32) option {
+	b := binary.BigEndian.AppendUint32(nil, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return option{num: num, value: b}
+}
+
+func decodeUint(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+// block is a Block1/Block2 option value.
+type block struct {
+	num  uint32
+	more bool
+	szx  uint32 // size is 1 << (szx+4)
+}
+
+func (b block) size() int { return 1 << (b.szx + 4) }
+
+func (b block) option(num int) option {
+	v := b.num<<4 | b.szx
+	if b.more {
+		v |= 8
+	}
+	return uintOption(num, v)
+}
+
+func parseBlock(v []byte) (block, bool) {
+	if len(v) > 3 {
+		return block{}, false
+	}
+	x := decodeUint(v)
+	b := block{num: x >> 4, more: x&8 != 0, szx: x & 7}
+	return b, b.szx < 7
+}
+
+func newToken() []byte {
+	t := make([]byte, 4)
+	rand.Read(t)
+	return t
+}
@@ -0,0 +1,124 @@
+package coap
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	m := &message{
+		typ:     Confirmable,
+		code:    POST,
+		id:      0x1234,
+		token:   []byte{0xAA, 0xBB},
+		options: []option{uintOption(optContentFormat, FormatJSON), {num: optURIPath, value: []byte("up")}},
+		payload: []byte("x"),
+	}
+	want := []byte{
+		0x42, 0x02, 0x12, 0x34, // version 1, CON, token length 2; POST; message ID
+		0xAA, 0xBB,
+		0xB2, 'u', 'p', // Uri-Path: delta 11, length 2
+		0x11, 50, // Content-Format: delta 1, length 1
+		0xFF, 'x',
+	}
+	if got := m.marshal(); !bytes.Equal(got, want) {
+		t.Errorf("marshal = % x\nwant      % x", got, want)
+	}
+}
+
+func TestOptionExtensions(t *testing.T) {
+	tests := []struct {
+		v      int
+		nibble byte
+		ext    []byte
+	}{
+		{0, 0, nil},
+		{12, 12, nil},
+		{13, 13, []byte{0}},
+		{268, 13, []byte{255}},
+		{269, 14, []byte{0, 0}},
+		{1000, 14, []byte{0x02, 0xDB}},
+	}
+	for _, tt := range tests {
+		n, ext := optionNibble(tt.v)
+		if n != tt.nibble || !bytes.Equal(ext, tt.ext) {
+			t.Errorf("optionNibble(%d) = %d % x, want %d % x", tt.v, n, ext, tt.nibble, tt.ext)
+		}
+		v, rest, ok := optionValue(int(n), append(ext, 0x77))
+		if !ok || v != tt.v || !bytes.Equal(rest, []byte{0x77}) {
+			t.Errorf("optionValue of %d = %d, % x, %v", tt.v, v, rest, ok)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	m := &message{
+		typ:   NonConfirmable,
+		code:  Changed,
+		id:    7,
+		token: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		options: []option{
+			{num: optURIPath, value: []byte("sensors")},
+			{num: optURIPath, value: []byte(strings.Repeat("b", 20))}, // 1-byte length extension
+			{num: optBlock1, value: []byte{0x16}},                     // delta 16: 1-byte delta extension
+			{num: optSize1, value: bytes.Repeat([]byte{9}, 300)},      // 2-byte length extension
+			{num: 2000, value: []byte{}},                              // 2-byte delta extension
+		},
+		payload: []byte("payload"),
+	}
+	got, err := unmarshal(m.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("got %+v\nwant %+v", got, m)
+	}
+	if p := got.path(); p != "sensors/"+strings.Repeat("b", 20) {
+		t.Errorf("path = %q", p)
+	}
+}
+
+func TestUnmarshalMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"short header", []byte{0x40, 0x02, 0x00}},
+		{"version 2", []byte{0x80, 0x02, 0x00, 0x01}},
+		{"token length 9", []byte{0x49, 0x02, 0x00, 0x01, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"token past end", []byte{0x44, 0x02, 0x00, 0x01, 1, 2}},
+		{"marker without payload", []byte{0x40, 0x02, 0x00, 0x01, 0xFF}},
+		{"reserved delta nibble", []byte{0x40, 0x02, 0x00, 0x01, 0xF0}},
+		{"missing delta extension", []byte{0x40, 0x02, 0x00, 0x01, 0xD0}},
+		{"missing length extension", []byte{0x40, 0x02, 0x00, 0x01, 0xBE, 0x00}},
+		{"value past end", []byte{0x40, 0x02, 0x00, 0x01, 0xB3, 'a'}},
+	}
+	for _, tt := range tests {
+		if m, err := unmarshal(tt.b); err == nil {
+			t.Errorf("%s: unmarshal = %+v, want error", tt.name, m)
+		}
+	}
+}
+
+func TestBlockOption(t *testing.T) {
+	for _, b := range []block{{0, true, 6}, {1, false, 0}, {300, true, 2}, {1 << 19, false, 6}} {
+		got, ok := parseBlock(b.option(optBlock1).value)
+		if !ok || got != b {
+			t.Errorf("parseBlock(%+v) = %+v, %v", b, got, ok)
+		}
+	}
+	if b := (block{szx: 6}); b.size() != 1024 {
+		t.Errorf("szx 6 size %d", b.size())
+	}
+	if _, ok := parseBlock([]byte{0x07}); ok {
+		t.Error("szx 7 accepted")
+	}
+	if _, ok := parseBlock([]byte{1, 2, 3, 4}); ok {
+		t.Error("4-byte block option accepted")
+	}
+	if o := uintOption(optContentFormat, 0); len(o.value) != 0 {
+		t.Errorf("uintOption(0) = % x, want empty", o.value)
+	}
+}
@@ -0,0 +1,154 @@
+package coap
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Handler receives a complete request body sent to path and returns the
+// response code.
+type Handler func(path string, payload []byte) Code
+
+// exchangeLifetime is how long responses are remembered to answer
+// retransmitted requests (RFC 7252 uses 247 s).
+const exchangeLifetime = 247 * time.Second
+
+// Server answers POSTs, reassembling block-wise bodies before calling
+// Handler. Requests are handled one at a time, so a retransmission that
+// arrives while its original is being handled is answered from the cache.
+type Server struct {
+	Handler     Handler
+	MaxBodySize int // largest reassembled body; 0 means 16 MiB
+
+	transfers map[string]*transfer // remote address + path -> body so far
+	responses map[string]cached    // remote address + message ID -> response
+	lastSweep time.Time
+}
+
+type transfer struct {
+	body    bytes.Buffer
+	updated time.Time
+}
+
+type cached struct {
+	resp []byte
+	at   time.Time
+}
+
+// ListenAndServe serves CoAP on the UDP address addr.
+func (s *Server) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return s.Serve(conn)
+}
+
+// Serve answers requests on conn until reading from it fails.
+func (s *Server) Serve(conn net.PacketConn) error {
+	if s.MaxBodySize <= 0 {
+		s.MaxBodySize = 16 << 20
+	}
+	s.transfers = make(map[string]*transfer)
+	s.responses = make(map[string]cached)
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		s.sweep(now)
+		req, err := unmarshal(buf[:n])
+		if err != nil || req.typ == Acknowledgement || req.typ == Reset {
+			continue
+		}
+		if req.code == Empty {
+			// CoAP ping
+			if req.typ == Confirmable {
+				conn.WriteTo((&message{typ: Reset, id: req.id}).marshal(), addr)
+			}
+			continue
+		}
+		key := addr.String() + "#" + strconv.Itoa(int(req.id))
+		if c, ok := s.responses[key]; ok {
+			conn.WriteTo(c.resp, addr)
+			continue
+		}
+		resp := s.handle(addr.String(), req, now)
+		if req.typ == Confirmable {
+			resp.typ, resp.id = Acknowledgement, req.id
+		} else {
+			resp.typ, resp.id = NonConfirmable, req.id
+		}
+		out := resp.marshal()
+		s.responses[key] = cached{resp: out, at: now}
+		conn.WriteTo(out, addr)
+	}
+}
+
+func (s *Server) handle(remote string, req *message, now time.Time) *message {
+	resp := &message{token: req.token}
+	if req.code != POST {
+		resp.code = MethodNotAllowed
+		return resp
+	}
+	path := req.path()
+	v, blockwise := req.option(optBlock1)
+	if !blockwise {
+		resp.code = s.Handler(path, req.payload)
+		return resp
+	}
+	b, ok := parseBlock(v)
+	if !ok {
+		resp.code = BadRequest
+		return resp
+	}
+	resp.options = []option{b.option(optBlock1)}
+	tkey := remote + " " + path
+	t := s.transfers[tkey]
+	if b.num == 0 {
+		t = &transfer{}
+		s.transfers[tkey] = t
+	}
+	if t == nil || t.body.Len() != int(b.num)*b.size() {
+		delete(s.transfers, tkey)
+		resp.code = RequestEntityIncomplete
+		return resp
+	}
+	if t.body.Len()+len(req.payload) > s.MaxBodySize {
+		delete(s.transfers, tkey)
+		resp.code = RequestEntityTooLarge
+		return resp
+	}
+	t.body.Write(req.payload)
+	t.updated = now
+	if b.more {
+		resp.code = Continue
+		return resp
+	}
+	delete(s.transfers, tkey)
+	resp.code = s.Handler(path, t.body.Bytes())
+	return resp
+}
+
+// sweep forgets stale transfers and cached responses, at most every 10s.
+func (s *Server) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < 10*time.Second {
+		return
+	}
+	s.lastSweep = now
+	for k, t := range s.transfers {
+		if now.Sub(t.updated) > exchangeLifetime {
+			delete(s.transfers, k)
+		}
+	}
+	for k, c := range s.responses {
+		if now.Sub(c.at) > exchangeLifetime {
+			delete(s.responses, k)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package coap
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+type received struct {
+	path string
+	body []byte
+}
+
+func startServer(t *testing.T, code Code) (addr string, got chan received) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = make(chan received, 10)
+	s := &Server{MaxBodySize: 4096, Handler: func(path string, body []byte) Code {
+		got <- received{path, append([]byte(nil), body...)}
+		return code
+	}}
+	go s.Serve(conn)
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String(), got
+}
+
+func TestBlockwisePost(t *testing.T) {
+	addr, got := startServer(t, Changed)
+	c := dial(t, addr)
+	c.BlockSize = 64
+	body := bytes.Repeat([]byte("0123456789"), 30) // 5 blocks of 64
+	code, err := c.Post("/sensors/b1/npz", FormatOctetStream, body, 5*time.Second)
+	if err != nil || code != Changed {
+		t.Fatalf("Post = %s, %v", code, err)
+	}
+	r := <-got
+	if r.path != "sensors/b1/npz" || !bytes.Equal(r.body, body) {
+		t.Errorf("handler got %q, %d bytes", r.path, len(r.body))
+	}
+	if len(got) != 0 {
+		t.Error("handler called more than once")
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	addr, got := startServer(t, Changed)
+	c := dial(t, addr)
+	code, err := c.Post("up", FormatOctetStream, make([]byte, 5000), 5*time.Second)
+	if code != RequestEntityTooLarge || err == nil {
+		t.Errorf("Post = %s, %v; want 4.13", code, err)
+	}
+	if len(got) != 0 {
+		t.Error("handler called for a rejected body")
+	}
+}
+
+// TestDuplicate sends the same confirmable request twice, as a client
+// does when the ACK is lost; the handler must run once and both copies
+// get the same answer.
+func TestDuplicate(t *testing.T) {
+	addr, got := startServer(t, Changed)
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := (&message{typ: Confirmable, code: POST, id: 42, token: []byte{7}, options: pathOptions("up"), payload: []byte("x")}).marshal()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1500)
+	for i := range 2 {
+		conn.Write(req)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := unmarshal(buf[:n])
+		if err != nil || resp.typ != Acknowledgement || resp.id != 42 || resp.code != Changed || !bytes.Equal(resp.token, []byte{7}) {
+			t.Errorf("response %d: %+v, %v", i, resp, err)
+		}
+	}
+	if len(got) != 1 {
+		t.Errorf("handler called %d times, want 1", len(got))
+	}
+}
+
+func TestServerRejects(t *testing.T) {
+	addr, got := startServer(t, Changed)
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tests := []struct {
+		name string
+		req  *message
+		want *message
+	}{
+		{"ping", &message{typ: Confirmable, code: Empty, id: 1}, &message{typ: Reset, id: 1}},
+		{"GET", &message{typ: Confirmable, code: 1, id: 2}, &message{typ: Acknowledgement, code: MethodNotAllowed, id: 2}},
+		{"block out of order", &message{typ: Confirmable, code: POST, id: 3, options: []option{block{num: 2, more: true, szx: 2}.option(optBlock1)}},
+			&message{typ: Acknowledgement, code: RequestEntityIncomplete, id: 3, options: []option{block{num: 2, more: true, szx: 2}.option(optBlock1)}}},
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1500)
+	for _, tt := range tests {
+		conn.Write(tt.req.marshal())
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(buf[:n], tt.want.marshal()) {
+			t.Errorf("%s: response % x, want % x", tt.name, buf[:n], tt.want.marshal())
+		}
+	}
+	if len(got) != 0 {
+		t.Error("handler called")
+	}
+}
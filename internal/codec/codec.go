@@ -0,0 +1,18 @@
+// Package codec holds helpers for decoding message payload fields.
+package codec
+
+import "encoding/base64"
+
+// AutoDecodeBase64 decodes s as standard base64 and, if that fails, as
+// URL-safe base64. The two alphabets only differ in "+/" versus "-_", so
+// any string valid in both decodes to the same bytes either way.
+func AutoDecodeBase64(s string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err == nil {
+		return b, nil
+	}
+	if b, urlErr := base64.URLEncoding.DecodeString(s); urlErr == nil {
+		return b, nil
+	}
+	return nil, err
+}
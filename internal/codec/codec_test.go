@@ -0,0 +1,42 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestAutoDecodeBase64(t *testing.T) {
+	// 0xfb 0xff encodes to "+/8=" in standard and "-_8=" in URL-safe base64
+	data := []byte{0xfb, 0xff, 0x00, 'n', 'p', 'z', 0xfe}
+	tests := []struct {
+		name    string
+		in      string
+		want    []byte
+		wantErr bool
+	}{
+		{"standard", base64.StdEncoding.EncodeToString(data), data, false},
+		{"url-safe", base64.URLEncoding.EncodeToString(data), data, false},
+		{"valid in both", base64.StdEncoding.EncodeToString([]byte("npz")), []byte("npz"), false},
+		{"empty", "", []byte{}, false},
+		{"mixed alphabets", "+_8=", nil, true},
+		{"not base64", "not base64!", nil, true},
+		{"unpadded", strings.TrimRight(base64.URLEncoding.EncodeToString(data), "="), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AutoDecodeBase64(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("AutoDecodeBase64(%q) = %x, want %x", tt.in, got, tt.want)
+			}
+		})
+	}
+	std, url := base64.StdEncoding.EncodeToString(data), base64.URLEncoding.EncodeToString(data)
+	if !strings.ContainsAny(std, "+/") || strings.ContainsAny(url, "+/") {
+		t.Fatalf("test data does not tell the alphabets apart: %s, %s", std, url)
+	}
+}
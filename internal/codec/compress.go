@@ -0,0 +1,97 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression names accepted by Compress and Decompress. The publisher
+// records the name in the payload's "compression" field; an empty field
+// means the data is not compressed.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// ValidCompression reports whether name is a known compression.
+func ValidCompression(name string) bool {
+	switch name {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// Compress compresses data with the named compression. "" and "none"
+// return data unchanged.
+func Compress(data []byte, name string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch name {
+	case "", CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("codec: unknown compression %q", name)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress reverses Compress. Output beyond maxSize bytes is an error,
+// so a small payload cannot expand without bound; 0 means no limit.
+func Decompress(data []byte, name string, maxSize int64) ([]byte, error) {
+	var r io.Reader
+	switch name {
+	case "", CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("codec: gzip: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	case CompressionZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("codec: zstd: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("codec: unknown compression %q", name)
+	}
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("codec: %s: %w", name, err)
+	}
+	if maxSize > 0 && int64(len(out)) > maxSize {
+		return nil, fmt.Errorf("codec: %s: decompressed size exceeds %d bytes", name, maxSize)
+	}
+	return out, nil
+}
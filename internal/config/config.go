@@ -0,0 +1,68 @@
+// Package config collects the effective configuration of a binary so it
+// can be printed for operators (--export-config).
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Redacted replaces the value of sensitive settings in exported output.
+const Redacted = "[REDACTED]"
+
+// Config maps setting names (flag names, or env-style names for settings
+// that only come from the environment) to their effective values.
+type Config map[string]string
+
+// FromFlags returns every flag defined on fs with its current value, so
+// defaults taken from env vars are reflected as well. The export flags
+// themselves are left out.
+func FromFlags(fs *flag.FlagSet) Config {
+	cfg := make(Config)
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "export-config" || f.Name == "C" {
+			return
+		}
+		cfg[f.Name] = f.Value.String()
+	})
+	return cfg
+}
+
+// Sensitive reports whether a setting holds a secret, judged by the
+// words in its name (password, secret, token, key, ...).
+func Sensitive(name string) bool {
+	for _, word := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	}) {
+		switch word {
+		case "password", "passwd", "secret", "token", "key", "credentials":
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalSafe encodes cfg as indented JSON with non-empty sensitive values
+// replaced by Redacted.
+func MarshalSafe(cfg Config) ([]byte, error) {
+	safe := make(Config, len(cfg))
+	for k, v := range cfg {
+		if v != "" && Sensitive(k) {
+			v = Redacted
+		}
+		safe[k] = v
+	}
+	return json.MarshalIndent(safe, "", "  ")
+}
+
+// Export prints cfg as JSON to stdout via MarshalSafe.
+func Export(cfg Config) error {
+	data, err := MarshalSafe(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(data))
+	return err
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalSafe(t *testing.T) {
+	cfg := Config{
+		"mqtt-password":         "hunter2",
+		"s3-secret-key":         "abc",
+		"influx-token":          "tok",
+		"mqtt-credentials-file": "/run/secrets/mqtt",
+		"SIGNING_KEY":           "k",
+		"broker":                "tcp://127.0.0.1:1883",
+		"mqtt-username":         "sat",
+		"keepalive":             "30s",
+		"tls-client-cert":       "/etc/cert.pem",
+		"s3-access-key":         "",
+	}
+	data, err := MarshalSafe(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("output %s: %v", data, err)
+	}
+	want := Config{
+		"mqtt-password":         Redacted,
+		"s3-secret-key":         Redacted,
+		"influx-token":          Redacted,
+		"mqtt-credentials-file": Redacted,
+		"SIGNING_KEY":           Redacted,
+		"broker":                "tcp://127.0.0.1:1883",
+		"mqtt-username":         "sat",
+		"keepalive":             "30s", // "key" only counts as a whole word
+		"tls-client-cert":       "/etc/cert.pem",
+		"s3-access-key":         "", // unset secrets stay visibly unset
+	}
+	if !reflect.DeepEqual(Config(got), want) {
+		t.Errorf("MarshalSafe = %v, want %v", got, want)
+	}
+	if cfg["mqtt-password"] != "hunter2" {
+		t.Error("MarshalSafe changed its argument")
+	}
+}
+
+func TestFromFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("broker", "tcp://default:1883", "")
+	fs.Int("qos", 1, "")
+	fs.Bool("export-config", false, "")
+	fs.String("C", "", "")
+	if err := fs.Parse([]string{"-qos", "2", "-export-config"}); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{"broker": "tcp://default:1883", "qos": "2"}
+	if got := FromFlags(fs); !reflect.DeepEqual(got, want) {
+		t.Errorf("FromFlags = %v, want %v", got, want)
+	}
+}
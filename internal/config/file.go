@@ -0,0 +1,169 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFile reads a YAML (.yaml, .yml) or TOML (.toml) configuration file.
+// Keys are flag names; nested tables are joined with "-", so
+//
+//	tls:
+//	  ca-cert: /etc/mqtt/ca.pem
+//
+// sets --tls-ca-cert. Lists become comma-separated values. Upper-case keys
+// such as BROKER_URL name environment variables instead (see SetEnv). An
+// empty path returns an empty Config.
+func LoadFile(path string) (Config, error) {
+	if path == "" {
+		return Config{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	var raw map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config: %s: unknown format (want .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: parse %s: %w", path, err)
+	}
+	cfg := make(Config)
+	if err := flatten(cfg, "", raw); err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func flatten(cfg Config, prefix string, m map[string]any) error {
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "-" + k
+		}
+		if sub, ok := v.(map[string]any); ok {
+			if err := flatten(cfg, k, sub); err != nil {
+				return err
+			}
+			continue
+		}
+		s, err := scalar(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		cfg[k] = s
+	}
+	return nil
+}
+
+func scalar(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			s, err := scalar(e)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// FilePath returns the value of the -config/--config argument in args,
+// or the environment variable env when it is not given. It lets a binary
+// load its file before defining the flags whose defaults it supplies.
+func FilePath(args []string, env string) string {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if v, ok := strings.CutPrefix(name, "config="); ok {
+			return v
+		}
+	}
+	return strings.TrimSpace(os.Getenv(env))
+}
+
+func isEnvName(key string) bool {
+	return key == strings.ToUpper(key) && strings.ContainsAny(key, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+}
+
+// SetEnv exports the upper-case keys of cfg as environment variables,
+// leaving variables that are already set alone. Call it before defining
+// flags so env-backed defaults pick the values up.
+func (cfg Config) SetEnv() {
+	for k, v := range cfg {
+		if isEnvName(k) {
+			if _, set := os.LookupEnv(k); !set {
+				os.Setenv(k, v)
+			}
+		}
+	}
+}
+
+// Apply sets the flags named in cfg, skipping flags given on the command
+// line. Call it after fs.Parse. Unknown flag names are an error so typos
+// do not go unnoticed.
+//
+// The resulting precedence is: command line, then environment, then file,
+// then built-in default for env-style keys; command line, then file, then
+// environment, then default for flag-name keys.
+func (cfg Config) Apply(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		if !isEnvName(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if fs.Lookup(k) == nil {
+			return fmt.Errorf("config: unknown setting %q", k)
+		}
+		if given[k] {
+			continue
+		}
+		if err := fs.Set(k, cfg[k]); err != nil {
+			return fmt.Errorf("config: %s: %w", k, err)
+		}
+	}
+	return nil
+}
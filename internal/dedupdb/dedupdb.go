@@ -0,0 +1,71 @@
+// Package dedupdb persists processed-message keys in SQLite so the
+// satellite's de-dup survives restarts.
+package dedupdb
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS processed_messages (
+	key TEXT PRIMARY KEY,
+	ts  DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS processed_messages_ts ON processed_messages(ts);`
+
+// Store is a SQLite-backed set of processed message keys.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (or creates) the database at path in WAL mode.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("dedupdb: create schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Seen reports whether key has been recorded.
+func (s *Store) Seen(key string) (bool, error) {
+	var one int
+	err := s.db.QueryRow(`SELECT 1 FROM processed_messages WHERE key = ?`, key).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Mark records key as processed at ts.
+func (s *Store) Mark(key string, ts time.Time) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO processed_messages(key, ts) VALUES (?, ?)`, key, ts.UTC())
+	return err
+}
+
+// Cleanup deletes keys recorded before cutoff and returns how many were removed.
+func (s *Store) Cleanup(cutoff time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM processed_messages WHERE ts < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Count returns the number of stored keys.
+func (s *Store) Count() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM processed_messages`).Scan(&n)
+	return n, err
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
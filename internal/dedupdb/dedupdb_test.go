@@ -0,0 +1,74 @@
+package dedupdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Mark("a", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"b", false},
+	}
+	for _, tt := range tests {
+		if seen, err := s.Seen(tt.key); err != nil || seen != tt.want {
+			t.Errorf("Seen(%q) = %v, %v; want %v", tt.key, seen, err, tt.want)
+		}
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "dedup.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	now := time.Now()
+	marks := map[string]time.Duration{"old": -time.Hour, "older": -2 * time.Hour, "fresh": -time.Minute}
+	for key, age := range marks {
+		if err := s.Mark(key, now.Add(age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// marking again refreshes the timestamp
+	if err := s.Mark("old", now); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := s.Cleanup(now.Add(-5 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Cleanup removed %d, want 1", removed)
+	}
+	if n, _ := s.Count(); n != 2 {
+		t.Errorf("Count = %d, want 2", n)
+	}
+	for key, want := range map[string]bool{"old": true, "older": false, "fresh": true} {
+		if seen, _ := s.Seen(key); seen != want {
+			t.Errorf("Seen(%q) = %v after cleanup, want %v", key, seen, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Package eventhook publishes lifecycle events (reconnects, ...) so
+// monitoring can track link stability.
+package eventhook
+
+import (
+	"encoding/json"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Event is the JSON document published for each lifecycle event.
+type Event struct {
+	Event       string `json:"event"`
+	SatelliteID string `json:"satellite_id"`
+	Attempt     int64  `json:"attempt"`
+	TS          string `json:"ts"`
+}
+
+// MQTTNotifier publishes events through an existing client. Delivery is
+// best effort: QoS 0 and the publish token is never waited on.
+type MQTTNotifier struct {
+	Topic       string
+	SatelliteID string
+	// Client returns the connection to publish on (e.g. the current global client).
+	Client func() MQTT.Client
+}
+
+// Notify publishes event; it is a no-op if no client is connected.
+func (n MQTTNotifier) Notify(event string, attempt int64) {
+	c := n.Client()
+	if c == nil || !c.IsConnected() {
+		return
+	}
+	body, err := json.Marshal(Event{
+		Event:       event,
+		SatelliteID: n.SatelliteID,
+		Attempt:     attempt,
+		TS:          time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return
+	}
+	c.Publish(n.Topic, 0, false, body)
+}
@@ -0,0 +1,70 @@
+package eventhook
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+type published struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+}
+
+// recordingClient captures publishes; the rest of MQTT.Client is unused.
+type recordingClient struct {
+	MQTT.Client
+	connected bool
+	sent      []published
+}
+
+func (c *recordingClient) IsConnected() bool { return c.connected }
+
+func (c *recordingClient) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	c.sent = append(c.sent, published{topic, qos, retained, payload.([]byte)})
+	return nil
+}
+
+func TestNotifyOnEachReconnect(t *testing.T) {
+	c := &recordingClient{connected: true}
+	n := MQTTNotifier{Topic: "satellite/sat-1/events", SatelliteID: "sat-1", Client: func() MQTT.Client { return c }}
+
+	// the satellite bumps its counter and notifies once per reconnect
+	var reconnects atomic.Int64
+	for range 3 {
+		n.Notify("reconnect", reconnects.Add(1))
+	}
+
+	if len(c.sent) != 3 {
+		t.Fatalf("%d events published, want 3", len(c.sent))
+	}
+	for i, p := range c.sent {
+		if p.topic != "satellite/sat-1/events" || p.qos != 0 || p.retained {
+			t.Errorf("event %d: topic %q qos %d retained %v", i, p.topic, p.qos, p.retained)
+		}
+		var ev Event
+		if err := json.Unmarshal(p.payload, &ev); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if ev.Event != "reconnect" || ev.SatelliteID != "sat-1" || ev.Attempt != int64(i+1) {
+			t.Errorf("event %d = %+v, want attempt %d", i, ev, i+1)
+		}
+		if _, err := time.Parse(time.RFC3339, ev.TS); err != nil {
+			t.Errorf("event %d: ts %q: %v", i, ev.TS, err)
+		}
+	}
+}
+
+func TestNotifyWithoutConnection(t *testing.T) {
+	c := &recordingClient{}
+	MQTTNotifier{Topic: "t", Client: func() MQTT.Client { return c }}.Notify("reconnect", 1)
+	if len(c.sent) != 0 {
+		t.Error("published while disconnected")
+	}
+	MQTTNotifier{Topic: "t", Client: func() MQTT.Client { return nil }}.Notify("reconnect", 1)
+}
@@ -0,0 +1,45 @@
+// Package filefilter selects files by glob patterns on their base name.
+package filefilter
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Validate reports a malformed glob so callers can fail at startup.
+func Validate(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("filefilter: bad pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// Filter keeps the files whose base name matches include (empty matches
+// everything) and then drops those matching exclude. Order is preserved.
+func Filter(files []string, include, exclude string) ([]string, error) {
+	if err := Validate(include); err != nil {
+		return nil, err
+	}
+	if err := Validate(exclude); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, f := range files {
+		name := filepath.Base(f)
+		if include != "" {
+			if ok, _ := filepath.Match(include, name); !ok {
+				continue
+			}
+		}
+		if exclude != "" {
+			if ok, _ := filepath.Match(exclude, name); ok {
+				continue
+			}
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
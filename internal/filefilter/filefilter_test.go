@@ -0,0 +1,55 @@
+package filefilter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	files := []string{"b1/2024-01-01.npz", "b1/2024-01-02.npz", "b1/2024-01-02.tmp.npz", "b1/notes.txt"}
+	tests := []struct {
+		name             string
+		include, exclude string
+		want             []string
+		wantErr          bool
+	}{
+		{"everything", "", "", files, false},
+		{"include", "*.npz", "", []string{"b1/2024-01-01.npz", "b1/2024-01-02.npz", "b1/2024-01-02.tmp.npz"}, false},
+		{"include by date", "2024-01-02*", "", []string{"b1/2024-01-02.npz", "b1/2024-01-02.tmp.npz"}, false},
+		{"exclude", "", "*.tmp.npz", []string{"b1/2024-01-01.npz", "b1/2024-01-02.npz", "b1/notes.txt"}, false},
+		{"include then exclude", "*.npz", "*.tmp.*", []string{"b1/2024-01-01.npz", "b1/2024-01-02.npz"}, false},
+		{"matches base name only", "b1*", "", nil, false},
+		{"bad include", "[", "", nil, true},
+		{"bad exclude", "*.npz", "a[b", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Filter(files, tt.include, tt.exclude)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Filter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{"", false},
+		{"*.npz", false},
+		{"buoy_[0-9]*.npz", false},
+		{"[", true},
+		{"buoy_[0-9.npz", true},
+		{`\`, true},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.pattern); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q) = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package filelock provides advisory, whole-file locks with a timeout so
+// several processes can append to the same file without interleaving.
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrTimeout is returned by Lock when the lock is still held elsewhere
+// after the timeout has elapsed.
+var ErrTimeout = errors.New("filelock: timed out waiting for lock")
+
+// ErrUnsupported is returned by Lock and Unlock on platforms without
+// advisory locks (see Supported).
+var ErrUnsupported = fmt.Errorf("filelock: %w on this platform", errors.ErrUnsupported)
+
+// Locker acquires exclusive advisory locks on open files.
+type Locker struct {
+	// PollInterval is how often a busy lock is retried. Zero means 10ms.
+	PollInterval time.Duration
+}
+
+// Lock takes an exclusive lock on f, retrying until timeout.
+func (l Locker) Lock(f *os.File, timeout time.Duration) error {
+	poll := l.PollInterval
+	if poll <= 0 {
+		poll = 10 * time.Millisecond
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(poll)
+	}
+}
+
+// Unlock releases a lock taken with Lock.
+func (l Locker) Unlock(f *os.File) error {
+	return unlock(f)
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package filelock
+
+import "os"
+
+// Supported reports whether Lock can lock files on this platform.
+const Supported = false
+
+// Advisory locks are not implemented on this platform; taking one fails
+// rather than letting writers interleave unnoticed.
+func tryLock(f *os.File) (bool, error) { return false, ErrUnsupported }
+
+func unlock(f *os.File) error { return ErrUnsupported }
@@ -0,0 +1,98 @@
+//go:build unix
+
+package filelock
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openAppend(t *testing.T, path string) *os.File {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestLockTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rows.csv")
+	holder, waiter := openAppend(t, path), openAppend(t, path)
+	var l Locker
+	if err := l.Lock(holder, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := l.Lock(waiter, 50*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Lock on a held file = %v, want ErrTimeout", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("gave up after %v", waited)
+	}
+	if err := l.Unlock(holder); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Lock(waiter, 50*time.Millisecond); err != nil {
+		t.Fatalf("Lock after Unlock: %v", err)
+	}
+}
+
+// Two writers append rows field by field, one write per field and
+// yielding in between, so rows would interleave without the lock.
+func TestConcurrentWriters(t *testing.T) {
+	const rows = 50
+	path := filepath.Join(t.TempDir(), "rows.csv")
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for _, writer := range []string{"a", "b"} {
+		f := openAppend(t, path)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			l := Locker{PollInterval: time.Millisecond}
+			for i := range rows {
+				if err := l.Lock(f, 5*time.Second); err != nil {
+					t.Error(err)
+					return
+				}
+				for _, field := range []string{writer, ",", fmt.Sprint(i), ",", writer, "\n"} {
+					if _, err := f.WriteString(field); err != nil {
+						t.Error(err)
+					}
+					time.Sleep(10 * time.Microsecond)
+				}
+				if err := l.Unlock(f); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); n++ {
+		fields := strings.Split(sc.Text(), ",")
+		if len(fields) != 3 || fields[0] != fields[2] {
+			t.Fatalf("interleaved row %q", sc.Text())
+		}
+	}
+	if n != 2*rows {
+		t.Errorf("got %d rows, want %d", n, 2*rows)
+	}
+}
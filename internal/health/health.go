@@ -0,0 +1,98 @@
+// Package health serves liveness (/healthz) and readiness (/readyz) probes
+// for container orchestrators. Each endpoint runs its registered checks
+// and answers 200 when all pass or 503 otherwise, with a JSON body naming
+// the result of every check:
+//
+//	{"status":"fail","checks":{"broker":"not connected","predict_script":"ok"}}
+//
+// Readiness includes the liveness checks: a process that should be
+// restarted is never ready.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Check reports a problem, or nil when healthy. Checks run on every probe
+// and must not block.
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Server holds the checks behind /healthz and /readyz.
+type Server struct {
+	mu    sync.RWMutex
+	live  []namedCheck
+	ready []namedCheck
+}
+
+// New returns a Server without checks; both endpoints report ok.
+func New() *Server {
+	return &Server{}
+}
+
+// Live registers a liveness check: failing it means the process is stuck
+// and should be restarted.
+func (s *Server) Live(name string, c Check) {
+	s.mu.Lock()
+	s.live = append(s.live, namedCheck{name, c})
+	s.mu.Unlock()
+}
+
+// Ready registers a readiness check: failing it means the process is
+// running but cannot do its work yet, e.g. the broker is unreachable.
+func (s *Server) Ready(name string, c Check) {
+	s.mu.Lock()
+	s.ready = append(s.ready, namedCheck{name, c})
+	s.mu.Unlock()
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Handler serves /healthz and /readyz.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		checks := append([]namedCheck(nil), s.live...)
+		s.mu.RUnlock()
+		serve(w, checks)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		checks := append(append([]namedCheck(nil), s.live...), s.ready...)
+		s.mu.RUnlock()
+		serve(w, checks)
+	})
+	return mux
+}
+
+// ListenAndServe serves Handler on addr.
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+func serve(w http.ResponseWriter, checks []namedCheck) {
+	rep := report{Status: "ok", Checks: make(map[string]string, len(checks))}
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			rep.Status = "fail"
+			rep.Checks[c.name] = err.Error()
+		} else {
+			rep.Checks[c.name] = "ok"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if rep.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(rep)
+}
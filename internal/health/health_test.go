@@ -0,0 +1,82 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func get(t *testing.T, srv *httptest.Server, path string) (int, report) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s Content-Type %q", path, ct)
+	}
+	var rep report
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return resp.StatusCode, rep
+}
+
+func TestNoChecks(t *testing.T) {
+	srv := httptest.NewServer(New().Handler())
+	defer srv.Close()
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if code, rep := get(t, srv, path); code != http.StatusOK || rep.Status != "ok" || len(rep.Checks) != 0 {
+			t.Errorf("%s = %d %+v", path, code, rep)
+		}
+	}
+}
+
+func TestProbes(t *testing.T) {
+	var connected atomic.Bool
+	s := New()
+	s.Live("worker", func() error { return nil })
+	s.Ready("broker", func() error {
+		if !connected.Load() {
+			return errors.New("not connected")
+		}
+		return nil
+	})
+	s.Ready("predict_script", func() error { return nil })
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	// a failing readiness check leaves the process alive
+	code, rep := get(t, srv, "/healthz")
+	if want := (report{"ok", map[string]string{"worker": "ok"}}); code != http.StatusOK || !reflect.DeepEqual(rep, want) {
+		t.Errorf("/healthz = %d %+v", code, rep)
+	}
+	code, rep = get(t, srv, "/readyz")
+	want := report{"fail", map[string]string{"worker": "ok", "broker": "not connected", "predict_script": "ok"}}
+	if code != http.StatusServiceUnavailable || !reflect.DeepEqual(rep, want) {
+		t.Errorf("/readyz = %d %+v, want 503 %+v", code, rep, want)
+	}
+
+	connected.Store(true)
+	if code, rep := get(t, srv, "/readyz"); code != http.StatusOK || rep.Status != "ok" || rep.Checks["broker"] != "ok" {
+		t.Errorf("/readyz once connected = %d %+v", code, rep)
+	}
+}
+
+func TestLivenessFailsReadiness(t *testing.T) {
+	s := New()
+	s.Live("worker", func() error { return errors.New("stalled for 2m0s") })
+	s.Ready("broker", func() error { return nil })
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if code, rep := get(t, srv, path); code != http.StatusServiceUnavailable || rep.Checks["worker"] != "stalled for 2m0s" {
+			t.Errorf("%s = %d %+v", path, code, rep)
+		}
+	}
+}
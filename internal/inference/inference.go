@@ -0,0 +1,361 @@
+// Package inference talks to a long-lived Python inference server over a
+// Unix socket, instead of starting predict.py for every message. The
+// protocol is one JSON object per line in each direction:
+//
+//	{"npz_path": "/tmp/x.npz"}  ->  {"output": "..."} or {"error": "..."}
+//	{"npz_paths": ["a", "b"]}   ->  {"outputs": [{"output": "..."}, {"error": "..."}]}
+//	{"ping": true}              ->  {"ok": true}
+//
+// An error caused by the file rather than the model carries
+// "bad_input": true and is returned as ErrBadInput.
+//
+// When Command is set the Client also starts the server, restarts it when
+// it exits, and kills it when a request times out or a health check
+// fails, since a stuck server would otherwise block every later request.
+//
+// Pool is the alternative without a socket: several predict.py processes
+// spoken to over their stdin and stdout.
+package inference
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+)
+
+// ErrTimeout is returned by Predict when the server does not answer in time.
+var ErrTimeout = errors.New("inference: timed out")
+
+// ErrBadInput wraps errors the model reports for an unreadable or
+// malformed input file; the backend itself is working.
+var ErrBadInput = errors.New("inference: input rejected")
+
+// Client sends prediction requests to the server one at a time.
+type Client struct {
+	Socket string
+	// Command starts the server; empty means it is managed elsewhere.
+	Command []string
+	Env     []string
+	// DialTimeout bounds how long a request waits for the socket to
+	// accept connections, which covers the server's startup.
+	DialTimeout time.Duration
+
+	mu   sync.Mutex // one request at a time
+	conn net.Conn
+	r    *bufio.Reader
+
+	procMu    sync.Mutex
+	proc      *os.Process
+	exited    chan struct{} // closed once proc has been reaped
+	startedAt time.Time
+	closed    bool
+	healthErr error // result of the last HealthCheck ping
+}
+
+type request struct {
+	NPZPath  string   `json:"npz_path,omitempty"`
+	NPZPaths []string `json:"npz_paths,omitempty"`
+	Ping     bool     `json:"ping,omitempty"`
+}
+
+type response struct {
+	Result
+	Outputs []Result `json:"outputs"`
+	OK      bool     `json:"ok"`
+}
+
+// Result is the outcome of one file of a batch.
+type Result struct {
+	Output   string `json:"output"`
+	Error    string `json:"error"`
+	BadInput bool   `json:"bad_input"`
+}
+
+// Err returns the file's error, if any, wrapping ErrBadInput when the
+// input was to blame.
+func (r Result) Err() error {
+	switch {
+	case r.Error == "":
+		return nil
+	case r.BadInput:
+		return fmt.Errorf("%w: %s", ErrBadInput, r.Error)
+	default:
+		return fmt.Errorf("inference: %s", r.Error)
+	}
+}
+
+// Start launches and supervises the server when Command is set.
+func (c *Client) Start() {
+	if len(c.Command) == 0 {
+		return
+	}
+	go c.supervise()
+}
+
+func (c *Client) supervise() {
+	delay := backoff.New(time.Second, 30*time.Second)
+	for {
+		c.procMu.Lock()
+		if c.closed {
+			c.procMu.Unlock()
+			return
+		}
+		cmd := exec.Command(c.Command[0], c.Command[1:]...)
+		cmd.Env = append(os.Environ(), c.Env...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err := cmd.Start()
+		exited := make(chan struct{})
+		if err == nil {
+			c.proc, c.exited, c.startedAt = cmd.Process, exited, time.Now()
+		}
+		c.procMu.Unlock()
+		if err != nil {
+			slog.Error("inference server start failed", "err", err)
+		} else {
+			slog.Info("inference server started", "pid", cmd.Process.Pid, "socket", c.Socket)
+			started := time.Now()
+			err = cmd.Wait()
+			close(exited)
+			if time.Since(started) > time.Minute {
+				delay.Reset()
+			}
+			c.procMu.Lock()
+			c.proc = nil
+			closed := c.closed
+			c.procMu.Unlock()
+			if closed {
+				return
+			}
+			slog.Warn("inference server exited", "err", err)
+		}
+		time.Sleep(delay.Next())
+	}
+}
+
+// restart kills a supervised server so it is started afresh, and waits
+// for it to go away so the next request cannot reach the old one.
+func (c *Client) restart() {
+	c.procMu.Lock()
+	proc, exited := c.proc, c.exited
+	c.procMu.Unlock()
+	if proc == nil {
+		return
+	}
+	_ = proc.Kill()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// Close stops the server (when supervised) and drops the connection.
+func (c *Client) Close() {
+	c.procMu.Lock()
+	c.closed = true
+	c.procMu.Unlock()
+	c.restart()
+	c.mu.Lock()
+	c.drop()
+	c.mu.Unlock()
+}
+
+func (c *Client) drop() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.r = nil, nil
+	}
+}
+
+func (c *Client) dialTimeout() time.Duration {
+	if c.DialTimeout > 0 {
+		return c.DialTimeout
+	}
+	return time.Minute
+}
+
+// connect dials the socket, retrying for up to DialTimeout (default one
+// minute) while the server starts, or until ctx is canceled; ctx's
+// deadline is left to the request. c.mu is held.
+func (c *Client) connect(ctx context.Context) error {
+	if c.conn != nil {
+		return nil
+	}
+	dialCtx, cancel := context.WithTimeout(context.Background(), c.dialTimeout())
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			cancel()
+		}
+	})
+	defer stop()
+	var d net.Dialer
+	for {
+		conn, err := d.DialContext(dialCtx, "unix", c.Socket)
+		if err == nil {
+			c.conn, c.r = conn, bufio.NewReader(conn)
+			return nil
+		}
+		select {
+		case <-dialCtx.Done():
+			return fmt.Errorf("inference: connect %s: %w", c.Socket, err)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// call sends req and waits for the reply until ctx is done; ctx's
+// deadline does not count the time spent connecting. c.mu is held.
+func (c *Client) call(ctx context.Context, req request) (response, error) {
+	var resp response
+	start := time.Now()
+	if err := c.connect(ctx); err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return resp, ctx.Err()
+		}
+		return resp, err
+	}
+	conn := c.conn
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline.Add(time.Since(start)))
+	} else {
+		conn.SetDeadline(time.Time{})
+	}
+	// cancellation interrupts the read like a timeout
+	stop := context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			conn.SetDeadline(time.Now())
+		}
+	})
+	defer stop()
+	line, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	if _, err = c.conn.Write(append(line, '\n')); err == nil {
+		var reply []byte
+		if reply, err = c.r.ReadBytes('\n'); err == nil {
+			err = json.Unmarshal(reply, &resp)
+		}
+	}
+	if err != nil {
+		c.drop()
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			// the server is still busy with this request; start over
+			c.restart()
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return resp, ctx.Err()
+			}
+			return resp, ErrTimeout
+		}
+		return resp, fmt.Errorf("inference: %w", err)
+	}
+	return resp, nil
+}
+
+// Predict runs predict.py on npzPath in the server and returns its stdout.
+// It returns ErrTimeout when ctx's deadline passes and ctx.Err() when ctx
+// is canceled.
+func (c *Client) Predict(ctx context.Context, npzPath string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp, err := c.call(ctx, request{NPZPath: npzPath})
+	if err != nil {
+		return "", err
+	}
+	if err := resp.Err(); err != nil {
+		return "", err
+	}
+	return resp.Output, nil
+}
+
+// PredictBatch runs predict.py on every path in one request and returns
+// one Result per path, in order. ctx's deadline covers the whole batch.
+func (c *Client) PredictBatch(ctx context.Context, npzPaths []string) ([]Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp, err := c.call(ctx, request{NPZPaths: npzPaths})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("inference: %s", resp.Error)
+	}
+	if len(resp.Outputs) != len(npzPaths) {
+		return nil, fmt.Errorf("inference: %d results for %d files", len(resp.Outputs), len(npzPaths))
+	}
+	return resp.Outputs, nil
+}
+
+// Ping checks the server answers within timeout. A server busy with a
+// prediction counts as healthy; Predict's own timeout catches a stuck one.
+// A failed ping restarts a supervised server once it has had DialTimeout
+// to start up.
+func (c *Client) Ping(timeout time.Duration) error {
+	if !c.mu.TryLock() {
+		return nil
+	}
+	defer c.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := c.call(ctx, request{Ping: true})
+	if err == nil && !resp.OK {
+		err = errors.New("inference: unexpected ping reply")
+	}
+	if err != nil {
+		c.drop()
+		c.procMu.Lock()
+		starting := c.proc != nil && time.Since(c.startedAt) < c.dialTimeout()
+		c.procMu.Unlock()
+		if !starting {
+			c.restart()
+		}
+	}
+	return err
+}
+
+// HealthCheck pings the server every interval until Close.
+func (c *Client) HealthCheck(interval, timeout time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			c.procMu.Lock()
+			closed := c.closed
+			c.procMu.Unlock()
+			if closed {
+				return
+			}
+			err := c.Ping(timeout)
+			c.procMu.Lock()
+			closed = c.closed
+			c.procMu.Unlock()
+			if closed {
+				return
+			}
+			if err != nil {
+				slog.Warn("inference server health check failed", "err", err)
+			}
+			c.procMu.Lock()
+			c.healthErr = err
+			c.procMu.Unlock()
+		}
+	}()
+}
+
+// Err returns the result of the last HealthCheck ping.
+func (c *Client) Err() error {
+	c.procMu.Lock()
+	defer c.procMu.Unlock()
+	return c.healthErr
+}
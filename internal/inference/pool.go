@@ -0,0 +1,309 @@
+package inference
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+)
+
+// maxFrame bounds a reply, so a worker writing garbage to stdout cannot
+// make the pool allocate without limit.
+const maxFrame = 64 << 20
+
+var errPoolClosed = errors.New("inference: pool closed")
+
+// Pool keeps Size worker processes running (predict.py --worker) and
+// sends them one NPZ path per request over their stdin and stdout. Each
+// frame is a 4-byte big-endian length followed by a JSON object:
+//
+//	{"npz_path": "/tmp/x.npz"}  ->  {"output": "..."} or {"error": "..."}
+//
+// with "bad_input": true on errors caused by the file. Requests go to the
+// workers round-robin; a worker handles one at a time. A worker that exits
+// is started again, after a backoff when it keeps exiting, and one that
+// does not answer before the request's deadline is killed, since its next
+// reply would belong to the wrong request.
+type Pool struct {
+	Command []string
+	Env     []string
+	Size    int
+
+	workers  []*worker
+	next     atomic.Uint64
+	restarts atomic.Int64
+}
+
+type worker struct {
+	pool  *Pool
+	id    int
+	delay *backoff.Backoff
+
+	mu     sync.Mutex // one request at a time; guards the fields below
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	exited chan struct{} // closed once cmd has been reaped
+	closed bool
+}
+
+// Start launches the workers. One that fails to start is retried on the
+// next request it gets.
+func (p *Pool) Start() {
+	for i := range max(p.Size, 1) {
+		w := &worker{pool: p, id: i, delay: backoff.New(time.Second, 30*time.Second)}
+		p.workers = append(p.workers, w)
+		w.mu.Lock()
+		if err := w.start(); err != nil {
+			slog.Error("inference worker start failed", "worker", i, "err", err)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Close stops the workers.
+func (p *Pool) Close() {
+	for _, w := range p.workers {
+		w.mu.Lock()
+		w.closed = true
+		w.stop()
+		w.mu.Unlock()
+	}
+}
+
+// Restarts returns how many times a worker has been started again after
+// exiting or being killed.
+func (p *Pool) Restarts() int64 {
+	return p.restarts.Load()
+}
+
+// Err fails while no worker is running.
+func (p *Pool) Err() error {
+	for _, w := range p.workers {
+		// a busy worker is running
+		if !w.mu.TryLock() {
+			return nil
+		}
+		running := w.cmd != nil
+		w.mu.Unlock()
+		if running {
+			return nil
+		}
+	}
+	return errors.New("inference: no worker running")
+}
+
+// Predict runs the model on npzPath in the next worker and returns its
+// output. It returns ErrTimeout when ctx's deadline passes and ctx.Err()
+// when ctx is canceled.
+func (p *Pool) Predict(ctx context.Context, npzPath string) (string, error) {
+	res, err := p.pick().call(ctx, npzPath)
+	if err != nil {
+		return "", err
+	}
+	if err := res.Err(); err != nil {
+		return "", err
+	}
+	return res.Output, nil
+}
+
+// PredictBatch spreads npzPaths over the workers and returns one Result
+// per path, in order. A worker failing or ctx ending fails the batch.
+func (p *Pool) PredictBatch(ctx context.Context, npzPaths []string) ([]Result, error) {
+	results := make([]Result, len(npzPaths))
+	errs := make([]error, len(npzPaths))
+	var wg sync.WaitGroup
+	for i, path := range npzPaths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = p.pick().call(ctx, path)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (p *Pool) pick() *worker {
+	return p.workers[(p.next.Add(1)-1)%uint64(len(p.workers))]
+}
+
+// start launches the worker process. w.mu is held.
+func (w *worker) start() error {
+	cmd := exec.Command(w.pool.Command[0], w.pool.Command[1:]...)
+	cmd.Env = append(os.Environ(), w.pool.Env...)
+	cmd.Stderr = os.Stderr
+	// own pipes rather than StdinPipe/StdoutPipe, which Wait closes while
+	// a reply may still be read
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return err
+	}
+	cmd.Stdin, cmd.Stdout = inR, outW
+	err = cmd.Start()
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return fmt.Errorf("inference: start worker %d: %w", w.id, err)
+	}
+	exited := make(chan struct{})
+	w.cmd, w.stdin, w.stdout, w.exited = cmd, inW, outR, exited
+	slog.Info("inference worker started", "worker", w.id, "pid", cmd.Process.Pid)
+	go w.reap(cmd, exited, time.Now())
+	return nil
+}
+
+// reap waits for cmd to exit and, unless a request has already replaced
+// it or the pool is closed, starts the worker again.
+func (w *worker) reap(cmd *exec.Cmd, exited chan struct{}, started time.Time) {
+	err := cmd.Wait()
+	close(exited)
+	w.mu.Lock()
+	if w.cmd == cmd {
+		w.release()
+	}
+	closed := w.closed
+	w.mu.Unlock()
+	if closed {
+		return
+	}
+	slog.Warn("inference worker exited", "worker", w.id, "pid", cmd.Process.Pid, "err", err)
+	if time.Since(started) > time.Minute {
+		w.delay.Reset()
+	}
+	time.Sleep(w.delay.Next())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.cmd != nil {
+		return
+	}
+	if err := w.restart(); err != nil {
+		slog.Error("inference worker start failed", "worker", w.id, "err", err)
+	}
+}
+
+// restart starts a worker that exited or was killed. w.mu is held.
+func (w *worker) restart() error {
+	w.pool.restarts.Add(1)
+	return w.start()
+}
+
+// stop kills the worker process and waits for it to go away. w.mu is held.
+func (w *worker) stop() {
+	if w.cmd == nil {
+		return
+	}
+	_ = w.cmd.Process.Kill()
+	select {
+	case <-w.exited:
+	case <-time.After(5 * time.Second):
+	}
+	w.release()
+}
+
+// release drops the pipes of an exited or killed process. w.mu is held.
+func (w *worker) release() {
+	w.stdin.Close()
+	w.stdout.Close()
+	w.cmd, w.stdin, w.stdout, w.exited = nil, nil, nil, nil
+}
+
+// call sends npzPath to the worker and waits for its reply until ctx is
+// done, which kills the worker.
+func (w *worker) call(ctx context.Context, npzPath string) (Result, error) {
+	var res Result
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ctx.Err() != nil {
+		return res, ctxErr(ctx)
+	}
+	if w.closed {
+		return res, errPoolClosed
+	}
+	if w.cmd == nil {
+		if err := w.restart(); err != nil {
+			return res, err
+		}
+	}
+	proc := w.cmd.Process
+	stop := context.AfterFunc(ctx, func() { _ = proc.Kill() })
+	err := writeFrame(w.stdin, request{NPZPath: npzPath})
+	if err == nil {
+		err = readFrame(w.stdout, &res)
+	}
+	killed := !stop()
+	if err != nil && !killed {
+		// a crashed worker is on its way out; let its exit status be logged
+		select {
+		case <-w.exited:
+		case <-time.After(time.Second):
+		}
+	}
+	if killed || err != nil {
+		w.stop()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return res, ctxErr(ctx)
+		}
+		return res, fmt.Errorf("inference: worker %d: %w", w.id, err)
+	}
+	return res, nil
+}
+
+// ctxErr maps a done ctx to ErrTimeout or the cancellation.
+func ctxErr(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
+
+func writeFrame(w io.Writer, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(body)), uint32(len(body)))
+	_, err = w.Write(append(frame, body...))
+	return err
+}
+
+func readFrame(r io.Reader, v any) error {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n > maxFrame {
+		return fmt.Errorf("reply of %d bytes", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
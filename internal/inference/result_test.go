@@ -0,0 +1,32 @@
+package inference
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestResultErr(t *testing.T) {
+	tests := []struct {
+		reply   string
+		wantErr bool
+		bad     bool
+	}{
+		{`{"output": "a,b\n1,2\n"}`, false, false},
+		{`{"error": "model file missing"}`, true, false},
+		{`{"error": "ValueError: cannot reshape", "bad_input": true}`, true, true},
+	}
+	for _, tt := range tests {
+		var r Result
+		if err := json.Unmarshal([]byte(tt.reply), &r); err != nil {
+			t.Fatal(err)
+		}
+		err := r.Err()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Err() = %v, want error %v", tt.reply, err, tt.wantErr)
+		}
+		if errors.Is(err, ErrBadInput) != tt.bad {
+			t.Errorf("%s: errors.Is(ErrBadInput) = %v, want %v", tt.reply, !tt.bad, tt.bad)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package influx writes points to InfluxDB in the line protocol over HTTP
+// (/api/v2/write, which InfluxDB 1.8+ serves as well). Points are
+// buffered and sent in batches; a batch that fails is kept and retried
+// with the next flush, holding at most BufferSize points while InfluxDB
+// is unreachable.
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	URL    string // e.g. http://localhost:8086
+	Org    string
+	Bucket string // "db/retention-policy" on InfluxDB 1.x
+	Token  string // "user:password" on InfluxDB 1.x; empty sends no auth
+	// BatchSize points trigger a flush; FlushInterval flushes whatever is
+	// buffered.
+	BatchSize     int
+	FlushInterval time.Duration
+	BufferSize    int
+	Client        *http.Client
+}
+
+// Point is one line-protocol point. Field values may be float64, int64,
+// bool or string.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]any
+	Time        time.Time
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// Line renders p in the line protocol with millisecond precision. Tags
+// and fields are sorted by key; NaN and infinite floats are skipped, as
+// InfluxDB rejects them. It returns "" when no field is left.
+func (p Point) Line() string {
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+	for _, k := range sortedKeys(p.Tags) {
+		if v := p.Tags[k]; v != "" {
+			b.WriteString("," + keyEscaper.Replace(k) + "=" + keyEscaper.Replace(v))
+		}
+	}
+	sep := " "
+	for _, k := range sortedKeys(p.Fields) {
+		var v string
+		switch x := p.Fields[k].(type) {
+		case float64:
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				continue
+			}
+			v = strconv.FormatFloat(x, 'g', -1, 64)
+		case int64:
+			v = strconv.FormatInt(x, 10) + "i"
+		case bool:
+			v = strconv.FormatBool(x)
+		case string:
+			v = `"` + stringEscaper.Replace(x) + `"`
+		default:
+			continue
+		}
+		b.WriteString(sep + keyEscaper.Replace(k) + "=" + v)
+		sep = ","
+	}
+	if sep == " " {
+		return ""
+	}
+	b.WriteString(" " + strconv.FormatInt(p.Time.UnixMilli(), 10))
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Writer batches points and sends them in the background.
+type Writer struct {
+	cfg     Config
+	mu      sync.Mutex
+	lines   []string
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+func New(cfg Config) *Writer {
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.BufferSize < cfg.BatchSize {
+		cfg.BufferSize = cfg.BatchSize
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Writer{
+		cfg:  cfg,
+		kick: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Start begins flushing in the background. flushErr, if not nil, is
+// called with every failed flush.
+func (w *Writer) Start(flushErr func(error)) {
+	go func() {
+		defer close(w.done)
+		tk := time.NewTicker(w.cfg.FlushInterval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-tk.C:
+			case <-w.kick:
+			}
+			if err := w.Flush(); err != nil && flushErr != nil {
+				flushErr(err)
+			}
+		}
+	}()
+}
+
+// Write queues p. When the buffer is full the oldest points are dropped.
+func (w *Writer) Write(p Point) {
+	line := p.Line()
+	if line == "" {
+		return
+	}
+	w.mu.Lock()
+	w.lines = append(w.lines, line)
+	if over := len(w.lines) - w.cfg.BufferSize; over > 0 {
+		w.lines = w.lines[over:]
+		w.dropped.Add(int64(over))
+	}
+	full := len(w.lines) >= w.cfg.BatchSize
+	w.mu.Unlock()
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Dropped returns how many points were discarded because the buffer was full.
+func (w *Writer) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Flush sends the buffered points, BatchSize at a time. Points of a batch
+// that fails stay buffered.
+func (w *Writer) Flush() error {
+	for {
+		w.mu.Lock()
+		n := min(len(w.lines), w.cfg.BatchSize)
+		batch := append([]string(nil), w.lines[:n]...)
+		w.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if err := w.send(batch); err != nil {
+			return err
+		}
+		w.mu.Lock()
+		// Write may have dropped some of the batch meanwhile
+		if n > len(w.lines) {
+			n = len(w.lines)
+		}
+		w.lines = w.lines[n:]
+		w.mu.Unlock()
+	}
+}
+
+func (w *Writer) send(lines []string) error {
+	q := url.Values{"bucket": {w.cfg.Bucket}, "precision": {"ms"}}
+	if w.cfg.Org != "" {
+		q.Set("org", w.cfg.Org)
+	}
+	endpoint := strings.TrimSuffix(w.cfg.URL, "/") + "/api/v2/write?" + q.Encode()
+	body := strings.Join(lines, "\n")
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, endpoint, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.cfg.Token)
+	}
+	resp, err := w.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("influx: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influx: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Close stops the background flush and sends what is left.
+func (w *Writer) Close() error {
+	close(w.stop)
+	<-w.done
+	return w.Flush()
+}
@@ -0,0 +1,168 @@
+package influx
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var t0 = time.UnixMilli(1700000000123)
+
+func TestLine(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Point
+		want string
+	}{
+		{"types", Point{"m", nil, map[string]any{"f": 1.5, "i": int64(-3), "b": true, "s": "ok"}, t0},
+			`m b=true,f=1.5,i=-3i,s="ok" 1700000000123`},
+		{"sorted tags", Point{"m", map[string]string{"z": "1", "a": "2"}, map[string]any{"v": 1.0}, t0},
+			`m,a=2,z=1 v=1 1700000000123`},
+		{"escaped measurement", Point{"wave height,m", nil, map[string]any{"v": 1.0}, t0},
+			`wave\ height\,m v=1 1700000000123`},
+		{"escaped tags", Point{"m", map[string]string{"buoy id": "a=b,c d"}, map[string]any{"v": 1.0}, t0},
+			`m,buoy\ id=a\=b\,c\ d v=1 1700000000123`},
+		{"escaped field key", Point{"m", nil, map[string]any{"Hs=m,s x": 1.0}, t0},
+			`m Hs\=m\,s\ x=1 1700000000123`},
+		{"escaped string", Point{"m", nil, map[string]any{"s": `say "hi" \o/`}, t0},
+			`m s="say \"hi\" \\o/" 1700000000123`},
+		{"empty tag skipped", Point{"m", map[string]string{"a": "", "b": "x"}, map[string]any{"v": 1.0}, t0},
+			`m,b=x v=1 1700000000123`},
+		{"NaN and Inf skipped", Point{"m", nil, map[string]any{"a": math.NaN(), "b": math.Inf(1), "c": 2.0}, t0},
+			`m c=2 1700000000123`},
+		{"unsupported type skipped", Point{"m", nil, map[string]any{"a": 3, "b": 2.0}, t0},
+			`m b=2 1700000000123`},
+		{"no fields", Point{"m", map[string]string{"a": "1"}, map[string]any{"a": math.NaN()}, t0}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Line(); got != tt.want {
+				t.Errorf("Line() = %s\nwant     %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeInflux records the bodies of write requests and fails them while
+// down is set.
+type fakeInflux struct {
+	mu     sync.Mutex
+	down   bool
+	writes []string
+	req    *http.Request
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		http.Error(w, `{"code":"unavailable"}`, http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.writes = append(f.writes, string(body))
+	f.req = r
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeInflux) batches() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.writes...)
+}
+
+func point(v float64) Point {
+	return Point{Measurement: "m", Fields: map[string]any{"v": v}, Time: t0}
+}
+
+func TestBatching(t *testing.T) {
+	f := &fakeInflux{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	w := New(Config{URL: srv.URL + "/", Org: "marine", Bucket: "buoys", Token: "secret", BatchSize: 2, BufferSize: 10})
+	for i := range 5 {
+		w.Write(point(float64(i)))
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"m v=0 1700000000123\nm v=1 1700000000123",
+		"m v=2 1700000000123\nm v=3 1700000000123",
+		"m v=4 1700000000123",
+	}
+	if got := f.batches(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("batches %q, want %q", got, want)
+	}
+	r := f.req
+	if r.Method != http.MethodPost || r.URL.Path != "/api/v2/write" {
+		t.Errorf("%s %s", r.Method, r.URL.Path)
+	}
+	if q := r.URL.Query(); q.Get("org") != "marine" || q.Get("bucket") != "buoys" || q.Get("precision") != "ms" {
+		t.Errorf("query %s", r.URL.RawQuery)
+	}
+	if a := r.Header.Get("Authorization"); a != "Token secret" {
+		t.Errorf("Authorization %q", a)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	f := &fakeInflux{down: true}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	w := New(Config{URL: srv.URL, Bucket: "b", BatchSize: 2, BufferSize: 3})
+	for i := range 5 {
+		w.Write(point(float64(i)))
+	}
+	if err := w.Flush(); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("Flush = %v, want the 503", err)
+	}
+	if w.Dropped() != 2 {
+		t.Errorf("Dropped() = %d, want 2", w.Dropped())
+	}
+
+	// the oldest points went; the failed batch is still buffered
+	f.mu.Lock()
+	f.down = false
+	f.mu.Unlock()
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(f.batches(), "\n")
+	if want := "m v=2 1700000000123\nm v=3 1700000000123\nm v=4 1700000000123"; got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+func TestBackgroundFlush(t *testing.T) {
+	f := &fakeInflux{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	w := New(Config{URL: srv.URL, Bucket: "b", BatchSize: 2, FlushInterval: time.Hour})
+	w.Start(func(err error) { t.Error(err) })
+
+	// a full batch is sent without waiting for the interval
+	w.Write(point(1))
+	w.Write(point(2))
+	deadline := time.Now().Add(2 * time.Second)
+	for len(f.batches()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(f.batches()) != 1 {
+		t.Fatalf("%d batches after a full batch, want 1", len(f.batches()))
+	}
+
+	// Close sends what is left
+	w.Write(point(3))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.batches(); len(got) != 2 || got[1] != "m v=3 1700000000123" {
+		t.Errorf("batches %q", got)
+	}
+}
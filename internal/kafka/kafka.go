@@ -0,0 +1,503 @@
+// Package kafka is a minimal Kafka producer. It speaks just enough of the
+// wire protocol to publish to one topic: Metadata v1 to find partition
+// leaders and Produce v3 with uncompressed v2 record batches. Keyed
+// messages are partitioned with murmur2 like the Java client, so consumers
+// see every buoy's results on one partition, in order; messages without a
+// key are spread round-robin.
+package kafka
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Brokers  []string // bootstrap host:port list
+	Topic    string
+	ClientID string
+	// Acks is 1 (leader only), -1 (all in-sync replicas) or 0 (none).
+	Acks    int16
+	Timeout time.Duration // per request
+	// TLS, if not nil, is used for every broker connection.
+	TLS *tls.Config
+}
+
+// Message is one record. A nil Key means no key.
+type Message struct {
+	Key   []byte
+	Value []byte
+	Time  time.Time
+}
+
+// Error is a Kafka protocol error code.
+type Error int16
+
+func (e Error) Error() string {
+	if s, ok := errorNames[e]; ok {
+		return "kafka: " + s
+	}
+	return "kafka: error code " + strconv.Itoa(int(e))
+}
+
+// Retriable reports whether a send failing with e may succeed later, once
+// the cluster has recovered or the leaders were looked up again. Other
+// codes fail the same way on every attempt.
+func (e Error) Retriable() bool {
+	switch e {
+	case 2, 3, 5, 6, 7, 19, 20:
+		return true
+	}
+	return false
+}
+
+var errorNames = map[Error]string{
+	1:  "offset out of range",
+	2:  "corrupt message",
+	3:  "unknown topic or partition",
+	5:  "leader not available",
+	6:  "not leader for partition",
+	7:  "request timed out",
+	10: "message too large",
+	19: "not enough replicas",
+	20: "not enough replicas after append",
+	29: "topic authorization failed",
+}
+
+const (
+	apiProduce  = 0
+	apiMetadata = 3
+)
+
+// Producer sends messages to Config.Topic. It is safe for concurrent use,
+// but sends are serialized.
+type Producer struct {
+	cfg     Config
+	mu      sync.Mutex
+	brokers map[int32]string // node ID -> host:port
+	leaders []int32          // partition -> leader node ID
+	conns   map[string]*conn
+	next    int // round-robin partition for keyless messages
+}
+
+func NewProducer(cfg Config) *Producer {
+	if cfg.ClientID == "" {
+		cfg.ClientID = "cloudletsapps"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &Producer{cfg: cfg, conns: make(map[string]*conn)}
+}
+
+// Send publishes msgs and returns once every partition leader has
+// acknowledged them (per Acks). On error some partitions may have been
+// written; the cluster layout is looked up again on the next call.
+func (p *Producer) Send(msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.leaders == nil {
+		if err := p.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+	byPartition := make(map[int32][]Message)
+	for _, m := range msgs {
+		part := p.partition(m.Key)
+		byPartition[part] = append(byPartition[part], m)
+	}
+	byLeader := make(map[int32][]int32)
+	for part := range byPartition {
+		leader := p.leaders[part]
+		byLeader[leader] = append(byLeader[leader], part)
+	}
+	for leader, parts := range byLeader {
+		if err := p.produce(leader, parts, byPartition); err != nil {
+			p.leaders = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all broker connections.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, c := range p.conns {
+		c.Close()
+		delete(p.conns, addr)
+	}
+	return nil
+}
+
+func (p *Producer) partition(key []byte) int32 {
+	n := len(p.leaders)
+	if key == nil {
+		p.next = (p.next + 1) % n
+		return int32(p.next)
+	}
+	return int32((murmur2(key) & 0x7fffffff) % uint32(n))
+}
+
+// murmur2 is the hash the Java client's default partitioner uses.
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	h := uint32(seed) ^ uint32(len(data))
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+		data = data[4:]
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// conn is one broker connection. Requests are sent one at a time.
+type conn struct {
+	net.Conn
+	r       *bufio.Reader
+	corr    int32
+	timeout time.Duration
+}
+
+func (p *Producer) dial(addr string) (*conn, error) {
+	if c := p.conns[addr]; c != nil {
+		return c, nil
+	}
+	d := &net.Dialer{Timeout: p.cfg.Timeout}
+	var nc net.Conn
+	var err error
+	if p.cfg.TLS != nil {
+		nc, err = tls.DialWithDialer(d, "tcp", addr, p.cfg.TLS)
+	} else {
+		nc, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kafka: %w", err)
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc), timeout: p.cfg.Timeout}
+	p.conns[addr] = c
+	return c, nil
+}
+
+// roundTrip sends a request and, if wantResponse, returns the response
+// body after its correlation ID. A connection that fails is closed and
+// forgotten.
+func (p *Producer) roundTrip(addr string, api, version int16, body []byte, wantResponse bool) ([]byte, error) {
+	c, err := p.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.roundTrip(p.cfg.ClientID, api, version, body, wantResponse)
+	if err != nil {
+		c.Close()
+		delete(p.conns, addr)
+		return nil, fmt.Errorf("kafka: %s: %w", addr, err)
+	}
+	return resp, nil
+}
+
+func (c *conn) roundTrip(clientID string, api, version int16, body []byte, wantResponse bool) ([]byte, error) {
+	c.corr++
+	var w encoder
+	w.int32(0) // size, filled in below
+	w.int16(api)
+	w.int16(version)
+	w.int32(c.corr)
+	w.string(clientID)
+	w.buf = append(w.buf, body...)
+	binary.BigEndian.PutUint32(w.buf, uint32(len(w.buf)-4))
+
+	c.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.Write(w.buf); err != nil {
+		return nil, err
+	}
+	if !wantResponse {
+		return nil, nil
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != c.corr {
+		return nil, errors.New("correlation ID mismatch")
+	}
+	return resp[4:], nil
+}
+
+func (p *Producer) refreshMetadata() error {
+	var req encoder
+	req.int32(1)
+	req.string(p.cfg.Topic)
+
+	var errs []error
+	addrs := append([]string(nil), p.cfg.Brokers...)
+	for _, addr := range p.brokers {
+		addrs = append(addrs, addr)
+	}
+	for _, addr := range addrs {
+		resp, err := p.roundTrip(addr, apiMetadata, 1, req.buf, true)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return p.parseMetadata(resp)
+	}
+	if len(errs) == 0 {
+		return errors.New("kafka: no brokers configured")
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Producer) parseMetadata(resp []byte) error {
+	d := decoder{buf: resp}
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller ID
+	var leaders []int32
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		code := Error(d.int16())
+		name := d.string()
+		d.int8() // is_internal
+		partLeaders := make(map[int32]int32)
+		for np := d.int32(); np > 0 && d.err == nil; np-- {
+			d.int16() // partition error; a missing leader shows as -1
+			idx := d.int32()
+			partLeaders[idx] = d.int32()
+			d.int32Array() // replicas
+			d.int32Array() // isr
+		}
+		if name != p.cfg.Topic {
+			continue
+		}
+		if code != 0 {
+			return code
+		}
+		leaders = make([]int32, len(partLeaders))
+		for idx, leader := range partLeaders {
+			if int(idx) >= len(leaders) {
+				return errors.New("kafka: partitions are not numbered 0..n-1")
+			}
+			if _, ok := brokers[leader]; !ok {
+				return Error(5)
+			}
+			leaders[idx] = leader
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("kafka: metadata: %w", d.err)
+	}
+	if len(leaders) == 0 {
+		return Error(3)
+	}
+	p.brokers, p.leaders = brokers, leaders
+	return nil
+}
+
+func (p *Producer) produce(leader int32, parts []int32, byPartition map[int32][]Message) error {
+	var req encoder
+	req.int16(-1) // no transactional ID
+	req.int16(p.cfg.Acks)
+	req.int32(int32(p.cfg.Timeout / time.Millisecond))
+	req.int32(1)
+	req.string(p.cfg.Topic)
+	req.int32(int32(len(parts)))
+	for _, part := range parts {
+		req.int32(part)
+		batch := recordBatch(byPartition[part])
+		req.int32(int32(len(batch)))
+		req.buf = append(req.buf, batch...)
+	}
+	// with acks 0 the broker sends no response
+	resp, err := p.roundTrip(p.brokers[leader], apiProduce, 3, req.buf, p.cfg.Acks != 0)
+	if err != nil || p.cfg.Acks == 0 {
+		return err
+	}
+	d := decoder{buf: resp}
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.string()
+		for np := d.int32(); np > 0 && d.err == nil; np-- {
+			d.int32()
+			code := Error(d.int16())
+			d.int64() // base offset
+			d.int64() // log append time
+			if code != 0 && d.err == nil {
+				return code
+			}
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("kafka: produce: %w", d.err)
+	}
+	return nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// recordBatch encodes msgs as one uncompressed v2 record batch.
+func recordBatch(msgs []Message) []byte {
+	first := msgs[0].Time
+	maxTime := first
+	var records []byte
+	for i, m := range msgs {
+		if m.Time.After(maxTime) {
+			maxTime = m.Time
+		}
+		var r []byte
+		r = append(r, 0) // attributes
+		r = binary.AppendVarint(r, m.Time.Sub(first).Milliseconds())
+		r = binary.AppendVarint(r, int64(i))
+		if m.Key == nil {
+			r = binary.AppendVarint(r, -1)
+		} else {
+			r = binary.AppendVarint(r, int64(len(m.Key)))
+			r = append(r, m.Key...)
+		}
+		r = binary.AppendVarint(r, int64(len(m.Value)))
+		r = append(r, m.Value...)
+		r = binary.AppendVarint(r, 0) // headers
+		records = binary.AppendVarint(records, int64(len(r)))
+		records = append(records, r...)
+	}
+
+	// the CRC covers everything from the attributes on
+	var tail encoder
+	tail.int16(0) // attributes: no compression
+	tail.int32(int32(len(msgs) - 1))
+	tail.int64(first.UnixMilli())
+	tail.int64(maxTime.UnixMilli())
+	tail.int64(-1) // producer ID
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(int32(len(msgs)))
+	tail.buf = append(tail.buf, records...)
+
+	var b encoder
+	b.int64(0)                                // base offset
+	b.int32(int32(4 + 1 + 4 + len(tail.buf))) // batch length
+	b.int32(-1)                               // partition leader epoch
+	b.int8(2)                                 // magic
+	b.int32(int32(crc32.Checksum(tail.buf, castagnoli)))
+	b.buf = append(b.buf, tail.buf...)
+	return b.buf
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8)   { e.buf = append(e.buf, byte(v)) }
+func (e *encoder) int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *encoder) int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *encoder) int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// decoder reads big-endian fields; after the first short read every call
+// returns zero and err is set.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a (nullable) string; null reads as "".
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *decoder) int32Array() {
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.int32()
+	}
+}
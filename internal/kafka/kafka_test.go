@@ -0,0 +1,465 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// record is one message as decoded from a produce request.
+type record struct {
+	partition int32
+	key       []byte // nil for a null key
+	value     []byte
+	offset    int64 // offset delta within its batch
+}
+
+// fakeCluster runs one listener per node. Every node answers Metadata v1
+// with the same layout: partition i is led by node leaders[i]. Produce v3
+// requests are decoded and recorded per node; produceErr, if set, picks the
+// error code answered for a partition.
+type fakeCluster struct {
+	topic   string
+	nodes   []net.Listener
+	leaders []int32
+
+	mu         sync.Mutex
+	metadata   int
+	clientIDs  []string
+	received   map[int32][]record // node -> records
+	produceErr func(node, partition int32) int16
+	badBatch   error
+}
+
+func startCluster(t *testing.T, topic string, nodes int, leaders []int32) *fakeCluster {
+	t.Helper()
+	c := &fakeCluster{topic: topic, leaders: leaders, received: map[int32][]record{}}
+	for i := range nodes {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		c.nodes = append(c.nodes, ln)
+		go func(node int32) {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go c.serve(node, conn)
+			}
+		}(int32(i))
+	}
+	return c
+}
+
+func (c *fakeCluster) addr(node int) string { return c.nodes[node].Addr().String() }
+
+func (c *fakeCluster) serve(node int32, conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := decoder{buf: req}
+		api, version, corr := d.int16(), d.int16(), d.int32()
+		clientID := d.string()
+		c.mu.Lock()
+		c.clientIDs = append(c.clientIDs, clientID)
+		c.mu.Unlock()
+
+		var resp encoder
+		resp.int32(corr)
+		switch {
+		case api == apiMetadata && version == 1:
+			c.metadataResponse(&resp)
+		case api == apiProduce && version == 3:
+			acks, ok := c.produce(node, &d, &resp)
+			if !ok {
+				return
+			}
+			if acks == 0 {
+				continue
+			}
+		default:
+			return
+		}
+		var out encoder
+		out.int32(int32(len(resp.buf)))
+		conn.Write(append(out.buf, resp.buf...))
+	}
+}
+
+func (c *fakeCluster) metadataResponse(resp *encoder) {
+	c.mu.Lock()
+	c.metadata++
+	c.mu.Unlock()
+	resp.int32(int32(len(c.nodes)))
+	for i, ln := range c.nodes {
+		host, port, _ := net.SplitHostPort(ln.Addr().String())
+		p, _ := strconv.Atoi(port)
+		resp.int32(int32(i))
+		resp.string(host)
+		resp.int32(int32(p))
+		resp.int16(-1) // null rack
+	}
+	resp.int32(0) // controller
+	resp.int32(2) // topics, one of them not ours
+	for _, name := range []string{"other", c.topic} {
+		resp.int16(0)
+		resp.string(name)
+		resp.int8(0)
+		resp.int32(int32(len(c.leaders)))
+		for part, leader := range c.leaders {
+			resp.int16(0)
+			resp.int32(int32(part))
+			resp.int32(leader)
+			resp.int32(1)
+			resp.int32(leader) // replicas
+			resp.int32(1)
+			resp.int32(leader) // isr
+		}
+	}
+}
+
+// produce decodes a Produce v3 request and writes the response.
+func (c *fakeCluster) produce(node int32, d *decoder, resp *encoder) (acks int16, ok bool) {
+	d.string() // transactional ID
+	acks = d.int16()
+	d.int32() // timeout
+	resp.int32(d.int32())
+	topic := d.string()
+	resp.string(topic)
+	n := d.int32()
+	resp.int32(n)
+	for ; n > 0 && d.err == nil; n-- {
+		part := d.int32()
+		batch := d.take(int(d.int32()))
+		recs, err := decodeBatch(batch)
+		c.mu.Lock()
+		if err != nil {
+			c.badBatch = err
+		}
+		for i := range recs {
+			recs[i].partition = part
+		}
+		c.received[node] = append(c.received[node], recs...)
+		var code int16
+		if c.produceErr != nil {
+			code = c.produceErr(node, part)
+		}
+		c.mu.Unlock()
+		resp.int32(part)
+		resp.int16(code)
+		resp.int64(0)
+		resp.int64(-1)
+	}
+	resp.int32(0) // throttle time
+	return acks, d.err == nil
+}
+
+// decodeBatch parses a v2 record batch, checking its length and CRC.
+func decodeBatch(b []byte) ([]record, error) {
+	d := decoder{buf: b}
+	d.int64() // base offset
+	if n := d.int32(); int(n) != len(d.buf) {
+		return nil, errors.New("batch length does not match")
+	}
+	d.int32() // leader epoch
+	if magic := d.int8(); magic != 2 {
+		return nil, errors.New("magic is not 2")
+	}
+	crc := uint32(d.int32())
+	if crc32.Checksum(d.buf, castagnoli) != crc {
+		return nil, errors.New("bad CRC")
+	}
+	d.int16() // attributes
+	lastDelta := d.int32()
+	d.int64() // first timestamp
+	d.int64() // max timestamp
+	d.int64() // producer ID
+	d.int16() // producer epoch
+	d.int32() // base sequence
+	count := d.int32()
+	if lastDelta != count-1 {
+		return nil, errors.New("last offset delta does not match count")
+	}
+	var recs []record
+	r := bytes.NewReader(d.buf)
+	for range count {
+		if _, err := binary.ReadVarint(r); err != nil { // length
+			return nil, err
+		}
+		r.ReadByte() // attributes
+		binary.ReadVarint(r)
+		offset, _ := binary.ReadVarint(r)
+		rec := record{offset: offset}
+		if n, _ := binary.ReadVarint(r); n >= 0 {
+			rec.key = make([]byte, n)
+			io.ReadFull(r, rec.key)
+		}
+		n, _ := binary.ReadVarint(r)
+		rec.value = make([]byte, n)
+		if _, err := io.ReadFull(r, rec.value); err != nil {
+			return nil, err
+		}
+		binary.ReadVarint(r) // headers
+		recs = append(recs, rec)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing bytes after records")
+	}
+	return recs, d.err
+}
+
+func TestMurmur2(t *testing.T) {
+	// vectors from the Java client's UtilsTest.testMurmur2
+	tests := []struct {
+		in   string
+		want int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	}
+	for _, tt := range tests {
+		if got := int32(murmur2([]byte(tt.in))); got != tt.want {
+			t.Errorf("murmur2(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSendPartitionsByLeader(t *testing.T) {
+	// partitions 0 and 2 on node 0, 1 and 3 on node 1
+	c := startCluster(t, "results", 2, []int32{0, 1, 0, 1})
+	p := NewProducer(Config{Brokers: []string{c.addr(1)}, Topic: "results", ClientID: "bridge", Acks: 1, Timeout: time.Second})
+	defer p.Close()
+
+	now := time.Now()
+	var msgs []Message
+	for _, key := range []string{"buoy-1", "buoy-2", "buoy-3", "buoy-4", "buoy-5", "buoy-1"} {
+		msgs = append(msgs, Message{Key: []byte(key), Value: []byte("v " + key), Time: now})
+	}
+	if err := p.Send(msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.badBatch != nil {
+		t.Fatalf("malformed batch: %v", c.badBatch)
+	}
+	got := map[string]int32{}
+	total := 0
+	for node, recs := range c.received {
+		for _, r := range recs {
+			want := int32((murmur2(r.key) & 0x7fffffff) % 4)
+			if r.partition != want {
+				t.Errorf("%s on partition %d, want %d", r.key, r.partition, want)
+			}
+			if c.leaders[r.partition] != node {
+				t.Errorf("partition %d sent to node %d, leader is %d", r.partition, node, c.leaders[r.partition])
+			}
+			if string(r.value) != "v "+string(r.key) {
+				t.Errorf("value %q for key %q", r.value, r.key)
+			}
+			if prev, ok := got[string(r.key)]; ok && prev != r.partition {
+				t.Errorf("%s split over partitions %d and %d", r.key, prev, r.partition)
+			}
+			got[string(r.key)] = r.partition
+			total++
+		}
+	}
+	if total != len(msgs) {
+		t.Errorf("broker received %d records, want %d", total, len(msgs))
+	}
+	if c.metadata != 1 {
+		t.Errorf("%d metadata requests, want 1", c.metadata)
+	}
+	for _, id := range c.clientIDs {
+		if id != "bridge" {
+			t.Errorf("client ID %q", id)
+		}
+	}
+}
+
+func TestSendKeyless(t *testing.T) {
+	c := startCluster(t, "results", 1, []int32{0, 0, 0})
+	p := NewProducer(Config{Brokers: []string{c.addr(0)}, Topic: "results", Acks: -1, Timeout: time.Second})
+	defer p.Close()
+	for i := range 6 {
+		if err := p.Send([]Message{{Value: []byte{byte(i)}, Time: time.Now()}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	perPartition := map[int32]int{}
+	for _, r := range c.received[0] {
+		if r.key != nil {
+			t.Errorf("keyless message sent with key %q", r.key)
+		}
+		perPartition[r.partition]++
+	}
+	for part := range int32(3) {
+		if perPartition[part] != 2 {
+			t.Errorf("round robin gave %v, want 2 per partition", perPartition)
+			break
+		}
+	}
+}
+
+func TestRecordBatch(t *testing.T) {
+	t0 := time.UnixMilli(1_700_000_000_000)
+	msgs := []Message{
+		{Key: []byte("k"), Value: []byte("first"), Time: t0},
+		{Value: []byte("second"), Time: t0.Add(1500 * time.Millisecond)},
+		{Key: []byte{}, Value: nil, Time: t0.Add(time.Second)},
+	}
+	batch := recordBatch(msgs)
+	recs, err := decodeBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("%d records, want 3", len(recs))
+	}
+	for i, r := range recs {
+		if r.offset != int64(i) {
+			t.Errorf("record %d has offset delta %d", i, r.offset)
+		}
+		if !bytes.Equal(r.value, msgs[i].Value) || (r.key == nil) != (msgs[i].Key == nil) {
+			t.Errorf("record %d = %q/%q, want %q/%q", i, r.key, r.value, msgs[i].Key, msgs[i].Value)
+		}
+	}
+	d := decoder{buf: batch[27:]} // first and max timestamp
+	if first, last := d.int64(), d.int64(); first != t0.UnixMilli() || last != t0.UnixMilli()+1500 {
+		t.Errorf("timestamps %d..%d", first, last)
+	}
+}
+
+func TestAcksZero(t *testing.T) {
+	c := startCluster(t, "results", 1, []int32{0})
+	p := NewProducer(Config{Brokers: []string{c.addr(0)}, Topic: "results", Acks: 0, Timeout: time.Second})
+	defer p.Close()
+	for range 2 {
+		if err := p.Send([]Message{{Value: []byte("x"), Time: time.Now()}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		n := len(c.received[0])
+		c.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("broker received %d records, want 2", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      int16
+		retriable bool
+	}{
+		{"not leader", 6, true},
+		{"leader not available", 5, true},
+		{"request timed out", 7, true},
+		{"not enough replicas", 19, true},
+		{"message too large", 10, false},
+		{"topic authorization failed", 29, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := startCluster(t, "results", 1, []int32{0, 0})
+			failures := 1
+			c.produceErr = func(node, partition int32) int16 {
+				if failures > 0 {
+					failures--
+					return tt.code
+				}
+				return 0
+			}
+			p := NewProducer(Config{Brokers: []string{c.addr(0)}, Topic: "results", Acks: 1, Timeout: time.Second})
+			defer p.Close()
+			msg := []Message{{Key: []byte("buoy-1"), Value: []byte("x"), Time: time.Now()}}
+
+			err := p.Send(msg)
+			var code Error
+			if !errors.As(err, &code) || int16(code) != tt.code {
+				t.Fatalf("Send = %v, want error code %d", err, tt.code)
+			}
+			if code.Retriable() != tt.retriable {
+				t.Errorf("Retriable() = %v, want %v", code.Retriable(), tt.retriable)
+			}
+			// a failed send drops the cached layout, so the retry asks again
+			if err := p.Send(msg); err != nil {
+				t.Fatalf("retry: %v", err)
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.metadata != 2 {
+				t.Errorf("%d metadata requests, want 2", c.metadata)
+			}
+		})
+	}
+}
+
+func TestMetadataErrors(t *testing.T) {
+	c := startCluster(t, "results", 1, []int32{0})
+	p := NewProducer(Config{Brokers: []string{c.addr(0)}, Topic: "missing", Timeout: time.Second})
+	defer p.Close()
+	err := p.Send([]Message{{Value: []byte("x")}})
+	if code, ok := err.(Error); !ok || code != 3 || !code.Retriable() {
+		t.Errorf("unknown topic: Send = %v, want retriable error code 3", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := ln.Addr().String()
+	ln.Close()
+	// the first bootstrap broker is down; the second answers
+	p = NewProducer(Config{Brokers: []string{dead, c.addr(0)}, Topic: "results", Timeout: time.Second})
+	defer p.Close()
+	if err := p.Send([]Message{{Value: []byte("x")}}); err != nil {
+		t.Errorf("Send with one bootstrap broker down: %v", err)
+	}
+	p = NewProducer(Config{Brokers: []string{dead}, Topic: "results", Timeout: time.Second})
+	defer p.Close()
+	if err := p.Send([]Message{{Value: []byte("x")}}); err == nil {
+		t.Error("Send with every broker down succeeded")
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	if got := Error(6).Error(); got != "kafka: not leader for partition" {
+		t.Errorf("Error(6) = %q", got)
+	}
+	if got := Error(87).Error(); got != "kafka: error code 87" {
+		t.Errorf("Error(87) = %q", got)
+	}
+}
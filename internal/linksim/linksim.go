@@ -0,0 +1,149 @@
+// Package linksim emulates a slow, long-delay link (such as a narrowband
+// satellite uplink) on the sending side of a net.Conn, so the effect can
+// be tried without tc/netem. Outbound bytes are paced by a token bucket
+// and then held back for a one-way delay with optional jitter; the byte
+// stream is never reordered. Reads are not affected.
+package linksim
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config describes the emulated link. The zero value is a plain
+// connection.
+type Config struct {
+	Rate   float64       // bytes per second; 0 means unlimited
+	Burst  int           // bytes that may be sent at once; 0 means Rate/10, at least 1500
+	Delay  time.Duration // one-way delay added to every write
+	Jitter time.Duration // random +/- offset on Delay, never below zero
+}
+
+// Enabled reports whether c changes anything.
+func (c Config) Enabled() bool {
+	return c.Rate > 0 || c.Delay > 0 || c.Jitter > 0
+}
+
+func (c Config) burst() float64 {
+	if c.Burst > 0 {
+		return float64(c.Burst)
+	}
+	return max(c.Rate/10, 1500)
+}
+
+// Conn is a net.Conn whose writes go through the emulated link. Write
+// returns once the bytes have been "transmitted" at the link rate; they
+// reach the underlying connection Delay (+/- Jitter) later.
+type Conn struct {
+	net.Conn
+	cfg Config
+
+	wmu    sync.Mutex // serialises Write
+	tokens float64
+	last   time.Time // last token refill
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []pending
+	arrival time.Time // latest scheduled arrival, to keep order
+	err     error     // first error writing to Conn
+	closed  bool
+	done    chan struct{}
+}
+
+type pending struct {
+	data []byte
+	at   time.Time
+}
+
+// Wrap returns c behind the emulated link cfg, or c itself if cfg is not
+// enabled.
+func Wrap(c net.Conn, cfg Config) net.Conn {
+	if !cfg.Enabled() {
+		return c
+	}
+	l := &Conn{Conn: c, cfg: cfg, tokens: cfg.burst(), last: time.Now(), done: make(chan struct{})}
+	l.cond = sync.NewCond(&l.mu)
+	go l.deliver()
+	return l
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.cfg.Rate > 0 {
+		now := time.Now()
+		c.tokens = min(c.tokens+now.Sub(c.last).Seconds()*c.cfg.Rate, c.cfg.burst())
+		c.last = now
+		c.tokens -= float64(len(b))
+		if c.tokens < 0 {
+			time.Sleep(time.Duration(-c.tokens / c.cfg.Rate * float64(time.Second)))
+		}
+	}
+	d := c.cfg.Delay
+	if c.cfg.Jitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*c.cfg.Jitter)+1)) - c.cfg.Jitter
+	}
+	at := time.Now().Add(max(d, 0))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if at.Before(c.arrival) {
+		at = c.arrival
+	}
+	c.arrival = at
+	c.queue = append(c.queue, pending{data: append([]byte(nil), b...), at: at})
+	c.cond.Signal()
+	return len(b), nil
+}
+
+// deliver writes queued bytes to the underlying connection as they come
+// due.
+func (c *Conn) deliver() {
+	defer close(c.done)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		for len(c.queue) == 0 && !c.closed {
+			c.cond.Wait()
+		}
+		if len(c.queue) == 0 {
+			return
+		}
+		p := c.queue[0]
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+		time.Sleep(time.Until(p.at))
+		_, err := c.Conn.Write(p.data)
+		c.mu.Lock()
+		if err != nil {
+			c.err = err
+			c.queue = nil
+			c.Conn.Close()
+			return
+		}
+	}
+}
+
+// Close delivers what is still in flight, giving up after the longest
+// possible delay plus a few seconds, then closes the underlying
+// connection.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Signal()
+	c.mu.Unlock()
+	select {
+	case <-c.done:
+	case <-time.After(c.cfg.Delay + c.cfg.Jitter + 5*time.Second):
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,174 @@
+package linksim
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// arrival is a chunk read from the far end of the link.
+type arrival struct {
+	data []byte
+	at   time.Time
+}
+
+// pipe returns the near end of a link with cfg and a function returning
+// everything that arrived at the far end so far.
+func pipe(t *testing.T, cfg Config) (net.Conn, func() []arrival) {
+	t.Helper()
+	near, far := net.Pipe()
+	t.Cleanup(func() { far.Close() })
+	var mu sync.Mutex
+	var got []arrival
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := far.Read(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			got = append(got, arrival{append([]byte(nil), buf[:n]...), time.Now()})
+			mu.Unlock()
+		}
+	}()
+	return Wrap(near, cfg), func() []arrival {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]arrival(nil), got...)
+	}
+}
+
+func waitFor(t *testing.T, arrived func() []arrival, n int) []arrival {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(arrived()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d chunks arrived, want %d", len(arrived()), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return arrived()
+}
+
+func TestDisabled(t *testing.T) {
+	near, far := net.Pipe()
+	defer near.Close()
+	defer far.Close()
+	if c := Wrap(near, Config{}); c != near {
+		t.Error("zero Config wrapped the connection")
+	}
+	if (Config{Burst: 100}).Enabled() {
+		t.Error("Burst alone enabled the link")
+	}
+	if b := (Config{Rate: 1000}).burst(); b != 1500 {
+		t.Errorf("default burst at 1000 B/s = %v, want 1500", b)
+	}
+	if b := (Config{Rate: 1e6}).burst(); b != 1e5 {
+		t.Errorf("default burst at 1 MB/s = %v, want a tenth of a second", b)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	c, arrived := pipe(t, Config{Rate: 20000, Burst: 1000})
+	defer c.Close()
+	start := time.Now()
+	for range 5 {
+		if _, err := c.Write(make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first 1000 bytes are the burst; the other 4000 take 200ms at 20 kB/s
+	if d := time.Since(start); d < 180*time.Millisecond || d > time.Second {
+		t.Errorf("5000 bytes written in %v, want about 200ms", d)
+	}
+	waitFor(t, arrived, 5)
+
+	// after a pause the bucket has refilled
+	time.Sleep(60 * time.Millisecond)
+	start = time.Now()
+	c.Write(make([]byte, 1000))
+	if d := time.Since(start); d > 20*time.Millisecond {
+		t.Errorf("write within the refilled burst took %v", d)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	c, arrived := pipe(t, Config{Delay: 100 * time.Millisecond})
+	defer c.Close()
+	start := time.Now()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 20*time.Millisecond {
+		t.Errorf("Write blocked for %v; the delay is on the way, not in Write", d)
+	}
+	got := waitFor(t, arrived, 1)
+	if d := got[0].at.Sub(start); d < 100*time.Millisecond || d > 500*time.Millisecond {
+		t.Errorf("arrived after %v, want about 100ms", d)
+	}
+}
+
+func TestJitterKeepsOrder(t *testing.T) {
+	c, arrived := pipe(t, Config{Delay: 30 * time.Millisecond, Jitter: 25 * time.Millisecond})
+	defer c.Close()
+	var want []byte
+	start := time.Now()
+	for i := range 50 {
+		b := []byte{byte(i)}
+		want = append(want, b...)
+		c.Write(b)
+	}
+	var all []byte
+	deadline := time.Now().Add(5 * time.Second)
+	for len(all) < len(want) && time.Now().Before(deadline) {
+		all = all[:0]
+		for _, a := range arrived() {
+			all = append(all, a.data...)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !bytes.Equal(all, want) {
+		t.Errorf("received % x, want % x", all, want)
+	}
+	if first := arrived()[0].at.Sub(start); first < 5*time.Millisecond {
+		t.Errorf("first byte after %v, below Delay-Jitter", first)
+	}
+}
+
+func TestClose(t *testing.T) {
+	c, arrived := pipe(t, Config{Delay: 50 * time.Millisecond})
+	c.Write([]byte("in flight"))
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitFor(t, arrived, 1); string(got[0].data) != "in flight" {
+		t.Errorf("Close delivered %q", got[0].data)
+	}
+	if _, err := c.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Write after Close = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	near, far := net.Pipe()
+	far.Close()
+	c := Wrap(near, Config{Delay: time.Millisecond})
+	defer c.Close()
+	c.Write([]byte("lost")) // accepted; fails on delivery
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := c.Write([]byte("x"))
+		if errors.Is(err, io.ErrClosedPipe) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Write = %v, want the delivery error", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
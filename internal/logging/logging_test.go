@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// setup runs Setup and restores the previous default logger afterwards.
+func setup(t *testing.T, level, format string) (*bytes.Buffer, error) {
+	t.Helper()
+	old := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(old) })
+	var buf bytes.Buffer
+	return &buf, Setup(&buf, level, format)
+}
+
+func TestSetupRejects(t *testing.T) {
+	tests := []struct {
+		level, format string
+		want          string
+	}{
+		{"verbose", "text", "invalid level"},
+		{"", "text", "invalid level"},
+		{"info", "xml", "invalid format"},
+		{"info", "logfmt", "invalid format"},
+	}
+	for _, tt := range tests {
+		before := slog.Default()
+		_, err := setup(t, tt.level, tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Setup(%q, %q) = %v, want %q", tt.level, tt.format, err, tt.want)
+		}
+		if slog.Default() != before {
+			t.Errorf("Setup(%q, %q) replaced the default logger", tt.level, tt.format)
+		}
+	}
+}
+
+func TestSetupJSON(t *testing.T) {
+	buf, err := setup(t, " WARN ", "JSON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slog.Info("dropped below the level")
+	slog.Warn("queue full", "buoy", "41001", "dropped", 3)
+	slog.Error("publish failed", "err", `broker said "no"`)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines logged, want 2:\n%s", len(lines), buf)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("line %q is not JSON: %v", lines[0], err)
+	}
+	if rec["level"] != "WARN" || rec["msg"] != "queue full" || rec["buoy"] != "41001" || rec["dropped"] != 3.0 {
+		t.Errorf("logged %v", rec)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil || rec["err"] != `broker said "no"` {
+		t.Errorf("line %q: %v", lines[1], err)
+	}
+}
+
+func TestSetupText(t *testing.T) {
+	for _, format := range []string{"", "text"} {
+		buf, err := setup(t, "debug", format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slog.Debug("connected", "broker", "tcp://localhost:1883")
+		if got := buf.String(); !strings.Contains(got, "level=DEBUG msg=connected broker=tcp://localhost:1883") || json.Valid(buf.Bytes()) {
+			t.Errorf("format %q logged %q", format, got)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package mqttbridge republishes messages to a second MQTT broker. The
+// output connection has its own lifecycle; while it is down, messages are
+// held in a bounded buffer and delivered in order once it is back.
+package mqttbridge
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+	"cloudletsapps/internal/mqttutil"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ErrBufferFull is returned by Publish when the outage buffer is exhausted.
+var ErrBufferFull = errors.New("mqttbridge: buffer full")
+
+type Config struct {
+	Broker   string
+	ClientID string
+	// TopicPattern may contain {buoy_id}.
+	TopicPattern string
+	BufferSize   int
+	QoS          byte
+	// TLSConfig is used for ssl:// output brokers; nil uses paho's default.
+	TLSConfig *tls.Config
+	// Log receives diagnostics; nil discards them.
+	Log io.Writer
+	// Reconnect paces the output connection's retries; the zero value
+	// uses backoff.DefaultPolicy.
+	Reconnect backoff.Policy
+}
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+type Bridge struct {
+	cfg    Config
+	client MQTT.Client
+	queue  chan message
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func New(cfg Config) *Bridge {
+	if cfg.BufferSize < 1 {
+		cfg.BufferSize = 1
+	}
+	if cfg.Log == nil {
+		cfg.Log = io.Discard
+	}
+	return &Bridge{
+		cfg:   cfg,
+		queue: make(chan message, cfg.BufferSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Start connects to the output broker in the background and begins
+// forwarding. It does not wait for the first connection.
+func (b *Bridge) Start() {
+	opts := MQTT.NewClientOptions().AddBroker(b.cfg.Broker)
+	opts.SetClientID(b.cfg.ClientID)
+	opts.SetKeepAlive(10 * time.Second)
+	opts.SetConnectTimeout(10 * time.Second)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	if b.cfg.TLSConfig != nil {
+		opts.SetTLSConfig(b.cfg.TLSConfig)
+	}
+	opts.OnConnectionLost = func(_ MQTT.Client, err error) {
+		fmt.Fprintf(b.cfg.Log, "[Bridge] output connection lost: %v\n", err)
+	}
+	policy := b.cfg.Reconnect
+	if policy == (backoff.Policy{}) {
+		policy = backoff.DefaultPolicy
+	}
+	mqttutil.ApplyReconnectBackoff(opts, policy)
+	b.client = MQTT.NewClient(opts)
+	b.client.Connect()
+	go b.forward()
+}
+
+// Topic expands the output topic for buoyID.
+func (b *Bridge) Topic(buoyID string) string {
+	return strings.ReplaceAll(b.cfg.TopicPattern, "{buoy_id}", buoyID)
+}
+
+// Publish queues payload for the output broker without blocking.
+func (b *Bridge) Publish(buoyID string, payload []byte) error {
+	select {
+	case b.queue <- message{topic: b.Topic(buoyID), payload: payload}:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+func (b *Bridge) forward() {
+	defer close(b.done)
+	for {
+		select {
+		case <-b.stop:
+			return
+		case m := <-b.queue:
+			for !b.send(m) {
+				select {
+				case <-b.stop:
+					return
+				case <-time.After(time.Second):
+				}
+			}
+		}
+	}
+}
+
+func (b *Bridge) send(m message) bool {
+	if !b.client.IsConnectionOpen() {
+		return false
+	}
+	token := b.client.Publish(m.topic, b.cfg.QoS, false, m.payload)
+	if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		fmt.Fprintf(b.cfg.Log, "[Bridge] publish to %s failed: %v\n", m.topic, token.Error())
+		return false
+	}
+	return true
+}
+
+// Pending returns the number of buffered messages.
+func (b *Bridge) Pending() int { return len(b.queue) }
+
+// Close stops forwarding and disconnects; buffered messages are discarded.
+func (b *Bridge) Close() {
+	close(b.stop)
+	<-b.done
+	if b.client != nil {
+		b.client.Disconnect(250)
+	}
+}
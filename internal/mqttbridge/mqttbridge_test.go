@@ -0,0 +1,198 @@
+package mqttbridge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+)
+
+// fakeBroker accepts connections and records PUBLISH packets, acknowledging
+// QoS 1 ones.
+type fakeBroker struct {
+	ln net.Listener
+
+	mu        sync.Mutex
+	published []string // "topic payload"
+}
+
+// startBroker listens on addr; an empty addr picks a free port.
+func startBroker(t *testing.T, addr string) *fakeBroker {
+	t.Helper()
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		flags, body, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		switch flags >> 4 {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH: topic, packet id if QoS > 0, payload
+			n := int(body[0])<<8 | int(body[1])
+			rest := body[2+n:]
+			if qos := flags >> 1 & 3; qos > 0 {
+				conn.Write([]byte{0x40, 2, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			b.mu.Lock()
+			b.published = append(b.published, string(body[2:2+n])+" "+string(rest))
+			b.mu.Unlock()
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func readPacket(r io.Reader) (flags byte, body []byte, err error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, nil, err
+	}
+	flags = b[0]
+	n, shift := 0, 0
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n |= int(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body = make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return flags, body, err
+}
+
+// waitPublished waits until the broker has seen n messages and returns them.
+func (b *fakeBroker) waitPublished(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		b.mu.Lock()
+		got := append([]string(nil), b.published...)
+		b.mu.Unlock()
+		if len(got) >= n {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("broker saw %v, want %d messages", got, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newBridge(addr string, bufferSize int) *Bridge {
+	return New(Config{
+		Broker:       "tcp://" + addr,
+		ClientID:     "bridge-test",
+		TopicPattern: "out/{buoy_id}/predictions",
+		BufferSize:   bufferSize,
+		QoS:          1,
+		Reconnect:    backoff.Policy{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond},
+	})
+}
+
+func TestForward(t *testing.T) {
+	broker := startBroker(t, "")
+	b := newBridge(broker.ln.Addr().String(), 10)
+	b.Start()
+	defer b.Close()
+
+	for i, buoy := range []string{"b1", "b2", "b1"} {
+		if err := b.Publish(buoy, []byte(fmt.Sprint("m", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"out/b1/predictions m0", "out/b2/predictions m1", "out/b1/predictions m2"}
+	if got := broker.waitPublished(t, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+func TestBufferDuringOutage(t *testing.T) {
+	// reserve an address for the output broker, which starts out down
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	b := newBridge(addr, 3)
+	b.Start()
+	defer b.Close()
+
+	// the forwarder holds one message while it waits, so the bridge takes
+	// up to one more than the buffer
+	var want []string
+	for i := range 10 {
+		err := b.Publish("b1", []byte(fmt.Sprint("m", i)))
+		if errors.Is(err, ErrBufferFull) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, fmt.Sprint("out/b1/predictions m", i))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(want) < 3 || len(want) > 4 {
+		t.Fatalf("accepted %d messages during the outage with a buffer of 3", len(want))
+	}
+
+	broker := startBroker(t, addr)
+	if got := broker.waitPublished(t, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+	if b.Pending() != 0 {
+		t.Errorf("%d messages still pending", b.Pending())
+	}
+}
+
+func TestTopic(t *testing.T) {
+	tests := []struct {
+		pattern, buoy, want string
+	}{
+		{"out/{buoy_id}/predictions", "b7", "out/b7/predictions"},
+		{"all/predictions", "b7", "all/predictions"},
+		{"{buoy_id}/{buoy_id}", "b7", "b7/b7"},
+	}
+	for _, tt := range tests {
+		if got := New(Config{TopicPattern: tt.pattern}).Topic(tt.buoy); got != tt.want {
+			t.Errorf("Topic(%q) with %q = %q, want %q", tt.buoy, tt.pattern, got, tt.want)
+		}
+	}
+}
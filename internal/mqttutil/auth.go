@@ -0,0 +1,48 @@
+package mqttutil
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Credentials are the broker username and password, given directly or read
+// from File (username on the first line, password on the second).
+type Credentials struct {
+	Username string
+	Password string
+	File     string
+}
+
+// Load fills in whatever Username/Password leave empty from File.
+func (c Credentials) Load() (Credentials, error) {
+	if c.File == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return c, fmt.Errorf("mqttutil: read credentials: %w", err)
+	}
+	lines := strings.SplitN(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n", 3)
+	if c.Username == "" {
+		c.Username = strings.TrimSpace(lines[0])
+	}
+	if c.Password == "" && len(lines) > 1 {
+		c.Password = strings.TrimRight(lines[1], "\n")
+	}
+	if c.Username == "" {
+		return c, fmt.Errorf("mqttutil: no username in %s", c.File)
+	}
+	return c, nil
+}
+
+// Apply sets the credentials on opts; anonymous when no username is set.
+func (c Credentials) Apply(opts *MQTT.ClientOptions) {
+	if c.Username == "" {
+		return
+	}
+	opts.SetUsername(c.Username)
+	opts.SetPassword(c.Password)
+}
@@ -0,0 +1,218 @@
+package mqttutil
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Defaults for ClientSettings fields left at zero.
+const (
+	DefaultKeepAlive      = 5 * time.Second
+	DefaultPingTimeout    = 3 * time.Second
+	DefaultConnectTimeout = 10 * time.Second
+)
+
+// ErrSubscribeTimeout is returned by ConnectAndSubscribe when the broker
+// does not acknowledge the subscription in time.
+var ErrSubscribeTimeout = errors.New("mqttutil: no SUBACK in time")
+
+// ClientSettings are the broker connection settings shared by every
+// client a binary opens.
+type ClientSettings struct {
+	KeepAlive      time.Duration
+	PingTimeout    time.Duration
+	ConnectTimeout time.Duration
+	Headers        http.Header // WebSocket upgrade headers, e.g. the sticky-session cookie
+	TLS            *tls.Config // nil uses paho's default for ssl:// brokers
+	Auth           Credentials
+}
+
+// NewClientOptions returns options for a clean-session client of broker
+// with s applied. Callers add their handlers, LWT and dialer on top.
+func (s ClientSettings) NewClientOptions(broker, clientID string) *MQTT.ClientOptions {
+	opts := MQTT.NewClientOptions().AddBroker(broker)
+	opts.SetClientID(clientID)
+	opts.SetKeepAlive(orDefault(s.KeepAlive, DefaultKeepAlive))
+	opts.SetPingTimeout(orDefault(s.PingTimeout, DefaultPingTimeout))
+	opts.SetConnectTimeout(orDefault(s.ConnectTimeout, DefaultConnectTimeout))
+	opts.SetCleanSession(true)
+	if s.Headers != nil {
+		opts.SetHTTPHeaders(s.Headers)
+	}
+	if s.TLS != nil {
+		opts.SetTLSConfig(s.TLS)
+	}
+	s.Auth.Apply(opts)
+	return opts
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// ConnectAndSubscribe connects a client built from opts and subscribes to
+// topic, waiting at most subscribeTimeout for the SUBACK (0 waits as long
+// as paho does). On any failure the client is disconnected.
+func ConnectAndSubscribe(opts *MQTT.ClientOptions, topic string, qos byte, handler MQTT.MessageHandler, subscribeTimeout time.Duration) (MQTT.Client, error) {
+	return ConnectAndSubscribeAll(opts, []string{topic}, qos, handler, subscribeTimeout)
+}
+
+// ConnectAndSubscribeAll is ConnectAndSubscribe for several topic filters,
+// subscribed in one SUBSCRIBE packet.
+func ConnectAndSubscribeAll(opts *MQTT.ClientOptions, topics []string, qos byte, handler MQTT.MessageHandler, subscribeTimeout time.Duration) (MQTT.Client, error) {
+	c := MQTT.NewClient(opts)
+	if token := c.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("connect: %w", token.Error())
+	}
+	topic := strings.Join(topics, ", ")
+	var token MQTT.Token
+	if len(topics) == 1 {
+		token = c.Subscribe(topics[0], qos, handler)
+	} else {
+		filters := make(map[string]byte, len(topics))
+		for _, t := range topics {
+			filters[t] = qos
+		}
+		token = c.SubscribeMultiple(filters, handler)
+	}
+	if subscribeTimeout > 0 {
+		if !token.WaitTimeout(subscribeTimeout) {
+			c.Disconnect(250)
+			return nil, fmt.Errorf("subscribe to %s: %w", topic, ErrSubscribeTimeout)
+		}
+	} else {
+		token.Wait()
+	}
+	if err := token.Error(); err != nil {
+		c.Disconnect(250)
+		return nil, fmt.Errorf("subscribe to %s: %w", topic, err)
+	}
+	return c, nil
+}
+
+// Retry controls ConnectWithRetry.
+type Retry struct {
+	Attempts int              // 0 retries forever
+	Delay    time.Duration    // pause after a failed attempt
+	Backoff  *backoff.Backoff // if set, grows the pause instead of Delay
+	// OnError, if set, is told about every failed attempt (1-based).
+	OnError func(attempt int, err error)
+}
+
+// ConnectWithRetry calls connect until it succeeds or r.Attempts attempts
+// have failed, in which case the last error is returned.
+func ConnectWithRetry(r Retry, connect func(attempt int) (MQTT.Client, error)) (MQTT.Client, error) {
+	var err error
+	for attempt := 1; r.Attempts <= 0 || attempt <= r.Attempts; attempt++ {
+		var c MQTT.Client
+		if c, err = connect(attempt); err == nil {
+			return c, nil
+		}
+		if r.OnError != nil {
+			r.OnError(attempt, err)
+		}
+		if r.Attempts <= 0 || attempt < r.Attempts {
+			if r.Backoff != nil {
+				time.Sleep(r.Backoff.Next())
+			} else {
+				time.Sleep(r.Delay)
+			}
+		}
+	}
+	return nil, err
+}
+
+// Resubscriber replaces a lost connection with a freshly connected and
+// subscribed one. Clean sessions drop subscriptions on disconnect, so
+// Connect must subscribe again as well.
+type Resubscriber struct {
+	Lost        <-chan struct{}             // signalled when the current connection is lost
+	Connect     func() (MQTT.Client, error) // connect and subscribe
+	OnLost      func()                      // optional: tear down the old client
+	OnReconnect func(MQTT.Client)           // install the new client
+	OnError     func(err error)             // optional: a reconnect round failed
+	RetryDelay  time.Duration               // pause between failed rounds
+	Backoff     *backoff.Backoff            // if set, grows the pause instead of RetryDelay
+}
+
+// AutoResubscribe runs r in the background until r.Lost is closed.
+func AutoResubscribe(r Resubscriber) {
+	go func() {
+		for range r.Lost {
+			if r.OnLost != nil {
+				r.OnLost()
+			}
+			for {
+				c, err := r.Connect()
+				if err == nil {
+					if r.Backoff != nil {
+						r.Backoff.Reset()
+					}
+					r.OnReconnect(c)
+					break
+				}
+				if r.OnError != nil {
+					r.OnError(err)
+				}
+				if r.Backoff != nil {
+					time.Sleep(r.Backoff.Next())
+				} else {
+					time.Sleep(r.RetryDelay)
+				}
+			}
+		}
+	}()
+}
+
+// ApplyReconnectBackoff replaces paho's retry timing for a client that
+// connects and reconnects by itself (ConnectRetry, AutoReconnect): the
+// fixed ConnectRetryInterval and paho's jitter-free doubling give way to
+// p, so many clients that lose the broker together spread their attempts.
+// Call it after setting OnConnect, which it wraps to reset the backoff.
+func ApplyReconnectBackoff(opts *MQTT.ClientOptions, p backoff.Policy) {
+	b := p.New()
+	// set once an attempt has been made since the last connect; the next
+	// attempt waits first
+	var failed atomic.Bool
+	opts.SetConnectRetryInterval(0)
+	opts.SetMaxReconnectInterval(0)
+	prevReconnecting := opts.OnReconnecting
+	opts.SetReconnectingHandler(func(c MQTT.Client, o *MQTT.ClientOptions) {
+		// a lost connection waits too, or every client would be back at once
+		failed.Store(true)
+		if prevReconnecting != nil {
+			prevReconnecting(c, o)
+		}
+	})
+	prevAttempt := opts.OnConnectAttempt
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, cfg *tls.Config) *tls.Config {
+		if failed.Swap(true) {
+			time.Sleep(b.Next())
+		}
+		if prevAttempt != nil {
+			return prevAttempt(broker, cfg)
+		}
+		return cfg
+	})
+	prevConnect := opts.OnConnect
+	opts.SetOnConnectHandler(func(c MQTT.Client) {
+		failed.Store(false)
+		b.Reset()
+		if prevConnect != nil {
+			prevConnect(c)
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package mqttutil
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeBroker speaks just enough MQTT 3.1.1 for a client to connect and
+// subscribe: CONNACK, SUBACK (unless withheld) and PINGRESP.
+type fakeBroker struct {
+	ln       net.Listener
+	noSuback bool
+	subs     atomic.Int32
+}
+
+func startBroker(t *testing.T, noSuback bool) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, noSuback: noSuback}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) url() string { return "tcp://" + b.ln.Addr().String() }
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		kind, body, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		switch kind {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 8: // SUBSCRIBE: packet id, then (topic, qos) pairs
+			b.subs.Add(1)
+			if b.noSuback {
+				continue
+			}
+			ack := []byte{0x90, 2, body[0], body[1]}
+			for rest := body[2:]; len(rest) >= 2; {
+				n := int(rest[0])<<8 | int(rest[1])
+				ack = append(ack, rest[2+n])
+				ack[1]++
+				rest = rest[3+n:]
+			}
+			conn.Write(ack)
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func readPacket(r io.Reader) (kind byte, body []byte, err error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, nil, err
+	}
+	kind = b[0] >> 4
+	n, shift := 0, 0
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n |= int(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body = make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return kind, body, err
+}
+
+func TestConnectAndSubscribe(t *testing.T) {
+	tests := []struct {
+		name     string
+		topics   []string
+		noSuback bool
+		wantErr  error
+	}{
+		{"one topic", []string{"sensors/+/npz"}, false, nil},
+		{"several topics", []string{"a/#", "b/#", "c"}, false, nil},
+		{"no suback", []string{"sensors/+/npz"}, true, ErrSubscribeTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := startBroker(t, tt.noSuback)
+			opts := ClientSettings{ConnectTimeout: time.Second}.NewClientOptions(b.url(), "test")
+			c, err := ConnectAndSubscribeAll(opts, tt.topics, 1, func(MQTT.Client, MQTT.Message) {}, 200*time.Millisecond)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer c.Disconnect(0)
+				if !c.IsConnected() {
+					t.Error("client not connected")
+				}
+			} else if c != nil {
+				t.Error("client returned with an error")
+			}
+			if b.subs.Load() != 1 {
+				t.Errorf("%d SUBSCRIBE packets, want 1", b.subs.Load())
+			}
+		})
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	opts := ClientSettings{ConnectTimeout: time.Second}.NewClientOptions("tcp://"+addr, "test")
+	if _, err := ConnectAndSubscribe(opts, "t", 0, nil, time.Second); err == nil {
+		t.Fatal("connected to a closed port")
+	}
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	errDown := errors.New("broker down")
+	tests := []struct {
+		name      string
+		attempts  int
+		failures  int // attempts failing before one succeeds
+		wantCalls int
+		wantErr   bool
+	}{
+		{"first try", 3, 0, 1, false},
+		{"after failures", 3, 2, 3, false},
+		{"out of attempts", 3, 5, 3, true},
+		{"forever", 0, 7, 8, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var reported []int
+			r := Retry{
+				Attempts: tt.attempts,
+				Delay:    time.Millisecond,
+				OnError:  func(attempt int, err error) { reported = append(reported, attempt) },
+			}
+			_, err := ConnectWithRetry(r, func(attempt int) (MQTT.Client, error) {
+				calls++
+				if attempt != calls {
+					t.Errorf("attempt %d on call %d", attempt, calls)
+				}
+				if calls <= tt.failures {
+					return nil, errDown
+				}
+				return nil, nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errDown) {
+				t.Errorf("err = %v, want the last connect error", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls, tt.wantCalls)
+			}
+			if len(reported) != min(tt.failures, tt.wantCalls) {
+				t.Errorf("OnError saw attempts %v", reported)
+			}
+		})
+	}
+}
+
+func TestConnectWithRetryBackoff(t *testing.T) {
+	b := backoff.New(10*time.Millisecond, 40*time.Millisecond)
+	start := time.Now()
+	_, err := ConnectWithRetry(Retry{Attempts: 4, Delay: time.Hour, Backoff: b}, func(int) (MQTT.Client, error) {
+		return nil, errors.New("down")
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	// 10 + 20 + 40ms between four attempts, none after the last
+	if took := time.Since(start); took < 70*time.Millisecond || took > time.Second {
+		t.Errorf("took %v, want the backoff delays rather than Delay", took)
+	}
+}
+
+func TestAutoResubscribe(t *testing.T) {
+	lost := make(chan struct{})
+	reconnected := make(chan MQTT.Client)
+	var mu sync.Mutex
+	var events []string
+	record := func(e string) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+	failures := 2
+	b := backoff.New(time.Millisecond, time.Millisecond)
+	AutoResubscribe(Resubscriber{
+		Lost: lost,
+		Connect: func() (MQTT.Client, error) {
+			if failures > 0 {
+				failures--
+				return nil, errors.New("down")
+			}
+			return MQTT.NewClient(MQTT.NewClientOptions()), nil
+		},
+		OnLost:      func() { record("lost") },
+		OnReconnect: func(c MQTT.Client) { record("reconnect"); reconnected <- c },
+		OnError:     func(error) { record("error") },
+		Backoff:     b,
+	})
+
+	for round := range 2 {
+		lost <- struct{}{}
+		select {
+		case c := <-reconnected:
+			if c == nil {
+				t.Fatal("nil client installed")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("round %d: no reconnect", round)
+		}
+	}
+	close(lost)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"lost", "error", "error", "reconnect", "lost", "reconnect"}
+	if len(events) != len(want) {
+		t.Fatalf("events %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events %v, want %v", events, want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Package mqttutil holds connection helpers shared by the MQTT binaries.
+package mqttutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+
+	"cloudletsapps/internal/linksim"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DialOptions tune how Dial opens the broker connection.
+type DialOptions struct {
+	// NoDelay sets TCP_NODELAY on TCP and TLS connections. Go already
+	// enables it by default; false turns Nagle's algorithm back on.
+	NoDelay bool
+	// ServerName, if set, picks the TLS server name (SNI) for uri.
+	ServerName func(uri *url.URL) (string, error)
+	// VerifyConn, if set, is called after the TLS handshake; an error
+	// fails the connect attempt.
+	VerifyConn func(conn *tls.Conn) error
+	// Link, if enabled, sends outbound traffic through an emulated slow
+	// link (below TLS and WebSocket framing).
+	Link linksim.Config
+}
+
+// Dial opens the network connection for uri the way paho would for the
+// tcp, ssl and ws schemes, applying d on top.
+func Dial(uri *url.URL, options MQTT.ClientOptions, d DialOptions) (net.Conn, error) {
+	switch uri.Scheme {
+	case "ws", "wss":
+		return dialWebsocket(uri, options, d)
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
+	default:
+		conn, err := net.DialTimeout("tcp", uri.Host, options.ConnectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		setNoDelay(conn, d.NoDelay)
+		return linksim.Wrap(conn, d.Link), nil
+	}
+	cfg, err := tlsConfig(uri, options, d)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := net.DialTimeout("tcp", uri.Host, options.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	setNoDelay(raw, d.NoDelay)
+	raw = linksim.Wrap(raw, d.Link)
+	conn := tls.Client(raw, cfg)
+	ctx := context.Background()
+	if options.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.ConnectTimeout)
+		defer cancel()
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	if d.VerifyConn != nil {
+		if err := d.VerifyConn(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// OpenConnectionFn adapts Dial for ClientOptions.SetCustomOpenConnectionFn.
+func OpenConnectionFn(d DialOptions) MQTT.OpenConnectionFunc {
+	return func(uri *url.URL, options MQTT.ClientOptions) (net.Conn, error) {
+		return Dial(uri, options, d)
+	}
+}
+
+func tlsConfig(uri *url.URL, options MQTT.ClientOptions, d DialOptions) (*tls.Config, error) {
+	cfg := options.TLSConfig
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg = cfg.Clone()
+	if d.ServerName != nil {
+		name, err := d.ServerName(uri)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = name
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = uri.Hostname()
+	}
+	return cfg, nil
+}
+
+func setNoDelay(conn net.Conn, noDelay bool) {
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetNoDelay(noDelay)
+	}
+}
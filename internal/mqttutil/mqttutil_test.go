@@ -0,0 +1,80 @@
+package mqttutil
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// echoBroker sends every packet it receives straight back, standing in
+// for a broker delivering a publish to a subscriber on the same link.
+func echoBroker(b *testing.B) string {
+	b.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					kind, body, err := readPacket(conn)
+					if err != nil {
+						return
+					}
+					out := append([]byte{kind << 4, byte(len(body))}, body...)
+					if _, err := conn.Write(out); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// BenchmarkNoDelay measures the round trip of a small QoS 0 PUBLISH
+// written as fixed header and body, the write-write-read pattern that
+// Nagle's algorithm and delayed ACKs stall on, with and without
+// TCP_NODELAY.
+func BenchmarkNoDelay(b *testing.B) {
+	topic, payload := "sensors/b1/npz", []byte("0123456789")
+	body := append([]byte{0, byte(len(topic))}, topic...)
+	body = append(body, payload...)
+	header := []byte{0x30, byte(len(body))}
+	for _, tt := range []struct {
+		name    string
+		noDelay bool
+	}{{"nodelay", true}, {"nagle", false}} {
+		b.Run(tt.name, func(b *testing.B) {
+			uri := &url.URL{Scheme: "tcp", Host: echoBroker(b)}
+			conn, err := Dial(uri, *MQTT.NewClientOptions(), DialOptions{NoDelay: tt.noDelay})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			echo := make([]byte, len(header)+len(body))
+			b.ResetTimer()
+			for range b.N {
+				if _, err := conn.Write(header); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := conn.Write(body); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(conn, echo); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package mqttutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// TLSFiles names the PEM files used for ssl:// (and wss://) brokers.
+type TLSFiles struct {
+	CAFile   string // CA bundle for the broker certificate; empty uses the system roots
+	CertFile string // client certificate for mutual TLS
+	KeyFile  string // client private key for mutual TLS
+	// InsecureSkipVerify disables broker certificate checks (testing only).
+	InsecureSkipVerify bool
+}
+
+// LoadTLSConfig builds the TLS configuration for f, or returns nil when f
+// sets nothing so paho's defaults apply.
+func LoadTLSConfig(f TLSFiles) (*tls.Config, error) {
+	if f == (TLSFiles{}) {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: f.InsecureSkipVerify}
+	if f.CAFile != "" {
+		pem, err := os.ReadFile(f.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("mqttutil: read CA cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mqttutil: no certificates found in %s", f.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (f.CertFile == "") != (f.KeyFile == "") {
+		return nil, errors.New("mqttutil: client certificate and key must be given together")
+	}
+	if f.CertFile != "" {
+		return WithClientCert(cfg, f.CertFile, f.KeyFile)
+	}
+	return cfg, nil
+}
+
+// WithClientCert returns a copy of cfg that presents the client
+// certificate in certFile and keyFile. A nil cfg starts from the settings
+// LoadTLSConfig uses.
+func WithClientCert(cfg *tls.Config, certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("mqttutil: load client certificate: %w", err)
+	}
+	if cfg == nil {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	} else {
+		cfg = cfg.Clone()
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+	return cfg, nil
+}
+
+// DeviceCertFiles names the client certificate and key of one device in
+// dir: <id>.crt and <id>.key.
+func DeviceCertFiles(dir, id string) (certFile, keyFile string) {
+	return filepath.Join(dir, id+".crt"), filepath.Join(dir, id+".key")
+}
+
+// ClientCertCN returns the common name of cfg's client certificate, which
+// brokers such as mosquitto (use_identity_as_username) key ACLs on.
+func ClientCertCN(cfg *tls.Config) string {
+	if cfg == nil || len(cfg.Certificates) == 0 || cfg.Certificates[0].Leaf == nil {
+		return ""
+	}
+	return cfg.Certificates[0].Leaf.Subject.CommonName
+}
@@ -0,0 +1,119 @@
+package mqttutil
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"cloudletsapps/internal/linksim"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+)
+
+// dialWebsocket opens an MQTT-over-WebSocket connection for a ws:// or
+// wss:// uri, keeping its path (e.g. wss://broker.example.com/mqtt; the
+// port defaults to 80 or 443). Unlike paho's own dialer it applies d:
+// TCP_NODELAY and the emulated link on the underlying connection and
+// VerifyConn after the TLS handshake.
+func dialWebsocket(uri *url.URL, options MQTT.ClientOptions, d DialOptions) (net.Conn, error) {
+	timeout := options.ConnectTimeout
+	if timeout == 0 {
+		timeout = DefaultConnectTimeout
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: timeout,
+		Subprotocols:     []string{"mqtt"},
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{Timeout: timeout}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			setNoDelay(conn, d.NoDelay)
+			return linksim.Wrap(conn, d.Link), nil
+		},
+	}
+	if o := options.WebsocketOptions; o != nil {
+		dialer.ReadBufferSize, dialer.WriteBufferSize = o.ReadBufferSize, o.WriteBufferSize
+		if o.Proxy != nil {
+			dialer.Proxy = o.Proxy
+		}
+	}
+	if uri.Scheme == "wss" {
+		cfg, err := tlsConfig(uri, options, d)
+		if err != nil {
+			return nil, err
+		}
+		dialer.TLSClientConfig = cfg
+	}
+	dialURI := *uri
+	dialURI.User = nil // credentials go in the MQTT CONNECT, not the upgrade
+	ws, resp, err := dialer.Dial(dialURI.String(), options.HTTPHeaders)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("websocket upgrade: %s: %w", resp.Status, err)
+		}
+		return nil, err
+	}
+	if tc, ok := ws.UnderlyingConn().(*tls.Conn); ok && d.VerifyConn != nil {
+		if err := d.VerifyConn(tc); err != nil {
+			ws.Close()
+			return nil, err
+		}
+	}
+	return &wsConn{Conn: ws}, nil
+}
+
+// wsConn turns a WebSocket into the byte stream paho expects: writes are
+// binary messages, reads run across message boundaries.
+type wsConn struct {
+	*websocket.Conn
+	rmu sync.Mutex
+	r   io.Reader
+	wmu sync.Mutex
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for {
+		if c.r == nil {
+			var err error
+			if _, c.r, err = c.NextReader(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := c.r.Read(p)
+		if err == io.EOF {
+			c.r = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
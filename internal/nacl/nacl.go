@@ -0,0 +1,105 @@
+// Package nacl encrypts message payloads end to end between publisher and
+// satellite with NaCl box (Curve25519, XSalsa20, Poly1305), so a broker
+// relaying them cannot read the content.
+//
+// A sealed message is the 24-byte random nonce followed by the box.
+// Keys are stored as base64 text files holding the 32 raw key bytes.
+package nacl
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const nonceSize = 24
+
+// ErrDecrypt is returned when a message cannot be opened with the given keys.
+var ErrDecrypt = errors.New("nacl: decryption failed")
+
+// Key is a Curve25519 public or private key.
+type Key = [32]byte
+
+// Encrypt seals plaintext for the holder of peerPublic's private key.
+func Encrypt(plaintext []byte, peerPublic, private *Key) ([]byte, error) {
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return box.Seal(nonce[:], plaintext, &nonce, peerPublic, private), nil
+}
+
+// Decrypt opens a message produced by Encrypt from the holder of
+// peerPublic's private key.
+func Decrypt(ciphertext []byte, peerPublic, private *Key) ([]byte, error) {
+	if len(ciphertext) < nonceSize+box.Overhead {
+		return nil, ErrDecrypt
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], ciphertext[:nonceSize])
+	plain, ok := box.Open(nil, ciphertext[nonceSize:], &nonce, peerPublic, private)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// GenerateKey returns a new random key pair.
+func GenerateKey() (public, private *Key, err error) {
+	return box.GenerateKey(rand.Reader)
+}
+
+// LoadKey reads a key file written by WriteKey.
+func LoadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(raw) != len(Key{}) {
+		return nil, fmt.Errorf("nacl: %s is not a base64-encoded 32-byte key", path)
+	}
+	var k Key
+	copy(k[:], raw)
+	return &k, nil
+}
+
+// WriteKey stores k at path, readable by the owner only.
+func WriteKey(path string, k *Key) error {
+	return os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(k[:])+"\n"), 0600)
+}
+
+// LoadOrGenerate loads the private key at privPath, or creates a new key
+// pair there (the public half goes to privPath+".pub") if it does not
+// exist. created reports whether a new pair was written.
+func LoadOrGenerate(privPath string) (public, private *Key, created bool, err error) {
+	private, err = LoadKey(privPath)
+	if err == nil {
+		pub, err := curve25519.X25519(private[:], curve25519.Basepoint)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		public = new(Key)
+		copy(public[:], pub)
+		return public, private, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, false, err
+	}
+	if public, private, err = GenerateKey(); err != nil {
+		return nil, nil, false, err
+	}
+	if err := WriteKey(privPath, private); err != nil {
+		return nil, nil, false, err
+	}
+	if err := WriteKey(privPath+".pub", public); err != nil {
+		return nil, nil, false, err
+	}
+	return public, private, true, nil
+}
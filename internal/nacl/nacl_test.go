@@ -0,0 +1,125 @@
+package nacl
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func keyPair(t *testing.T) (public, private *Key) {
+	t.Helper()
+	public, private, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
+
+func TestRoundTrip(t *testing.T) {
+	pubPublic, pubPrivate := keyPair(t)
+	satPublic, satPrivate := keyPair(t)
+	for _, plain := range [][]byte{
+		[]byte(`{"buoy_id":"b1","filename":"a.npz","data":"UEsDBA=="}`),
+		{},
+		bytes.Repeat([]byte{0xaa}, 1<<20),
+	} {
+		sealed, err := Encrypt(plain, satPublic, pubPrivate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plain) > 0 && bytes.Contains(sealed, plain) {
+			t.Error("plaintext visible in the sealed message")
+		}
+		got, err := Decrypt(sealed, pubPublic, satPrivate)
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("round trip of %d bytes gave %d bytes", len(plain), len(got))
+		}
+	}
+}
+
+func TestNonceIsRandom(t *testing.T) {
+	_, private := keyPair(t)
+	public, _ := keyPair(t)
+	a, _ := Encrypt([]byte("x"), public, private)
+	b, _ := Encrypt([]byte("x"), public, private)
+	if bytes.Equal(a, b) {
+		t.Error("same message sealed twice gave identical output")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	pubPublic, pubPrivate := keyPair(t)
+	satPublic, satPrivate := keyPair(t)
+	otherPublic, otherPrivate := keyPair(t)
+	sealed, err := Encrypt([]byte("payload"), satPublic, pubPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name       string
+		msg        []byte
+		peer, priv *Key
+	}{
+		{"wrong recipient", sealed, pubPublic, otherPrivate},
+		{"wrong sender", sealed, otherPublic, satPrivate},
+		{"tampered", tampered, pubPublic, satPrivate},
+		{"truncated", sealed[:nonceSize+4], pubPublic, satPrivate},
+		{"plaintext", []byte(`{"buoy_id":"b1"}`), pubPublic, satPrivate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.msg, tt.peer, tt.priv); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Decrypt = %v, want ErrDecrypt", err)
+			}
+		})
+	}
+}
+
+func TestKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	priv := filepath.Join(dir, "satellite.key")
+
+	public, private, created, err := LoadOrGenerate(priv)
+	if err != nil || !created {
+		t.Fatalf("LoadOrGenerate = created %v, %v", created, err)
+	}
+	if st, err := os.Stat(priv); err != nil || st.Mode().Perm() != 0600 {
+		t.Errorf("private key file: %v, %v", st, err)
+	}
+	loadedPub, err := LoadKey(priv + ".pub")
+	if err != nil || *loadedPub != *public {
+		t.Fatalf("public key file: %v", err)
+	}
+
+	public2, private2, created, err := LoadOrGenerate(priv)
+	if err != nil || created {
+		t.Fatalf("second LoadOrGenerate = created %v, %v", created, err)
+	}
+	if *public2 != *public || *private2 != *private {
+		t.Error("reloaded key pair differs")
+	}
+
+	// keys from files work end to end
+	peerPublic, peerPrivate := keyPair(t)
+	sealed, _ := Encrypt([]byte("npz"), loadedPub, peerPrivate)
+	if got, err := Decrypt(sealed, peerPublic, private2); err != nil || string(got) != "npz" {
+		t.Errorf("Decrypt with loaded keys = %q, %v", got, err)
+	}
+
+	bad := filepath.Join(dir, "bad.key")
+	os.WriteFile(bad, []byte("c2hvcnQ=\n"), 0600)
+	if _, err := LoadKey(bad); err == nil {
+		t.Error("short key accepted")
+	}
+	if _, _, _, err := LoadOrGenerate(bad); err == nil {
+		t.Error("LoadOrGenerate replaced an invalid key file")
+	}
+}
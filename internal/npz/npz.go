@@ -0,0 +1,143 @@
+// Package npz reads numeric arrays from NumPy .npz archives, enough to
+// feed models from Go without a Python runtime. Only little-endian
+// float32/float64 and int16/int32/int64 arrays in C order are supported.
+package npz
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Array is one array of an archive, converted to float64.
+type Array struct {
+	Shape []int
+	Data  []float64
+}
+
+// Read decodes every array in the .npz archive data, keyed by name
+// without the ".npy" suffix. Names lists the keys in archive order.
+func Read(data []byte) (arrays map[string]Array, names []string, err error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("npz: %w", err)
+	}
+	arrays = make(map[string]Array)
+	for _, f := range zr.File {
+		name := strings.TrimSuffix(f.Name, ".npy")
+		rc, err := f.Open()
+		if err != nil {
+			return nil, nil, fmt.Errorf("npz: %s: %w", f.Name, err)
+		}
+		a, err := readNPY(rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("npz: %s: %w", f.Name, err)
+		}
+		arrays[name] = a
+		names = append(names, name)
+	}
+	return arrays, names, nil
+}
+
+var (
+	descrRe   = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	fortranRe = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	shapeRe   = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+func readNPY(r io.Reader) (Array, error) {
+	var magic [8]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return Array{}, err
+	}
+	if string(magic[:6]) != "\x93NUMPY" {
+		return Array{}, errors.New("not a .npy file")
+	}
+	var hlen int
+	switch magic[6] {
+	case 1:
+		var n uint16
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return Array{}, err
+		}
+		hlen = int(n)
+	case 2, 3:
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return Array{}, err
+		}
+		hlen = int(n)
+	default:
+		return Array{}, fmt.Errorf("unsupported .npy version %d", magic[6])
+	}
+	header := make([]byte, hlen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Array{}, err
+	}
+	h := string(header)
+
+	m := descrRe.FindStringSubmatch(h)
+	if m == nil {
+		return Array{}, errors.New("header has no descr")
+	}
+	descr := m[1]
+	if m := fortranRe.FindStringSubmatch(h); m != nil && m[1] == "True" {
+		return Array{}, errors.New("fortran-ordered arrays are not supported")
+	}
+	m = shapeRe.FindStringSubmatch(h)
+	if m == nil {
+		return Array{}, errors.New("header has no shape")
+	}
+	var a Array
+	n := 1
+	for _, s := range strings.Split(m[1], ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		d, err := strconv.Atoi(s)
+		if err != nil {
+			return Array{}, fmt.Errorf("bad shape %q", m[1])
+		}
+		a.Shape = append(a.Shape, d)
+		n *= d
+	}
+
+	size, conv, err := decoder(descr)
+	if err != nil {
+		return Array{}, err
+	}
+	raw := make([]byte, n*size)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return Array{}, err
+	}
+	a.Data = make([]float64, n)
+	for i := range a.Data {
+		a.Data[i] = conv(raw[i*size:])
+	}
+	return a, nil
+}
+
+func decoder(descr string) (int, func([]byte) float64, error) {
+	le := binary.LittleEndian
+	switch descr {
+	case "<f8":
+		return 8, func(b []byte) float64 { return math.Float64frombits(le.Uint64(b)) }, nil
+	case "<f4":
+		return 4, func(b []byte) float64 { return float64(math.Float32frombits(le.Uint32(b))) }, nil
+	case "<i8":
+		return 8, func(b []byte) float64 { return float64(int64(le.Uint64(b))) }, nil
+	case "<i4":
+		return 4, func(b []byte) float64 { return float64(int32(le.Uint32(b))) }, nil
+	case "<i2":
+		return 2, func(b []byte) float64 { return float64(int16(le.Uint16(b))) }, nil
+	}
+	return 0, nil, fmt.Errorf("unsupported dtype %q", descr)
+}
@@ -0,0 +1,82 @@
+// Package ocsp checks the revocation status of a TLS peer certificate.
+// A stapled OCSP response is preferred; otherwise the responder named in
+// the certificate's AuthorityInfoAccess extension is queried.
+package ocsp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	xocsp "golang.org/x/crypto/ocsp"
+)
+
+// HTTPClient is used to reach OCSP responders.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Check completes the handshake on conn if needed and returns nil only
+// when the server certificate's OCSP status is Good.
+func Check(conn *tls.Conn) error {
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	state := conn.ConnectionState()
+	leaf, issuer, err := leafAndIssuer(state)
+	if err != nil {
+		return err
+	}
+
+	raw := state.OCSPResponse
+	if len(raw) == 0 {
+		if raw, err = fetch(leaf, issuer); err != nil {
+			return err
+		}
+	}
+	resp, err := xocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return fmt.Errorf("ocsp: parse response: %w", err)
+	}
+	switch resp.Status {
+	case xocsp.Good:
+		return nil
+	case xocsp.Revoked:
+		return fmt.Errorf("ocsp: certificate %s revoked at %s", leaf.Subject.CommonName, resp.RevokedAt.Format(time.RFC3339))
+	default:
+		return fmt.Errorf("ocsp: certificate %s status unknown", leaf.Subject.CommonName)
+	}
+}
+
+func leafAndIssuer(state tls.ConnectionState) (*x509.Certificate, *x509.Certificate, error) {
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 1 {
+		chain := state.VerifiedChains[0]
+		return chain[0], chain[1], nil
+	}
+	if len(state.PeerCertificates) > 1 {
+		return state.PeerCertificates[0], state.PeerCertificates[1], nil
+	}
+	return nil, nil, errors.New("ocsp: server did not present an issuer certificate")
+}
+
+func fetch(leaf, issuer *x509.Certificate) ([]byte, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, fmt.Errorf("ocsp: certificate %s has no OCSP responder", leaf.Subject.CommonName)
+	}
+	req, err := xocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ocsp: build request: %w", err)
+	}
+	resp, err := HTTPClient.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("ocsp: query %s: %w", leaf.OCSPServer[0], err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ocsp: responder %s returned %s", leaf.OCSPServer[0], resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
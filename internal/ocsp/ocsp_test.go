@@ -0,0 +1,164 @@
+package ocsp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	xocsp "golang.org/x/crypto/ocsp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newCA(t *testing.T) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return testCA{cert: cert, key: key}
+}
+
+// leaf issues a certificate for 127.0.0.1 naming responder, if any, as
+// its OCSP server.
+func (ca testCA) leaf(t *testing.T, responder string) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "broker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if responder != "" {
+		tmpl.OCSPServer = []string{responder}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}, cert
+}
+
+func (ca testCA) response(t *testing.T, leaf *x509.Certificate, status int) []byte {
+	t.Helper()
+	resp, err := xocsp.CreateResponse(ca.cert, ca.cert, xocsp.Response{
+		Status:       status,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now().Add(-time.Minute),
+	}, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		staple    int // OCSP status stapled by the server; -1 for none
+		responder int // status served by the responder; -1 for no responder
+		httpFail  bool
+		wantErr   string
+	}{
+		{"stapled good", xocsp.Good, -1, false, ""},
+		{"stapled revoked", xocsp.Revoked, -1, false, "revoked"},
+		{"stapled unknown", xocsp.Unknown, -1, false, "unknown"},
+		{"responder good", -1, xocsp.Good, false, ""},
+		{"responder revoked", -1, xocsp.Revoked, false, "revoked"},
+		{"staple preferred", xocsp.Good, xocsp.Revoked, false, ""},
+		{"responder down", -1, xocsp.Good, true, "returned 500"},
+		{"no responder", -1, -1, false, "no OCSP responder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := newCA(t)
+			var leafCert *x509.Certificate
+			responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if _, err := xocsp.ParseRequest(body); err != nil || tt.httpFail {
+					http.Error(w, "failed", http.StatusInternalServerError)
+					return
+				}
+				w.Write(ca.response(t, leafCert, tt.responder))
+			}))
+			defer responder.Close()
+			url := ""
+			if tt.responder >= 0 {
+				url = responder.URL
+			}
+			cert, leaf := ca.leaf(t, url)
+			leafCert = leaf
+			if tt.staple >= 0 {
+				cert.OCSPStaple = ca.response(t, leaf, tt.staple)
+			}
+
+			ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+				io.Copy(io.Discard, conn)
+			}()
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.cert)
+			conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			err = Check(conn)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Check = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Check = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
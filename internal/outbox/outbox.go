@@ -0,0 +1,222 @@
+// Package outbox spools undelivered payloads to a directory, one file per
+// message. File names sort in the order the payloads were added, so a
+// flusher that drains List() front to back keeps publish order, including
+// across restarts.
+//
+// An outbox is unbounded unless SetLimits caps it by entry count and/or
+// total size; a full outbox then either evicts its oldest entries or
+// rejects new ones, depending on the Policy.
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrFull is returned by Put when the outbox is at its limits and the
+// policy is RejectNew, or when a single payload exceeds MaxBytes.
+var ErrFull = errors.New("outbox: full")
+
+// Policy decides what Put does when the outbox is full.
+type Policy int
+
+const (
+	DropOldest Policy = iota // evict the oldest entries to make room
+	RejectNew                // keep the spooled entries and fail with ErrFull
+)
+
+// ParsePolicy parses "drop-oldest" or "reject-new".
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "drop-oldest":
+		return DropOldest, nil
+	case "reject-new":
+		return RejectNew, nil
+	}
+	return 0, fmt.Errorf("outbox: unknown overflow policy %q (want drop-oldest or reject-new)", s)
+}
+
+// Limits bounds an outbox. Zero values mean no limit.
+type Limits struct {
+	MaxEntries int
+	MaxBytes   int64
+	Policy     Policy
+	// OnEvict, if set, is called with the name of every entry DropOldest
+	// discards.
+	OnEvict func(name string)
+}
+
+const (
+	msgSuffix = ".msg"
+	tmpSuffix = ".tmp"
+)
+
+// Dir is a spool directory. Put may be called concurrently with List,
+// Read and Remove: entries only appear once fully written.
+type Dir struct {
+	path    string
+	seq     atomic.Uint64
+	pending atomic.Int64
+
+	mu     sync.Mutex // serialises size accounting and eviction
+	bytes  int64
+	limits Limits
+}
+
+// Open creates path if needed and counts the entries already spooled.
+// Partially written entries from a crash are discarded.
+func Open(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+	d := &Dir{path: path}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), tmpSuffix) {
+			os.Remove(filepath.Join(path, e.Name()))
+		}
+	}
+	names, err := d.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if st, err := os.Stat(filepath.Join(path, name)); err == nil {
+			d.bytes += st.Size()
+		}
+	}
+	d.pending.Store(int64(len(names)))
+	return d, nil
+}
+
+// SetLimits bounds the outbox from now on. Entries already spooled beyond
+// the limits are only evicted by the next Put.
+func (d *Dir) SetLimits(l Limits) {
+	d.mu.Lock()
+	d.limits = l
+	d.mu.Unlock()
+}
+
+// makeRoom applies the limits for a new entry of size n. d.mu is held.
+func (d *Dir) makeRoom(n int64) error {
+	l := d.limits
+	if l.MaxBytes > 0 && n > l.MaxBytes {
+		return ErrFull
+	}
+	full := func() bool {
+		return (l.MaxEntries > 0 && d.pending.Load() >= int64(l.MaxEntries)) ||
+			(l.MaxBytes > 0 && d.bytes+n > l.MaxBytes)
+	}
+	if !full() {
+		return nil
+	}
+	if l.Policy == RejectNew {
+		return ErrFull
+	}
+	names, err := d.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !full() {
+			break
+		}
+		if err := d.remove(name); err != nil {
+			return err
+		}
+		if l.OnEvict != nil {
+			l.OnEvict(name)
+		}
+	}
+	return nil
+}
+
+// Put writes payload as a new entry at the tail of the outbox.
+func (d *Dir) Put(payload []byte) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.makeRoom(int64(len(payload))); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), d.seq.Add(1)%1000000, msgSuffix)
+	tmp := filepath.Join(d.path, name+tmpSuffix)
+	if err := os.WriteFile(tmp, payload, 0644); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("outbox: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(d.path, name)); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("outbox: %w", err)
+	}
+	d.pending.Add(1)
+	d.bytes += int64(len(payload))
+	return name, nil
+}
+
+// List returns the spooled entry names, oldest first.
+func (d *Dir) List() ([]string, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), msgSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Read returns the payload of entry name.
+func (d *Dir) Read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(d.path, name))
+}
+
+// Remove deletes entry name once it has been delivered. Removing an
+// entry that was already evicted is not an error.
+func (d *Dir) Remove(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.remove(name)
+}
+
+func (d *Dir) remove(name string) error {
+	p := filepath.Join(d.path, name)
+	st, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	d.pending.Add(-1)
+	d.bytes -= st.Size()
+	return nil
+}
+
+// Len returns the number of spooled entries.
+func (d *Dir) Len() int {
+	return int(d.pending.Load())
+}
+
+// Size returns the total size of the spooled entries in bytes.
+func (d *Dir) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bytes
+}
@@ -0,0 +1,150 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func payloads(t *testing.T, d *Dir) []string {
+	t.Helper()
+	names, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, name := range names {
+		data, err := d.Read(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, string(data))
+	}
+	return out
+}
+
+// Payloads spooled while the broker is down survive a restart and drain
+// in the order they were added, each removed once delivered.
+func TestOutageAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := range 50 {
+		p := fmt.Sprintf("msg-%02d", i)
+		if _, err := d.Put([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, p)
+	}
+	// a crash mid-write leaves a temporary file behind
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001-000001.msg.tmp"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Len() != 50 || d.Size() != 50*6 {
+		t.Fatalf("reopened with %d entries, %d bytes", d.Len(), d.Size())
+	}
+	if got := payloads(t, d); !reflect.DeepEqual(got, want) {
+		t.Fatalf("spooled %v, want %v", got, want)
+	}
+
+	// the link comes back: deliver the first half, then restart again
+	names, _ := d.List()
+	for _, name := range names[:25] {
+		if err := d.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := payloads(t, d); !reflect.DeepEqual(got, want[25:]) {
+		t.Errorf("after partial delivery %v, want %v", got, want[25:])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000001-000001.msg.tmp")); !os.IsNotExist(err) {
+		t.Error("partial entry not discarded")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   Limits
+		puts     []string
+		wantErrs int
+		want     []string
+		evicted  int
+	}{
+		{"unbounded", Limits{}, []string{"a", "b", "c"}, 0, []string{"a", "b", "c"}, 0},
+		{"entries drop oldest", Limits{MaxEntries: 2}, []string{"a", "b", "c"}, 0, []string{"b", "c"}, 1},
+		{"entries reject new", Limits{MaxEntries: 2, Policy: RejectNew}, []string{"a", "b", "c"}, 1, []string{"a", "b"}, 0},
+		{"bytes drop oldest", Limits{MaxBytes: 5}, []string{"aa", "bb", "cc"}, 0, []string{"bb", "cc"}, 1},
+		{"bytes reject new", Limits{MaxBytes: 5, Policy: RejectNew}, []string{"aa", "bb", "c", "d"}, 1, []string{"aa", "bb", "c"}, 0},
+		{"payload over the limit", Limits{MaxBytes: 2}, []string{"a", "toolong"}, 1, []string{"a"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			evicted := 0
+			tt.limits.OnEvict = func(string) { evicted++ }
+			d.SetLimits(tt.limits)
+			errs := 0
+			for _, p := range tt.puts {
+				if _, err := d.Put([]byte(p)); err != nil {
+					if !errors.Is(err, ErrFull) {
+						t.Fatal(err)
+					}
+					errs++
+				}
+			}
+			if errs != tt.wantErrs || evicted != tt.evicted {
+				t.Errorf("%d rejected, %d evicted; want %d, %d", errs, evicted, tt.wantErrs, tt.evicted)
+			}
+			if got := payloads(t, d); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spooled %v, want %v", got, tt.want)
+			}
+			if d.Len() != len(tt.want) {
+				t.Errorf("Len = %d, want %d", d.Len(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestRemoveEvicted(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := d.Put([]byte("a"))
+	if err := d.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove(name); err != nil || d.Len() != 0 {
+		t.Errorf("second Remove = %v, Len %d", err, d.Len())
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for s, want := range map[string]Policy{"drop-oldest": DropOldest, "reject-new": RejectNew} {
+		if p, err := ParsePolicy(s); err != nil || p != want {
+			t.Errorf("ParsePolicy(%q) = %v, %v", s, p, err)
+		}
+	}
+	if _, err := ParsePolicy("drop-newest"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
@@ -0,0 +1,62 @@
+// Package pacing draws the wait between two publishes of one sender, so
+// simulated traffic can be strictly periodic or bursty like a real sensor
+// network.
+package pacing
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Interval distributions.
+const (
+	Fixed   = "fixed"   // always Mean
+	Uniform = "uniform" // Mean scaled by a random factor in [1-Jitter, 1+Jitter]
+	Poisson = "poisson" // exponentially distributed waits with mean Mean (Poisson arrivals)
+)
+
+// Interval describes the waits of one sender.
+type Interval struct {
+	Dist   string
+	Mean   time.Duration
+	Jitter float64 // uniform only, fraction of Mean in [0, 1]
+	// Rand is the source of the draws. A *rand.Rand is not safe for
+	// concurrent use, so senders sharing an Interval leave it nil, which
+	// uses the global source.
+	Rand *rand.Rand
+}
+
+// Validate checks the distribution and its parameters.
+func (i Interval) Validate() error {
+	switch i.Dist {
+	case Fixed, Poisson:
+	case Uniform:
+		if i.Jitter < 0 || i.Jitter > 1 {
+			return fmt.Errorf("pacing: uniform jitter %g is outside [0, 1]", i.Jitter)
+		}
+	default:
+		return fmt.Errorf("pacing: unknown interval distribution %q (want fixed, uniform or poisson)", i.Dist)
+	}
+	if i.Mean < 0 {
+		return fmt.Errorf("pacing: negative mean interval %s", i.Mean)
+	}
+	return nil
+}
+
+// Next returns the wait before the next publish. Draws are independent,
+// so senders sharing an Interval still get their own sequence of waits.
+func (i Interval) Next() time.Duration {
+	float, exp := rand.Float64, rand.ExpFloat64
+	if i.Rand != nil {
+		float, exp = i.Rand.Float64, i.Rand.ExpFloat64
+	}
+	switch i.Dist {
+	case Uniform:
+		return time.Duration(float64(i.Mean) * (1 + i.Jitter*(2*float()-1)))
+	case Poisson:
+		return time.Duration(float64(i.Mean) * exp())
+	default:
+		return i.Mean
+	}
+}
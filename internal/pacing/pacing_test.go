@@ -0,0 +1,116 @@
+package pacing
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+const draws = 20000
+
+// sample draws n waits from i with a fixed seed and returns them in
+// units of i.Mean.
+func sample(i Interval, n int) []float64 {
+	i.Rand = rand.New(rand.NewPCG(1, 2))
+	s := make([]float64, n)
+	for k := range s {
+		s[k] = float64(i.Next()) / float64(i.Mean)
+	}
+	return s
+}
+
+func meanAndStddev(s []float64) (mean, stddev float64) {
+	for _, v := range s {
+		mean += v
+	}
+	mean /= float64(len(s))
+	for _, v := range s {
+		stddev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(s)))
+}
+
+func TestFixed(t *testing.T) {
+	i := Interval{Dist: Fixed, Mean: 1500 * time.Millisecond}
+	for range 10 {
+		if d := i.Next(); d != i.Mean {
+			t.Fatalf("fixed interval drew %v", d)
+		}
+	}
+	if d := (Interval{Dist: Uniform, Mean: time.Second}).Next(); d != time.Second {
+		t.Errorf("uniform without jitter drew %v", d)
+	}
+}
+
+func TestUniform(t *testing.T) {
+	s := sample(Interval{Dist: Uniform, Mean: time.Second, Jitter: 0.2}, draws)
+	var quarters [4]int
+	for _, v := range s {
+		if v < 0.8 || v > 1.2 {
+			t.Fatalf("draw %g outside [0.8, 1.2]", v)
+		}
+		quarters[min(int((v-0.8)/0.1), 3)]++
+	}
+	if mean, _ := meanAndStddev(s); math.Abs(mean-1) > 0.01 {
+		t.Errorf("mean %g, want 1", mean)
+	}
+	for q, n := range quarters {
+		if math.Abs(float64(n)/draws-0.25) > 0.02 {
+			t.Errorf("quarter %d holds %d of %d draws", q, n, draws)
+		}
+	}
+}
+
+func TestPoisson(t *testing.T) {
+	s := sample(Interval{Dist: Poisson, Mean: 2 * time.Second}, draws)
+	below := 0
+	for _, v := range s {
+		if v < 0 {
+			t.Fatalf("negative draw %g", v)
+		}
+		if v < 1 {
+			below++
+		}
+	}
+	// an exponential distribution has its standard deviation equal to
+	// its mean and 1-1/e of its mass below the mean
+	mean, stddev := meanAndStddev(s)
+	if math.Abs(mean-1) > 0.03 || math.Abs(stddev-1) > 0.05 {
+		t.Errorf("mean %g, stddev %g; want both 1", mean, stddev)
+	}
+	if f := float64(below) / draws; math.Abs(f-(1-1/math.E)) > 0.02 {
+		t.Errorf("%g of the draws below the mean, want %g", f, 1-1/math.E)
+	}
+}
+
+func TestSeeded(t *testing.T) {
+	i := Interval{Dist: Poisson, Mean: time.Second}
+	a, b := sample(i, 100), sample(i, 100)
+	for k := range a {
+		if a[k] != b[k] {
+			t.Fatalf("draw %d differs with the same seed: %g and %g", k, a[k], b[k])
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		i       Interval
+		wantErr bool
+	}{
+		{Interval{Dist: Fixed, Mean: time.Second}, false},
+		{Interval{Dist: Poisson}, false},
+		{Interval{Dist: Uniform, Mean: time.Second, Jitter: 1}, false},
+		{Interval{Dist: Uniform, Mean: time.Second, Jitter: 1.5}, true},
+		{Interval{Dist: Uniform, Mean: time.Second, Jitter: -0.1}, true},
+		{Interval{Dist: Fixed, Mean: -time.Second}, true},
+		{Interval{Dist: "gaussian", Mean: time.Second}, true},
+		{Interval{Mean: time.Second}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.i.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: Validate() = %v", tt.i, err)
+		}
+	}
+}
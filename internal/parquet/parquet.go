@@ -0,0 +1,306 @@
+// Package parquet writes flat Apache Parquet files: one row group of
+// OPTIONAL DOUBLE and UTF8 columns, PLAIN-encoded and uncompressed. It
+// covers what the result sinks need without pulling in a Thrift runtime;
+// the file footer is encoded by hand with the Thrift compact protocol.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Type is a column's physical type.
+type Type int
+
+const (
+	Double Type = iota // float64 values
+	String             // string values, annotated UTF8
+)
+
+// Column describes one column of the file.
+type Column struct {
+	Name string
+	Type Type
+}
+
+// Writer buffers rows in memory and encodes them on WriteTo.
+type Writer struct {
+	cols   []Column
+	values [][]any // per column; nil entries are nulls
+	rows   int
+}
+
+// NewWriter returns a writer for rows with the given columns.
+func NewWriter(cols []Column) *Writer {
+	return &Writer{cols: cols, values: make([][]any, len(cols))}
+}
+
+// Append adds a row. Each value is nil, a float64 for Double columns or a
+// string for String columns; a short row is padded with nulls.
+func (w *Writer) Append(row []any) error {
+	if len(row) > len(w.cols) {
+		return fmt.Errorf("parquet: row has %d values for %d columns", len(row), len(w.cols))
+	}
+	// check the whole row first, so a rejected row leaves no values behind
+	for i, c := range w.cols {
+		var v any
+		if i < len(row) {
+			v = row[i]
+		}
+		switch v.(type) {
+		case nil:
+		case float64:
+			if c.Type != Double {
+				return fmt.Errorf("parquet: column %s: float64 in a string column", c.Name)
+			}
+		case string:
+			if c.Type != String {
+				return fmt.Errorf("parquet: column %s: string in a double column", c.Name)
+			}
+		default:
+			return fmt.Errorf("parquet: column %s: unsupported value %T", c.Name, v)
+		}
+	}
+	for i := range w.cols {
+		var v any
+		if i < len(row) {
+			v = row[i]
+		}
+		w.values[i] = append(w.values[i], v)
+	}
+	w.rows++
+	return nil
+}
+
+// Rows returns the number of rows appended.
+func (w *Writer) Rows() int {
+	return w.rows
+}
+
+// Parquet enum values used below (parquet.thrift).
+const (
+	typeDouble    = 5
+	typeByteArray = 6
+
+	repetitionOptional = 1
+	convertedUTF8      = 0
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	pageData = 0
+)
+
+// WriteTo encodes the buffered rows as a complete Parquet file.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+	chunks := make([]columnChunk, len(w.cols))
+	var total int64
+	for i := range w.cols {
+		page := encodePage(w.values[i])
+		var hdr thriftWriter
+		hdr.fieldI32(1, pageData)
+		hdr.fieldI32(2, int32(len(page)))
+		hdr.fieldI32(3, int32(len(page)))
+		hdr.fieldStruct(5, func(t *thriftWriter) {
+			t.fieldI32(1, int32(w.rows))
+			t.fieldI32(2, encodingPlain)
+			t.fieldI32(3, encodingRLE)
+			t.fieldI32(4, encodingRLE)
+		})
+		hdr.stop()
+		chunks[i] = columnChunk{offset: int64(file.Len()), size: int64(hdr.buf.Len() + len(page))}
+		total += chunks[i].size
+		file.Write(hdr.buf.Bytes())
+		file.Write(page)
+	}
+
+	var meta thriftWriter
+	meta.fieldI32(1, 1)
+	meta.fieldList(2, thriftStruct, len(w.cols)+1, func(t *thriftWriter) {
+		t.structElem(func(t *thriftWriter) {
+			t.fieldBinary(4, "schema")
+			t.fieldI32(5, int32(len(w.cols)))
+		})
+		for _, c := range w.cols {
+			t.structElem(func(t *thriftWriter) {
+				t.fieldI32(1, c.physicalType())
+				t.fieldI32(3, repetitionOptional)
+				t.fieldBinary(4, c.Name)
+				if c.Type == String {
+					t.fieldI32(6, convertedUTF8)
+				}
+			})
+		}
+	})
+	meta.fieldI64(3, int64(w.rows))
+	meta.fieldList(4, thriftStruct, 1, func(t *thriftWriter) {
+		t.structElem(func(t *thriftWriter) {
+			t.fieldList(1, thriftStruct, len(w.cols), func(t *thriftWriter) {
+				for i, c := range w.cols {
+					ch := chunks[i]
+					t.structElem(func(t *thriftWriter) {
+						t.fieldI64(2, ch.offset)
+						t.fieldStruct(3, func(t *thriftWriter) {
+							t.fieldI32(1, c.physicalType())
+							t.fieldList(2, thriftI32, 2, func(t *thriftWriter) {
+								t.varint(zigzag(encodingPlain))
+								t.varint(zigzag(encodingRLE))
+							})
+							t.fieldList(3, thriftBinary, 1, func(t *thriftWriter) {
+								t.binary(c.Name)
+							})
+							t.fieldI32(4, 0) // UNCOMPRESSED
+							t.fieldI64(5, int64(w.rows))
+							t.fieldI64(6, ch.size)
+							t.fieldI64(7, ch.size)
+							t.fieldI64(9, ch.offset)
+						})
+					})
+				}
+			})
+			t.fieldI64(2, total)
+			t.fieldI64(3, int64(w.rows))
+		})
+	})
+	meta.fieldBinary(6, "cloudletsapps parquet")
+	meta.stop()
+
+	file.Write(meta.buf.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.buf.Len()))
+	file.WriteString("PAR1")
+	return file.WriteTo(out)
+}
+
+func (c Column) physicalType() int32 {
+	if c.Type == Double {
+		return typeDouble
+	}
+	return typeByteArray
+}
+
+type columnChunk struct {
+	offset, size int64
+}
+
+// encodePage returns a v1 data page: RLE definition levels (1 = present)
+// behind their 4-byte length, then the PLAIN values of the non-null rows.
+func encodePage(values []any) []byte {
+	var levels bytes.Buffer
+	for i := 0; i < len(values); {
+		present := values[i] != nil
+		run := 1
+		for i+run < len(values) && (values[i+run] != nil) == present {
+			run++
+		}
+		levels.Write(binary.AppendUvarint(nil, uint64(run)<<1))
+		if present {
+			levels.WriteByte(1)
+		} else {
+			levels.WriteByte(0)
+		}
+		i += run
+	}
+	var page bytes.Buffer
+	binary.Write(&page, binary.LittleEndian, uint32(levels.Len()))
+	page.Write(levels.Bytes())
+	for _, v := range values {
+		switch v := v.(type) {
+		case float64:
+			binary.Write(&page, binary.LittleEndian, math.Float64bits(v))
+		case string:
+			binary.Write(&page, binary.LittleEndian, uint32(len(v)))
+			page.WriteString(v)
+		}
+	}
+	return page.Bytes()
+}
+
+// Thrift compact protocol type codes.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes one Thrift struct in the compact protocol.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last int16 // previous field ID, for delta encoding
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if d := id - t.last; d > 0 && d <= 15 {
+		t.buf.WriteByte(byte(d)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) fieldI32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) fieldI64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) fieldBinary(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.binary(s)
+}
+
+// structElem writes a nested struct (as a field value or list element),
+// with its own field ID sequence.
+func (t *thriftWriter) structElem(fields func(*thriftWriter)) {
+	last := t.last
+	t.last = 0
+	fields(t)
+	t.stop()
+	t.last = last
+}
+
+func (t *thriftWriter) fieldStruct(id int16, fields func(*thriftWriter)) {
+	t.fieldHeader(id, thriftStruct)
+	t.structElem(fields)
+}
+
+func (t *thriftWriter) fieldList(id int16, elem byte, n int, elems func(*thriftWriter)) {
+	t.fieldHeader(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xF0 | elem)
+		t.varint(uint64(n))
+	}
+	last := t.last
+	elems(t)
+	t.last = last
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
@@ -0,0 +1,151 @@
+package parquet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cloudletsapps/internal/parquet/parquettest"
+)
+
+func encode(t *testing.T, w *Writer) []byte {
+	t.Helper()
+	var file bytes.Buffer
+	n, err := w.WriteTo(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(file.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, file.Len())
+	}
+	return file.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	cols := []Column{
+		{Name: "buoy_id", Type: String},
+		{Name: "height", Type: Double},
+		{Name: "label", Type: String},
+	}
+	rows := [][]any{
+		{"b1", 2.5, "calm"},
+		{"b1", nil, "storm"},
+		{"b2", -0.125},
+		{nil, nil, nil},
+		{"b3", 1e300, ""},
+		{"b3", 3.0, "ünïcode"},
+	}
+	w := NewWriter(cols)
+	for _, r := range rows {
+		if err := w.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Rows() != len(rows) {
+		t.Errorf("Rows = %d, want %d", w.Rows(), len(rows))
+	}
+
+	f, err := parquettest.Read(encode(t, w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Rows != int64(len(rows)) {
+		t.Fatalf("file has %d rows, want %d", f.Rows, len(rows))
+	}
+	if len(f.Columns) != len(cols) {
+		t.Fatalf("file has %d columns, want %d", len(f.Columns), len(cols))
+	}
+	for i, c := range cols {
+		got := f.Columns[i]
+		wantType := "DOUBLE"
+		if c.Type == String {
+			wantType = "BYTE_ARRAY"
+		}
+		if got.Name != c.Name || got.Type != wantType || got.UTF8 != (c.Type == String) || !got.Optional {
+			t.Errorf("column %d = %s %s utf8=%v optional=%v", i, got.Name, got.Type, got.UTF8, got.Optional)
+		}
+		for r, row := range rows {
+			var want any
+			if i < len(row) {
+				want = row[i]
+			}
+			if got.Values[r] != want {
+				t.Errorf("row %d column %s = %#v, want %#v", r, c.Name, got.Values[r], want)
+			}
+		}
+	}
+}
+
+func TestLongRuns(t *testing.T) {
+	// runs longer than 63 need a multi-byte run header, and a schema of
+	// 15 or more elements a long list header
+	var cols []Column
+	for i := range 20 {
+		cols = append(cols, Column{Name: "c" + strings.Repeat("x", i), Type: Double})
+	}
+	w := NewWriter(cols)
+	for r := range 300 {
+		row := make([]any, len(cols))
+		if r < 100 || r >= 250 {
+			for i := range row {
+				row[i] = float64(r)
+			}
+		}
+		w.Append(row)
+	}
+	f, err := parquettest.Read(encode(t, w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range f.Columns {
+		for r, v := range c.Values {
+			if (r < 100 || r >= 250) != (v != nil) || (v != nil && v != float64(r)) {
+				t.Fatalf("column %s row %d = %v", c.Name, r, v)
+			}
+		}
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+	f, err := parquettest.Read(encode(t, NewWriter([]Column{{Name: "x", Type: Double}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Rows != 0 || len(f.Columns) != 1 || len(f.Columns[0].Values) != 0 {
+		t.Errorf("empty file read as %+v", f)
+	}
+}
+
+func TestAppendErrors(t *testing.T) {
+	cols := []Column{{Name: "id", Type: String}, {Name: "v", Type: Double}}
+	tests := []struct {
+		name string
+		row  []any
+	}{
+		{"too long", []any{"a", 1.0, 2.0}},
+		{"string in double", []any{"a", "1"}},
+		{"double in string", []any{1.0, 1.0}},
+		{"unsupported type", []any{"a", 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWriter(cols)
+			if err := w.Append(tt.row); err == nil {
+				t.Error("Append accepted the row")
+			}
+			if w.Rows() != 0 {
+				t.Errorf("Rows = %d after a rejected row", w.Rows())
+			}
+			if err := w.Append([]any{"b1", 2.0}); err != nil {
+				t.Fatal(err)
+			}
+			f, err := parquettest.Read(encode(t, w))
+			if err != nil {
+				t.Fatalf("file after a rejected row: %v", err)
+			}
+			if id, v := f.Columns[0].Values, f.Columns[1].Values; len(id) != 1 || id[0] != "b1" || v[0] != 2.0 {
+				t.Errorf("rows after a rejected row: %v %v", id, v)
+			}
+		})
+	}
+}
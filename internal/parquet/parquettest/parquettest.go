@@ -0,0 +1,422 @@
+// Package parquettest reads flat Parquet files back for tests. It is
+// written from parquet.thrift and the Parquet encoding spec, with its own
+// generic Thrift compact decoder, and shares no code with package parquet,
+// so a writer bug is not mirrored by the reader. It reads uncompressed
+// PLAIN DOUBLE, BYTE_ARRAY, INT32 and INT64 columns of REQUIRED or
+// OPTIONAL fields: enough to check what the sinks write.
+package parquettest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Column is one column of a file and its values, nil for nulls. DOUBLE
+// values are float64, UTF8 byte arrays string, other byte arrays []byte,
+// INT32 int32 and INT64 int64.
+type Column struct {
+	Name     string
+	Type     string // physical type, e.g. "DOUBLE"
+	UTF8     bool
+	Optional bool
+	Values   []any
+}
+
+// File is a decoded Parquet file.
+type File struct {
+	Rows      int64
+	CreatedBy string
+	Columns   []Column
+}
+
+// Column returns the column called name, or nil.
+func (f *File) Column(name string) *Column {
+	for i := range f.Columns {
+		if f.Columns[i].Name == name {
+			return &f.Columns[i]
+		}
+	}
+	return nil
+}
+
+var physicalTypes = map[int64]string{1: "INT32", 2: "INT64", 5: "DOUBLE", 6: "BYTE_ARRAY"}
+
+// Read decodes a complete Parquet file.
+func Read(b []byte) (*File, error) {
+	if len(b) < 12 || string(b[:4]) != "PAR1" || string(b[len(b)-4:]) != "PAR1" {
+		return nil, errors.New("parquettest: missing PAR1 magic")
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	if n > len(b)-12 {
+		return nil, fmt.Errorf("parquettest: footer length %d exceeds file", n)
+	}
+	d := &decoder{buf: b[len(b)-8-n : len(b)-8]}
+	meta := d.structValue()
+	if d.err != nil {
+		return nil, fmt.Errorf("parquettest: footer: %w", d.err)
+	}
+	if d.pos != len(d.buf) {
+		return nil, fmt.Errorf("parquettest: %d bytes after footer", len(d.buf)-d.pos)
+	}
+
+	f := &File{Rows: meta.i64(3), CreatedBy: string(meta.bytes(6))}
+	schema := meta.list(2)
+	if len(schema) == 0 {
+		return nil, errors.New("parquettest: empty schema")
+	}
+	root := schema[0].(fields)
+	if int(root.i64(5)) != len(schema)-1 {
+		return nil, fmt.Errorf("parquettest: root has %d children, schema %d leaves", root.i64(5), len(schema)-1)
+	}
+	for _, el := range schema[1:] {
+		el := el.(fields)
+		if _, ok := el[5]; ok {
+			return nil, errors.New("parquettest: nested groups are not supported")
+		}
+		typ, ok := physicalTypes[el.i64(1)]
+		if !ok {
+			return nil, fmt.Errorf("parquettest: unsupported type %d", el.i64(1))
+		}
+		rep := el.i64(3)
+		if rep > 1 {
+			return nil, errors.New("parquettest: repeated fields are not supported")
+		}
+		_, converted := el[6]
+		f.Columns = append(f.Columns, Column{
+			Name:     string(el.bytes(4)),
+			Type:     typ,
+			UTF8:     converted && el.i64(6) == 0,
+			Optional: rep == 1,
+		})
+	}
+
+	for g, rg := range meta.list(4) {
+		rg := rg.(fields)
+		chunks := rg.list(1)
+		if len(chunks) != len(f.Columns) {
+			return nil, fmt.Errorf("parquettest: row group %d has %d chunks for %d columns", g, len(chunks), len(f.Columns))
+		}
+		var total int64
+		for i, ch := range chunks {
+			cm := ch.(fields).structField(3)
+			c := &f.Columns[i]
+			if path := cm.list(3); len(path) != 1 || string(path[0].([]byte)) != c.Name {
+				return nil, fmt.Errorf("parquettest: chunk %d path %q, column %s", i, path, c.Name)
+			}
+			if physicalTypes[cm.i64(1)] != c.Type {
+				return nil, fmt.Errorf("parquettest: column %s: chunk type %d", c.Name, cm.i64(1))
+			}
+			if cm.i64(4) != 0 {
+				return nil, fmt.Errorf("parquettest: column %s: compressed with codec %d", c.Name, cm.i64(4))
+			}
+			start, size := cm.i64(9), cm.i64(7)
+			if start < 4 || start+size > int64(len(b)-8-n) {
+				return nil, fmt.Errorf("parquettest: column %s: chunk %d+%d outside the data", c.Name, start, size)
+			}
+			if cm.i64(6) != size {
+				return nil, fmt.Errorf("parquettest: column %s: sizes %d and %d differ uncompressed", c.Name, cm.i64(6), size)
+			}
+			values, err := readChunk(b[start:start+size], c, cm.i64(5))
+			if err != nil {
+				return nil, fmt.Errorf("parquettest: column %s: %w", c.Name, err)
+			}
+			c.Values = append(c.Values, values...)
+			total += size
+		}
+		if rg.i64(2) != total {
+			return nil, fmt.Errorf("parquettest: row group %d size %d, chunks add up to %d", g, rg.i64(2), total)
+		}
+		for _, c := range f.Columns {
+			if int64(len(c.Values)) != rowsSoFar(meta, g) {
+				return nil, fmt.Errorf("parquettest: column %s has %d values after row group %d", c.Name, len(c.Values), g)
+			}
+		}
+	}
+	for _, c := range f.Columns {
+		if int64(len(c.Values)) != f.Rows {
+			return nil, fmt.Errorf("parquettest: column %s has %d values for %d rows", c.Name, len(c.Values), f.Rows)
+		}
+	}
+	return f, nil
+}
+
+func rowsSoFar(meta fields, group int) int64 {
+	var n int64
+	for _, rg := range meta.list(4)[:group+1] {
+		n += rg.(fields).i64(3)
+	}
+	return n
+}
+
+// readChunk decodes the v1 data pages of a column chunk, which must use
+// up all of b.
+func readChunk(b []byte, c *Column, numValues int64) ([]any, error) {
+	var values []any
+	for len(b) > 0 {
+		d := &decoder{buf: b}
+		hdr := d.structValue()
+		if d.err != nil {
+			return nil, fmt.Errorf("page header: %w", d.err)
+		}
+		if hdr.i64(1) != 0 {
+			return nil, fmt.Errorf("page type %d, want DATA_PAGE", hdr.i64(1))
+		}
+		size := int(hdr.i64(3))
+		if hdr.i64(2) != int64(size) || d.pos+size > len(b) {
+			return nil, fmt.Errorf("page size %d/%d with %d bytes left", hdr.i64(2), size, len(b)-d.pos)
+		}
+		dp := hdr.structField(5)
+		if dp.i64(2) != 0 {
+			return nil, fmt.Errorf("value encoding %d, want PLAIN", dp.i64(2))
+		}
+		page, err := readPage(b[d.pos:d.pos+size], c, int(dp.i64(1)))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, page...)
+		b = b[d.pos+size:]
+	}
+	if int64(len(values)) != numValues {
+		return nil, fmt.Errorf("%d values, metadata says %d", len(values), numValues)
+	}
+	return values, nil
+}
+
+func readPage(b []byte, c *Column, n int) ([]any, error) {
+	present := make([]bool, n)
+	for i := range present {
+		present[i] = true
+	}
+	if c.Optional {
+		if len(b) < 4 {
+			return nil, errors.New("page too short for definition levels")
+		}
+		size := int(binary.LittleEndian.Uint32(b))
+		if 4+size > len(b) {
+			return nil, errors.New("definition levels overrun the page")
+		}
+		levels, err := hybrid(b[4:4+size], n)
+		if err != nil {
+			return nil, fmt.Errorf("definition levels: %w", err)
+		}
+		for i, l := range levels {
+			if l > 1 {
+				return nil, fmt.Errorf("definition level %d, max 1", l)
+			}
+			present[i] = l == 1
+		}
+		b = b[4+size:]
+	}
+	values := make([]any, n)
+	for i := range values {
+		if !present[i] {
+			continue
+		}
+		switch c.Type {
+		case "DOUBLE", "INT64":
+			if len(b) < 8 {
+				return nil, errors.New("page ends inside a value")
+			}
+			v := binary.LittleEndian.Uint64(b)
+			if c.Type == "DOUBLE" {
+				values[i] = math.Float64frombits(v)
+			} else {
+				values[i] = int64(v)
+			}
+			b = b[8:]
+		case "INT32":
+			if len(b) < 4 {
+				return nil, errors.New("page ends inside a value")
+			}
+			values[i] = int32(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case "BYTE_ARRAY":
+			if len(b) < 4 || 4+int(binary.LittleEndian.Uint32(b)) > len(b) {
+				return nil, errors.New("page ends inside a value")
+			}
+			v := b[4 : 4+binary.LittleEndian.Uint32(b)]
+			if c.UTF8 {
+				values[i] = string(v)
+			} else {
+				values[i] = bytes.Clone(v)
+			}
+			b = b[4+len(v):]
+		}
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("%d bytes after the last value", len(b))
+	}
+	return values, nil
+}
+
+// hybrid decodes n levels of bit width 1 from the RLE/bit-packed hybrid
+// encoding.
+func hybrid(b []byte, n int) ([]int, error) {
+	var levels []int
+	for len(levels) < n {
+		h, k := binary.Uvarint(b)
+		if k <= 0 {
+			return nil, errors.New("bad run header")
+		}
+		b = b[k:]
+		if h&1 == 0 { // RLE run: count, then the value in one byte
+			if len(b) < 1 {
+				return nil, errors.New("RLE run without a value")
+			}
+			for range h >> 1 {
+				levels = append(levels, int(b[0]))
+			}
+			b = b[1:]
+			continue
+		}
+		groups := int(h >> 1) // bit-packed: groups of 8 values, one byte each
+		if len(b) < groups {
+			return nil, errors.New("bit-packed run overruns")
+		}
+		for _, by := range b[:groups] {
+			for bit := range 8 {
+				levels = append(levels, int(by>>bit&1))
+			}
+		}
+		b = b[groups:]
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("%d bytes after the levels", len(b))
+	}
+	return levels[:n], nil
+}
+
+// fields is a decoded Thrift struct by field ID. Integers are int64,
+// binaries []byte, structs fields and lists []any.
+type fields map[int16]any
+
+func (f fields) i64(id int16) int64 {
+	v, _ := f[id].(int64)
+	return v
+}
+
+func (f fields) bytes(id int16) []byte {
+	v, _ := f[id].([]byte)
+	return v
+}
+
+func (f fields) list(id int16) []any {
+	v, _ := f[id].([]any)
+	return v
+}
+
+func (f fields) structField(id int16) fields {
+	v, _ := f[id].(fields)
+	return v
+}
+
+// decoder reads the Thrift compact protocol without a schema.
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || d.pos >= len(d.buf) {
+		d.fail("unexpected end at %d", d.pos)
+		return 0
+	}
+	d.pos++
+	return d.buf[d.pos-1]
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		d.fail("bad varint at %d", d.pos)
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) zigzag() int64 {
+	v := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *decoder) structValue() fields {
+	f := fields{}
+	var last int16
+	for d.err == nil {
+		h := d.byte()
+		if h == 0 {
+			return f
+		}
+		typ := h & 0x0f
+		if delta := int16(h >> 4); delta != 0 {
+			last += delta
+		} else {
+			last = int16(d.zigzag())
+		}
+		switch typ {
+		case 1, 2: // booleans carry their value in the type
+			f[last] = typ == 1
+		default:
+			f[last] = d.value(typ)
+		}
+	}
+	return f
+}
+
+func (d *decoder) value(typ byte) any {
+	switch typ {
+	case 1, 2: // bool as a list element
+		return d.byte() == 1
+	case 3: // byte
+		return int64(int8(d.byte()))
+	case 4, 5, 6: // i16, i32, i64
+		return d.zigzag()
+	case 7: // double
+		if d.pos+8 > len(d.buf) {
+			d.fail("double overruns at %d", d.pos)
+			return nil
+		}
+		d.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(d.buf[d.pos-8:]))
+	case 8: // binary
+		n := int(d.uvarint())
+		if d.err != nil || n < 0 || d.pos+n > len(d.buf) {
+			d.fail("binary overruns at %d", d.pos)
+			return nil
+		}
+		d.pos += n
+		return d.buf[d.pos-n : d.pos]
+	case 9, 10: // list, set
+		h := d.byte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(d.uvarint())
+		}
+		l := make([]any, 0, n)
+		for range n {
+			if d.err != nil {
+				break
+			}
+			l = append(l, d.value(h&0x0f))
+		}
+		return l
+	case 12:
+		return d.structValue()
+	}
+	d.fail("unsupported type %d at %d", typ, d.pos)
+	return nil
+}
@@ -0,0 +1,169 @@
+// Package parquetsink writes CSV result rows into Parquet files
+// partitioned per buoy and per UTC day, in the Hive layout pandas and
+// Spark read as one dataset:
+//
+//	<dir>/buoy=<id>/date=<YYYY-MM-DD>/part-<unix-nanos>.parquet
+//
+// Parquet files cannot be appended to, so each buoy collects its rows in
+// memory and a part file is written on a new day, a header change,
+// MaxRows rows, RollInterval or Close. Rows not yet written are lost if
+// the process dies, so RollInterval bounds how much a crash can cost.
+//
+// Column types are taken from the first row of each part: values that
+// parse as numbers become DOUBLE, everything else UTF8. Later values
+// that do not fit a DOUBLE column are stored as null.
+package parquetsink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudletsapps/internal/parquet"
+)
+
+// Sink routes rows to the open part of their buoy.
+type Sink struct {
+	Dir          string
+	MaxRows      int           // rows per part; 0 means no limit
+	RollInterval time.Duration // maximum age of a part; 0 means no limit
+
+	mu    sync.Mutex
+	parts map[string]*part // buoy -> open part
+}
+
+type part struct {
+	dir     string
+	day     string
+	header  string
+	numeric []bool
+	w       *parquet.Writer
+	opened  time.Time
+}
+
+// New returns a sink writing under dir.
+func New(dir string, maxRows int, rollInterval time.Duration) *Sink {
+	return &Sink{Dir: dir, MaxRows: maxRows, RollInterval: rollInterval, parts: make(map[string]*part)}
+}
+
+// Write adds CSV data rows sharing header to buoy's current part.
+func (s *Sink) Write(buoy, header string, rows []string, now time.Time) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day := now.UTC().Format("2006-01-02")
+	if p := s.parts[buoy]; p != nil && (p.day != day || p.header != header) {
+		delete(s.parts, buoy)
+		if err := p.flush(); err != nil {
+			return err
+		}
+	}
+	p := s.parts[buoy]
+	if p == nil {
+		p = s.newPart(buoy, day, header, rows[0], now)
+		s.parts[buoy] = p
+	}
+	for _, row := range rows {
+		fields := strings.Split(row, ",")
+		rec := make([]any, len(p.numeric))
+		for i := 0; i < len(rec) && i < len(fields); i++ {
+			v := strings.TrimSpace(fields[i])
+			if !p.numeric[i] {
+				rec[i] = v
+			} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+				rec[i] = f
+			}
+		}
+		if err := p.w.Append(rec); err != nil {
+			return err
+		}
+	}
+	if (s.MaxRows > 0 && p.w.Rows() >= s.MaxRows) || (s.RollInterval > 0 && now.Sub(p.opened) >= s.RollInterval) {
+		delete(s.parts, buoy)
+		return p.flush()
+	}
+	return nil
+}
+
+// Roll writes out every part older than RollInterval, for buoys that
+// have gone quiet. Call it periodically.
+func (s *Sink) Roll(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for buoy, p := range s.parts {
+		if s.RollInterval > 0 && now.Sub(p.opened) >= s.RollInterval {
+			errs = append(errs, p.flush())
+			delete(s.parts, buoy)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close writes out every open part.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for buoy, p := range s.parts {
+		errs = append(errs, p.flush())
+		delete(s.parts, buoy)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Sink) newPart(buoy, day, header, firstRow string, now time.Time) *part {
+	names := strings.Split(header, ",")
+	first := strings.Split(firstRow, ",")
+	p := &part{
+		dir:     filepath.Join(s.Dir, "buoy="+buoy, "date="+day),
+		day:     day,
+		header:  header,
+		numeric: make([]bool, len(names)),
+		opened:  now,
+	}
+	cols := make([]parquet.Column, len(names))
+	for i, name := range names {
+		cols[i] = parquet.Column{Name: strings.TrimSpace(name), Type: parquet.String}
+		if i < len(first) {
+			if _, err := strconv.ParseFloat(strings.TrimSpace(first[i]), 64); err == nil {
+				p.numeric[i] = true
+				cols[i].Type = parquet.Double
+			}
+		}
+	}
+	p.w = parquet.NewWriter(cols)
+	return p
+}
+
+// flush writes the part to a temporary file and renames it into place,
+// so readers never see a partial file.
+func (p *part) flush() error {
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(p.dir, fmt.Sprintf("part-%d.parquet", p.opened.UnixNano()))
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = p.w.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("parquetsink: %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package parquetsink
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/parquet/parquettest"
+)
+
+// parts reads every part file under dir, keyed by its path relative to
+// dir with the file name dropped, in file name order.
+func parts(t *testing.T, dir string) map[string][]*parquettest.File {
+	t.Helper()
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !strings.HasSuffix(path, ".parquet") {
+			t.Errorf("stray file %s", path)
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	got := map[string][]*parquettest.File{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := parquettest.Read(b)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		rel, _ := filepath.Rel(dir, filepath.Dir(path))
+		got[filepath.ToSlash(rel)] = append(got[filepath.ToSlash(rel)], f)
+	}
+	return got
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir, 0, 0)
+	day1 := time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Minute)
+	header := "buoy_id,height,label"
+	if err := s.Write("b1", header, []string{"b1,2.5,calm", "b1, 3 ,storm"}, day1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write("b2", header, []string{"b2,1.0,calm"}, day1); err != nil {
+		t.Fatal(err)
+	}
+	// a value that is not a number in a DOUBLE column, and a short row
+	if err := s.Write("b1", header, []string{"b1,n/a,calm", "b1"}, day1); err != nil {
+		t.Fatal(err)
+	}
+	// the next UTC day starts a new part
+	if err := s.Write("b1", header, []string{"b1,4,calm"}, day2); err != nil {
+		t.Fatal(err)
+	}
+	if got := parts(t, dir); len(got) != 1 {
+		t.Fatalf("parts written before Close: %v", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := parts(t, dir)
+	if len(got) != 3 {
+		t.Fatalf("got partitions %v, want 3", got)
+	}
+	f := got["buoy=b1/date=2024-05-01"]
+	if len(f) != 1 {
+		t.Fatalf("buoy=b1/date=2024-05-01 has %d parts", len(f))
+	}
+	if f[0].Rows != 4 {
+		t.Fatalf("first part has %d rows, want 4", f[0].Rows)
+	}
+	id, height, label := f[0].Column("buoy_id"), f[0].Column("height"), f[0].Column("label")
+	if id == nil || height == nil || label == nil {
+		t.Fatalf("columns %+v", f[0].Columns)
+	}
+	if id.Type != "BYTE_ARRAY" || height.Type != "DOUBLE" || label.Type != "BYTE_ARRAY" {
+		t.Errorf("column types %s %s %s", id.Type, height.Type, label.Type)
+	}
+	wantHeight := []any{2.5, 3.0, nil, nil}
+	wantLabel := []any{"calm", "storm", "calm", nil}
+	for r := range 4 {
+		if id.Values[r] != "b1" || height.Values[r] != wantHeight[r] || label.Values[r] != wantLabel[r] {
+			t.Errorf("row %d = %v %v %v", r, id.Values[r], height.Values[r], label.Values[r])
+		}
+	}
+	if f := got["buoy=b1/date=2024-05-02"]; len(f) != 1 || f[0].Column("height").Values[0] != 4.0 {
+		t.Errorf("second day part: %+v", f)
+	}
+	if f := got["buoy=b2/date=2024-05-01"]; len(f) != 1 || f[0].Rows != 1 {
+		t.Errorf("b2 part: %+v", f)
+	}
+}
+
+func TestRoll(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		maxRows   int
+		roll      time.Duration
+		writes    int // single-row writes, a second apart
+		wantParts int // written before Close
+	}{
+		{"max rows", 2, 0, 5, 2},
+		{"roll interval", 0, 2 * time.Second, 5, 1},
+		{"no limit", 0, 0, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := New(dir, tt.maxRows, tt.roll)
+			for i := range tt.writes {
+				if err := s.Write("b1", "n", []string{"1"}, t0.Add(time.Duration(i)*time.Second)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := len(parts(t, dir)["buoy=b1/date=2024-05-01"]); got != tt.wantParts {
+				t.Errorf("%d parts before Close, want %d", got, tt.wantParts)
+			}
+			s.Close()
+			var rows int64
+			for _, f := range parts(t, dir)["buoy=b1/date=2024-05-01"] {
+				rows += f.Rows
+			}
+			if rows != int64(tt.writes) {
+				t.Errorf("%d rows written, want %d", rows, tt.writes)
+			}
+		})
+	}
+}
+
+func TestRollQuietBuoy(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir, 0, time.Minute)
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.Write("b1", "n", []string{"1"}, t0)
+	s.Write("b2", "n", []string{"1"}, t0.Add(30*time.Second))
+	if err := s.Roll(t0.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	got := parts(t, dir)
+	if len(got["buoy=b1/date=2024-05-01"]) != 1 || len(got["buoy=b2/date=2024-05-01"]) != 0 {
+		t.Errorf("after Roll: %v", got)
+	}
+}
+
+func TestHeaderChange(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir, 0, 0)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.Write("b1", "a,b", []string{"1,x"}, now)
+	s.Write("b1", "a,b,c", []string{"2,y,3"}, now.Add(time.Second))
+	s.Close()
+	f := parts(t, dir)["buoy=b1/date=2024-05-01"]
+	if len(f) != 2 {
+		t.Fatalf("%d parts, want one per header", len(f))
+	}
+	if len(f[0].Columns) != 2 || len(f[1].Columns) != 3 {
+		t.Errorf("columns %d and %d, want 2 and 3", len(f[0].Columns), len(f[1].Columns))
+	}
+}
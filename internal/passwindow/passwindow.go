@@ -0,0 +1,100 @@
+// Package passwindow models the contact windows of a low-earth-orbit
+// pass: connected for Window out of every Period. Windows are anchored to
+// the Unix epoch (shifted by Offset), so separate processes with the same
+// schedule open and close together without coordinating.
+package passwindow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a repeating contact window. The zero value is always open.
+type Schedule struct {
+	Window time.Duration // how long each pass lasts
+	Period time.Duration // time from the start of one pass to the next
+	Offset time.Duration // shifts when passes start
+}
+
+// Parse reads a schedule written as "<window>/<period>", e.g. "8m/90m".
+// An empty string is the always-open schedule.
+func Parse(s string) (Schedule, error) {
+	if strings.TrimSpace(s) == "" {
+		return Schedule{}, nil
+	}
+	w, p, ok := strings.Cut(s, "/")
+	if !ok {
+		return Schedule{}, fmt.Errorf("pass window %q: want <window>/<period>, e.g. 8m/90m", s)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(w))
+	if err != nil {
+		return Schedule{}, fmt.Errorf("pass window %q: %w", s, err)
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(p))
+	if err != nil {
+		return Schedule{}, fmt.Errorf("pass window %q: %w", s, err)
+	}
+	if window <= 0 || period <= window {
+		return Schedule{}, fmt.Errorf("pass window %q: want 0 < window < period", s)
+	}
+	return Schedule{Window: window, Period: period}, nil
+}
+
+// Enabled reports whether s ever closes.
+func (s Schedule) Enabled() bool {
+	return s.Window > 0 && s.Period > s.Window
+}
+
+// phase is how far t is into its period.
+func (s Schedule) phase(t time.Time) time.Duration {
+	ph := time.Duration((t.UnixNano() - int64(s.Offset)) % int64(s.Period))
+	if ph < 0 {
+		ph += s.Period
+	}
+	return ph
+}
+
+// Open reports whether t falls inside a pass.
+func (s Schedule) Open(t time.Time) bool {
+	return !s.Enabled() || s.phase(t) < s.Window
+}
+
+// Next returns when the state at t next changes: the end of the current
+// pass if t is inside one, otherwise the start of the next. It returns the
+// zero time for a schedule that never closes.
+func (s Schedule) Next(t time.Time) time.Time {
+	if !s.Enabled() {
+		return time.Time{}
+	}
+	ph := s.phase(t)
+	if ph < s.Window {
+		return t.Add(s.Window - ph)
+	}
+	return t.Add(s.Period - ph)
+}
+
+// Wait blocks until a pass is open.
+func (s Schedule) Wait() {
+	if now := time.Now(); !s.Open(now) {
+		time.Sleep(time.Until(s.Next(now)))
+	}
+}
+
+// Run calls onChange at every pass boundary, with open reporting the new
+// state, until stop is closed. It does not report the state at the start.
+func (s Schedule) Run(stop <-chan struct{}, onChange func(open bool)) {
+	if !s.Enabled() {
+		return
+	}
+	for {
+		t := time.NewTimer(time.Until(s.Next(time.Now())))
+		select {
+		case <-stop:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		onChange(s.Open(time.Now()))
+	}
+}
@@ -0,0 +1,119 @@
+package passwindow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Schedule
+		wantErr bool
+	}{
+		{"", Schedule{}, false},
+		{"8m/90m", Schedule{Window: 8 * time.Minute, Period: 90 * time.Minute}, false},
+		{" 10s / 1m ", Schedule{Window: 10 * time.Second, Period: time.Minute}, false},
+		{"8m", Schedule{}, true},
+		{"8x/90m", Schedule{}, true},
+		{"8m/90", Schedule{}, true},
+		{"0s/90m", Schedule{}, true},
+		{"90m/90m", Schedule{}, true},
+		{"2h/1h", Schedule{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Parse(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestOpenAndNext(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time { return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	// 8 of every 90 minutes; 2024-05-01 00:00 UTC is a multiple of 90m
+	// after the epoch, so passes start at 00:00, 01:30, 03:00...
+	orbit := Schedule{Window: 8 * time.Minute, Period: 90 * time.Minute}
+	// two hours from 23:00 to 01:00 UTC, across midnight
+	night := Schedule{Window: 2 * time.Hour, Period: 24 * time.Hour, Offset: 23 * time.Hour}
+
+	tests := []struct {
+		name     string
+		s        Schedule
+		t        time.Time
+		wantOpen bool
+		wantNext time.Time
+	}{
+		{"start of pass", orbit, at(0, 0), true, at(0, 8)},
+		{"inside pass", orbit, at(1, 35), true, at(1, 38)},
+		{"end of pass is closed", orbit, at(0, 8), false, at(1, 30)},
+		{"between passes", orbit, at(2, 0), false, at(3, 0)},
+		{"just before a pass", orbit, at(1, 30).Add(-time.Nanosecond), false, at(1, 30)},
+		{"before midnight", night, at(23, 30), true, at(25, 0)},
+		{"after midnight", night, at(0, 30), true, at(1, 0)},
+		{"closed after the wrap", night, at(1, 0), false, at(23, 0)},
+		{"closed in the evening", night, at(22, 0), false, at(23, 0)},
+		{"before the epoch", Schedule{Window: time.Minute, Period: time.Hour}, time.Unix(-30, 0), false, time.Unix(0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.Open(tt.t); got != tt.wantOpen {
+				t.Errorf("Open(%v) = %v", tt.t, got)
+			}
+			if got := tt.s.Next(tt.t); !got.Equal(tt.wantNext) {
+				t.Errorf("Next(%v) = %v, want %v", tt.t, got, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestAlwaysOpen(t *testing.T) {
+	for _, s := range []Schedule{{}, {Window: time.Hour, Period: time.Hour}} {
+		if s.Enabled() || !s.Open(time.Now()) || !s.Next(time.Now()).IsZero() {
+			t.Errorf("%+v is not always open", s)
+		}
+		done := make(chan struct{})
+		go func() { s.Run(nil, func(bool) { t.Error("always-open schedule changed") }); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Run of an always-open schedule did not return")
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := Schedule{Window: 20 * time.Millisecond, Period: 50 * time.Millisecond}
+	changes := make(chan bool, 10)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop, func(open bool) { changes <- open })
+		close(done)
+	}()
+	prev := s.Open(time.Now())
+	for range 4 {
+		select {
+		case open := <-changes:
+			if open == prev {
+				t.Fatalf("reported %v twice in a row", open)
+			}
+			prev = open
+		case <-time.After(time.Second):
+			t.Fatal("no change reported")
+		}
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after stop")
+	}
+
+	start := time.Now()
+	s.Wait()
+	if !s.Open(time.Now()) || time.Since(start) > s.Period {
+		t.Errorf("Wait returned after %v with the window closed", time.Since(start))
+	}
+}
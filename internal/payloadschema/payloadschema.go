@@ -0,0 +1,123 @@
+// Package payloadschema checks buoy payloads against a JSON Schema before
+// the satellite decodes them, so a malformed message is rejected up front
+// with every problem named, rather than failing part way through
+// prediction. The built-in schema describes what pub_only_client sends;
+// deployments with other publishers can supply their own.
+package payloadschema
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed buoy_payload.schema.json
+var builtin string
+
+// Validator checks payloads against one compiled schema.
+type Validator struct {
+	schema *jsonschema.Schema
+}
+
+// Load compiles the schema in path, or the built-in buoy payload schema
+// when path is empty. Besides the standard formats, "base64" accepts
+// standard or URL-safe base64, like the satellite's decoder.
+func Load(path string) (*Validator, error) {
+	src, name := builtin, "buoy_payload.schema.json"
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		src, name = string(b), path
+	}
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	c.Formats["base64"] = isBase64
+	if err := c.AddResource(name, strings.NewReader(src)); err != nil {
+		return nil, fmt.Errorf("payload schema %s: %w", name, err)
+	}
+	s, err := c.Compile(name)
+	if err != nil {
+		return nil, fmt.Errorf("payload schema %s: %w", name, err)
+	}
+	return &Validator{schema: s}, nil
+}
+
+const maxProblemLen = 200
+
+// Error lists why a payload was rejected, one entry per failed check.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid payload: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the JSON document body. A payload that is not JSON or
+// does not match the schema yields an *Error.
+func (v *Validator) Validate(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return &Error{Problems: []string{"not JSON: " + err.Error()}}
+	}
+	return v.ValidateDoc(doc)
+}
+
+// ValidateDoc checks an already decoded document, such as a protobuf
+// payload converted to the fields the schema describes. Numbers must be
+// json.Number or float64.
+func (v *Validator) ValidateDoc(doc any) error {
+	err := v.schema.Validate(doc)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+	var problems []string
+	for _, u := range ve.BasicOutput().Errors {
+		// the leaves say what is wrong; the rest only say where
+		if strings.HasPrefix(u.Error, "doesn't validate with") || u.Error == "" {
+			continue
+		}
+		loc := strings.TrimPrefix(u.InstanceLocation, "/")
+		if loc == "" {
+			loc = "payload"
+		}
+		msg := u.Error
+		if len(msg) > maxProblemLen {
+			// messages quote the value, which for data can be megabytes
+			msg = msg[:maxProblemLen] + "..."
+		}
+		problems = append(problems, loc+": "+msg)
+	}
+	if len(problems) == 0 {
+		problems = []string{ve.Message}
+	}
+	return &Error{Problems: problems}
+}
+
+// isBase64 reports whether a string is standard or URL-safe base64,
+// without holding the decoded bytes.
+func isBase64(v any) bool {
+	s, ok := v.(string)
+	if !ok {
+		return true
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+		if _, err := io.Copy(io.Discard, base64.NewDecoder(enc, strings.NewReader(s))); err == nil {
+			return true
+		}
+	}
+	return false
+}
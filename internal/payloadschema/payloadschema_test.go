@@ -0,0 +1,115 @@
+package payloadschema
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	v, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		payload string
+		want    []string // substrings of the problems; empty for a valid payload
+	}{
+		{"valid", `{"buoy_id":"b1","filename":"a.npz","data":"UEsDBA=="}`, nil},
+		{"all fields", `{"buoy_id":"b1","filename":"a.npz","data":"UEsDBA==","compression":"gzip","send_time":1700000000.5,"message_id":"m1","encryption":"aes-256-gcm","key_id":"k1"}`, nil},
+		{"url-safe base64", `{"buoy_id":"b1","filename":"a.npz","data":"_-8="}`, nil},
+		{"not JSON", `buoy_id=b1`, []string{"not JSON"}},
+		{"not an object", `[1,2]`, []string{"payload"}},
+		{"missing fields", `{"buoy_id":"b1"}`, []string{"filename", "data"}},
+		{"empty buoy id", `{"buoy_id":"","filename":"a.npz","data":"UEsDBA=="}`, []string{"buoy_id"}},
+		{"path in filename", `{"buoy_id":"b1","filename":"../etc/passwd","data":"UEsDBA=="}`, []string{"filename"}},
+		{"hidden file", `{"buoy_id":"b1","filename":".bashrc","data":"UEsDBA=="}`, []string{"filename"}},
+		{"bad base64", `{"buoy_id":"b1","filename":"a.npz","data":"not base64!"}`, []string{"data"}},
+		{"unknown compression", `{"buoy_id":"b1","filename":"a.npz","data":"UEsDBA==","compression":"lz4"}`, []string{"compression"}},
+		{"negative send time", `{"buoy_id":"b1","filename":"a.npz","data":"UEsDBA==","send_time":-1}`, []string{"send_time"}},
+		{"encryption without key", `{"buoy_id":"b1","filename":"a.npz","data":"UEsDBA==","encryption":"aes-256-gcm"}`, []string{"key_id"}},
+		{"wrong types", `{"buoy_id":7,"filename":"a.npz","data":"UEsDBA==","send_time":"now"}`, []string{"buoy_id", "send_time"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Validate([]byte(tt.payload))
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate = %v", err)
+				}
+				return
+			}
+			var ve *Error
+			if !errors.As(err, &ve) {
+				t.Fatalf("Validate = %v, want *Error", err)
+			}
+			msg := ve.Error()
+			for _, w := range tt.want {
+				if !strings.Contains(msg, w) {
+					t.Errorf("%q does not mention %s", msg, w)
+				}
+			}
+		})
+	}
+}
+
+func TestLongValueTruncated(t *testing.T) {
+	v, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Repeat("!", 1<<20)
+	err = v.Validate([]byte(`{"buoy_id":"b1","filename":"a.npz","data":"` + data + `"}`))
+	var ve *Error
+	if !errors.As(err, &ve) {
+		t.Fatalf("Validate = %v, want *Error", err)
+	}
+	for _, p := range ve.Problems {
+		if len(p) > maxProblemLen+100 {
+			t.Errorf("problem of %d bytes", len(p))
+		}
+	}
+}
+
+func TestValidateDoc(t *testing.T) {
+	v, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := map[string]any{"buoy_id": "b1", "filename": "a.npz", "data": "UEsDBA==", "send_time": json.Number("12")}
+	if err := v.ValidateDoc(doc); err != nil {
+		t.Errorf("ValidateDoc = %v", err)
+	}
+	doc["send_time"] = float64(-3)
+	if err := v.ValidateDoc(doc); err == nil {
+		t.Error("negative send_time accepted")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "custom.json")
+	os.WriteFile(custom, []byte(`{"type":"object","required":["station"]}`), 0644)
+	v, err := Load(custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Validate([]byte(`{"station":"s1"}`)); err != nil {
+		t.Errorf("custom schema rejected a match: %v", err)
+	}
+	if err := v.Validate([]byte(`{"buoy_id":"b1","filename":"a.npz","data":"UEsDBA=="}`)); err == nil {
+		t.Error("custom schema not used")
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte(`{"type":"no such type"}`), 0644)
+	for _, path := range []string{bad, filepath.Join(dir, "missing.json")} {
+		if _, err := Load(path); err == nil {
+			t.Errorf("Load(%s) succeeded", path)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package predictout turns the raw stdout of predict.py into the CSV
+// message the satellite publishes. It is shared by the satellite and the
+// reprocess tool so cached output goes through the same logic.
+package predictout
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoCSV is returned when the output has no header and data line left
+// after dropping log noise.
+var ErrNoCSV = errors.New("no valid CSV lines in prediction output")
+
+// noise marks TensorFlow/CUDA log lines that end up on predict.py's stdout.
+var noise = []string{
+	"tensorflow",
+	"cudart",
+	"dlerror",
+	"libcudart",
+	"GPU",
+	"CUDA",
+	"stream_executor",
+	"dso_loader",
+	"Could not load dynamic library",
+	"Ignore above",
+}
+
+// Extract returns the model's CSV header and first data line from raw.
+func Extract(raw string) (header, data string, err error) {
+	var csvLines []string
+	for _, line := range strings.Split(strings.TrimSpace(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || isNoise(line) {
+			continue
+		}
+		csvLines = append(csvLines, line)
+	}
+	if len(csvLines) < 2 {
+		return "", "", ErrNoCSV
+	}
+	return csvLines[0], csvLines[1], nil
+}
+
+func isNoise(line string) bool {
+	for _, n := range noise {
+		if strings.Contains(line, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// Row is one prediction with the fields the satellite adds around the
+// model output. Latencies are in milliseconds.
+type Row struct {
+	BuoyID           string
+	Header           string // model CSV header
+	Data             string // model CSV data line
+	LatencyReception int64
+	LatencyInference int64
+	SendTime         float64 // seconds since the epoch
+	NodeID           string
+	ModelVersion     string
+	// ClockOffset is the NTP reference time minus the satellite clock (ms),
+	// as measured when correcting the latencies; the column is only
+	// written when HasClockOffset is set.
+	ClockOffset    int64
+	HasClockOffset bool
+}
+
+// Lines renders the final CSV header and data line.
+func (r Row) Lines() (header, data string) {
+	header = "Buoy-station," + r.Header + ",Observation-to-Reception-LATENCY,Observation-to-Inference-LATENCY,send_time,Node-ID,Model-Version"
+	data = fmt.Sprintf("%s,%s,%d,%d,%.6f,%s,%s", r.BuoyID, r.Data, r.LatencyReception, r.LatencyInference, r.SendTime, r.NodeID, r.ModelVersion)
+	if r.HasClockOffset {
+		header += ",Clock-Offset-ms"
+		data += fmt.Sprintf(",%d", r.ClockOffset)
+	}
+	return header, data
+}
+
+// Message renders the two-line CSV message (header, data).
+func (r Row) Message() string {
+	header, data := r.Lines()
+	return header + "\n" + data
+}
@@ -0,0 +1,41 @@
+package predictout
+
+import "testing"
+
+func TestRowLines(t *testing.T) {
+	row := Row{
+		BuoyID:           "b1",
+		Header:           "Hs,Tp",
+		Data:             "1.5,8",
+		LatencyReception: 120,
+		LatencyInference: 340,
+		SendTime:         1700000000.25,
+		NodeID:           "sat-7",
+		ModelVersion:     "v3",
+	}
+	tests := []struct {
+		name         string
+		offset       bool
+		header, data string
+	}{
+		{"plain", false,
+			"Buoy-station,Hs,Tp,Observation-to-Reception-LATENCY,Observation-to-Inference-LATENCY,send_time,Node-ID,Model-Version",
+			"b1,1.5,8,120,340,1700000000.250000,sat-7,v3"},
+		{"clock offset", true,
+			"Buoy-station,Hs,Tp,Observation-to-Reception-LATENCY,Observation-to-Inference-LATENCY,send_time,Node-ID,Model-Version,Clock-Offset-ms",
+			"b1,1.5,8,120,340,1700000000.250000,sat-7,v3,-15"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := row
+			r.ClockOffset, r.HasClockOffset = -15, tt.offset
+			header, data := r.Lines()
+			if header != tt.header || data != tt.data {
+				t.Errorf("Lines =\n%s\n%s\nwant\n%s\n%s", header, data, tt.header, tt.data)
+			}
+			if r.Message() != tt.header+"\n"+tt.data {
+				t.Errorf("Message = %q", r.Message())
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package predictout
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Column is one expected column of the model output.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "float", "int" or "string" (default)
+}
+
+// Schema lists the columns predict.py is expected to print, in order:
+//
+//	{"columns": [{"name": "Hs", "type": "float"}, {"name": "label", "type": "string"}]}
+type Schema struct {
+	Columns []Column `json:"columns"`
+}
+
+// LoadSchema reads a JSON schema file.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("predictout: parse schema %s: %w", path, err)
+	}
+	for _, c := range s.Columns {
+		switch c.Type {
+		case "", "string", "float", "int":
+		default:
+			return nil, fmt.Errorf("predictout: schema column %q has unknown type %q", c.Name, c.Type)
+		}
+	}
+	return &s, nil
+}
+
+// Validate checks the model output against the schema: every schema
+// column must be present, in schema order, and hold a value of its type.
+// Columns not in the schema are ignored.
+func (s *Schema) Validate(header, data string) error {
+	names := strings.Split(header, ",")
+	values := strings.Split(data, ",")
+	pos := 0
+	for _, c := range s.Columns {
+		idx := -1
+		for i, n := range names {
+			if strings.TrimSpace(n) == c.Name {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case idx < 0:
+			return fmt.Errorf("schema mismatch: missing column %q", c.Name)
+		case idx < pos:
+			return fmt.Errorf("schema mismatch: column %q out of order", c.Name)
+		case idx >= len(values):
+			return fmt.Errorf("schema mismatch: no value for column %q", c.Name)
+		}
+		pos = idx + 1
+		v := strings.TrimSpace(values[idx])
+		var err error
+		switch c.Type {
+		case "float":
+			_, err = strconv.ParseFloat(v, 64)
+		case "int":
+			_, err = strconv.ParseInt(v, 10, 64)
+		}
+		if err != nil {
+			return fmt.Errorf("schema mismatch: column %q is not %s: %q", c.Name, c.Type, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package predictout
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	s := &Schema{Columns: []Column{{"Hs", "float"}, {"Tp", "int"}, {"label", ""}}}
+	tests := []struct {
+		name         string
+		header, data string
+		wantErr      string
+	}{
+		{"matching", "Hs,Tp,label", "1.5,8,calm", ""},
+		{"extra column", "Hs,extra,Tp,label,more", "1.5,x,8,calm,y", ""},
+		{"spaces", " Hs , Tp , label ", " 1.5 , 8 , calm ", ""},
+		{"missing column", "Hs,label", "1.5,calm", `missing column "Tp"`},
+		{"reordered", "Tp,Hs,label", "8,1.5,calm", `column "Tp" out of order`},
+		{"short row", "Hs,Tp,label", "1.5,8", `no value for column "label"`},
+		{"wrong float", "Hs,Tp,label", "high,8,calm", `"Hs" is not float`},
+		{"wrong int", "Hs,Tp,label", "1.5,8.5,calm", `"Tp" is not int`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Validate(tt.header, tt.data)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Validate = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Validate = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	s, err := LoadSchema(write("ok.json", `{"columns": [{"name": "Hs", "type": "float"}, {"name": "label"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Columns) != 2 || s.Columns[0] != (Column{"Hs", "float"}) || s.Columns[1] != (Column{"label", ""}) {
+		t.Errorf("LoadSchema = %+v", s)
+	}
+	for name, data := range map[string]string{
+		"bad type.json": `{"columns": [{"name": "Hs", "type": "double"}]}`,
+		"bad json.json": `{"columns": [`,
+	} {
+		if _, err := LoadSchema(write(name, data)); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+	if _, err := LoadSchema(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
@@ -0,0 +1,55 @@
+// Package probe actively checks a live MQTT connection. paho only notices
+// a silently dropped TCP link when the keepalive fires; a QoS 1 probe
+// publish that never gets its PUBACK exposes it sooner.
+package probe
+
+import (
+	"context"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultTopic receives the probe messages.
+const DefaultTopic = "__probe__"
+
+type Prober struct {
+	// Topic to publish probes on; empty means DefaultTopic.
+	Topic string
+	// Timeout for each probe's PUBACK; zero means the probe interval.
+	Timeout time.Duration
+}
+
+// Start probes client every interval until ctx is done. onFailure is
+// called once, after which probing stops.
+func (p Prober) Start(ctx context.Context, client MQTT.Client, interval time.Duration, onFailure func()) {
+	if interval <= 0 {
+		return
+	}
+	topic := p.Topic
+	if topic == "" {
+		topic = DefaultTopic
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = interval
+	}
+	go func() {
+		tk := time.NewTicker(interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+			}
+			token := client.Publish(topic, 1, false, []byte(time.Now().UTC().Format(time.RFC3339Nano)))
+			if !token.WaitTimeout(timeout) || token.Error() != nil {
+				if ctx.Err() == nil {
+					onFailure()
+				}
+				return
+			}
+		}
+	}()
+}
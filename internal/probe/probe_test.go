@@ -0,0 +1,148 @@
+package probe
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// silentBroker acknowledges CONNECT and QoS 1 publishes until silent is
+// set; after that it keeps reading but never answers, like a peer whose
+// link dropped without a FIN.
+type silentBroker struct {
+	ln     net.Listener
+	silent atomic.Bool
+	probes atomic.Int32
+}
+
+func startBroker(t *testing.T) *silentBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &silentBroker{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *silentBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		kind, body, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		if b.silent.Load() {
+			continue
+		}
+		switch kind {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH, QoS 1: topic, then packet id
+			b.probes.Add(1)
+			n := int(body[0])<<8 | int(body[1])
+			conn.Write([]byte{0x40, 2, body[2+n], body[3+n]})
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		}
+	}
+}
+
+func readPacket(r io.Reader) (kind byte, body []byte, err error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, nil, err
+	}
+	kind = b[0] >> 4
+	n, shift := 0, 0
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n |= int(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body = make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return kind, body, err
+}
+
+func connect(t *testing.T, b *silentBroker) MQTT.Client {
+	t.Helper()
+	opts := MQTT.NewClientOptions().AddBroker("tcp://" + b.ln.Addr().String()).SetClientID("probe-test")
+	// a keepalive far beyond the test, so only the probe can notice the drop
+	opts.SetKeepAlive(time.Hour).SetAutoReconnect(false)
+	c := MQTT.NewClient(opts)
+	if tk := c.Connect(); !tk.WaitTimeout(5*time.Second) || tk.Error() != nil {
+		t.Fatalf("connect: %v", tk.Error())
+	}
+	t.Cleanup(func() { c.Disconnect(0) })
+	return c
+}
+
+func TestDetectsSilentDrop(t *testing.T) {
+	b := startBroker(t)
+	c := connect(t, b)
+	failed := make(chan struct{}, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	Prober{Timeout: 100 * time.Millisecond}.Start(ctx, c, 20*time.Millisecond, func() { failed <- struct{}{} })
+
+	time.Sleep(150 * time.Millisecond)
+	select {
+	case <-failed:
+		t.Fatal("probe failed while the broker was answering")
+	default:
+	}
+	if b.probes.Load() == 0 {
+		t.Fatal("no probes published")
+	}
+
+	b.silent.Store(true)
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("silent drop not detected")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(failed) != 0 {
+		t.Error("onFailure called more than once")
+	}
+}
+
+func TestStopsWithContext(t *testing.T) {
+	b := startBroker(t)
+	c := connect(t, b)
+	ctx, cancel := context.WithCancel(context.Background())
+	Prober{}.Start(ctx, c, 10*time.Millisecond, func() { t.Error("onFailure after cancel") })
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	sent := b.probes.Load()
+	time.Sleep(50 * time.Millisecond)
+	if b.probes.Load() != sent {
+		t.Error("probing continued after the context was done")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	Prober{}.Start(context.Background(), nil, 0, func() { t.Error("onFailure with probing disabled") })
+}
@@ -0,0 +1,145 @@
+// Package psk encrypts the npz data of a payload with AES-256-GCM under a
+// pre-shared key, so the broker operator cannot read the sensor data while
+// the payload's other fields stay visible for routing and de-duplication.
+//
+// Keys are identified by a short ID sent along with the data, so the
+// satellite can hold several keys while they are rotated. A keyring is
+// written as "id:base64key" entries, separated by commas or newlines
+// (e.g. "k1:$(openssl rand -base64 32)"); "#" starts a comment line.
+//
+// Sealed data is the 12-byte random nonce followed by the ciphertext and
+// tag. The key ID and the file name are authenticated as additional data.
+package psk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Algorithm is the payload's "encryption" value for data sealed here.
+const Algorithm = "aes-256-gcm"
+
+// ErrDecrypt is returned when sealed data does not open with its key.
+var ErrDecrypt = errors.New("psk: decryption failed")
+
+// Keyring maps key IDs to 32-byte AES keys.
+type Keyring map[string][]byte
+
+// Parse reads keyring entries from s.
+func Parse(s string) (Keyring, error) {
+	k := make(Keyring)
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, b64, ok := strings.Cut(line, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("psk: entry %q: want id:base64key", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("psk: key %q: want 32 bytes in base64", id)
+		}
+		if _, dup := k[id]; dup {
+			return nil, fmt.Errorf("psk: key %q listed twice", id)
+		}
+		k[id] = key
+	}
+	return k, nil
+}
+
+// Load merges the entries of inline and of the file at path; either may
+// be empty.
+func Load(inline, path string) (Keyring, error) {
+	k, err := Parse(inline)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return k, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fromFile, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for id, key := range fromFile {
+		if _, dup := k[id]; dup {
+			return nil, fmt.Errorf("psk: key %q listed twice", id)
+		}
+		k[id] = key
+	}
+	return k, nil
+}
+
+// IDs returns the key IDs in order.
+func (k Keyring) IDs() []string {
+	ids := make([]string, 0, len(k))
+	for id := range k {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (k Keyring) aead(keyID string) (cipher.AEAD, error) {
+	key, ok := k[keyID]
+	if !ok {
+		return nil, fmt.Errorf("psk: unknown key %q", keyID)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func additionalData(keyID, filename string) []byte {
+	return []byte(keyID + "\x00" + filename)
+}
+
+// Seal encrypts plaintext with key keyID for a payload carrying filename.
+func (k Keyring) Seal(keyID, filename string, plaintext []byte) ([]byte, error) {
+	gcm, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData(keyID, filename)), nil
+}
+
+// Open decrypts data sealed by Seal. algorithm is the payload's
+// "encryption" field.
+func (k Keyring) Open(algorithm, keyID, filename string, sealed []byte) ([]byte, error) {
+	if algorithm != Algorithm {
+		return nil, fmt.Errorf("psk: unsupported encryption %q", algorithm)
+	}
+	gcm, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrDecrypt
+	}
+	n := gcm.NonceSize()
+	plain, err := gcm.Open(nil, sealed[:n], sealed[n:], additionalData(keyID, filename))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
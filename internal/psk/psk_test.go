@@ -0,0 +1,170 @@
+package psk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func keyring(t *testing.T, entries string) Keyring {
+	t.Helper()
+	k, err := Parse(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := keyring(t, "k1:"+newKey(t)+",k2:"+newKey(t))
+	for _, plain := range [][]byte{
+		[]byte("npz data"),
+		{},
+		bytes.Repeat([]byte{0x5a}, 1<<20),
+	} {
+		for _, id := range k.IDs() {
+			sealed, err := k.Seal(id, "a.npz", plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plain) > 0 && bytes.Contains(sealed, plain) {
+				t.Error("plaintext visible in the sealed data")
+			}
+			got, err := k.Open(Algorithm, id, "a.npz", sealed)
+			if err != nil {
+				t.Fatalf("Open with %s: %v", id, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("round trip of %d bytes gave %d bytes", len(plain), len(got))
+			}
+		}
+	}
+
+	a, _ := k.Seal("k1", "a.npz", []byte("x"))
+	b, _ := k.Seal("k1", "a.npz", []byte("x"))
+	if bytes.Equal(a[:12], b[:12]) {
+		t.Error("nonce reused")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	k := keyring(t, "k1:"+newKey(t)+",k2:"+newKey(t))
+	other := keyring(t, "k1:"+newKey(t))
+	sealed, err := k.Seal("k1", "a.npz", []byte("npz data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flip := func(i int) []byte {
+		b := bytes.Clone(sealed)
+		b[i] ^= 1
+		return b
+	}
+
+	tests := []struct {
+		name      string
+		keys      Keyring
+		algorithm string
+		keyID     string
+		filename  string
+		sealed    []byte
+		wantErr   error // nil: any error other than ErrDecrypt
+	}{
+		{"wrong key", other, Algorithm, "k1", "a.npz", sealed, ErrDecrypt},
+		{"other key id", k, Algorithm, "k2", "a.npz", sealed, ErrDecrypt},
+		{"other file name", k, Algorithm, "k1", "b.npz", sealed, ErrDecrypt},
+		{"tampered nonce", k, Algorithm, "k1", "a.npz", flip(0), ErrDecrypt},
+		{"tampered ciphertext", k, Algorithm, "k1", "a.npz", flip(12), ErrDecrypt},
+		{"tampered tag", k, Algorithm, "k1", "a.npz", flip(len(sealed) - 1), ErrDecrypt},
+		{"truncated", k, Algorithm, "k1", "a.npz", sealed[:len(sealed)-1], ErrDecrypt},
+		{"shorter than nonce and tag", k, Algorithm, "k1", "a.npz", sealed[:27], ErrDecrypt},
+		{"empty", k, Algorithm, "k1", "a.npz", nil, ErrDecrypt},
+		{"unknown key id", k, Algorithm, "k3", "a.npz", sealed, nil},
+		{"unsupported algorithm", k, "chacha20-poly1305", "k1", "a.npz", sealed, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, err := tt.keys.Open(tt.algorithm, tt.keyID, tt.filename, tt.sealed)
+			if err == nil {
+				t.Fatalf("Open = %q, want an error", plain)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Open = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && errors.Is(err, ErrDecrypt) {
+				t.Errorf("Open = %v, want a configuration error", err)
+			}
+		})
+	}
+
+	if _, err := k.Seal("k3", "a.npz", []byte("x")); err == nil {
+		t.Error("Seal with an unknown key id succeeded")
+	}
+}
+
+func TestParse(t *testing.T) {
+	k1, k2 := newKey(t), newKey(t)
+	tests := []struct {
+		name    string
+		in      string
+		wantIDs []string
+		wantErr bool
+	}{
+		{"comma separated", "k1:" + k1 + ",k2:" + k2, []string{"k1", "k2"}, false},
+		{"lines and comments", "# rotated 2024-05\n k2 : " + k2 + "\n\nk1:" + k1 + "\n", []string{"k1", "k2"}, false},
+		{"empty", "", []string{}, false},
+		{"no id", ":" + k1, nil, true},
+		{"no separator", k1, nil, true},
+		{"not base64", "k1:not-base64!", nil, true},
+		{"short key", "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), nil, true},
+		{"duplicate", "k1:" + k1 + ",k1:" + k2, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := Parse(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && strings.Join(k.IDs(), ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("IDs = %v, want %v", k.IDs(), tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys")
+	k1, k2 := newKey(t), newKey(t)
+	os.WriteFile(path, []byte("k2:"+k2+"\n"), 0600)
+
+	k, err := Load("k1:"+k1, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(k.IDs(), ","); got != "k1,k2" {
+		t.Errorf("IDs = %s, want k1,k2", got)
+	}
+	if _, err := Load("k2:"+k1, path); err == nil {
+		t.Error("key listed inline and in the file accepted")
+	}
+	if _, err := Load("", filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing key file accepted")
+	}
+	if k, err := Load("k1:"+k1, ""); err != nil || len(k) != 1 {
+		t.Errorf("Load without a file = %v, %v", k, err)
+	}
+}
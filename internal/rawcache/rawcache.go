@@ -0,0 +1,67 @@
+// Package rawcache keeps the raw predict.py output on disk so prediction
+// post-processing can be rerun later without the original NPZ.
+//
+// Files are laid out as <dir>/<buoy_id>/<timestamp>_raw.txt.
+package rawcache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the UTC timestamp used in cache file names.
+const TimeFormat = "20060102T150405.000000000Z"
+
+const suffix = "_raw.txt"
+
+type Store struct {
+	Dir string
+}
+
+// Save writes output for buoyID and returns the file path.
+func (s Store) Save(buoyID string, ts time.Time, output string) (string, error) {
+	if buoyID == "" || strings.ContainsAny(buoyID, `/\`) || buoyID == "." || buoyID == ".." {
+		return "", fmt.Errorf("rawcache: invalid buoy id %q", buoyID)
+	}
+	dir := filepath.Join(s.Dir, buoyID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, ts.UTC().Format(TimeFormat)+suffix)
+	if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Entry is one cached output file.
+type Entry struct {
+	BuoyID string
+	TS     time.Time
+	Path   string
+}
+
+// List returns the cached files, optionally only for buoyID, oldest first
+// within each buoy. Files not following the naming scheme are skipped.
+func (s Store) List(buoyID string) ([]Entry, error) {
+	pattern := filepath.Join(s.Dir, "*", "*"+suffix)
+	if buoyID != "" {
+		pattern = filepath.Join(s.Dir, buoyID, "*"+suffix)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, p := range paths {
+		ts, err := time.Parse(TimeFormat, strings.TrimSuffix(filepath.Base(p), suffix))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, Entry{BuoyID: filepath.Base(filepath.Dir(p)), TS: ts, Path: p})
+	}
+	return entries, nil
+}
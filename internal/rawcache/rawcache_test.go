@@ -0,0 +1,79 @@
+package rawcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSave(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 30, 5, 123456789, time.FixedZone("CET", 3600))
+	tests := []struct {
+		name    string
+		buoy    string
+		wantErr bool
+	}{
+		{"plain", "buoy_001", false},
+		{"empty id", "", true},
+		{"slash", "../etc", true},
+		{"backslash", `a\b`, true},
+		{"dot", ".", true},
+		{"dot dot", "..", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path, err := Store{Dir: dir}.Save(tt.buoy, ts, "Hs,Tp\n1.5,8\n")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if want := filepath.Join(dir, tt.buoy, "20240301T113005.123456789Z_raw.txt"); path != want {
+				t.Errorf("Save = %s, want %s", path, want)
+			}
+			if data, err := os.ReadFile(path); err != nil || string(data) != "Hs,Tp\n1.5,8\n" {
+				t.Errorf("saved %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	s := Store{Dir: dir}
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, e := range []struct {
+		buoy string
+		ts   time.Time
+	}{{"b2", t0}, {"b1", t0.Add(time.Minute)}, {"b1", t0}} {
+		if _, err := s.Save(e.buoy, e.ts, "out"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "b1", "notes_raw.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "b1", "other.txt"), []byte("x"), 0644)
+
+	all, err := s.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range all {
+		got = append(got, e.BuoyID+" "+e.TS.Format(time.TimeOnly))
+	}
+	want := []string{"b1 12:00:00", "b1 12:01:00", "b2 12:00:00"}
+	if len(got) != len(want) {
+		t.Fatalf("List = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("List = %v, want %v", got, want)
+		}
+	}
+	if one, _ := s.List("b2"); len(one) != 1 || one[0].BuoyID != "b2" || !one[0].TS.Equal(t0) {
+		t.Errorf("List(b2) = %v", one)
+	}
+}
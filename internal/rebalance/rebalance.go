@@ -0,0 +1,78 @@
+// Package rebalance holds the message format and assignment algorithm
+// shared by the coordinator and subscribers for dynamic topic assignment.
+//
+// Topics under the coordinator base topic:
+//
+//	<base>/join                     subscriber -> coordinator (JoinMsg)
+//	<base>/leave                    subscriber -> coordinator (JoinMsg, also the LWT)
+//	<base>/rejoin                   coordinator -> subscribers, asks everyone to join again
+//	<base>/assign/<group>/<id>      coordinator -> subscriber (AssignMsg, retained)
+package rebalance
+
+import "sort"
+
+// DefaultGroup is used when a subscriber does not name a rebalance group.
+const DefaultGroup = "default"
+
+// JoinMsg announces a subscriber and the topics it is able to consume.
+type JoinMsg struct {
+	SubscriberID string   `json:"subscriber_id"`
+	Group        string   `json:"group,omitempty"`
+	Topics       []string `json:"topics"`
+}
+
+// AssignMsg lists the topics a subscriber should be subscribed to.
+type AssignMsg struct {
+	SubscriberID string   `json:"subscriber_id"`
+	Topics       []string `json:"topics"`
+}
+
+func JoinTopic(base string) string   { return base + "/join" }
+func LeaveTopic(base string) string  { return base + "/leave" }
+func RejoinTopic(base string) string { return base + "/rejoin" }
+
+func AssignTopic(base, group, subscriberID string) string {
+	return base + "/assign/" + group + "/" + subscriberID
+}
+
+// Assign distributes the union of all offered topics round-robin across
+// the members (subscriber ID -> offered topics), both taken in sorted
+// order so every run gives the same result. A topic is only given to a
+// member that offered it. Every member appears in the result, possibly
+// with no topics.
+func Assign(members map[string][]string) map[string][]string {
+	ids := make([]string, 0, len(members))
+	offered := make(map[string]map[string]bool, len(members))
+	all := make(map[string]bool)
+	for id, topics := range members {
+		ids = append(ids, id)
+		offered[id] = make(map[string]bool, len(topics))
+		for _, t := range topics {
+			offered[id][t] = true
+			all[t] = true
+		}
+	}
+	sort.Strings(ids)
+	topics := make([]string, 0, len(all))
+	for t := range all {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+
+	out := make(map[string][]string, len(ids))
+	for _, id := range ids {
+		out[id] = []string{}
+	}
+	next := 0
+	for _, t := range topics {
+		for i := 0; i < len(ids); i++ {
+			id := ids[(next+i)%len(ids)]
+			if offered[id][t] {
+				out[id] = append(out[id], t)
+				next = (next + i + 1) % len(ids)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,48 @@
+package rebalance
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssign(t *testing.T) {
+	six := []string{"t1", "t2", "t3", "t4", "t5", "t6"}
+	tests := []struct {
+		name    string
+		members map[string][]string
+		want    map[string][]string
+	}{
+		{
+			"3 subscribers, 6 topics",
+			map[string][]string{"s1": six, "s2": six, "s3": six},
+			map[string][]string{"s1": {"t1", "t4"}, "s2": {"t2", "t5"}, "s3": {"t3", "t6"}},
+		},
+		{
+			"after one leaves",
+			map[string][]string{"s1": six, "s3": six},
+			map[string][]string{"s1": {"t1", "t3", "t5"}, "s3": {"t2", "t4", "t6"}},
+		},
+		{
+			"only offered topics",
+			map[string][]string{"s1": {"t1", "t2"}, "s2": {"t3", "t4", "t5", "t6"}, "s3": {"t2", "t6"}},
+			map[string][]string{"s1": {"t1"}, "s2": {"t3", "t4", "t5"}, "s3": {"t2", "t6"}},
+		},
+		{
+			"union of offers",
+			map[string][]string{"s1": {"t1", "t2", "t3"}, "s2": {"t4", "t5", "t6"}, "s3": nil},
+			map[string][]string{"s1": {"t1", "t2", "t3"}, "s2": {"t4", "t5", "t6"}, "s3": {}},
+		},
+		{"no members", map[string][]string{}, map[string][]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Assign(tt.members)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Assign = %v, want %v", got, tt.want)
+			}
+			if again := Assign(tt.members); !reflect.DeepEqual(again, got) {
+				t.Errorf("second run gave %v", again)
+			}
+		})
+	}
+}
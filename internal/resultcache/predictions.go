@@ -0,0 +1,88 @@
+package resultcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// Key identifies a model input by the hash of its content.
+type Key [sha256.Size]byte
+
+// KeyOf returns the key of the model input data.
+func KeyOf(data []byte) Key {
+	return sha256.Sum256(data)
+}
+
+type predictionEntry struct {
+	key    Key
+	output string
+	at     time.Time
+}
+
+// PredictionCache is an LRU of raw model output by input content, so an
+// input seen before skips inference. Entries older than the TTL are
+// dropped on lookup.
+type PredictionCache struct {
+	size int
+	ttl  time.Duration // 0 keeps entries until evicted
+
+	mu      sync.Mutex
+	order   *list.List // front = most recently used
+	entries map[Key]*list.Element
+}
+
+func NewPredictionCache(size int, ttl time.Duration) *PredictionCache {
+	return &PredictionCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[Key]*list.Element),
+	}
+}
+
+// Get returns the output stored for k, if it has not expired.
+func (c *PredictionCache) Get(k Key) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[k]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*predictionEntry)
+	if c.ttl > 0 && time.Since(e.at) >= c.ttl {
+		c.order.Remove(el)
+		delete(c.entries, k)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return e.output, true
+}
+
+// Put stores output for k, evicting the least recently used entry when
+// the cache is full.
+func (c *PredictionCache) Put(k Key, output string) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[k]; ok {
+		e := el.Value.(*predictionEntry)
+		e.output, e.at = output, now
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[k] = c.order.PushFront(&predictionEntry{key: k, output: output, at: now})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*predictionEntry).key)
+	}
+}
+
+// Len returns the number of cached outputs, expired ones included.
+func (c *PredictionCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,74 @@
+// Package resultcache remembers recent predictions: the published results,
+// so the same result is not published twice for one input file, and the
+// raw model output by input content, so repeated inputs skip inference.
+package resultcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type key struct {
+	buoyID   string
+	filename string
+}
+
+type entry struct {
+	key    key
+	result string
+	at     time.Time
+}
+
+// RecentResultsCache is an LRU of the last published result per
+// (buoy, filename). Entries older than the window are ignored.
+type RecentResultsCache struct {
+	size   int
+	window time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // front = most recently used
+	entries map[key]*list.Element
+}
+
+func NewRecentResultsCache(size int, window time.Duration) *RecentResultsCache {
+	return &RecentResultsCache{
+		size:    size,
+		window:  window,
+		order:   list.New(),
+		entries: make(map[key]*list.Element),
+	}
+}
+
+// CheckAndStore reports whether result was already recorded for
+// (buoyID, filename) within the window; otherwise it records result as
+// the latest one.
+func (c *RecentResultsCache) CheckAndStore(buoyID, filename, result string) bool {
+	now := time.Now()
+	k := key{buoyID, filename}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[k]; ok {
+		e := el.Value.(*entry)
+		c.order.MoveToFront(el)
+		if e.result == result && now.Sub(e.at) < c.window {
+			return true
+		}
+		e.result, e.at = result, now
+		return false
+	}
+	c.entries[k] = c.order.PushFront(&entry{key: k, result: result, at: now})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key)
+	}
+	return false
+}
+
+// Len returns the number of cached results.
+func (c *RecentResultsCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,78 @@
+package resultcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckAndStore(t *testing.T) {
+	tests := []struct {
+		name                   string
+		buoy, filename, result string
+		want                   bool
+	}{
+		{"hit", "b1", "a.npz", "wave,1", true},
+		{"other result", "b1", "a.npz", "wave,2", false},
+		{"other file", "b1", "b.npz", "wave,1", false},
+		{"other buoy", "b2", "a.npz", "wave,1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewRecentResultsCache(100, time.Hour)
+			if c.CheckAndStore("b1", "a.npz", "wave,1") {
+				t.Fatal("first result reported as a duplicate")
+			}
+			if got := c.CheckAndStore(tt.buoy, tt.filename, tt.result); got != tt.want {
+				t.Errorf("CheckAndStore = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAndStoreReplaces(t *testing.T) {
+	c := NewRecentResultsCache(100, time.Hour)
+	c.CheckAndStore("b1", "a.npz", "wave,1")
+	c.CheckAndStore("b1", "a.npz", "wave,2")
+	if !c.CheckAndStore("b1", "a.npz", "wave,2") {
+		t.Error("latest result not remembered")
+	}
+	if c.CheckAndStore("b1", "a.npz", "wave,1") {
+		t.Error("replaced result still matched")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len = %d, want 1", c.Len())
+	}
+}
+
+func TestCheckAndStoreWindow(t *testing.T) {
+	c := NewRecentResultsCache(100, 20*time.Millisecond)
+	c.CheckAndStore("b1", "a.npz", "wave,1")
+	time.Sleep(30 * time.Millisecond)
+	if c.CheckAndStore("b1", "a.npz", "wave,1") {
+		t.Error("result older than the window reported as a duplicate")
+	}
+	if !c.CheckAndStore("b1", "a.npz", "wave,1") {
+		t.Error("refreshed result not remembered")
+	}
+}
+
+func TestCheckAndStoreEviction(t *testing.T) {
+	c := NewRecentResultsCache(3, time.Hour)
+	for _, f := range []string{"a", "b", "c"} {
+		c.CheckAndStore("b1", f, "r")
+	}
+	// touching a makes b the least recently used
+	c.CheckAndStore("b1", "a", "r")
+	c.CheckAndStore("b1", "d", "r")
+	if c.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", c.Len())
+	}
+	for _, f := range []string{"a", "c", "d"} {
+		if !c.CheckAndStore("b1", f, "r") {
+			t.Errorf("%s evicted", f)
+		}
+	}
+	if c.CheckAndStore("b1", "b", "r") {
+		t.Error("least recently used entry b not evicted")
+	}
+}
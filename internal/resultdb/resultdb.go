@@ -0,0 +1,127 @@
+// Package resultdb archives prediction results in a local SQLite
+// database, so they survive downlink outages and can be bulk-synced
+// later. Rows start unsynced; a sync job reads them with Unsynced and
+// flags them with MarkSynced once delivered.
+package resultdb
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS predictions (
+	id                   INTEGER PRIMARY KEY AUTOINCREMENT,
+	buoy_id              TEXT NOT NULL,
+	filename             TEXT NOT NULL,
+	send_time            REAL NOT NULL,
+	received_at          DATETIME NOT NULL,
+	predicted_at         DATETIME NOT NULL,
+	node_id              TEXT NOT NULL,
+	model_version        TEXT NOT NULL,
+	npz_size             INTEGER NOT NULL,
+	header               TEXT NOT NULL,
+	data                 TEXT NOT NULL,
+	latency_reception_ms INTEGER NOT NULL,
+	latency_inference_ms INTEGER NOT NULL,
+	synced               INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS predictions_unsynced ON predictions(synced, id);`
+
+// Record is one archived prediction. Header and Data are the CSV lines
+// as published.
+type Record struct {
+	ID                 int64
+	BuoyID             string
+	Filename           string
+	SendTime           float64 // seconds since the epoch
+	ReceivedAt         time.Time
+	PredictedAt        time.Time
+	NodeID             string
+	ModelVersion       string
+	NPZSize            int64
+	Header             string
+	Data               string
+	LatencyReceptionMs int64
+	LatencyInferenceMs int64
+}
+
+// Store is a SQLite archive of prediction results.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (or creates) the database at path in WAL mode.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("resultdb: create schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Insert archives r and returns its row ID.
+func (s *Store) Insert(r Record) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO predictions(buoy_id, filename, send_time, received_at, predicted_at,
+		node_id, model_version, npz_size, header, data, latency_reception_ms, latency_inference_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.BuoyID, r.Filename, r.SendTime, r.ReceivedAt.UTC(), r.PredictedAt.UTC(),
+		r.NodeID, r.ModelVersion, r.NPZSize, r.Header, r.Data, r.LatencyReceptionMs, r.LatencyInferenceMs)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// Unsynced returns up to limit rows not yet marked synced, oldest first.
+func (s *Store) Unsynced(limit int) ([]Record, error) {
+	rows, err := s.db.Query(`SELECT id, buoy_id, filename, send_time, received_at, predicted_at,
+		node_id, model_version, npz_size, header, data, latency_reception_ms, latency_inference_ms
+		FROM predictions WHERE synced = 0 ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Record
+	for rows.Next() {
+		var r Record
+		if err := rows.Scan(&r.ID, &r.BuoyID, &r.Filename, &r.SendTime, &r.ReceivedAt, &r.PredictedAt,
+			&r.NodeID, &r.ModelVersion, &r.NPZSize, &r.Header, &r.Data, &r.LatencyReceptionMs, &r.LatencyInferenceMs); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// MarkSynced flags the rows with the given IDs as synced.
+func (s *Store) MarkSynced(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	_, err := s.db.Exec(`UPDATE predictions SET synced = 1 WHERE id IN (`+placeholders+`)`, args...)
+	return err
+}
+
+// Count returns the number of archived rows.
+func (s *Store) Count() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM predictions`).Scan(&n)
+	return n, err
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,93 @@
+package resultdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func record(i int) Record {
+	received := time.Date(2024, 5, 1, 12, 0, i, 250_000_000, time.FixedZone("CEST", 2*3600))
+	return Record{
+		BuoyID:             fmt.Sprintf("4100%d", i),
+		Filename:           fmt.Sprintf("4100%d_20240501.npz", i),
+		SendTime:           1714557600.125 + float64(i),
+		ReceivedAt:         received,
+		PredictedAt:        received.Add(40 * time.Millisecond),
+		NodeID:             "sat-1",
+		ModelVersion:       "v3",
+		NPZSize:            int64(2048 + i),
+		Header:             "Buoy-station,WVHT,DPD",
+		Data:               fmt.Sprintf("4100%d,1.2,%d", i, 8+i),
+		LatencyReceptionMs: int64(120 + i),
+		LatencyInferenceMs: 40,
+	}
+}
+
+func TestInsertAndReadBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []Record
+	for i := range 3 {
+		r := record(i)
+		id, err := s.Insert(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.ID = id
+		want = append(want, r)
+	}
+	if n, err := s.Count(); err != nil || n != 3 {
+		t.Fatalf("Count() = %d, %v", n, err)
+	}
+
+	got, err := s.Unsynced(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("read back %d rows, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].ReceivedAt.Equal(want[i].ReceivedAt) || !got[i].PredictedAt.Equal(want[i].PredictedAt) {
+			t.Errorf("row %d times %v, %v; want %v, %v", i, got[i].ReceivedAt, got[i].PredictedAt, want[i].ReceivedAt, want[i].PredictedAt)
+		}
+		g, w := got[i], want[i]
+		g.ReceivedAt, g.PredictedAt, w.ReceivedAt, w.PredictedAt = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("row %d = %+v\nwant %+v", i, g, w)
+		}
+	}
+	if first, err := s.Unsynced(1); err != nil || len(first) != 1 || first[0].ID != want[0].ID {
+		t.Errorf("Unsynced(1) = %+v, %v; want the oldest row", first, err)
+	}
+
+	// synced rows stay archived but are no longer handed out, and
+	// survive reopening the database
+	if err := s.MarkSynced([]int64{want[0].ID, want[2].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkSynced(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	got, err = s.Unsynced(10)
+	if err != nil || len(got) != 1 || got[0].ID != want[1].ID || got[0].BuoyID != want[1].BuoyID {
+		t.Errorf("after MarkSynced, Unsynced = %+v, %v; want row %d", got, err, want[1].ID)
+	}
+	if n, err := s.Count(); err != nil || n != 3 {
+		t.Errorf("Count() after reopening = %d, %v", n, err)
+	}
+}
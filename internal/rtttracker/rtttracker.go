@@ -0,0 +1,119 @@
+// Package rtttracker measures broker round-trip time from the MQTT
+// keepalive exchange. Conn wraps the client's net.Conn, follows the MQTT
+// packet framing in both directions and times each PINGREQ against the
+// PINGRESP that answers it.
+package rtttracker
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	pingreq  = 12
+	pingresp = 13
+)
+
+// Conn is a net.Conn that records the latest PINGREQ/PINGRESP round trip.
+type Conn struct {
+	net.Conn
+	// OnRTT, if set, is called with every new measurement.
+	OnRTT func(time.Duration)
+
+	mu       sync.Mutex
+	out, in  framer
+	pingSent time.Time
+	rtt      time.Duration
+}
+
+// Wrap returns c with RTT tracking; onRTT may be nil.
+func Wrap(c net.Conn, onRTT func(time.Duration)) *Conn {
+	return &Conn{Conn: c, OnRTT: onRTT}
+}
+
+// RTT returns the last measured round trip, or 0 before the first one.
+func (c *Conn) RTT() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rtt
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.mu.Lock()
+	c.out.feed(b[:n], func(typ byte) {
+		if typ == pingreq {
+			c.pingSent = time.Now()
+		}
+	})
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	var measured time.Duration
+	c.mu.Lock()
+	c.in.feed(b[:n], func(typ byte) {
+		if typ == pingresp && !c.pingSent.IsZero() {
+			c.rtt = time.Since(c.pingSent)
+			c.pingSent = time.Time{}
+			measured = c.rtt
+		}
+	})
+	c.mu.Unlock()
+	if measured > 0 && c.OnRTT != nil {
+		c.OnRTT(measured)
+	}
+	return n, err
+}
+
+// framer follows MQTT fixed-header framing across arbitrarily split
+// reads or writes and reports the type of each complete packet.
+type framer struct {
+	state  int
+	typ    byte
+	length int
+	shift  uint
+}
+
+const (
+	stateHeader = iota
+	stateLength
+	stateBody
+)
+
+func (f *framer) feed(b []byte, packet func(typ byte)) {
+	for len(b) > 0 {
+		switch f.state {
+		case stateHeader:
+			f.typ = b[0] >> 4
+			f.length, f.shift = 0, 0
+			f.state = stateLength
+			b = b[1:]
+		case stateLength:
+			c := b[0]
+			b = b[1:]
+			f.length |= int(c&0x7f) << f.shift
+			f.shift += 7
+			if c&0x80 != 0 {
+				continue
+			}
+			if f.length == 0 {
+				f.state = stateHeader
+				packet(f.typ)
+				continue
+			}
+			f.state = stateBody
+		case stateBody:
+			n := min(len(b), f.length)
+			f.length -= n
+			b = b[n:]
+			if f.length == 0 {
+				f.state = stateHeader
+				packet(f.typ)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package rtttracker
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pingServer answers every PINGREQ with a PINGRESP after delay. Before
+// the PINGRESP it sends a PUBLISH whose payload holds PINGRESP-like
+// bytes, so only framing, not byte matching, tells the packets apart.
+func pingServer(t *testing.T, delay time.Duration) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var b [2]byte
+		for {
+			if _, err := io.ReadFull(conn, b[:]); err != nil {
+				return
+			}
+			if b[0]>>4 != pingreq {
+				continue
+			}
+			time.Sleep(delay)
+			conn.Write([]byte{0x30, 5, 0, 1, 't', 0xd0, 0x00}) // PUBLISH "t", payload d0 00
+			conn.Write([]byte{0xd0, 0x00})
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRTT(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	raw, err := net.Dial("tcp", pingServer(t, delay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var measured []time.Duration
+	c := Wrap(raw, func(d time.Duration) { measured = append(measured, d) })
+	defer c.Close()
+	if c.RTT() != 0 {
+		t.Fatal("RTT before any ping")
+	}
+
+	for round := range 3 {
+		if _, err := c.Write([]byte{0xc0, 0x00}); err != nil {
+			t.Fatal(err)
+		}
+		// 7 bytes of PUBLISH, 2 of PINGRESP, read in small pieces
+		buf := make([]byte, 9)
+		for n := 0; n < len(buf); {
+			m, err := c.Read(buf[n:min(n+2, len(buf))])
+			if err != nil {
+				t.Fatal(err)
+			}
+			n += m
+		}
+		rtt := c.RTT()
+		if rtt < delay || rtt > delay+200*time.Millisecond {
+			t.Errorf("round %d: RTT %v, want about %v", round, rtt, delay)
+		}
+		if len(measured) != round+1 || measured[round] != rtt {
+			t.Errorf("round %d: OnRTT saw %v", round, measured)
+		}
+	}
+}
+
+func TestFramer(t *testing.T) {
+	// PINGREQ, PUBLISH with a 200-byte body (two length bytes), PINGRESP
+	stream := []byte{0xc0, 0x00, 0x30, 0xc8, 0x01}
+	stream = append(stream, make([]byte, 200)...)
+	stream = append(stream, 0xd0, 0x00)
+	for _, chunk := range []int{1, 2, 3, 7, len(stream)} {
+		var f framer
+		var got []byte
+		for b := stream; len(b) > 0; {
+			n := min(chunk, len(b))
+			f.feed(b[:n], func(typ byte) { got = append(got, typ) })
+			b = b[n:]
+		}
+		if want := []byte{pingreq, 3, pingresp}; !reflect.DeepEqual(got, want) {
+			t.Errorf("chunks of %d: packets %v, want %v", chunk, got, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package s3sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/s3source"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TestS3Compatible uploads files with s3sink and reads them back with
+// s3source against a real S3-compatible store. It runs only when
+// S3_TEST_ENDPOINT names one, e.g. with MinIO:
+//
+//	docker run -d -p 9000:9000 minio/minio server /data
+//	mc alias set local http://127.0.0.1:9000 minioadmin minioadmin && mc mb local/test
+//	S3_TEST_ENDPOINT=http://127.0.0.1:9000 S3_TEST_BUCKET=test \
+//	S3_TEST_ACCESS_KEY=minioadmin S3_TEST_SECRET_KEY=minioadmin go test ./internal/s3sink
+func TestS3Compatible(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT not set")
+	}
+	prefix := fmt.Sprintf("cloudletsapps-test-%d", time.Now().UnixNano())
+	cfg := Config{
+		Endpoint:  endpoint,
+		Bucket:    os.Getenv("S3_TEST_BUCKET"),
+		Prefix:    prefix,
+		AccessKey: os.Getenv("S3_TEST_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_TEST_SECRET_KEY"),
+		Region:    os.Getenv("S3_TEST_REGION"),
+		PartSize:  minPartSize,
+	}
+	u, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	t.Cleanup(func() {
+		for _, key := range []string{"sent/b1/a.npz", "sent/b1/big file.npz", "b1/a.npz", "b1/big file.npz"} {
+			u.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &u.bucket, Key: aws.String(prefix + "/" + key)})
+		}
+	})
+
+	small := []byte("npz data")
+	big := make([]byte, minPartSize+1<<20) // two parts
+	rand.Read(big)
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"a.npz": small, "big file.npz": big} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := u.Upload(ctx, p, "b1/"+name); err != nil {
+			t.Fatalf("Upload %s: %v", name, err)
+		}
+	}
+
+	src, err := s3source.New(s3source.Config{Endpoint: cfg.Endpoint, Bucket: cfg.Bucket, Prefix: prefix, AccessKey: cfg.AccessKey, SecretKey: cfg.SecretKey, Region: cfg.Region})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buoys, err := src.ListBuoys()
+	if err != nil || !reflect.DeepEqual(buoys, []string{"b1"}) {
+		t.Fatalf("ListBuoys = %v, %v", buoys, err)
+	}
+	files, err := src.ListFiles("b1")
+	want := []string{prefix + "/b1/a.npz", prefix + "/b1/big file.npz"}
+	if err != nil || !reflect.DeepEqual(files, want) {
+		t.Fatalf("ListFiles = %v, %v; want %v", files, err, want)
+	}
+	for i, data := range [][]byte{small, big} {
+		got, err := src.ReadFile(files[i])
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("ReadFile %s: %d bytes, %v; want %d bytes", files[i], len(got), err, len(data))
+		}
+		if err := src.MarkSent(files[i]); err != nil {
+			t.Errorf("MarkSent %s: %v", files[i], err)
+		}
+	}
+	if files, err := src.ListFiles("b1"); err != nil || len(files) != 0 {
+		t.Errorf("ListFiles after MarkSent = %v, %v", files, err)
+	}
+	if got, err := src.ReadFile(prefix + "/sent/b1/big file.npz"); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("sent copy: %d bytes, %v", len(got), err)
+	}
+}
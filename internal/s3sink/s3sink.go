@@ -0,0 +1,202 @@
+// Package s3sink uploads finished output files to AWS S3 or an
+// S3-compatible object store (MinIO, ...) with aws-sdk-go-v2.
+//
+// Files larger than the part size go up as multipart uploads, so a link
+// that drops mid-transfer costs one part rather than the whole file.
+// Requests failing with a network error, 429 or 5xx are retried with
+// backoff.
+package s3sink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+	"cloudletsapps/internal/s3util"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	defaultPartSize = 16 << 20
+	minPartSize     = 5 << 20 // S3's minimum for every part but the last
+	defaultRetries  = 3
+)
+
+type Config struct {
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	// PartSize is the file size above which uploads are multipart, and
+	// their part size. Zero means 16 MiB; it is raised to S3's 5 MiB
+	// minimum.
+	PartSize int64
+	// Retries is how often a failed request is retried. Zero means 3;
+	// negative disables retries.
+	Retries int
+}
+
+// Uploader PUTs local files into a single bucket/prefix.
+type Uploader struct {
+	client   *s3.Client
+	bucket   string
+	prefix   string
+	partSize int64
+	retries  int
+	retry    backoff.Policy
+}
+
+func New(cfg Config) (*Uploader, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3sink: bucket is required")
+	}
+	client, err := s3util.NewClient(s3util.Endpoint{URL: cfg.Endpoint, Region: cfg.Region, AccessKey: cfg.AccessKey, SecretKey: cfg.SecretKey}, 5*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("s3sink: %w", err)
+	}
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+	retries := cfg.Retries
+	if retries == 0 {
+		retries = defaultRetries
+	}
+	return &Uploader{
+		client:   client,
+		bucket:   cfg.Bucket,
+		prefix:   strings.Trim(cfg.Prefix, "/"),
+		partSize: max(partSize, minPartSize),
+		retries:  max(retries, 0),
+		retry:    backoff.DefaultPolicy,
+	}, nil
+}
+
+// Upload stores the file at localPath under <prefix>/<s3Key>.
+func (u *Uploader) Upload(ctx context.Context, localPath, s3Key string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	key := s3Key
+	if u.prefix != "" {
+		key = path.Join(u.prefix, s3Key)
+	}
+	if st.Size() > u.partSize {
+		return u.uploadParts(ctx, f, st.Size(), key)
+	}
+	err = u.do(ctx, func(ctx context.Context) error {
+		_, err := u.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        &u.bucket,
+			Key:           &key,
+			Body:          io.NewSectionReader(f, 0, st.Size()),
+			ContentLength: aws.Int64(st.Size()),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("s3sink: put %s: %w", key, err)
+	}
+	return nil
+}
+
+// uploadParts stores f as a multipart upload of u.partSize parts. An
+// upload that fails is aborted, so the store does not keep its parts.
+func (u *Uploader) uploadParts(ctx context.Context, f *os.File, size int64, key string) error {
+	var id *string
+	err := u.do(ctx, func(ctx context.Context) error {
+		out, err := u.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: &u.bucket, Key: &key})
+		if err == nil {
+			id = out.UploadId
+		}
+		return err
+	})
+	if err == nil && aws.ToString(id) == "" {
+		err = fmt.Errorf("no upload id")
+	}
+	if err != nil {
+		return fmt.Errorf("s3sink: start upload of %s: %w", key, err)
+	}
+
+	var parts []types.CompletedPart
+	for n, off := int32(1), int64(0); off < size; n, off = n+1, off+u.partSize {
+		partSize := min(u.partSize, size-off)
+		err := u.do(ctx, func(ctx context.Context) error {
+			out, err := u.client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:        &u.bucket,
+				Key:           &key,
+				UploadId:      id,
+				PartNumber:    aws.Int32(n),
+				Body:          io.NewSectionReader(f, off, partSize),
+				ContentLength: aws.Int64(partSize),
+			})
+			if err == nil {
+				parts = append(parts, types.CompletedPart{PartNumber: aws.Int32(n), ETag: out.ETag})
+			}
+			return err
+		})
+		if err != nil {
+			u.abort(ctx, key, id)
+			return fmt.Errorf("s3sink: put part %d of %s: %w", n, key, err)
+		}
+	}
+
+	err = u.do(ctx, func(ctx context.Context) error {
+		_, err := u.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          &u.bucket,
+			Key:             &key,
+			UploadId:        id,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+		return err
+	})
+	if err != nil {
+		u.abort(ctx, key, id)
+		return fmt.Errorf("s3sink: complete upload of %s: %w", key, err)
+	}
+	return nil
+}
+
+// abort drops the parts of a failed upload, even once ctx is canceled. It
+// is best effort; a lifecycle rule on the bucket can clean up what is left.
+func (u *Uploader) abort(ctx context.Context, key string, uploadID *string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	u.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: &u.bucket, Key: &key, UploadId: uploadID})
+}
+
+// do runs one S3 call, retrying network errors, 429 and 5xx with backoff
+// until u.retries is used up or ctx is done. Other errors fail at once.
+func (u *Uploader) do(ctx context.Context, call func(context.Context) error) error {
+	delay := u.retry.New()
+	for attempt := 0; ; attempt++ {
+		err := call(ctx)
+		if err == nil {
+			return nil
+		}
+		if !s3util.Retriable(err) || attempt >= u.retries || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(delay.Next()):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
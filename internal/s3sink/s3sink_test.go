@@ -0,0 +1,283 @@
+package s3sink
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+)
+
+type completeUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+// fakeS3 stores PUT objects and multipart uploads in memory. fail, when
+// set, can answer a request with an error status instead.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+	uploads map[string]map[int]string // upload id -> part number -> data
+	aborted []string
+	calls   []string
+	fail    func(r *http.Request) int
+}
+
+func newFake(t *testing.T, cfg Config) (*fakeS3, *Uploader) {
+	t.Helper()
+	f := &fakeS3{objects: map[string]string{}, uploads: map[string]map[int]string{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	cfg.Endpoint, cfg.Bucket, cfg.AccessKey, cfg.SecretKey = srv.URL, "out", "key", "secret"
+	u, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.retry = backoff.Policy{Initial: time.Millisecond, Max: time.Millisecond}
+	return f, u
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// the SDK names the operation in x-id; the tests match on the rest
+	q := r.URL.Query()
+	q.Del("x-id")
+	f.calls = append(f.calls, r.Method+" "+q.Encode())
+	if f.fail != nil {
+		if code := f.fail(r); code != 0 {
+			w.WriteHeader(code)
+			fmt.Fprint(w, "<Error><Code>InjectedError</Code><Message>injected</Message></Error>")
+			return
+		}
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/out/")
+	body, _ := io.ReadAll(r.Body)
+	if r.Method == http.MethodPut && int64(len(body)) != r.ContentLength {
+		http.Error(w, "IncompleteBody", http.StatusBadRequest)
+		return
+	}
+	id := q.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		id = fmt.Sprint("upload-", len(f.uploads)+1)
+		f.uploads[id] = map[int]string{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && id != "":
+		var n int
+		fmt.Sscan(q.Get("partNumber"), &n)
+		f.uploads[id][n] = string(body)
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, n))
+	case r.Method == http.MethodPut:
+		f.objects[key] = string(body)
+	case r.Method == http.MethodPost && id != "":
+		var done completeUpload
+		if err := xml.Unmarshal(body, &done); err != nil {
+			http.Error(w, "MalformedXML", http.StatusBadRequest)
+			return
+		}
+		var data strings.Builder
+		for i, p := range done.Parts {
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				fmt.Fprint(w, "<Error><Code>InvalidPart</Code><Message>bad part list</Message></Error>")
+				return
+			}
+			data.WriteString(f.uploads[id][p.PartNumber])
+		}
+		f.objects[key] = data.String()
+		delete(f.uploads, id)
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && id != "":
+		f.aborted = append(f.aborted, id)
+		delete(f.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeFile(t *testing.T, data string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "station.csv")
+	if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestUpload(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		partSize int64
+		parts    int // 0 for a single PUT
+	}{
+		{"single put", "a,b\n1,2\n", 0, 0},
+		{"empty file", "", 0, 0},
+		{"multipart", "0123456789abcdefghij-", 8, 3},
+		{"exact parts", "0123456789abcdef", 8, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, u := newFake(t, Config{Prefix: "/csv/"})
+			if tt.partSize > 0 {
+				u.partSize = tt.partSize
+			}
+			if err := u.Upload(context.Background(), writeFile(t, tt.data), "station 1/a.csv"); err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := f.objects["csv/station 1/a.csv"]; !ok || got != tt.data {
+				t.Errorf("stored %q (%v), want %q; objects %v", got, ok, tt.data, f.objects)
+			}
+			parts := 0
+			for _, c := range f.calls {
+				if strings.HasPrefix(c, "PUT partNumber=") {
+					parts++
+				}
+			}
+			if parts != tt.parts {
+				t.Errorf("uploaded %d parts, want %d", parts, tt.parts)
+			}
+		})
+	}
+}
+
+func TestUploadRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		fail      []int // statuses answered before the request goes through
+		retries   int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"recovers from 503", []int{503, 503}, 0, false, 3},
+		{"recovers from 429", []int{429}, 0, false, 2},
+		{"gives up", []int{500, 500, 500, 500}, 0, true, 4},
+		{"no retries", []int{503}, -1, true, 1},
+		{"client error is final", []int{403}, 0, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, u := newFake(t, Config{Retries: tt.retries})
+			pending := tt.fail
+			f.fail = func(*http.Request) int {
+				if len(pending) == 0 {
+					return 0
+				}
+				code := pending[0]
+				pending = pending[1:]
+				return code
+			}
+			err := u.Upload(context.Background(), writeFile(t, "x"), "a.csv")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(f.calls) != tt.wantCalls {
+				t.Errorf("%d requests, want %d: %v", len(f.calls), tt.wantCalls, f.calls)
+			}
+		})
+	}
+}
+
+func TestMultipartRetriesOnePart(t *testing.T) {
+	f, u := newFake(t, Config{})
+	u.partSize = 4
+	failed := false
+	f.fail = func(r *http.Request) int {
+		if r.URL.Query().Get("partNumber") == "2" && !failed {
+			failed = true
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+	if err := u.Upload(context.Background(), writeFile(t, "aaaabbbbcc"), "a.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if f.objects["a.csv"] != "aaaabbbbcc" {
+		t.Errorf("stored %q", f.objects["a.csv"])
+	}
+	var puts []string
+	for _, c := range f.calls {
+		if strings.HasPrefix(c, "PUT") {
+			puts = append(puts, c)
+		}
+	}
+	sort.Strings(puts)
+	if len(puts) != 4 || puts[1] != puts[2] {
+		t.Errorf("part uploads %v, want part 2 sent twice", puts)
+	}
+}
+
+func TestMultipartAbort(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(r *http.Request) int
+	}{
+		{"part rejected", func(r *http.Request) int {
+			if r.URL.Query().Get("partNumber") == "2" {
+				return http.StatusBadRequest
+			}
+			return 0
+		}},
+		{"completion fails", func(r *http.Request) int {
+			if r.Method == http.MethodPost && r.URL.Query().Has("uploadId") {
+				return http.StatusInternalServerError
+			}
+			return 0
+		}},
+		// S3 may report a failed completion in the body of a 200
+		{"completion error in 200", func(r *http.Request) int {
+			if r.Method == http.MethodPost && r.URL.Query().Has("uploadId") {
+				return http.StatusOK
+			}
+			return 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, u := newFake(t, Config{Retries: -1})
+			u.partSize = 4
+			f.fail = tt.fail
+			if err := u.Upload(context.Background(), writeFile(t, "aaaabbbbcc"), "a.csv"); err == nil {
+				t.Fatal("upload succeeded")
+			}
+			if len(f.aborted) != 1 || len(f.uploads) != 0 {
+				t.Errorf("aborted %v, left %v", f.aborted, f.uploads)
+			}
+			if _, ok := f.objects["a.csv"]; ok {
+				t.Error("object stored")
+			}
+		})
+	}
+}
+
+func TestUploadCanceled(t *testing.T) {
+	f, u := newFake(t, Config{Retries: 100})
+	u.retry = backoff.Policy{Initial: time.Hour, Max: time.Hour}
+	f.fail = func(*http.Request) int { return http.StatusServiceUnavailable }
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := u.Upload(ctx, writeFile(t, "x"), "a.csv"); err == nil {
+		t.Fatal("upload succeeded")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("retry backoff ignored the canceled context")
+	}
+}
@@ -0,0 +1,152 @@
+// Package s3source lists and reads buoy NPZ files from an S3-compatible
+// object store (AWS S3, MinIO, ...). Objects are laid out as
+// <prefix>/<buoyID>/<file>.npz, mirroring the local base folder layout.
+//
+// It uses aws-sdk-go-v2; a custom endpoint is addressed path-style.
+package s3source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloudletsapps/internal/s3util"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// SentDir is the sub-prefix that published objects are moved to.
+const SentDir = "sent"
+
+type Config struct {
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Region    string
+}
+
+// Source reads NPZ objects from a single bucket/prefix.
+type Source struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// timeout bounds every request; NPZ files are small.
+const timeout = 30 * time.Second
+
+func New(cfg Config) (*Source, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3source: bucket is required")
+	}
+	client, err := s3util.NewClient(s3util.Endpoint{URL: cfg.Endpoint, Region: cfg.Region, AccessKey: cfg.AccessKey, SecretKey: cfg.SecretKey}, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("s3source: %w", err)
+	}
+	return &Source{client: client, bucket: cfg.Bucket, prefix: strings.Trim(cfg.Prefix, "/")}, nil
+}
+
+func (s *Source) key(parts ...string) string {
+	if s.prefix != "" {
+		parts = append([]string{s.prefix}, parts...)
+	}
+	return path.Join(parts...)
+}
+
+// ListBuoys returns the buoy IDs found directly under the prefix.
+func (s *Source) ListBuoys() ([]string, error) {
+	base := ""
+	if s.prefix != "" {
+		base = s.prefix + "/"
+	}
+	_, prefixes, err := s.list(base)
+	if err != nil {
+		return nil, fmt.Errorf("s3source: list buoys: %w", err)
+	}
+	var buoys []string
+	for _, p := range prefixes {
+		id := strings.TrimSuffix(strings.TrimPrefix(p, base), "/")
+		if id != "" && id != SentDir {
+			buoys = append(buoys, id)
+		}
+	}
+	sort.Strings(buoys)
+	return buoys, nil
+}
+
+// ListFiles returns the sorted keys of all .npz objects under <prefix>/<buoyID>/.
+func (s *Source) ListFiles(buoyID string) ([]string, error) {
+	keys, _, err := s.list(s.key(buoyID) + "/")
+	if err != nil {
+		return nil, fmt.Errorf("s3source: list %s: %w", buoyID, err)
+	}
+	var npz []string
+	for _, k := range keys {
+		if path.Ext(k) == ".npz" {
+			npz = append(npz, k)
+		}
+	}
+	sort.Strings(npz)
+	return npz, nil
+}
+
+// ReadFile downloads the object stored at key.
+func (s *Source) ReadFile(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &key})
+	if err != nil {
+		return nil, fmt.Errorf("s3source: get %s: %w", key, err)
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+// MarkSent moves key to <prefix>/sent/<buoyID>/<file> so it is not listed again.
+func (s *Source) MarkSent(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rel := strings.TrimPrefix(key, s.prefix+"/")
+	dst := s.key(SentDir, rel)
+	// the copy source is URL-encoded, one path segment at a time
+	src := strings.Split(s.bucket+"/"+key, "/")
+	for i := range src {
+		src[i] = url.PathEscape(src[i])
+	}
+	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{Bucket: &s.bucket, Key: &dst, CopySource: aws.String(strings.Join(src, "/"))})
+	if err != nil {
+		return fmt.Errorf("s3source: copy %s: %w", key, err)
+	}
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: &key}); err != nil {
+		return fmt.Errorf("s3source: delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// list pages through ListObjectsV2 with "/" as delimiter.
+func (s *Source) list(prefix string) (keys, prefixes []string, err error) {
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &prefix, Delimiter: aws.String("/")})
+	for pages.HasMorePages() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		page, err := pages.NextPage(ctx)
+		cancel()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range page.Contents {
+			keys = append(keys, aws.ToString(c.Key))
+		}
+		for _, p := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(p.Prefix))
+		}
+	}
+	return keys, prefixes, nil
+}
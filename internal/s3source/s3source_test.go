@@ -0,0 +1,212 @@
+package s3source
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 serves a path-style bucket from memory: ListObjectsV2 (one key
+// or prefix per page, so paging is exercised), GET, copy and DELETE.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]string
+	calls   []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.Method+" "+r.RequestURI)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket)
+	if !ok {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(body))
+	case r.Method == http.MethodPut:
+		src, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+		body, ok := f.objects[strings.TrimPrefix(src, f.bucket+"/")]
+		if err != nil || !strings.HasPrefix(src, f.bucket+"/") {
+			ok = false
+		}
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		f.objects[key] = body
+		fmt.Fprint(w, "<CopyObjectResult><ETag>&quot;etag&quot;</ETag></CopyObjectResult>")
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	var entries []string // keys, and prefixes ending in delim
+	seen := map[string]bool{}
+	for k := range f.objects {
+		rest, ok := strings.CutPrefix(k, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delim); delim != "" && i >= 0 {
+			k = prefix + rest[:i+1]
+		}
+		if !seen[k] {
+			seen[k] = true
+			entries = append(entries, k)
+		}
+	}
+	sort.Strings(entries)
+	start := 0
+	if token := q.Get("continuation-token"); token != "" {
+		start = sort.SearchStrings(entries, token)
+	}
+	var page struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []struct {
+			Key string
+		}
+		CommonPrefixes []struct {
+			Prefix string
+		}
+		IsTruncated           bool
+		NextContinuationToken string
+	}
+	if start < len(entries) {
+		e := entries[start]
+		if strings.HasSuffix(e, delim) {
+			page.CommonPrefixes = append(page.CommonPrefixes, struct{ Prefix string }{e})
+		} else {
+			page.Contents = append(page.Contents, struct{ Key string }{e})
+		}
+		if start+1 < len(entries) {
+			page.IsTruncated, page.NextContinuationToken = true, entries[start+1]
+		}
+	}
+	xml.NewEncoder(w).Encode(page)
+}
+
+func newFake(t *testing.T, objects map[string]string) (*fakeS3, *Source) {
+	t.Helper()
+	return newFakeWithKey(t, objects, "key")
+}
+
+func newFakeWithKey(t *testing.T, objects map[string]string, accessKey string) (*fakeS3, *Source) {
+	t.Helper()
+	f := &fakeS3{bucket: "buoys", objects: objects}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	src, err := New(Config{Endpoint: srv.URL, Bucket: "buoys", Prefix: "/raw/", AccessKey: accessKey, SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, src
+}
+
+func TestListing(t *testing.T) {
+	_, src := newFake(t, map[string]string{
+		"raw/b2/c.npz":      "c",
+		"raw/b1/a.npz":      "a",
+		"raw/b1/b.npz":      "b",
+		"raw/b1/notes.txt":  "-",
+		"raw/sent/b1/x.npz": "x",
+		"other/b3/d.npz":    "d",
+	})
+	buoys, err := src.ListBuoys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b1", "b2"}; !reflect.DeepEqual(buoys, want) {
+		t.Errorf("ListBuoys = %v, want %v", buoys, want)
+	}
+	files, err := src.ListFiles("b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"raw/b1/a.npz", "raw/b1/b.npz"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("ListFiles = %v, want %v", files, want)
+	}
+}
+
+func TestReadAndMarkSent(t *testing.T) {
+	f, src := newFake(t, map[string]string{"raw/b1/a 1.npz": "data"})
+	got, err := src.ReadFile("raw/b1/a 1.npz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "data" {
+		t.Errorf("ReadFile = %q", got)
+	}
+	if err := src.MarkSent("raw/b1/a 1.npz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.objects["raw/b1/a 1.npz"]; ok {
+		t.Error("original left in place")
+	}
+	if f.objects["raw/sent/b1/a 1.npz"] != "data" {
+		t.Errorf("objects after MarkSent: %v", f.objects)
+	}
+	for _, c := range f.calls {
+		if !strings.Contains(c, "/a%201.npz") {
+			t.Errorf("key not escaped in %q", c)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, src := newFake(t, map[string]string{})
+	if _, err := src.ReadFile("raw/b1/missing.npz"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("ReadFile of a missing key: %v", err)
+	}
+	_, anon := newFakeWithKey(t, map[string]string{}, "")
+	if _, err := anon.ListBuoys(); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("unsigned ListBuoys: %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"aws", Config{Bucket: "b", Region: "eu-west-1"}, false},
+		{"custom endpoint", Config{Bucket: "b", Endpoint: "http://minio:9000"}, false},
+		{"no bucket", Config{}, true},
+		{"bad endpoint", Config{Bucket: "b", Endpoint: "minio"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
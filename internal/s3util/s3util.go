@@ -0,0 +1,78 @@
+// Package s3util builds the aws-sdk-go-v2 S3 client that s3source and
+// s3sink share, for AWS or an S3-compatible store such as MinIO.
+package s3util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Endpoint says where a bucket lives and how to sign in.
+type Endpoint struct {
+	URL       string // empty means AWS in Region
+	Region    string // empty means us-east-1
+	AccessKey string // empty sends anonymous requests
+	SecretKey string
+}
+
+// NewClient returns a client for e whose requests time out after timeout.
+// A custom URL is addressed path-style, as MinIO and most S3-compatible
+// stores expect. The SDK's own retries are off; callers decide what to
+// retry (see Retriable).
+func NewClient(e Endpoint, timeout time.Duration) (*s3.Client, error) {
+	region := e.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	var base *string
+	if e.URL != "" {
+		u, err := url.Parse(e.URL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid endpoint %q", e.URL)
+		}
+		base = aws.String(e.URL)
+	}
+	var creds aws.CredentialsProvider = aws.AnonymousCredentials{}
+	if e.AccessKey != "" {
+		static := aws.Credentials{AccessKeyID: e.AccessKey, SecretAccessKey: e.SecretKey, Source: "s3util"}
+		creds = aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) { return static, nil })
+	}
+	return s3.New(s3.Options{
+		Region:       region,
+		BaseEndpoint: base,
+		UsePathStyle: base != nil,
+		Credentials:  creds,
+		HTTPClient:   &http.Client{Timeout: timeout},
+		Retryer:      aws.NopRetryer{},
+		// S3-compatible stores do not all accept the CRC trailers the SDK
+		// adds by default; send checksums only where S3 requires them.
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	}), nil
+}
+
+// StatusCode returns the HTTP status of the response err failed with, or 0
+// when no response was received.
+func StatusCode(err error) int {
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode()
+	}
+	return 0
+}
+
+// Retriable reports whether a request failing with err may succeed if
+// sent again: network errors, 429 and 5xx. Other statuses fail the same
+// way every time.
+func Retriable(err error) bool {
+	code := StatusCode(err)
+	return code == 0 || code == http.StatusTooManyRequests || code >= 500
+}
@@ -0,0 +1,121 @@
+package s3util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		name      string
+		e         Endpoint
+		wantErr   bool
+		region    string
+		base      string
+		pathStyle bool
+	}{
+		{"aws", Endpoint{Region: "eu-west-1"}, false, "eu-west-1", "", false},
+		{"default region", Endpoint{}, false, "us-east-1", "", false},
+		{"minio", Endpoint{URL: "http://minio:9000"}, false, "us-east-1", "http://minio:9000", true},
+		{"no scheme", Endpoint{URL: "minio:9000"}, true, "", "", false},
+		{"no host", Endpoint{URL: "http://"}, true, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(tt.e, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			o := c.Options()
+			if o.Region != tt.region || aws.ToString(o.BaseEndpoint) != tt.base || o.UsePathStyle != tt.pathStyle {
+				t.Errorf("region %s, endpoint %q, path style %v", o.Region, aws.ToString(o.BaseEndpoint), o.UsePathStyle)
+			}
+		})
+	}
+}
+
+func TestSigning(t *testing.T) {
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>denied</Message></Error>")
+			return
+		}
+		fmt.Fprint(w, "<ListBucketResult></ListBucketResult>")
+	}))
+	defer srv.Close()
+
+	for _, key := range []string{"key", ""} {
+		c, err := NewClient(Endpoint{URL: srv.URL, AccessKey: key, SecretKey: "secret"}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("b")})
+		if key != "" && err != nil {
+			t.Errorf("signed request: %v", err)
+		}
+		if key == "" && StatusCode(err) != http.StatusForbidden {
+			t.Errorf("anonymous request: %v", err)
+		}
+	}
+	if len(auth) != 2 || !strings.HasPrefix(auth[0], "AWS4-HMAC-SHA256 Credential=key/") || auth[1] != "" {
+		t.Errorf("Authorization headers %q", auth)
+	}
+}
+
+func TestRetriable(t *testing.T) {
+	statuses := map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusBadRequest:          false,
+		http.StatusForbidden:           false,
+		http.StatusNotFound:            false,
+	}
+	var code, hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(code)
+		fmt.Fprint(w, "<Error><Code>Injected</Code><Message>injected</Message></Error>")
+	}))
+	defer srv.Close()
+	c, err := NewClient(Endpoint{URL: srv.URL, AccessKey: "key", SecretKey: "secret"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() error {
+		_, err := c.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String("b"), Key: aws.String("k")})
+		return err
+	}
+	for code = range statuses {
+		hits = 0
+		err := get()
+		if StatusCode(err) != code {
+			t.Errorf("StatusCode = %d, want %d (%v)", StatusCode(err), code, err)
+		}
+		if Retriable(err) != statuses[code] {
+			t.Errorf("Retriable for %d = %v", code, !statuses[code])
+		}
+		if hits != 1 {
+			t.Errorf("status %d: %d requests; the SDK must not retry by itself", code, hits)
+		}
+	}
+
+	srv.Close()
+	if err := get(); err == nil || StatusCode(err) != 0 || !Retriable(err) {
+		t.Errorf("network error %v: status %d, retriable %v", err, StatusCode(err), Retriable(err))
+	}
+}
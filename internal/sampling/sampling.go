@@ -0,0 +1,22 @@
+// Package sampling decides which messages get verbose logs on
+// high-volume streams.
+package sampling
+
+import "math/rand/v2"
+
+// Sampler selects roughly Rate (0.0-1.0) of the calls to ShouldLog.
+type Sampler struct {
+	Rate float64
+}
+
+// ShouldLog reports whether the current message should be logged in
+// detail. Rate >= 1 logs everything and Rate <= 0 nothing.
+func (s Sampler) ShouldLog() bool {
+	switch {
+	case s.Rate >= 1:
+		return true
+	case s.Rate <= 0:
+		return false
+	}
+	return rand.Float64() < s.Rate
+}
@@ -0,0 +1,29 @@
+package sampling
+
+import (
+	"math"
+	"testing"
+)
+
+func TestShouldLogRate(t *testing.T) {
+	const n = 10000
+	for _, rate := range []float64{0, 0.01, 0.1, 0.5, 0.9, 1, -1, 2} {
+		s := Sampler{Rate: rate}
+		logged := 0
+		for range n {
+			if s.ShouldLog() {
+				logged++
+			}
+		}
+		got := float64(logged) / n
+		want := math.Max(0, math.Min(1, rate))
+		// within 5 percentage points; the standard deviation of the rate
+		// over 10,000 messages is at most 0.005
+		if math.Abs(got-want) > 0.05 {
+			t.Errorf("Rate %v: logged %.4f of messages", rate, got)
+		}
+		if (want == 0 && logged != 0) || (want == 1 && logged != n) {
+			t.Errorf("Rate %v: logged %d of %d", rate, logged, n)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package signing signs message payloads with a per-device Ed25519 key and
+// verifies them on the receiving side, so a message altered or forged on
+// the way (by the broker or another client) is detected.
+//
+// A signed message is an envelope around the payload as it was published:
+//
+//	"SIG1" | key ID length (1 byte) | key ID | signature (64) | payload
+//
+// The signature covers everything but itself. The key ID names the
+// device, and the receiver looks its public key up by that name. The
+// magic never starts a JSON, CBOR or protobuf payload, so signed and
+// unsigned messages can be told apart.
+//
+// Keys are stored as base64 text files: the 32-byte seed for a private
+// key, the 32-byte public key for <file>.pub.
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var magic = []byte("SIG1")
+
+var (
+	// ErrUnsigned is returned for a message without a signature envelope.
+	ErrUnsigned = errors.New("signing: message is not signed")
+	// ErrUnknownKey is returned when the signer's public key is not known.
+	ErrUnknownKey = errors.New("signing: unknown signing key")
+	// ErrBadSignature is returned when the signature does not match.
+	ErrBadSignature = errors.New("signing: signature does not match")
+)
+
+// What a receiver does with a message that fails verification.
+const (
+	PolicyReject = "reject" // drop it
+	PolicyFlag   = "flag"   // log and count it, then handle it as usual
+)
+
+// ValidPolicy reports whether p names a policy.
+func ValidPolicy(p string) bool {
+	return p == PolicyReject || p == PolicyFlag
+}
+
+// Signer signs messages as one device.
+type Signer struct {
+	KeyID string
+	Key   ed25519.PrivateKey
+}
+
+// Sign wraps payload in a signed envelope.
+func (s *Signer) Sign(payload []byte) []byte {
+	id := s.KeyID
+	if len(id) > 255 {
+		id = id[:255]
+	}
+	msg := make([]byte, 0, len(magic)+1+len(id)+ed25519.SignatureSize+len(payload))
+	msg = append(msg, magic...)
+	msg = append(msg, byte(len(id)))
+	msg = append(msg, id...)
+	sigAt := len(msg)
+	msg = append(msg, make([]byte, ed25519.SignatureSize)...)
+	msg = append(msg, payload...)
+	copy(msg[sigAt:], ed25519.Sign(s.Key, signedBytes(msg, sigAt)))
+	return msg
+}
+
+// signedBytes is the envelope without the signature at sigAt.
+func signedBytes(msg []byte, sigAt int) []byte {
+	b := make([]byte, 0, len(msg)-ed25519.SignatureSize)
+	b = append(b, msg[:sigAt]...)
+	return append(b, msg[sigAt+ed25519.SignatureSize:]...)
+}
+
+// IsSigned reports whether msg carries a signature envelope.
+func IsSigned(msg []byte) bool {
+	return bytes.HasPrefix(msg, magic)
+}
+
+// split parses an envelope.
+func split(msg []byte) (keyID string, sigAt int, ok bool) {
+	if !IsSigned(msg) || len(msg) < len(magic)+1 {
+		return "", 0, false
+	}
+	n := int(msg[len(magic)])
+	sigAt = len(magic) + 1 + n
+	if len(msg) < sigAt+ed25519.SignatureSize {
+		return "", 0, false
+	}
+	return string(msg[len(magic)+1 : sigAt]), sigAt, true
+}
+
+// Unwrap returns the payload of a signed message without verifying it,
+// and any other message unchanged.
+func Unwrap(msg []byte) []byte {
+	if _, sigAt, ok := split(msg); ok {
+		return msg[sigAt+ed25519.SignatureSize:]
+	}
+	return msg
+}
+
+// Keyring maps device key IDs to their public keys.
+type Keyring map[string]ed25519.PublicKey
+
+// Verify checks the envelope msg and returns the payload inside and the
+// signer's key ID. The payload is also returned with ErrUnknownKey and
+// ErrBadSignature, for receivers that only flag such messages; for an
+// unsigned message it is msg itself.
+func (k Keyring) Verify(msg []byte) (payload []byte, keyID string, err error) {
+	keyID, sigAt, ok := split(msg)
+	if !ok {
+		return msg, "", ErrUnsigned
+	}
+	payload = msg[sigAt+ed25519.SignatureSize:]
+	pub, ok := k[keyID]
+	if !ok {
+		return payload, keyID, ErrUnknownKey
+	}
+	if !ed25519.Verify(pub, signedBytes(msg, sigAt), msg[sigAt:sigAt+ed25519.SignatureSize]) {
+		return payload, keyID, ErrBadSignature
+	}
+	return payload, keyID, nil
+}
+
+// IDs returns the key IDs in order.
+func (k Keyring) IDs() []string {
+	ids := make([]string, 0, len(k))
+	for id := range k {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("signing: %s is not a base64-encoded 32-byte key", path)
+	}
+	return raw, nil
+}
+
+func writeKeyFile(path string, raw []byte, perm os.FileMode) error {
+	return os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(raw)+"\n"), perm)
+}
+
+// LoadOrGenerate loads the private key at privPath, or creates a new key
+// pair there (the public half goes to privPath+".pub") if it does not
+// exist. created reports whether a new pair was written.
+func LoadOrGenerate(privPath string) (key ed25519.PrivateKey, created bool, err error) {
+	seed, err := readKeyFile(privPath)
+	if err == nil {
+		return ed25519.NewKeyFromSeed(seed), false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := writeKeyFile(privPath, key.Seed(), 0600); err != nil {
+		return nil, false, err
+	}
+	if err := writeKeyFile(privPath+".pub", pub, 0644); err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// LoadKeyDir reads the public keys in dir: each <id>.pub (or
+// <id>.key.pub, as LoadOrGenerate names it) holds the key of device <id>.
+func LoadKeyDir(dir string) (Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pub"))
+	if err != nil {
+		return nil, err
+	}
+	k := make(Keyring, len(paths))
+	for _, p := range paths {
+		raw, err := readKeyFile(p)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(p), ".pub"), ".key")
+		k[id] = ed25519.PublicKey(raw)
+	}
+	return k, nil
+}
@@ -0,0 +1,159 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newSigner(t *testing.T, id string) (*Signer, ed25519.PublicKey) {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Signer{KeyID: id, Key: key}, pub
+}
+
+func TestVerify(t *testing.T) {
+	s, pub := newSigner(t, "pub-1")
+	other, otherPub := newSigner(t, "pub-2")
+	k := Keyring{"pub-1": pub, "pub-2": otherPub}
+	payload := []byte(`{"buoy_id":"b1","filename":"a.npz"}`)
+	msg := s.Sign(payload)
+
+	altered := bytes.Clone(msg)
+	altered[len(altered)-2] ^= 1
+	badSig := bytes.Clone(msg)
+	badSig[len(magic)+1+len("pub-1")] ^= 1
+	// a valid signature under another device's name
+	impostor := (&Signer{KeyID: "pub-1", Key: other.Key}).Sign(payload)
+
+	tests := []struct {
+		name        string
+		keys        Keyring
+		msg         []byte
+		wantErr     error
+		wantKeyID   string
+		wantPayload []byte
+	}{
+		{"valid", k, msg, nil, "pub-1", payload},
+		{"empty payload", k, s.Sign(nil), nil, "pub-1", []byte{}},
+		{"altered payload", k, altered, ErrBadSignature, "pub-1", altered[len(msg)-len(payload):]},
+		{"altered signature", k, badSig, ErrBadSignature, "pub-1", payload},
+		{"signed by another key", k, impostor, ErrBadSignature, "pub-1", payload},
+		{"unknown key id", Keyring{"pub-2": otherPub}, msg, ErrUnknownKey, "pub-1", payload},
+		{"unsigned", k, payload, ErrUnsigned, "", payload},
+		{"truncated envelope", k, msg[:len(magic)+1+len("pub-1")+10], ErrUnsigned, "", msg[:len(magic)+1+len("pub-1")+10]},
+		{"magic only", k, []byte("SIG1"), ErrUnsigned, "", []byte("SIG1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, keyID, err := tt.keys.Verify(tt.msg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if keyID != tt.wantKeyID {
+				t.Errorf("key ID = %q, want %q", keyID, tt.wantKeyID)
+			}
+			if !bytes.Equal(got, tt.wantPayload) {
+				t.Errorf("payload = %q, want %q", got, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	s, _ := newSigner(t, "pub-1")
+	payload := []byte(`{"buoy_id":"b1"}`)
+	if got := Unwrap(s.Sign(payload)); !bytes.Equal(got, payload) {
+		t.Errorf("Unwrap(signed) = %q", got)
+	}
+	if got := Unwrap(payload); !bytes.Equal(got, payload) {
+		t.Errorf("Unwrap(unsigned) = %q", got)
+	}
+	if !IsSigned(s.Sign(payload)) || IsSigned(payload) {
+		t.Error("IsSigned wrong")
+	}
+}
+
+func TestLongKeyID(t *testing.T) {
+	s, pub := newSigner(t, strings.Repeat("x", 300))
+	id := strings.Repeat("x", 255)
+	if _, keyID, err := (Keyring{id: pub}).Verify(s.Sign([]byte("p"))); err != nil || keyID != id {
+		t.Errorf("Verify = %q, %v", keyID, err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	for p, want := range map[string]bool{PolicyReject: true, PolicyFlag: true, "": false, "drop": false, "Reject": false} {
+		if ValidPolicy(p) != want {
+			t.Errorf("ValidPolicy(%q) = %v", p, !want)
+		}
+	}
+}
+
+func TestKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	priv := filepath.Join(dir, "pub-1.key")
+	key, created, err := LoadOrGenerate(priv)
+	if err != nil || !created {
+		t.Fatalf("LoadOrGenerate = created %v, %v", created, err)
+	}
+	if st, err := os.Stat(priv); err != nil || st.Mode().Perm() != 0600 {
+		t.Errorf("private key file: %v, %v", st, err)
+	}
+	again, created, err := LoadOrGenerate(priv)
+	if err != nil || created || !again.Equal(key) {
+		t.Fatalf("second LoadOrGenerate = created %v, %v", created, err)
+	}
+
+	// a second device, named <id>.pub
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	os.WriteFile(filepath.Join(dir, "pub-2.pub"), []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0644)
+
+	k, err := LoadKeyDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(k.IDs(), ","); got != "pub-1,pub-2" {
+		t.Fatalf("IDs = %s, want pub-1,pub-2", got)
+	}
+	msg := (&Signer{KeyID: "pub-1", Key: key}).Sign([]byte("p"))
+	if _, _, err := k.Verify(msg); err != nil {
+		t.Errorf("message signed with the generated key: %v", err)
+	}
+}
+
+func TestMalformedKeyFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"not base64", "not a key!\n"},
+		{"short key", base64.StdEncoding.EncodeToString(make([]byte, 16))},
+		{"long key", base64.StdEncoding.EncodeToString(make([]byte, 64))},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "pub-1.pub")
+			os.WriteFile(path, []byte(tt.content), 0644)
+			if _, err := LoadKeyDir(dir); err == nil || !strings.Contains(err.Error(), path) {
+				t.Errorf("LoadKeyDir = %v, want an error naming %s", err, path)
+			}
+			priv := filepath.Join(dir, "device.key")
+			os.WriteFile(priv, []byte(tt.content), 0600)
+			if _, _, err := LoadOrGenerate(priv); err == nil {
+				t.Error("LoadOrGenerate replaced a malformed key file")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Package snimap picks the TLS server name (SNI) for a broker from a JSON
+// routing map, for deployments where several broker clusters sit behind
+// addresses that do not match their certificates:
+//
+//	{"ssl://10.0.0.1:8883": "cluster-a.internal", "ssl://10.0.1.1:8883": "cluster-b.internal"}
+//
+// The map file is re-read whenever its modification time or size changes,
+// so routes can be edited without restarting the client.
+package snimap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+type cachedMap struct {
+	modTime time.Time
+	size    int64
+	routes  map[string]string
+}
+
+var (
+	mu    sync.Mutex
+	cache = make(map[string]*cachedMap) // map path -> parsed contents
+)
+
+// Lookup returns the SNI for brokerURL from the map at mapPath. Without a
+// map, or when brokerURL is not listed, the broker's host name is returned.
+func Lookup(brokerURL string, mapPath string) (string, error) {
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return "", fmt.Errorf("snimap: invalid broker URL %q: %w", brokerURL, err)
+	}
+	if mapPath == "" {
+		return u.Hostname(), nil
+	}
+	routes, err := load(mapPath)
+	if err != nil {
+		return "", err
+	}
+	if sni, ok := routes[brokerURL]; ok && sni != "" {
+		return sni, nil
+	}
+	return u.Hostname(), nil
+}
+
+func load(path string) (map[string]string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("snimap: %w", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if c, ok := cache[path]; ok && c.modTime.Equal(st.ModTime()) && c.size == st.Size() {
+		return c.routes, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("snimap: %w", err)
+	}
+	var routes map[string]string
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("snimap: parse %s: %w", path, err)
+	}
+	cache[path] = &cachedMap{modTime: st.ModTime(), size: st.Size(), routes: routes}
+	return routes, nil
+}
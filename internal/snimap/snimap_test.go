@@ -0,0 +1,92 @@
+package snimap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeMap(t *testing.T, path, data string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// set explicitly: two writes within the file system's timestamp
+	// granularity would otherwise look unchanged
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sni.json")
+	writeMap(t, path, `{"ssl://10.0.0.1:8883": "cluster-a.internal", "ssl://10.0.1.1:8883": "cluster-b.internal", "ssl://10.0.2.1:8883": ""}`, time.Now())
+
+	tests := []struct {
+		name    string
+		broker  string
+		mapPath string
+		want    string
+		wantErr bool
+	}{
+		{"mapped", "ssl://10.0.0.1:8883", path, "cluster-a.internal", false},
+		{"second entry", "ssl://10.0.1.1:8883", path, "cluster-b.internal", false},
+		{"missing entry", "ssl://broker.example.com:8883", path, "broker.example.com", false},
+		{"empty entry", "ssl://10.0.2.1:8883", path, "10.0.2.1", false},
+		{"no map", "ssl://10.0.0.1:8883", "", "10.0.0.1", false},
+		{"missing map file", "ssl://10.0.0.1:8883", filepath.Join(t.TempDir(), "none.json"), "", true},
+		{"bad URL", "ssl://[::1", path, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Lookup(tt.broker, tt.mapPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Lookup = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sni.json")
+	mtime := time.Now().Add(-time.Hour)
+	writeMap(t, path, `{"ssl://10.0.0.1:8883": "cluster-a.internal"}`, mtime)
+	lookup := func() string {
+		t.Helper()
+		sni, err := Lookup("ssl://10.0.0.1:8883", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sni
+	}
+	if got := lookup(); got != "cluster-a.internal" {
+		t.Fatalf("initial Lookup = %q", got)
+	}
+
+	writeMap(t, path, `{"ssl://10.0.0.1:8883": "cluster-c.internal"}`, mtime.Add(time.Second))
+	if got := lookup(); got != "cluster-c.internal" {
+		t.Errorf("after edit Lookup = %q, want the new route", got)
+	}
+
+	// a broken edit is reported, and fixing it recovers
+	writeMap(t, path, `{"ssl://10.0.0.1:8883": `, mtime.Add(2*time.Second))
+	if _, err := Lookup("ssl://10.0.0.1:8883", path); err == nil {
+		t.Error("truncated map accepted")
+	}
+	writeMap(t, path, `{"ssl://10.0.0.1:8883": "cluster-d.internal"}`, mtime.Add(3*time.Second))
+	if got := lookup(); got != "cluster-d.internal" {
+		t.Errorf("after fix Lookup = %q", got)
+	}
+
+	// an unchanged file is served from the cache
+	mu.Lock()
+	cache[path].routes = map[string]string{"ssl://10.0.0.1:8883": "cached"}
+	mu.Unlock()
+	if got := lookup(); got != "cached" {
+		t.Errorf("unchanged file re-read: Lookup = %q", got)
+	}
+}
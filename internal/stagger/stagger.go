@@ -0,0 +1,31 @@
+// Package stagger spreads the startup of many workers over time so they
+// do not all hit the broker at once.
+package stagger
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Delay returns how long worker workerIndex (0-based) should wait:
+// workerIndex*delay, shifted by a random amount in [-jitter, +jitter] and
+// never negative.
+func Delay(workerIndex int, delay, jitter time.Duration) time.Duration {
+	d := time.Duration(workerIndex) * delay
+	if jitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*jitter)+1)) - jitter
+	}
+	return max(d, 0)
+}
+
+// Start blocks for the worker's stagger delay.
+func Start(workerIndex int, delay, jitter time.Duration) {
+	staggredStart(workerIndex, delay, jitter)
+}
+
+// staggredStart sleeps for Delay(workerIndex, delay, jitter).
+func staggredStart(workerIndex int, delay, jitter time.Duration) {
+	if d := Delay(workerIndex, delay, jitter); d > 0 {
+		time.Sleep(d)
+	}
+}
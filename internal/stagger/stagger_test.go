@@ -0,0 +1,55 @@
+package stagger
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		name          string
+		index         int
+		delay, jitter time.Duration
+		min, max      time.Duration
+	}{
+		{"first worker", 0, 100 * time.Millisecond, 0, 0, 0},
+		{"fifth worker", 4, 100 * time.Millisecond, 0, 400 * time.Millisecond, 400 * time.Millisecond},
+		{"jitter", 3, 100 * time.Millisecond, 20 * time.Millisecond, 280 * time.Millisecond, 320 * time.Millisecond},
+		{"never negative", 0, 100 * time.Millisecond, 50 * time.Millisecond, 0, 50 * time.Millisecond},
+		{"no delay", 7, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				if d := Delay(tt.index, tt.delay, tt.jitter); d < tt.min || d > tt.max {
+					t.Fatalf("Delay = %v, want within [%v, %v]", d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+// Five buoys 100ms apart start over at least 400ms, in order.
+func TestStaggredStartSpread(t *testing.T) {
+	const buoys = 5
+	begin := time.Now()
+	started := make([]time.Duration, buoys)
+	var wg sync.WaitGroup
+	for i := range buoys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			staggredStart(i, 100*time.Millisecond, 0)
+			started[i] = time.Since(begin)
+		}()
+	}
+	wg.Wait()
+	if spread := started[buoys-1] - started[0]; spread < 400*time.Millisecond {
+		t.Errorf("starts spread over %v, want at least 400ms", spread)
+	}
+	if !slices.IsSorted(started) {
+		t.Errorf("workers started out of order: %v", started)
+	}
+}
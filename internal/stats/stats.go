@@ -0,0 +1,201 @@
+// Package stats holds small in-process statistics used in diagnostics.
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SizeBounds are the log-scale byte buckets <1KB, 1-10KB, 10-100KB,
+// 100KB-1MB and >=1MB.
+var SizeBounds = []int64{1 << 10, 10 << 10, 100 << 10, 1 << 20}
+
+// Histogram counts values into buckets split at Bounds: bucket i holds
+// values below Bounds[i] (and at or above Bounds[i-1]); the last bucket
+// holds everything from the highest bound up.
+type Histogram struct {
+	Bounds []int64
+
+	mu     sync.Mutex
+	counts []int64
+}
+
+func NewHistogram(bounds []int64) *Histogram {
+	return &Histogram{Bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(v int64) {
+	i := 0
+	for i < len(h.Bounds) && v >= h.Bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.mu.Unlock()
+}
+
+// Counts returns a copy of the bucket counts, len(Bounds)+1 entries.
+func (h *Histogram) Counts() []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]int64(nil), h.counts...)
+}
+
+// String renders one line per bucket with a bar scaled to the largest
+// bucket, treating the bounds as byte sizes.
+func (h *Histogram) String() string {
+	counts := h.Counts()
+	var peak int64
+	for _, c := range counts {
+		peak = max(peak, c)
+	}
+	var b strings.Builder
+	for i, c := range counts {
+		var label string
+		switch {
+		case len(h.Bounds) == 0:
+			label = "all"
+		case i == 0:
+			label = "<" + formatBytes(h.Bounds[0])
+		case i == len(h.Bounds):
+			label = ">=" + formatBytes(h.Bounds[i-1])
+		default:
+			label = formatBytes(h.Bounds[i-1]) + "-" + formatBytes(h.Bounds[i])
+		}
+		bar := 0
+		if peak > 0 {
+			bar = int(c * 40 / peak)
+		}
+		fmt.Fprintf(&b, "%-12s %8d %s\n", label, c, strings.Repeat("#", bar))
+	}
+	return b.String()
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// quantileGamma sets the bucket width of Quantiles: neighbouring bounds are
+// 2% apart, so a bucket's midpoint is within 1% of any value in it.
+const quantileGamma = 1.02
+
+// Quantiles is a histogram of latencies in log-scale buckets from which
+// percentiles can be read to within 1% without keeping every sample.
+// Values below 1 (including negative latencies from skewed clocks) share
+// one bucket; reported percentiles are clamped to the observed min and max.
+type Quantiles struct {
+	mu       sync.Mutex
+	counts   map[int]int64 // bucket index -> count; index 0 holds values < 1
+	n        int64
+	sum      float64
+	min, max float64
+}
+
+func NewQuantiles() *Quantiles {
+	return &Quantiles{counts: make(map[int]int64)}
+}
+
+func quantileBucket(v float64) int {
+	if v < 1 {
+		return 0
+	}
+	return 1 + int(math.Ceil(math.Log(v)/math.Log(quantileGamma)))
+}
+
+// quantileValue is the midpoint of bucket i.
+func quantileValue(i int) float64 {
+	if i == 0 {
+		return 0
+	}
+	upper := math.Pow(quantileGamma, float64(i-1))
+	return 2 * upper / (quantileGamma + 1)
+}
+
+func (q *Quantiles) Observe(v float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n == 0 || v < q.min {
+		q.min = v
+	}
+	if q.n == 0 || v > q.max {
+		q.max = v
+	}
+	q.n++
+	q.sum += v
+	q.counts[quantileBucket(v)]++
+}
+
+// Merge adds the samples of o to q.
+func (q *Quantiles) Merge(o *Quantiles) {
+	o.mu.Lock()
+	counts := make(map[int]int64, len(o.counts))
+	for i, c := range o.counts {
+		counts[i] = c
+	}
+	n, sum, lo, hi := o.n, o.sum, o.min, o.max
+	o.mu.Unlock()
+	if n == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n == 0 || lo < q.min {
+		q.min = lo
+	}
+	if q.n == 0 || hi > q.max {
+		q.max = hi
+	}
+	q.n += n
+	q.sum += sum
+	for i, c := range counts {
+		q.counts[i] += c
+	}
+}
+
+// QuantileSummary is a snapshot of a Quantiles.
+type QuantileSummary struct {
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Summary returns the count, extremes, mean and p50/p95/p99.
+func (q *Quantiles) Summary() QuantileSummary {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := QuantileSummary{Count: q.n}
+	if q.n == 0 {
+		return s
+	}
+	s.Min, s.Max, s.Mean = q.min, q.max, q.sum/float64(q.n)
+	idx := make([]int, 0, len(q.counts))
+	for i := range q.counts {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	quantile := func(p float64) float64 {
+		rank := int64(p * float64(q.n-1))
+		var seen int64
+		for _, i := range idx {
+			if seen += q.counts[i]; seen > rank {
+				return min(max(quantileValue(i), q.min), q.max)
+			}
+		}
+		return q.max
+	}
+	s.P50, s.P95, s.P99 = quantile(0.50), quantile(0.95), quantile(0.99)
+	return s
+}
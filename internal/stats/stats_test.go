@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes []int64
+		want  []int64 // <1KB, 1-10KB, 10-100KB, 100KB-1MB, >=1MB
+	}{
+		{"empty", nil, []int64{0, 0, 0, 0, 0}},
+		{"one per bucket", []int64{500, 5 << 10, 50 << 10, 500 << 10, 5 << 20}, []int64{1, 1, 1, 1, 1}},
+		{"bounds go up", []int64{1023, 1024, 10<<10 - 1, 10 << 10, 1<<20 - 1, 1 << 20}, []int64{1, 2, 1, 1, 1}},
+		{"zero and huge", []int64{0, 0, 1 << 40}, []int64{2, 0, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistogram(SizeBounds)
+			for _, s := range tt.sizes {
+				h.Observe(s)
+			}
+			if got := h.Counts(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Counts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramString(t *testing.T) {
+	h := NewHistogram(SizeBounds)
+	for range 4 {
+		h.Observe(50 << 10)
+	}
+	h.Observe(2 << 20)
+	lines := strings.Split(strings.TrimSuffix(h.String(), "\n"), "\n")
+	labels := []string{"<1KB", "1KB-10KB", "10KB-100KB", "100KB-1MB", ">=1MB"}
+	bars := []int{0, 0, 40, 0, 10}
+	if len(lines) != len(labels) {
+		t.Fatalf("String =\n%s", h.String())
+	}
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if fields[0] != labels[i] {
+			t.Errorf("line %d label %q, want %q", i, fields[0], labels[i])
+		}
+		if got := strings.Count(line, "#"); got != bars[i] {
+			t.Errorf("line %q has a bar of %d, want %d", line, got, bars[i])
+		}
+	}
+}
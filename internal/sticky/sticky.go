@@ -0,0 +1,24 @@
+// Package sticky builds the session cookie that keeps MQTT-over-WebSocket
+// reconnects on the same backend behind an L7 load balancer. The value is
+// generated once per process and sent on every WebSocket upgrade.
+package sticky
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// NewValue returns a random cookie value.
+func NewValue() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Header returns upgrade headers carrying the cookie name=value.
+func Header(name, value string) http.Header {
+	h := http.Header{}
+	h.Set("Cookie", (&http.Cookie{Name: name, Value: value}).String())
+	return h
+}
@@ -0,0 +1,90 @@
+package sticky
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+	"cloudletsapps/internal/mqttutil"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+)
+
+func TestHeader(t *testing.T) {
+	h := Header("AWSALB", "abc123")
+	if got := h.Get("Cookie"); got != "AWSALB=abc123" {
+		t.Errorf("Cookie header %q", got)
+	}
+	a, b := NewValue(), NewValue()
+	if len(a) != 32 || a == b {
+		t.Errorf("NewValue gave %q and %q", a, b)
+	}
+}
+
+// TestCookieOnReconnect serves MQTT over WebSocket, drops the first
+// connection after its CONNACK and checks that the client's reconnect
+// upgrade carries the same cookie.
+func TestCookieOnReconnect(t *testing.T) {
+	var mu sync.Mutex
+	var cookies []string
+	reconnected := make(chan struct{})
+	upgrader := websocket.Upgrader{Subprotocols: []string{"mqtt"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		cookies = append(cookies, r.Header.Get("Cookie"))
+		n := len(cookies)
+		mu.Unlock()
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		if _, _, err := ws.ReadMessage(); err != nil { // CONNECT
+			return
+		}
+		ws.WriteMessage(websocket.BinaryMessage, []byte{0x20, 2, 0, 0})
+		if n == 1 {
+			return // drop the first connection
+		}
+		if n == 2 {
+			close(reconnected)
+		}
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	value := NewValue()
+	settings := mqttutil.ClientSettings{ConnectTimeout: 5 * time.Second, Headers: Header("AWSALB", value)}
+	opts := settings.NewClientOptions("ws://"+strings.TrimPrefix(srv.URL, "http://"), "sticky-test")
+	opts.SetCustomOpenConnectionFn(mqttutil.OpenConnectionFn(mqttutil.DialOptions{}))
+	opts.SetAutoReconnect(true)
+	mqttutil.ApplyReconnectBackoff(opts, backoff.Policy{Initial: 10 * time.Millisecond, Max: 10 * time.Millisecond})
+	c := MQTT.NewClient(opts)
+	if tk := c.Connect(); !tk.WaitTimeout(5*time.Second) || tk.Error() != nil {
+		t.Fatalf("connect: %v", tk.Error())
+	}
+	defer c.Disconnect(0)
+
+	select {
+	case <-reconnected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := "AWSALB=" + value
+	for i, got := range cookies {
+		if got != want {
+			t.Errorf("upgrade %d sent cookie %q, want %q", i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package summarizer aggregates per-prediction records into periodic
+// summaries for dashboards.
+package summarizer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SummaryMsg is the JSON document published on the summary topic.
+type SummaryMsg struct {
+	ActiveBuoys          []string `json:"active_buoys"`
+	PredictionsPerMinute float64  `json:"predictions_per_minute"`
+	AvgLatencyMs         float64  `json:"avg_latency_ms"`
+	ErrorRate            float64  `json:"error_rate"`
+	TS                   string   `json:"ts"`
+	NodeID               string   `json:"node_id,omitempty"`
+}
+
+// MetricsMsg is the cumulative worker metrics document. QueueDepth and
+// BrokerRTTMs are filled in by the caller.
+type MetricsMsg struct {
+	PredictionsTotal int64   `json:"predictions_total"`
+	ErrorsTotal      int64   `json:"errors_total"`
+	QueueDepth       int     `json:"queue_depth"`
+	UptimeSeconds    float64 `json:"uptime_seconds"`
+	AvgInferenceMs   float64 `json:"avg_inference_ms"`
+	BrokerRTTMs      float64 `json:"broker_rtt_ms,omitempty"`
+}
+
+// Summarizer collects records for the current window. Summary closes the
+// window and starts a new one; Metrics reports totals since creation.
+type Summarizer struct {
+	mu           sync.Mutex
+	started      time.Time
+	windowStart  time.Time
+	buoys        map[string]struct{}
+	count        int
+	errors       int
+	latencySum   time.Duration
+	latencyCount int
+	now          func() time.Time
+
+	predictionsTotal int64
+	errorsTotal      int64
+	inferenceSum     time.Duration
+	inferenceCount   int64
+}
+
+func New() *Summarizer {
+	s := &Summarizer{now: time.Now}
+	s.started = s.now()
+	s.reset()
+	return s
+}
+
+func (s *Summarizer) reset() {
+	s.windowStart = s.now()
+	s.buoys = make(map[string]struct{})
+	s.count, s.errors = 0, 0
+	s.latencySum, s.latencyCount = 0, 0
+}
+
+// Record adds one prediction outcome. Latency is only averaged for
+// successful predictions.
+func (s *Summarizer) Record(buoyID string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if buoyID != "" {
+		s.buoys[buoyID] = struct{}{}
+	}
+	s.count++
+	s.predictionsTotal++
+	if err != nil {
+		s.errors++
+		s.errorsTotal++
+		return
+	}
+	s.latencySum += latency
+	s.latencyCount++
+}
+
+// Summary returns the statistics for the window since the previous call.
+func (s *Summarizer) Summary() SummaryMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	msg := SummaryMsg{
+		ActiveBuoys: make([]string, 0, len(s.buoys)),
+		TS:          now.UTC().Format(time.RFC3339),
+	}
+	for id := range s.buoys {
+		msg.ActiveBuoys = append(msg.ActiveBuoys, id)
+	}
+	sort.Strings(msg.ActiveBuoys)
+	if minutes := now.Sub(s.windowStart).Minutes(); minutes > 0 {
+		msg.PredictionsPerMinute = float64(s.count) / minutes
+	}
+	if s.latencyCount > 0 {
+		msg.AvgLatencyMs = float64(s.latencySum) / float64(time.Millisecond) / float64(s.latencyCount)
+	}
+	if s.count > 0 {
+		msg.ErrorRate = float64(s.errors) / float64(s.count)
+	}
+	s.reset()
+	return msg
+}
+
+// RecordInference adds the model time of one message (with batching, its
+// share of the batch).
+func (s *Summarizer) RecordInference(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inferenceSum += d
+	s.inferenceCount++
+}
+
+// Metrics returns cumulative totals; it does not reset the summary window.
+func (s *Summarizer) Metrics() MetricsMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := MetricsMsg{
+		PredictionsTotal: s.predictionsTotal,
+		ErrorsTotal:      s.errorsTotal,
+		UptimeSeconds:    s.now().Sub(s.started).Seconds(),
+	}
+	if s.inferenceCount > 0 {
+		m.AvgInferenceMs = float64(s.inferenceSum) / float64(time.Millisecond) / float64(s.inferenceCount)
+	}
+	return m
+}
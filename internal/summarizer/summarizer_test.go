@@ -0,0 +1,117 @@
+package summarizer
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock starts at a fixed time and moves only when told to.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestSummarizer() (*Summarizer, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	s := &Summarizer{now: clock.now}
+	s.started = clock.now()
+	s.reset()
+	return s, clock
+}
+
+type record struct {
+	buoy    string
+	latency time.Duration
+	failed  bool
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []record
+		window  time.Duration
+		want    SummaryMsg
+	}{
+		{"empty window", nil, time.Minute, SummaryMsg{ActiveBuoys: []string{}}},
+		{
+			"mixed",
+			[]record{{"b2", 100 * time.Millisecond, false}, {"b1", 300 * time.Millisecond, false}, {"b1", time.Second, true}, {"b2", 200 * time.Millisecond, false}},
+			2 * time.Minute,
+			SummaryMsg{ActiveBuoys: []string{"b1", "b2"}, PredictionsPerMinute: 2, AvgLatencyMs: 200, ErrorRate: 0.25},
+		},
+		{
+			"errors only",
+			[]record{{"b1", time.Second, true}, {"", time.Second, true}},
+			30 * time.Second,
+			SummaryMsg{ActiveBuoys: []string{"b1"}, PredictionsPerMinute: 4, ErrorRate: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clock := newTestSummarizer()
+			for _, r := range tt.records {
+				var err error
+				if r.failed {
+					err = errors.New("failed")
+				}
+				s.Record(r.buoy, r.latency, err)
+			}
+			clock.t = clock.t.Add(tt.window)
+			got := s.Summary()
+			tt.want.TS = clock.t.Format(time.RFC3339)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Summary =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummaryStartsNewWindow(t *testing.T) {
+	s, clock := newTestSummarizer()
+	s.Record("b1", time.Second, errors.New("failed"))
+	clock.t = clock.t.Add(time.Minute)
+	s.Summary()
+
+	s.Record("b2", 50*time.Millisecond, nil)
+	clock.t = clock.t.Add(time.Minute)
+	got := s.Summary()
+	if !reflect.DeepEqual(got.ActiveBuoys, []string{"b2"}) || got.PredictionsPerMinute != 1 || got.ErrorRate != 0 || got.AvgLatencyMs != 50 {
+		t.Errorf("second window = %+v, want only the record made after the first Summary", got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s, clock := newTestSummarizer()
+	if got := s.Metrics(); got != (MetricsMsg{}) {
+		t.Errorf("initial metrics = %+v", got)
+	}
+
+	s.Record("b1", 100*time.Millisecond, nil)
+	s.Record("b2", 200*time.Millisecond, nil)
+	s.Record("b1", time.Second, errors.New("failed"))
+	s.RecordInference(40 * time.Millisecond)
+	s.RecordInference(60 * time.Millisecond)
+	clock.t = clock.t.Add(90 * time.Second)
+	s.Summary() // closing a window leaves the totals alone
+	s.Record("b3", 0, errors.New("failed"))
+
+	want := MetricsMsg{PredictionsTotal: 4, ErrorsTotal: 2, UptimeSeconds: 90, AvgInferenceMs: 50}
+	got := s.Metrics()
+	if got != want {
+		t.Errorf("Metrics = %+v, want %+v", got, want)
+	}
+
+	body, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	json.Unmarshal(body, &doc)
+	for _, key := range []string{"predictions_total", "errors_total", "queue_depth", "uptime_seconds", "avg_inference_ms"} {
+		if _, ok := doc[key]; !ok {
+			t.Errorf("metrics message %s has no %s", body, key)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package topicparse matches MQTT topics against patterns such as
+// "sensors/{region}/{buoy_id}/npz", where each {name} placeholder captures
+// exactly one topic level.
+package topicparse
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type segment struct {
+	literal string
+	name    string // non-empty for placeholders
+}
+
+// Parser compiles patterns on first use and caches them.
+type Parser struct {
+	mu    sync.Mutex
+	cache map[string][]segment
+}
+
+func (p *Parser) compile(pattern string) ([]segment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if segs, ok := p.cache[pattern]; ok {
+		return segs, nil
+	}
+	segs, err := compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if p.cache == nil {
+		p.cache = make(map[string][]segment)
+	}
+	p.cache[pattern] = segs
+	return segs, nil
+}
+
+func compile(pattern string) ([]segment, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("topicparse: empty pattern")
+	}
+	seen := make(map[string]bool)
+	var segs []segment
+	for _, level := range strings.Split(pattern, "/") {
+		if strings.ContainsAny(level, "+#") {
+			return nil, fmt.Errorf("topicparse: wildcard in pattern %q", pattern)
+		}
+		open, close := strings.Index(level, "{"), strings.LastIndex(level, "}")
+		if open == -1 && close == -1 {
+			segs = append(segs, segment{literal: level})
+			continue
+		}
+		if open != 0 || close != len(level)-1 {
+			return nil, fmt.Errorf("topicparse: placeholder must span a whole level in %q", pattern)
+		}
+		name := level[1 : len(level)-1]
+		if name == "" || strings.ContainsAny(name, "{}") {
+			return nil, fmt.Errorf("topicparse: bad placeholder %q in %q", level, pattern)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("topicparse: duplicate placeholder %q in %q", name, pattern)
+		}
+		seen[name] = true
+		segs = append(segs, segment{name: name})
+	}
+	return segs, nil
+}
+
+// Parse returns the placeholder values captured from topic.
+func (p *Parser) Parse(topic, pattern string) (map[string]string, error) {
+	segs, err := p.compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(segs) {
+		return nil, fmt.Errorf("topicparse: topic %q does not match %q", topic, pattern)
+	}
+	out := make(map[string]string)
+	for i, s := range segs {
+		if s.name == "" {
+			if levels[i] != s.literal {
+				return nil, fmt.Errorf("topicparse: topic %q does not match %q", topic, pattern)
+			}
+			continue
+		}
+		if levels[i] == "" {
+			return nil, fmt.Errorf("topicparse: empty %s in topic %q", s.name, topic)
+		}
+		out[s.name] = levels[i]
+	}
+	return out, nil
+}
+
+// Format fills every placeholder in pattern from values.
+func (p *Parser) Format(pattern string, values map[string]string) (string, error) {
+	segs, err := p.compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	levels := make([]string, len(segs))
+	for i, s := range segs {
+		if s.name == "" {
+			levels[i] = s.literal
+			continue
+		}
+		v, ok := values[s.name]
+		if !ok || v == "" {
+			return "", fmt.Errorf("topicparse: no value for %s in %q", s.name, pattern)
+		}
+		if strings.ContainsAny(v, "/+#") {
+			return "", fmt.Errorf("topicparse: value %q for %s is not a single topic level", v, s.name)
+		}
+		levels[i] = v
+	}
+	return strings.Join(levels, "/"), nil
+}
+
+// Wildcard returns the subscription filter for pattern, with every
+// placeholder replaced by "+".
+func (p *Parser) Wildcard(pattern string) (string, error) {
+	segs, err := p.compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	levels := make([]string, len(segs))
+	for i, s := range segs {
+		levels[i] = s.literal
+		if s.name != "" {
+			levels[i] = "+"
+		}
+	}
+	return strings.Join(levels, "/"), nil
+}
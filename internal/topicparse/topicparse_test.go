@@ -0,0 +1,78 @@
+package topicparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+const pattern = "sensors/{region}/{buoy_id}/npz"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		pattern string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"match", "sensors/north/buoy_7/npz", pattern, map[string]string{"region": "north", "buoy_id": "buoy_7"}, false},
+		{"no placeholders", "a/b", "a/b", map[string]string{}, false},
+		{"too short", "sensors/north/npz", pattern, nil, true},
+		{"too long", "sensors/north/buoy_7/npz/x", pattern, nil, true},
+		{"literal differs", "sensors/north/buoy_7/csv", pattern, nil, true},
+		{"empty level", "sensors//buoy_7/npz", pattern, nil, true},
+		{"empty pattern", "a", "", nil, true},
+		{"wildcard in pattern", "a/b", "a/+", nil, true},
+		{"partial placeholder", "a/xb", "a/x{b}", nil, true},
+		{"unclosed placeholder", "a/b", "a/{b", nil, true},
+		{"empty placeholder", "a/b", "a/{}", nil, true},
+		{"duplicate placeholder", "a/b", "{x}/{x}", nil, true},
+	}
+	var p Parser
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Parse(tt.topic, tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"all values", map[string]string{"region": "north", "buoy_id": "b7"}, "sensors/north/b7/npz", false},
+		{"missing value", map[string]string{"region": "north"}, "", true},
+		{"empty value", map[string]string{"region": "north", "buoy_id": ""}, "", true},
+		{"value spans levels", map[string]string{"region": "a/b", "buoy_id": "b7"}, "", true},
+		{"wildcard value", map[string]string{"region": "+", "buoy_id": "b7"}, "", true},
+	}
+	var p Parser
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Format(pattern, tt.values)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Format = %q, %v; want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	var p Parser
+	got, err := p.Wildcard(pattern)
+	if err != nil || got != "sensors/+/+/npz" {
+		t.Errorf("Wildcard = %q, %v", got, err)
+	}
+	if _, err := p.Wildcard("sensors/#"); err == nil {
+		t.Error("Wildcard accepted a pattern with #")
+	}
+}
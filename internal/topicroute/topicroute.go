@@ -0,0 +1,106 @@
+// Package topicroute maps MQTT topic filters to the action a subscriber
+// takes for messages on them, so one process can serve several sensor
+// streams. A table is written as comma-separated "filter=action" entries,
+// e.g.
+//
+//	buoy/+/observations=predict,buoy/+/raw=archive,buoy/+/debug=drop
+//
+// and the first entry whose filter matches a topic decides.
+package topicroute
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Actions.
+const (
+	Predict = "predict" // run the model and publish a result
+	Archive = "archive" // store the raw payload only
+	Drop    = "drop"    // discard
+)
+
+// Route sends the messages on Filter to Action.
+type Route struct {
+	Filter string
+	Action string
+}
+
+// Table is an ordered list of routes.
+type Table []Route
+
+// Parse reads a table from s; an empty s gives an empty table.
+func Parse(s string) (Table, error) {
+	var t Table
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		filter, action, ok := strings.Cut(entry, "=")
+		filter, action = strings.TrimSpace(filter), strings.TrimSpace(action)
+		if !ok || filter == "" {
+			return nil, fmt.Errorf("topicroute: entry %q: want filter=action", entry)
+		}
+		switch action {
+		case Predict, Archive, Drop:
+		default:
+			return nil, fmt.Errorf("topicroute: entry %q: unknown action %q (want predict, archive or drop)", entry, action)
+		}
+		if err := validFilter(filter); err != nil {
+			return nil, err
+		}
+		t = append(t, Route{Filter: filter, Action: action})
+	}
+	return t, nil
+}
+
+func validFilter(filter string) error {
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		if l == "#" && i != len(levels)-1 {
+			return fmt.Errorf("topicroute: %q: # must be the last level", filter)
+		}
+		if l != "+" && l != "#" && strings.ContainsAny(l, "+#") {
+			return fmt.Errorf("topicroute: %q: wildcards must span a whole level", filter)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether topic matches the MQTT topic filter.
+func Matches(filter, topic string) bool {
+	fl, tl := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) || (f != "+" && f != tl[i]) {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+// Match returns the first route whose filter matches topic.
+func (t Table) Match(topic string) (Route, bool) {
+	for _, r := range t {
+		if Matches(r.Filter, topic) {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+// Filters returns the filters to subscribe to, without duplicates.
+func (t Table) Filters() []string {
+	seen := make(map[string]bool, len(t))
+	var out []string
+	for _, r := range t {
+		if !seen[r.Filter] {
+			seen[r.Filter] = true
+			out = append(out, r.Filter)
+		}
+	}
+	return out
+}
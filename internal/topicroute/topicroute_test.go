@@ -0,0 +1,107 @@
+package topicroute
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"buoy/41001/observations", "buoy/41001/observations", true},
+		{"buoy/41001/observations", "buoy/41002/observations", false},
+		{"buoy/+/observations", "buoy/41001/observations", true},
+		{"buoy/+/observations", "buoy/41001/raw", false},
+		{"buoy/+/observations", "buoy/observations", false},
+		{"buoy/+/observations", "buoy/a/b/observations", false},
+		{"buoy/+", "buoy/", true},
+		{"+/+", "/x", true},
+		{"+", "buoy/41001", false},
+		{"buoy/#", "buoy/41001/observations", true},
+		{"buoy/#", "buoy", true}, // # also matches the parent level
+		{"buoy/#", "ship/1", false},
+		{"#", "buoy/41001/raw", true},
+		{"buoy/+/#", "buoy/41001", true},
+		{"buoy/+/#", "buoy", false},
+		{"buoy", "buoy/41001", false},
+		{"buoy/41001", "buoy", false},
+	}
+	for _, tt := range tests {
+		if got := Matches(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("Matches(%q, %q) = %v", tt.filter, tt.topic, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	got, err := Parse(" buoy/+/observations = predict , buoy/#=archive,,buoy/+/debug=drop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Table{{"buoy/+/observations", Predict}, {"buoy/#", Archive}, {"buoy/+/debug", Drop}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse = %+v, want %+v", got, want)
+	}
+	if tbl, err := Parse(""); err != nil || len(tbl) != 0 {
+		t.Errorf("Parse(\"\") = %+v, %v", tbl, err)
+	}
+
+	for _, s := range []string{
+		"buoy/+/observations",
+		"=predict",
+		"buoy/#=forward",
+		"buoy/#/raw=archive",
+		"buoy/41+/raw=archive",
+		"buoy/a#=drop",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) succeeded", s)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	tbl, err := Parse("buoy/41001/observations=archive,buoy/+/debug=drop,buoy/+/observations=predict,buoy/#=archive,buoy/+/debug=predict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		topic  string
+		want   string
+		wantOK bool
+	}{
+		// an earlier exact route wins over a later wildcard
+		{"buoy/41001/observations", Archive, true},
+		{"buoy/41002/observations", Predict, true},
+		// the first of two identical filters decides
+		{"buoy/41001/debug", Drop, true},
+		// the catch-all only gets what the routes before it left
+		{"buoy/41001/raw", Archive, true},
+		{"buoy", Archive, true},
+		{"ship/1/observations", "", false},
+	}
+	for _, tt := range tests {
+		r, ok := tbl.Match(tt.topic)
+		if ok != tt.wantOK || r.Action != tt.want {
+			t.Errorf("Match(%q) = %+v, %v; want %q", tt.topic, r, ok, tt.want)
+		}
+	}
+
+	// a catch-all first shadows everything after it
+	shadowed := Table{{"#", Drop}, {"buoy/+/observations", Predict}}
+	if r, _ := shadowed.Match("buoy/41001/observations"); r.Action != Drop {
+		t.Errorf("leading # routed to %q", r.Action)
+	}
+	if _, ok := (Table{}).Match("buoy/1"); ok {
+		t.Error("empty table matched")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	tbl := Table{{"buoy/+/debug", Drop}, {"buoy/#", Archive}, {"buoy/+/debug", Predict}}
+	if got, want := tbl.Filters(), []string{"buoy/+/debug", "buoy/#"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Filters() = %q, want %q", got, want)
+	}
+}
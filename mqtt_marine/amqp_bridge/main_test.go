@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestColumnValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		field   string
+		want    string
+	}{
+		{"column", "Buoy-station,Wave-height\nb1,2.5\n", "Buoy-station", "b1"},
+		{"spaces", "Buoy-station , Wave-height\n b1 , 2.5", "Wave-height", "2.5"},
+		{"crlf", "Buoy-station,Wave-height\r\nb7,1\r\n", "Buoy-station", "b7"},
+		{"no such column", "Buoy-station\nb1", "Node-ID", ""},
+		{"header only", "Buoy-station", "Buoy-station", ""},
+		{"short data line", "Buoy-station,Wave-height\nb1", "Wave-height", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := columnValue([]byte(tt.payload), tt.field); got != tt.want {
+				t.Errorf("columnValue = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// message is a received MQTT message that counts its acknowledgements.
+type message struct {
+	MQTT.Message
+	payload []byte
+	acks    atomic.Int32
+}
+
+func (m *message) Payload() []byte { return m.payload }
+func (m *message) Ack()            { m.acks.Add(1) }
+
+func useRetryPolicy(t *testing.T, p backoff.Policy) {
+	old := retryPolicy
+	t.Cleanup(func() { retryPolicy = old })
+	retryPolicy = p
+}
+
+// publishFunc adapts a function to resultPublisher.
+type publishFunc func(key string, body []byte, ts time.Time) error
+
+func (f publishFunc) publish(key string, body []byte, ts time.Time) error { return f(key, body, ts) }
+
+// runForward runs forward over msgs and stops it once until returns true.
+func runForward(t *testing.T, p resultPublisher, msgs []*message, until func() bool) int64 {
+	t.Helper()
+	queue := make(chan MQTT.Message, len(msgs))
+	for _, m := range msgs {
+		queue <- m
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var sent atomic.Int64
+	go func() {
+		defer close(done)
+		forward(p, queue, "buoy.{buoy_id}.prediction", "Buoy-station", stop, &sent)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !until() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("forward did not return after stop")
+	}
+	return sent.Load()
+}
+
+func TestForwardRetries(t *testing.T) {
+	useRetryPolicy(t, backoff.Policy{Initial: time.Millisecond, Max: time.Millisecond})
+	var mu sync.Mutex
+	var keys []string
+	failures := 2
+	p := publishFunc(func(key string, body []byte, ts time.Time) error {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, key)
+		if failures > 0 {
+			failures--
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	msgs := []*message{{payload: []byte("Buoy-station,Wave-height\nb1,2.5")}, {payload: []byte("Wave-height\n2.5")}}
+	sent := runForward(t, p, msgs, func() bool { return msgs[1].acks.Load() > 0 })
+	if sent != 2 {
+		t.Errorf("sent = %d, want 2", sent)
+	}
+	for i, m := range msgs {
+		if n := m.acks.Load(); n != 1 {
+			t.Errorf("message %d acknowledged %d times", i, n)
+		}
+	}
+	want := "[buoy.b1.prediction buoy.b1.prediction buoy.b1.prediction buoy..prediction]"
+	if got := fmt.Sprint(keys); got != want {
+		t.Errorf("routing keys %s, want %s", got, want)
+	}
+}
+
+func TestForwardStopsUnacked(t *testing.T) {
+	useRetryPolicy(t, backoff.Policy{Initial: time.Hour, Max: time.Hour})
+	var calls atomic.Int32
+	p := publishFunc(func(string, []byte, time.Time) error {
+		calls.Add(1)
+		return errors.New("connection refused")
+	})
+	m := &message{payload: []byte("Buoy-station\nb1")}
+	if sent := runForward(t, p, []*message{m}, func() bool { return calls.Load() > 0 }); sent != 0 {
+		t.Errorf("sent = %d", sent)
+	}
+	if m.acks.Load() != 0 {
+		t.Error("unpublished result acknowledged; it would not be redelivered")
+	}
+}
+
+// TestPublisher publishes through the real AMQP client to fakeRabbit,
+// drops the connection and checks the next result reconnects.
+func TestPublisher(t *testing.T) {
+	useRetryPolicy(t, backoff.Policy{Initial: time.Millisecond, Max: 10 * time.Millisecond})
+	rabbit := newFakeRabbit(t)
+	p := &publisher{url: "amqp://guest:guest@" + rabbit.addr() + "/", exchange: "buoy.predictions", exchangeType: "topic", queue: "results", timeout: 5 * time.Second}
+	defer p.close()
+
+	ts := time.Unix(1700000000, 0)
+	if err := p.publish("buoy.b1.prediction", []byte("Buoy-station\nb1"), ts); err != nil {
+		t.Fatal(err)
+	}
+	got := rabbit.published()
+	if len(got) != 1 {
+		t.Fatalf("%d messages published", len(got))
+	}
+	want := publishedMessage{exchange: "buoy.predictions", key: "buoy.b1.prediction", contentType: "text/csv", deliveryMode: 2, timestamp: ts.Unix(), body: "Buoy-station\nb1"}
+	if got[0] != want {
+		t.Errorf("published %+v\nwant %+v", got[0], want)
+	}
+	if d := rabbit.declared(); fmt.Sprint(d) != "[exchange buoy.predictions topic durable queue results durable bind results # buoy.predictions confirm]" {
+		t.Errorf("declared %v", d)
+	}
+
+	// the connection drops and the broker nacks for a while: the result
+	// goes out over a new connection, retried until it is confirmed
+	rabbit.dropConnections()
+	rabbit.setNack(true)
+	m := &message{payload: []byte("Buoy-station\nb2")}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		rabbit.setNack(false)
+	}()
+	runForward(t, p, []*message{m}, func() bool { return m.acks.Load() > 0 })
+	got = rabbit.published()
+	if last := got[len(got)-1]; last.key != "buoy.b2.prediction" || last.body != "Buoy-station\nb2" {
+		t.Errorf("last message %+v", last)
+	}
+	if n := rabbit.connections(); n < 2 {
+		t.Errorf("%d connections, want a reconnect", n)
+	}
+}
+
+// fakeRabbit is just enough of an AMQP 0-9-1 broker for publisher: it
+// accepts the handshake, exchange and queue declarations and confirmed
+// publishes, and acks (or nacks) every message.
+type fakeRabbit struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	accepted int
+	nack     bool
+	msgs     []publishedMessage
+	decls    []string
+}
+
+type publishedMessage struct {
+	exchange, key, contentType string
+	deliveryMode               byte
+	timestamp                  int64
+	body                       string
+}
+
+func newFakeRabbit(t *testing.T) *fakeRabbit {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRabbit{ln: ln}
+	t.Cleanup(func() {
+		ln.Close()
+		r.dropConnections()
+	})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			r.conns = append(r.conns, c)
+			r.accepted++
+			r.mu.Unlock()
+			go r.serve(c)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRabbit) addr() string { return r.ln.Addr().String() }
+
+func (r *fakeRabbit) dropConnections() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.conns {
+		c.Close()
+	}
+	r.conns = nil
+}
+
+func (r *fakeRabbit) setNack(v bool) {
+	r.mu.Lock()
+	r.nack = v
+	r.mu.Unlock()
+}
+
+func (r *fakeRabbit) published() []publishedMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]publishedMessage(nil), r.msgs...)
+}
+
+func (r *fakeRabbit) declared() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.decls...)
+}
+
+func (r *fakeRabbit) connections() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.accepted
+}
+
+// AMQP frame types and frame end marker.
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+type frame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(br *bufio.Reader) (frame, error) {
+	var h [7]byte
+	if _, err := io.ReadFull(br, h[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{typ: h[0], channel: binary.BigEndian.Uint16(h[1:]), payload: make([]byte, binary.BigEndian.Uint32(h[3:])+1)}
+	if _, err := io.ReadFull(br, f.payload); err != nil {
+		return frame{}, err
+	}
+	if f.payload[len(f.payload)-1] != frameEnd {
+		return frame{}, errors.New("bad frame end")
+	}
+	f.payload = f.payload[:len(f.payload)-1]
+	return f, nil
+}
+
+func writeMethod(w io.Writer, channel, class, method uint16, args ...[]byte) {
+	payload := binary.BigEndian.AppendUint16(nil, class)
+	payload = binary.BigEndian.AppendUint16(payload, method)
+	for _, a := range args {
+		payload = append(payload, a...)
+	}
+	b := []byte{frameMethod}
+	b = binary.BigEndian.AppendUint16(b, channel)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	b = append(b, payload...)
+	w.Write(append(b, frameEnd))
+}
+
+func shortstr(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+func longstr(s string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+}
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// reader consumes AMQP fields from a method or header payload.
+type reader struct{ b []byte }
+
+func (r *reader) u8() byte   { v := r.b[0]; r.b = r.b[1:]; return v }
+func (r *reader) u16() int   { v := binary.BigEndian.Uint16(r.b); r.b = r.b[2:]; return int(v) }
+func (r *reader) u32() int   { v := binary.BigEndian.Uint32(r.b); r.b = r.b[4:]; return int(v) }
+func (r *reader) u64() int64 { v := binary.BigEndian.Uint64(r.b); r.b = r.b[8:]; return int64(v) }
+func (r *reader) str() string {
+	n := int(r.u8())
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+func (r *reader) skipTable() { r.b = r.b[r.u32():] }
+
+func (r *fakeRabbit) serve(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	proto := make([]byte, 8)
+	if _, err := io.ReadFull(br, proto); err != nil || string(proto) != "AMQP\x00\x00\x09\x01" {
+		return
+	}
+	writeMethod(c, 0, 10, 10, []byte{0, 9}, u32(0), longstr("PLAIN"), longstr("en_US")) // Connection.Start
+
+	var pending *publishedMessage
+	var tag uint64
+	for {
+		f, err := readFrame(br)
+		if err != nil {
+			return
+		}
+		switch f.typ {
+		case frameHeartbeat:
+			continue
+		case frameHeader:
+			// class, weight, body size, property flags, then the properties set
+			h := &reader{f.payload[14:]}
+			flags := binary.BigEndian.Uint16(f.payload[12:])
+			if flags&0x8000 != 0 {
+				pending.contentType = h.str()
+			}
+			if flags&0x4000 != 0 {
+				h.str()
+			}
+			if flags&0x2000 != 0 {
+				h.skipTable()
+			}
+			if flags&0x1000 != 0 {
+				pending.deliveryMode = h.u8()
+			}
+			if flags&0x0800 != 0 {
+				h.u8()
+			}
+			for _, bit := range []uint16{0x0400, 0x0200, 0x0100, 0x0080} {
+				if flags&bit != 0 {
+					h.str()
+				}
+			}
+			if flags&0x0040 != 0 {
+				pending.timestamp = h.u64()
+			}
+			if binary.BigEndian.Uint64(f.payload[4:]) > 0 {
+				continue
+			}
+			fallthrough
+		case frameBody:
+			pending.body += string(f.payload)
+			// bodies here fit in one frame
+			r.mu.Lock()
+			nack := r.nack
+			if !nack {
+				r.msgs = append(r.msgs, *pending)
+			}
+			r.mu.Unlock()
+			tag++
+			if nack {
+				writeMethod(c, f.channel, 60, 120, u64(tag), []byte{0}) // Basic.Nack
+			} else {
+				writeMethod(c, f.channel, 60, 80, u64(tag), []byte{0}) // Basic.Ack
+			}
+			pending = nil
+			continue
+		}
+
+		m := &reader{f.payload}
+		class, method := m.u16(), m.u16()
+		switch {
+		case class == 10 && method == 11: // Connection.StartOk
+			writeMethod(c, 0, 10, 30, u16(0), u32(131072), u16(0)) // Connection.Tune
+		case class == 10 && method == 31: // Connection.TuneOk
+		case class == 10 && method == 40: // Connection.Open
+			writeMethod(c, 0, 10, 41, shortstr(""))
+		case class == 10 && method == 50: // Connection.Close
+			writeMethod(c, 0, 10, 51)
+			return
+		case class == 20 && method == 10: // Channel.Open
+			writeMethod(c, f.channel, 20, 11, longstr(""))
+		case class == 20 && method == 40: // Channel.Close
+			writeMethod(c, f.channel, 20, 41)
+		case class == 40 && method == 10: // Exchange.Declare
+			m.u16()
+			name, typ := m.str(), m.str()
+			r.declare("exchange", name, typ, durable(m.u8()&2 != 0))
+			writeMethod(c, f.channel, 40, 11)
+		case class == 50 && method == 10: // Queue.Declare
+			m.u16()
+			name := m.str()
+			r.declare("queue", name, durable(m.u8()&2 != 0))
+			writeMethod(c, f.channel, 50, 11, shortstr(name), u32(0), u32(0))
+		case class == 50 && method == 20: // Queue.Bind
+			m.u16()
+			queue, exchange, key := m.str(), m.str(), m.str()
+			r.declare("bind", queue, key, exchange)
+			writeMethod(c, f.channel, 50, 21)
+		case class == 85 && method == 10: // Confirm.Select
+			r.declare("confirm")
+			writeMethod(c, f.channel, 85, 11)
+		case class == 60 && method == 40: // Basic.Publish
+			m.u16()
+			pending = &publishedMessage{exchange: m.str(), key: m.str()}
+		}
+	}
+}
+
+func durable(d bool) string {
+	if d {
+		return "durable"
+	}
+	return "transient"
+}
+
+func (r *fakeRabbit) declare(fields ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.decls) > 0 && r.accepted > 1 {
+		return // only the first connection's declarations are checked
+	}
+	r.decls = append(r.decls, fields...)
+}
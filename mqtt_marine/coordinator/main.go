@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"cloudletsapps/internal/backoff"
+	"cloudletsapps/internal/config"
+	"cloudletsapps/internal/health"
+	"cloudletsapps/internal/logging"
+	"cloudletsapps/internal/mqttutil"
+	"cloudletsapps/internal/rebalance"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func getenvDefault(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// coordinator tracks subscribers per rebalance group and publishes a
+// fresh assignment to every member whenever a group changes.
+type coordinator struct {
+	base   string
+	group  string // only manage this group; empty = all groups
+	mu     sync.Mutex
+	groups map[string]map[string][]string // group -> subscriber ID -> offered topics
+}
+
+func (co *coordinator) handleJoin(client MQTT.Client, msg MQTT.Message) {
+	var join rebalance.JoinMsg
+	if err := json.Unmarshal(msg.Payload(), &join); err != nil || join.SubscriberID == "" {
+		slog.Warn("ignoring bad join message", "err", err)
+		return
+	}
+	if join.Group == "" {
+		join.Group = rebalance.DefaultGroup
+	}
+	if co.group != "" && join.Group != co.group {
+		return
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	members := co.groups[join.Group]
+	if members == nil {
+		members = make(map[string][]string)
+		co.groups[join.Group] = members
+	}
+	members[join.SubscriberID] = join.Topics
+	slog.Info("subscriber joined", "subscriber", join.SubscriberID, "group", join.Group, "topics", len(join.Topics))
+	co.publishGroup(client, join.Group)
+}
+
+func (co *coordinator) handleLeave(client MQTT.Client, msg MQTT.Message) {
+	var leave rebalance.JoinMsg
+	if err := json.Unmarshal(msg.Payload(), &leave); err != nil || leave.SubscriberID == "" {
+		return
+	}
+	if leave.Group == "" {
+		leave.Group = rebalance.DefaultGroup
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	members := co.groups[leave.Group]
+	if _, ok := members[leave.SubscriberID]; !ok {
+		return
+	}
+	delete(members, leave.SubscriberID)
+	slog.Info("subscriber left", "subscriber", leave.SubscriberID, "group", leave.Group)
+	// clear the retained assignment so a restarted subscriber does not pick it up
+	client.Publish(rebalance.AssignTopic(co.base, leave.Group, leave.SubscriberID), 1, true, []byte{})
+	co.publishGroup(client, leave.Group)
+}
+
+// publishGroup sends every member of group its assignment. Caller holds co.mu.
+func (co *coordinator) publishGroup(client MQTT.Client, group string) {
+	for id, topics := range rebalance.Assign(co.groups[group]) {
+		body, err := json.Marshal(rebalance.AssignMsg{SubscriberID: id, Topics: topics})
+		if err != nil {
+			continue
+		}
+		client.Publish(rebalance.AssignTopic(co.base, group, id), 1, true, body)
+		slog.Info("assigned", "group", group, "subscriber", id, "topics", strings.Join(topics, ","))
+	}
+}
+
+func main() {
+	// the file can supply env-backed defaults, so it is read before the flags
+	fileCfg, err := config.LoadFile(config.FilePath(os.Args[1:], "CONFIG_FILE"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fileCfg.SetEnv()
+
+	var clientID, brokerFlag, coordinatorTopic, group string
+	flag.StringVar(&clientID, "client_id", "marine_coordinator", "MQTT client id")
+	flag.StringVar(&brokerFlag, "broker", "", "Broker URL (e.g. tcp://127.0.0.1:1883 or wss://broker.example.com/mqtt)")
+	flag.StringVar(&coordinatorTopic, "coordinator-topic", getenvDefault("COORDINATOR_TOPIC", "marine/coordinator"), "Base topic for join/leave/assignment messages")
+	flag.StringVar(&group, "rebalance-group", "", "Only manage this rebalance group (empty = all groups)")
+	var brokerCreds mqttutil.Credentials
+	flag.StringVar(&brokerCreds.Username, "mqtt-username", getenvDefault("MQTT_USERNAME", ""), "Broker username (default: anonymous)")
+	flag.StringVar(&brokerCreds.Password, "mqtt-password", getenvDefault("MQTT_PASSWORD", ""), "Broker password")
+	flag.StringVar(&brokerCreds.File, "mqtt-credentials-file", getenvDefault("MQTT_CREDENTIALS_FILE", ""), "File with the broker username on the first line and password on the second")
+	var tlsFiles mqttutil.TLSFiles
+	flag.StringVar(&tlsFiles.CAFile, "tls-ca-cert", getenvDefault("MQTT_TLS_CA_CERT", ""), "CA certificate (PEM) for ssl:// and wss:// brokers (default: system roots)")
+	flag.StringVar(&tlsFiles.CertFile, "tls-client-cert", getenvDefault("MQTT_TLS_CLIENT_CERT", ""), "Client certificate (PEM) for mutual TLS")
+	flag.StringVar(&tlsFiles.KeyFile, "tls-client-key", getenvDefault("MQTT_TLS_CLIENT_KEY", ""), "Client private key (PEM) for mutual TLS")
+	flag.BoolVar(&tlsFiles.InsecureSkipVerify, "tls-insecure-skip-verify", getenvDefault("MQTT_TLS_INSECURE", "") == "true", "Do not verify the broker certificate (testing only)")
+	var healthAddr string
+	flag.StringVar(&healthAddr, "health-addr", getenvDefault("HEALTH_ADDR", ""), "Serve /healthz and /readyz on this address (e.g. :8080; empty disables)")
+	var logLevel, logFormat string
+	flag.StringVar(&logLevel, "log-level", getenvDefault("LOG_LEVEL", "info"), "Minimum log level: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log-format", getenvDefault("LOG_FORMAT", "text"), "Log output: text or json (one object per line)")
+	flag.String("config", getenvDefault("CONFIG_FILE", ""), "YAML or TOML file with settings; keys are flag names, UPPER_CASE keys set env variables")
+	flag.Parse()
+	if err := fileCfg.Apply(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := logging.Setup(os.Stdout, logLevel, logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	broker := strings.TrimSpace(brokerFlag)
+	if broker == "" {
+		broker = getenvDefault("BROKER", "tcp://127.0.0.1:1883")
+	}
+
+	co := &coordinator{
+		base:   strings.TrimSuffix(coordinatorTopic, "/"),
+		group:  group,
+		groups: make(map[string]map[string][]string),
+	}
+
+	var settings mqttutil.ClientSettings
+	if settings.TLS, err = mqttutil.LoadTLSConfig(tlsFiles); err != nil {
+		slog.Error("invalid TLS settings", "err", err)
+		os.Exit(2)
+	}
+	if settings.Auth, err = brokerCreds.Load(); err != nil {
+		slog.Error("invalid broker credentials", "err", err)
+		os.Exit(2)
+	}
+	opts := settings.NewClientOptions(broker, clientID)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.OnConnect = func(c MQTT.Client) {
+		slog.Info("connected", "broker", broker)
+		c.Subscribe(rebalance.JoinTopic(co.base), 1, co.handleJoin)
+		c.Subscribe(rebalance.LeaveTopic(co.base), 1, co.handleLeave)
+		// membership is only kept in memory; ask everyone to announce themselves again
+		c.Publish(rebalance.RejoinTopic(co.base), 1, false, []byte("{}"))
+	}
+	opts.OnConnectionLost = func(c MQTT.Client, err error) {
+		slog.Warn("connection lost", "err", err)
+	}
+	mqttutil.ApplyReconnectBackoff(opts, backoff.DefaultPolicy)
+
+	client := MQTT.NewClient(opts)
+	client.Connect()
+
+	if healthAddr != "" {
+		h := health.New()
+		h.Ready("broker", func() error {
+			if !client.IsConnectionOpen() {
+				return errors.New("not connected")
+			}
+			return nil
+		})
+		go func() {
+			if err := h.ListenAndServe(healthAddr); err != nil {
+				slog.Error("health server stopped", "err", err)
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	client.Disconnect(250)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"cloudletsapps/internal/rebalance"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// retainingClient keeps the last retained payload per topic, as a broker
+// would; the rest of MQTT.Client is unused.
+type retainingClient struct {
+	MQTT.Client
+	retained map[string][]byte
+}
+
+func (c *retainingClient) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	if retained {
+		c.retained[topic] = payload.([]byte)
+	}
+	return nil
+}
+
+type message struct {
+	MQTT.Message
+	payload []byte
+}
+
+func (m message) Payload() []byte { return m.payload }
+
+func joinMsg(t *testing.T, id, group string, topics []string) MQTT.Message {
+	t.Helper()
+	body, err := json.Marshal(rebalance.JoinMsg{SubscriberID: id, Group: group, Topics: topics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message{payload: body}
+}
+
+// assignments reads every subscriber's retained assignment in group.
+func (c *retainingClient) assignments(t *testing.T, group string, ids ...string) map[string][]string {
+	t.Helper()
+	out := map[string][]string{}
+	for _, id := range ids {
+		body, ok := c.retained[rebalance.AssignTopic("coord", group, id)]
+		if !ok || len(body) == 0 {
+			continue
+		}
+		var a rebalance.AssignMsg
+		if err := json.Unmarshal(body, &a); err != nil {
+			t.Fatal(err)
+		}
+		if a.SubscriberID != id {
+			t.Errorf("assignment for %s names %s", id, a.SubscriberID)
+		}
+		out[id] = a.Topics
+	}
+	return out
+}
+
+func TestCoordinator(t *testing.T) {
+	topics := []string{"buoys/1", "buoys/2", "buoys/3", "buoys/4", "buoys/5", "buoys/6"}
+	c := &retainingClient{retained: map[string][]byte{}}
+	co := &coordinator{base: "coord", groups: map[string]map[string][]string{}}
+
+	for _, id := range []string{"sub-b", "sub-c", "sub-a"} {
+		co.handleJoin(c, joinMsg(t, id, "", topics))
+	}
+	got := c.assignments(t, rebalance.DefaultGroup, "sub-a", "sub-b", "sub-c")
+	want := map[string][]string{
+		"sub-a": {"buoys/1", "buoys/4"},
+		"sub-b": {"buoys/2", "buoys/5"},
+		"sub-c": {"buoys/3", "buoys/6"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("after 3 joins: %v, want %v", got, want)
+	}
+
+	// a rejoin after a coordinator restart changes nothing
+	co.handleJoin(c, joinMsg(t, "sub-b", "", topics))
+	if got := c.assignments(t, rebalance.DefaultGroup, "sub-a", "sub-b", "sub-c"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after rejoin: %v, want %v", got, want)
+	}
+
+	co.handleLeave(c, joinMsg(t, "sub-b", "", nil))
+	got = c.assignments(t, rebalance.DefaultGroup, "sub-a", "sub-b", "sub-c")
+	if _, ok := got["sub-b"]; ok {
+		t.Error("retained assignment of the leaver not cleared")
+	}
+	var all []string
+	for id, ts := range got {
+		if len(ts) != 3 {
+			t.Errorf("%s got %v after sub-b left, want 3 topics", id, ts)
+		}
+		all = append(all, ts...)
+	}
+	sort.Strings(all)
+	if !reflect.DeepEqual(all, topics) {
+		t.Errorf("topics covered after a leave: %v", all)
+	}
+}
+
+func TestCoordinatorGroups(t *testing.T) {
+	c := &retainingClient{retained: map[string][]byte{}}
+	co := &coordinator{base: "coord", group: "east", groups: map[string]map[string][]string{}}
+	co.handleJoin(c, joinMsg(t, "s1", "east", []string{"a", "b"}))
+	co.handleJoin(c, joinMsg(t, "s2", "west", []string{"c"}))
+	co.handleJoin(c, message{payload: []byte("not json")})
+
+	if got := c.assignments(t, "east", "s1"); !reflect.DeepEqual(got["s1"], []string{"a", "b"}) {
+		t.Errorf("east assignment %v", got)
+	}
+	if got := c.assignments(t, "west", "s2"); len(got) != 0 {
+		t.Errorf("assigned outside the managed group: %v", got)
+	}
+}
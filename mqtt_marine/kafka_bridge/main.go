@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+	"cloudletsapps/internal/config"
+	"cloudletsapps/internal/health"
+	"cloudletsapps/internal/kafka"
+	"cloudletsapps/internal/logging"
+	"cloudletsapps/internal/mqttutil"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func getenvDefault(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// messageKey returns the value of column field in a CSV result (header
+// line, data line), or nil when there is no such column.
+func messageKey(payload []byte, field string) []byte {
+	if field == "" {
+		return nil
+	}
+	lines := strings.SplitN(strings.ReplaceAll(string(payload), "\r\n", "\n"), "\n", 3)
+	if len(lines) < 2 {
+		return nil
+	}
+	header := strings.Split(lines[0], ",")
+	data := strings.Split(lines[1], ",")
+	for i, h := range header {
+		if strings.TrimSpace(h) == field && i < len(data) {
+			return []byte(strings.TrimSpace(data[i]))
+		}
+	}
+	return nil
+}
+
+// retryPolicy spaces out attempts to resend a failed batch.
+var retryPolicy = backoff.Policy{Initial: time.Second, Max: 30 * time.Second}
+
+// sender is the part of kafka.Producer that forward uses.
+type sender interface {
+	Send(msgs []kafka.Message) error
+}
+
+// forward sends queued messages to Kafka in batches of up to batchSize,
+// waiting at most linger for a batch to fill, and retries a failed batch
+// until it is delivered or stop is closed. A batch the cluster rejects for
+// good (see kafka.Error.Retriable) is dropped. It returns the batch it was
+// still retrying.
+func forward(p sender, queue <-chan kafka.Message, batchSize int, linger time.Duration, stop <-chan struct{}, sent *atomic.Int64) []kafka.Message {
+	delay := retryPolicy.New()
+	for {
+		var batch []kafka.Message
+		select {
+		case <-stop:
+			return nil
+		case m := <-queue:
+			batch = append(batch, m)
+		}
+		timer := time.NewTimer(linger)
+	fill:
+		for len(batch) < batchSize {
+			select {
+			case m := <-queue:
+				batch = append(batch, m)
+			case <-timer.C:
+				break fill
+			}
+		}
+		timer.Stop()
+		for {
+			err := p.Send(batch)
+			if err == nil {
+				delay.Reset()
+				sent.Add(int64(len(batch)))
+				break
+			}
+			var code kafka.Error
+			if errors.As(err, &code) && !code.Retriable() {
+				slog.Error("kafka rejected batch; results lost", "messages", len(batch), "err", err)
+				break
+			}
+			d := delay.Next()
+			slog.Warn("kafka send failed; retrying", "messages", len(batch), "delay", d, "err", err)
+			select {
+			case <-stop:
+				return batch
+			case <-time.After(d):
+			}
+		}
+	}
+}
+
+func main() {
+	// the file can supply env-backed defaults, so it is read before the flags
+	fileCfg, err := config.LoadFile(config.FilePath(os.Args[1:], "CONFIG_FILE"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fileCfg.SetEnv()
+
+	var clientID, brokerFlag, topic string
+	var qos int
+	flag.StringVar(&clientID, "client_id", "marine_kafka_bridge", "MQTT client id")
+	flag.StringVar(&brokerFlag, "broker", "", "Broker URL (e.g. tcp://127.0.0.1:1883 or wss://broker.example.com/mqtt)")
+	flag.StringVar(&topic, "topic", getenvDefault("PUB_TOPIC", "buoy_sensors_data_prediction"), "MQTT topic the prediction results are published on")
+	flag.IntVar(&qos, "qos", 1, "QoS (0, 1 or 2) of the MQTT subscription")
+	var kafkaBrokers, kafkaAcks string
+	var kcfg kafka.Config
+	var keyField string
+	flag.StringVar(&kafkaBrokers, "kafka-brokers", getenvDefault("KAFKA_BROKERS", "127.0.0.1:9092"), "Comma-separated Kafka bootstrap brokers (host:port)")
+	flag.StringVar(&kcfg.Topic, "kafka-topic", getenvDefault("KAFKA_TOPIC", "buoy_predictions"), "Kafka topic the results are written to (must exist)")
+	flag.StringVar(&keyField, "kafka-key-field", "Buoy-station", "Result column used as the Kafka message key, keeping each buoy on one partition (empty = no key)")
+	flag.StringVar(&kafkaAcks, "kafka-acks", "1", "Acknowledgements to wait for: 0, 1 (leader) or all")
+	flag.DurationVar(&kcfg.Timeout, "kafka-timeout", 10*time.Second, "Timeout of a Kafka request")
+	var batchSize, bufferSize int
+	var linger time.Duration
+	flag.IntVar(&batchSize, "batch-size", 100, "Messages per Kafka produce request")
+	flag.DurationVar(&linger, "linger", 100*time.Millisecond, "How long to wait for a batch to fill")
+	flag.IntVar(&bufferSize, "buffer-size", 10000, "Messages to hold while Kafka is unavailable; newer ones are dropped beyond that")
+	var brokerCreds mqttutil.Credentials
+	flag.StringVar(&brokerCreds.Username, "mqtt-username", getenvDefault("MQTT_USERNAME", ""), "Broker username (default: anonymous)")
+	flag.StringVar(&brokerCreds.Password, "mqtt-password", getenvDefault("MQTT_PASSWORD", ""), "Broker password")
+	flag.StringVar(&brokerCreds.File, "mqtt-credentials-file", getenvDefault("MQTT_CREDENTIALS_FILE", ""), "File with the broker username on the first line and password on the second")
+	var tlsFiles mqttutil.TLSFiles
+	flag.StringVar(&tlsFiles.CAFile, "tls-ca-cert", getenvDefault("MQTT_TLS_CA_CERT", ""), "CA certificate (PEM) for ssl:// and wss:// brokers (default: system roots)")
+	flag.StringVar(&tlsFiles.CertFile, "tls-client-cert", getenvDefault("MQTT_TLS_CLIENT_CERT", ""), "Client certificate (PEM) for mutual TLS")
+	flag.StringVar(&tlsFiles.KeyFile, "tls-client-key", getenvDefault("MQTT_TLS_CLIENT_KEY", ""), "Client private key (PEM) for mutual TLS")
+	flag.BoolVar(&tlsFiles.InsecureSkipVerify, "tls-insecure-skip-verify", getenvDefault("MQTT_TLS_INSECURE", "") == "true", "Do not verify the broker certificate (testing only)")
+	var healthAddr string
+	flag.StringVar(&healthAddr, "health-addr", getenvDefault("HEALTH_ADDR", ""), "Serve /healthz and /readyz on this address (e.g. :8080; empty disables)")
+	var logLevel, logFormat string
+	flag.StringVar(&logLevel, "log-level", getenvDefault("LOG_LEVEL", "info"), "Minimum log level: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log-format", getenvDefault("LOG_FORMAT", "text"), "Log output: text or json (one object per line)")
+	flag.String("config", getenvDefault("CONFIG_FILE", ""), "YAML or TOML file with settings; keys are flag names, UPPER_CASE keys set env variables")
+	flag.Parse()
+	if err := fileCfg.Apply(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := logging.Setup(os.Stdout, logLevel, logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if qos < 0 || qos > 2 {
+		slog.Error("invalid -qos", "qos", qos)
+		os.Exit(2)
+	}
+	switch kafkaAcks {
+	case "0":
+		kcfg.Acks = 0
+	case "1":
+		kcfg.Acks = 1
+	case "all", "-1":
+		kcfg.Acks = -1
+	default:
+		slog.Error("invalid -kafka-acks (want 0, 1 or all)", "acks", kafkaAcks)
+		os.Exit(2)
+	}
+	for _, b := range strings.Split(kafkaBrokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			kcfg.Brokers = append(kcfg.Brokers, b)
+		}
+	}
+	if len(kcfg.Brokers) == 0 {
+		slog.Error("no Kafka brokers given")
+		os.Exit(2)
+	}
+	kcfg.ClientID = clientID
+	batchSize = max(batchSize, 1)
+
+	broker := strings.TrimSpace(brokerFlag)
+	if broker == "" {
+		broker = getenvDefault("BROKER", "tcp://127.0.0.1:1883")
+	}
+
+	var settings mqttutil.ClientSettings
+	if settings.TLS, err = mqttutil.LoadTLSConfig(tlsFiles); err != nil {
+		slog.Error("invalid TLS settings", "err", err)
+		os.Exit(2)
+	}
+	if settings.Auth, err = brokerCreds.Load(); err != nil {
+		slog.Error("invalid broker credentials", "err", err)
+		os.Exit(2)
+	}
+
+	producer := kafka.NewProducer(kcfg)
+	queue := make(chan kafka.Message, max(bufferSize, 1))
+	var dropped, sent atomic.Int64
+	handler := func(_ MQTT.Client, msg MQTT.Message) {
+		m := kafka.Message{Key: messageKey(msg.Payload(), keyField), Value: msg.Payload(), Time: time.Now()}
+		select {
+		case queue <- m:
+		default:
+			if n := dropped.Add(1); n == 1 || n%100 == 0 {
+				slog.Warn("kafka buffer full; dropping results", "dropped", n)
+			}
+		}
+	}
+
+	opts := settings.NewClientOptions(broker, clientID)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.OnConnect = func(c MQTT.Client) {
+		slog.Info("connected", "broker", broker, "topic", topic)
+		c.Subscribe(topic, byte(qos), handler)
+	}
+	opts.OnConnectionLost = func(c MQTT.Client, err error) {
+		slog.Warn("connection lost", "err", err)
+	}
+	mqttutil.ApplyReconnectBackoff(opts, backoff.DefaultPolicy)
+
+	stop := make(chan struct{})
+	done := make(chan []kafka.Message)
+	go func() {
+		done <- forward(producer, queue, batchSize, linger, stop, &sent)
+	}()
+
+	client := MQTT.NewClient(opts)
+	client.Connect()
+	slog.Info("forwarding to kafka", "brokers", kafkaBrokers, "kafka_topic", kcfg.Topic, "key_field", keyField)
+
+	if healthAddr != "" {
+		h := health.New()
+		h.Ready("broker", func() error {
+			if !client.IsConnectionOpen() {
+				return errors.New("not connected")
+			}
+			return nil
+		})
+		go func() {
+			if err := h.ListenAndServe(healthAddr); err != nil {
+				slog.Error("health server stopped", "err", err)
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	client.Disconnect(250)
+	close(stop)
+	// one last attempt for what is still buffered
+	rest := <-done
+	for len(queue) > 0 {
+		rest = append(rest, <-queue)
+	}
+	if err := producer.Send(rest); err != nil {
+		slog.Error("kafka send failed; results lost", "messages", len(rest), "err", err)
+	}
+	producer.Close()
+	slog.Info("stopped", "sent", sent.Load(), "dropped", dropped.Load())
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+	"cloudletsapps/internal/kafka"
+)
+
+func TestMessageKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		field   string
+		want    string
+		wantNil bool
+	}{
+		{"column", "buoy_id,height\nb1,2.5\n", "buoy_id", "b1", false},
+		{"later column", "buoy_id, height\nb1, 2.5", "height", "2.5", false},
+		{"crlf", "buoy_id,height\r\nb7,1\r\n", "buoy_id", "b7", false},
+		{"no such column", "buoy_id,height\nb1,2.5", "filename", "", true},
+		{"no field", "buoy_id\nb1", "", "", true},
+		{"header only", "buoy_id,height", "buoy_id", "", true},
+		{"short data line", "buoy_id,height\nb1", "height", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := messageKey([]byte(tt.payload), tt.field)
+			if (got == nil) != tt.wantNil || string(got) != tt.want {
+				t.Errorf("messageKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeSender returns the queued errors in turn, then succeeds, recording
+// every batch it is given.
+type fakeSender struct {
+	mu      sync.Mutex
+	errs    []error
+	batches [][]kafka.Message
+}
+
+func (s *fakeSender) Send(msgs []kafka.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, msgs)
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+	return nil
+}
+
+func (s *fakeSender) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+func useRetryPolicy(t *testing.T, p backoff.Policy) {
+	old := retryPolicy
+	t.Cleanup(func() { retryPolicy = old })
+	retryPolicy = p
+}
+
+// runForward feeds msgs to forward and stops it once the sender has been
+// called calls times.
+func runForward(t *testing.T, s *fakeSender, msgs []kafka.Message, calls int) (pending []kafka.Message, sent int64) {
+	t.Helper()
+	queue := make(chan kafka.Message, len(msgs))
+	for _, m := range msgs {
+		queue <- m
+	}
+	stop := make(chan struct{})
+	var n atomic.Int64
+	done := make(chan []kafka.Message)
+	go func() { done <- forward(s, queue, 10, 10*time.Millisecond, stop, &n) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.calls() < calls {
+		if time.Now().After(deadline) {
+			t.Fatalf("sender called %d times, want %d", s.calls(), calls)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	select {
+	case pending = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("forward did not return after stop")
+	}
+	return pending, n.Load()
+}
+
+func messages(n int) []kafka.Message {
+	var msgs []kafka.Message
+	for i := range n {
+		msgs = append(msgs, kafka.Message{Value: []byte{byte(i)}})
+	}
+	return msgs
+}
+
+func TestForward(t *testing.T) {
+	useRetryPolicy(t, backoff.Policy{Initial: time.Millisecond, Max: time.Millisecond})
+	tests := []struct {
+		name        string
+		errs        []error
+		calls       int
+		wantSent    int64
+		wantPending int
+	}{
+		{"delivered", nil, 3, 25, 0},
+		{"retried", []error{kafka.Error(6), errors.New("kafka: dial: connection refused")}, 5, 25, 0},
+		{"rejected for good", []error{kafka.Error(10)}, 3, 15, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeSender{errs: tt.errs}
+			pending, sent := runForward(t, s, messages(25), tt.calls)
+			if sent != tt.wantSent {
+				t.Errorf("sent = %d, want %d", sent, tt.wantSent)
+			}
+			if len(pending) != tt.wantPending {
+				t.Errorf("%d messages pending, want %d", len(pending), tt.wantPending)
+			}
+			for i, b := range s.batches {
+				if len(b) > 10 {
+					t.Errorf("batch %d has %d messages, limit 10", i, len(b))
+				}
+			}
+		})
+	}
+}
+
+func TestForwardReturnsPendingOnStop(t *testing.T) {
+	useRetryPolicy(t, backoff.Policy{Initial: time.Hour, Max: time.Hour})
+	s := &fakeSender{errs: []error{kafka.Error(5)}}
+	pending, sent := runForward(t, s, messages(4), 1)
+	if sent != 0 {
+		t.Errorf("sent = %d, want 0", sent)
+	}
+	if len(pending) != 4 {
+		t.Errorf("%d messages pending after stop, want the 4 being retried", len(pending))
+	}
+}
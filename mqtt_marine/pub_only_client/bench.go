@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"cloudletsapps/internal/stats"
+)
+
+// benchmark runs a fixed number of measured publishes per buoy at a target
+// rate, after a warm-up, and reports what was achieved (-bench-count).
+// nil outside benchmark mode.
+type benchmark struct {
+	count    int           // measured messages per buoy
+	interval time.Duration // between publishes per buoy; 0 publishes back to back
+	warmup   time.Duration
+	start    time.Time
+
+	mu    sync.Mutex
+	buoys map[string]*benchBuoy
+}
+
+var bench *benchmark
+
+func newBenchmark(count int, rate float64, warmup time.Duration) *benchmark {
+	b := &benchmark{count: count, warmup: warmup, start: time.Now(), buoys: make(map[string]*benchBuoy)}
+	if rate > 0 {
+		b.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return b
+}
+
+// buoy returns the counters of one buoy worker.
+func (b *benchmark) buoy(name string) *benchBuoy {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bb := &benchBuoy{b: b, publish: stats.NewQuantiles()}
+	b.buoys[name] = bb
+	return bb
+}
+
+// benchBuoy counts one worker's publishes. Only the worker touches it
+// until report, which runs after the workers are done.
+type benchBuoy struct {
+	b    *benchmark
+	next time.Time
+
+	warmupMessages int64
+	messages       int64 // measured, including failed ones
+	errors         int64 // publishes that failed (spooled or lost)
+	spooled        int64
+	bytes          int64 // of successful publishes
+	first, last    time.Time
+	publish        *stats.Quantiles // ms from publish to acknowledgement
+}
+
+// record adds the outcome of one send and reports whether the worker has
+// published its measured messages.
+func (bb *benchBuoy) record(size int, took time.Duration, spooled bool, err error) bool {
+	now := time.Now()
+	if now.Sub(bb.b.start) < bb.b.warmup {
+		bb.warmupMessages++
+		return false
+	}
+	if bb.messages == 0 {
+		bb.first = now.Add(-took)
+	}
+	bb.last = now
+	bb.messages++
+	switch {
+	case err != nil:
+		bb.errors++
+	case spooled:
+		bb.errors++
+		bb.spooled++
+	default:
+		bb.bytes += int64(size)
+		bb.publish.Observe(float64(took) / float64(time.Millisecond))
+	}
+	return bb.messages >= int64(bb.b.count)
+}
+
+// wait paces the worker at the target rate. A worker that falls behind
+// by more than one interval does not burst to catch up.
+func (bb *benchBuoy) wait() {
+	iv := bb.b.interval
+	if iv <= 0 {
+		return
+	}
+	now := time.Now()
+	if bb.next.IsZero() || now.Sub(bb.next) > iv {
+		bb.next = now
+	}
+	bb.next = bb.next.Add(iv)
+	time.Sleep(time.Until(bb.next))
+}
+
+// benchStats is the report for one buoy or the total.
+type benchStats struct {
+	Messages       int64                 `json:"messages"`
+	WarmupMessages int64                 `json:"warmup_messages"`
+	Errors         int64                 `json:"errors"`
+	Spooled        int64                 `json:"spooled"`
+	Bytes          int64                 `json:"bytes"`
+	Seconds        float64               `json:"seconds"`
+	Rate           float64               `json:"rate_msgs_per_sec"`
+	Throughput     float64               `json:"throughput_bytes_per_sec"`
+	PublishMs      stats.QuantileSummary `json:"publish_ms"`
+}
+
+type benchReport struct {
+	TargetRate    float64               `json:"target_rate_per_buoy"` // 0: unpaced
+	WarmupSeconds float64               `json:"warmup_seconds"`
+	Buoys         map[string]benchStats `json:"buoys"`
+	Total         benchStats            `json:"total"`
+}
+
+func (s *benchStats) finish(first, last time.Time) {
+	if !first.IsZero() && last.After(first) {
+		s.Seconds = last.Sub(first).Seconds()
+		s.Rate = float64(s.Messages) / s.Seconds
+		s.Throughput = float64(s.Bytes) / s.Seconds
+	}
+}
+
+func (b *benchmark) report() benchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := benchReport{WarmupSeconds: b.warmup.Seconds(), Buoys: make(map[string]benchStats)}
+	if b.interval > 0 {
+		r.TargetRate = float64(time.Second) / float64(b.interval)
+	}
+	all := stats.NewQuantiles()
+	var first, last time.Time
+	for name, bb := range b.buoys {
+		s := benchStats{
+			Messages:       bb.messages,
+			WarmupMessages: bb.warmupMessages,
+			Errors:         bb.errors,
+			Spooled:        bb.spooled,
+			Bytes:          bb.bytes,
+			PublishMs:      bb.publish.Summary(),
+		}
+		s.finish(bb.first, bb.last)
+		r.Buoys[name] = s
+		r.Total.Messages += s.Messages
+		r.Total.WarmupMessages += s.WarmupMessages
+		r.Total.Errors += s.Errors
+		r.Total.Spooled += s.Spooled
+		r.Total.Bytes += s.Bytes
+		all.Merge(bb.publish)
+		if bb.messages > 0 {
+			if first.IsZero() || bb.first.Before(first) {
+				first = bb.first
+			}
+			if bb.last.After(last) {
+				last = bb.last
+			}
+		}
+	}
+	r.Total.PublishMs = all.Summary()
+	r.Total.finish(first, last)
+	return r
+}
+
+// printBenchReport writes the report as a table to stdout and, with path,
+// as JSON to a file.
+func printBenchReport(r benchReport, path string) {
+	names := make([]string, 0, len(r.Buoys))
+	for n := range r.Buoys {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fmt.Printf("benchmark: target %.2f msg/s per buoy, warm-up %s\n", r.TargetRate, time.Duration(r.WarmupSeconds*float64(time.Second)))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "buoy\tmessages\terrors\tspooled\tbytes\tseconds\tmsg/s\tMB/s\tp50 ms\tp95 ms\tp99 ms\t")
+	row := func(name string, s benchStats) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.1f\t%.2f\t%.3f\t%.1f\t%.1f\t%.1f\t\n", name, s.Messages, s.Errors, s.Spooled, s.Bytes,
+			s.Seconds, s.Rate, s.Throughput/1e6, s.PublishMs.P50, s.PublishMs.P95, s.PublishMs.P99)
+	}
+	for _, n := range names {
+		row(n, r.Buoys[n])
+	}
+	row("total", r.Total)
+	w.Flush()
+	if path == "" {
+		return
+	}
+	body, _ := json.MarshalIndent(r, "", "  ")
+	if err := os.WriteFile(path, append(body, '\n'), 0644); err != nil {
+		slog.Error("write benchmark report failed", "err", err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListLocalFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"c.npz", "a.npz", "b.tmp.npz", "readme.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.npz"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	got, err := listLocalFiles(dir, "*.npz", "*.tmp.npz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a.npz"), filepath.Join(dir, "c.npz")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listLocalFiles = %v, want %v", got, want)
+	}
+	if _, err := listLocalFiles(dir, "[", ""); err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
@@ -1,27 +1,231 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"cloudletsapps/internal/backoff"
+	"cloudletsapps/internal/buoypb"
+	"cloudletsapps/internal/clocksync"
+	"cloudletsapps/internal/coap"
+	"cloudletsapps/internal/codec"
+	"cloudletsapps/internal/config"
+	"cloudletsapps/internal/filefilter"
+	"cloudletsapps/internal/health"
+	"cloudletsapps/internal/linksim"
+	"cloudletsapps/internal/logging"
+	"cloudletsapps/internal/mqttutil"
+	"cloudletsapps/internal/nacl"
+	"cloudletsapps/internal/outbox"
+	"cloudletsapps/internal/pacing"
+	"cloudletsapps/internal/passwindow"
+	"cloudletsapps/internal/psk"
+	"cloudletsapps/internal/s3source"
+	"cloudletsapps/internal/signing"
+	"cloudletsapps/internal/stagger"
+	"cloudletsapps/internal/sticky"
+	"cloudletsapps/internal/topicparse"
+
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 )
 
-const maxRetry = 3
+// Broker connection settings: sticky-session header, TLS (-tls-*) and
+// credentials (-mqtt-*)
+var brokerSettings mqttutil.ClientSettings
+
+// Wait between failed broker (re)connect attempts (-reconnect-*)
+var reconnectPolicy = backoff.DefaultPolicy
 
 type BuoyFileState struct {
 	Files []string
 }
 
+// defaultOutboxLimits bounds each buoy outbox unless -outbox-max-messages
+// or -outbox-max-bytes say otherwise, so a long outage cannot fill the disk.
+var defaultOutboxLimits = outbox.Limits{MaxEntries: 10000, MaxBytes: 512 << 20}
+
+// workerOptions holds the settings shared by every buoy worker.
+type workerOptions struct {
+	clientID    string
+	broker      string
+	intervalSec int
+	pace        pacing.Interval // wait after each publish, drawn per message
+	moveSent    bool
+	deleteSent  bool          // moveSent on a local folder: published files are deleted
+	idleOnEmpty bool          // keep polling once every file was moved/deleted instead of exiting
+	outboxDir   string        // undelivered payloads are spooled under <outboxDir>/<buoy>
+	outboxLimit outbox.Limits // per-buoy bound on the outbox
+	qos         byte          // publish QoS
+	statusTopic string        // base of the retained per-buoy online/offline topic; empty disables
+	index       int           // position of this worker, for the startup stagger
+	startDelay  time.Duration // per-buoy startup stagger
+	startJitter time.Duration // random +/- offset on the stagger
+	coapServer  string        // POST payloads to this CoAP server instead of the broker; empty uses MQTT
+	coapBlock   int           // CoAP block size for payloads larger than one block
+}
+
+// TCP_NODELAY on broker connections (--tcp-no-delay); Go enables it by default
+var tcpNoDelay = true
+
+// Emulated uplink applied to each buoy's broker connection (-link-*)
+var linkSim linksim.Config
+
+// End-to-end payload encryption (--enable-nacl-encryption); nil keys mean plain JSON
+var naclSatellitePublic, naclPrivate *nacl.Key
+
+// Pre-shared key the npz data is sealed with (-psk-key-id); an empty ID
+// sends it in the clear
+var pskKeys psk.Keyring
+var pskKeyID string
+
+// Reference clock for send_time (-ntp-server); nil uses the local clock
+var refClock *clocksync.Clock
+
+// Per-buoy TLS client certificates, <dir>/<buoy>.crt and .key
+// (-tls-client-cert-dir); empty uses -tls-client-cert for every buoy
+var tlsClientCertDir string
+
+// Per-buoy Ed25519 keys, <dir>/<buoy>.key, that sign every message
+// (-signing-key-dir); empty sends messages unsigned
+var signingKeyDir string
+
+// Encoding of the "data" field (--base64-variant)
+var dataEncoding = base64.StdEncoding
+
+// Compression applied to the npz bytes before encoding (-compress)
+var dataCompression = codec.CompressionNone
+
+// Payload encoding (-format): JSON with base64 data, a protobuf Observation or CBOR
+var payloadFormat = buoypb.FormatJSON
+
+var errBrokerUnavailable = errors.New("broker unavailable")
+
+// Contact windows outside of which buoys stay disconnected (-pass-window)
+var passWindow passwindow.Schedule
+
+var errOutsidePass = errors.New("outside pass window")
+
+// Broker connection of every running buoy worker, for /readyz (-health-addr)
+var buoyClients sync.Map // buoy -> MQTT.Client
+
+// checkBrokers fails while any buoy worker is disconnected; its payloads
+// are spooled to the outbox meanwhile. Between pass windows being
+// disconnected is expected.
+func checkBrokers() error {
+	if !passWindow.Open(time.Now()) {
+		return nil
+	}
+	var down []string
+	buoyClients.Range(func(k, v any) bool {
+		if !v.(MQTT.Client).IsConnectionOpen() {
+			down = append(down, k.(string))
+		}
+		return true
+	})
+	if len(down) > 0 {
+		sort.Strings(down)
+		return fmt.Errorf("not connected: %s", strings.Join(down, ", "))
+	}
+	return nil
+}
+
+// fileSource is where a buoy worker reads its npz files from.
+type fileSource interface {
+	ReadFile(path string) ([]byte, error)
+}
+
+type localSource struct{}
+
+func (localSource) ReadFile(path string) ([]byte, error) { return os.ReadFile(path) }
+
+// sentMover is implemented by sources that can move published files out of
+// the buoy's listing (see -s3-move-sent).
+type sentMover interface {
+	ListFiles(buoyID string) ([]string, error)
+	MarkSent(path string) error
+}
+
+// localDir lists a buoy folder on disk; with -file-delete-after-publish
+// published files are removed instead of moved.
+type localDir struct {
+	localSource
+	dir              string
+	include, exclude string
+}
+
+func (l localDir) ListFiles(string) ([]string, error) {
+	return listLocalFiles(l.dir, l.include, l.exclude)
+}
+
+func (localDir) MarkSent(path string) error { return os.Remove(path) }
+
+// listLocalFiles returns the sorted paths of the files in dir that pass the
+// -file-pattern/-file-exclude-pattern filters.
+func listLocalFiles(dir, include, exclude string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range entries {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	names, err = filefilter.Filter(names, include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	paths := make([]string, len(names))
+	for i, fn := range names {
+		paths[i] = filepath.Join(dir, fn)
+	}
+	return paths, nil
+}
+
+// filteredS3Source applies -file-pattern/-file-exclude-pattern to S3 listings.
+type filteredS3Source struct {
+	*s3source.Source
+	include, exclude string
+}
+
+func (f filteredS3Source) ListFiles(buoyID string) ([]string, error) {
+	keys, err := f.Source.ListFiles(buoyID)
+	if err != nil {
+		return nil, err
+	}
+	return filefilter.Filter(keys, f.include, f.exclude)
+}
+
+// buoyTopic resolves the publish topic for one buoy. With a metadata pattern
+// the placeholders come from the buoy's location: {buoy_id} is the buoy folder
+// and {region} the folder (or S3 prefix level) above it.
+func buoyTopic(parser *topicparse.Parser, pattern, fallback, buoyPath string) (string, error) {
+	if pattern == "" {
+		return fallback, nil
+	}
+	return parser.Format(pattern, map[string]string{
+		"buoy_id": path.Base(buoyPath),
+		"region":  path.Base(path.Dir(buoyPath)),
+	})
+}
+
 func getenvDefault(key, def string) string {
 	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
 		return v
@@ -29,121 +233,467 @@ func getenvDefault(key, def string) string {
 	return def
 }
 
-// Connect to a single broker with reasonable MQTT options and clear logs.
-func connectToBroker(broker, clientID string) (MQTT.Client, error) {
-	opts := MQTT.NewClientOptions().AddBroker(broker)
-	opts.SetClientID(clientID)
-	opts.SetKeepAlive(10 * time.Second)
-	opts.SetPingTimeout(5 * time.Second)
-	opts.SetConnectTimeout(10 * time.Second)
-	opts.SetCleanSession(true)
+// How long to wait for the broker to acknowledge a publish before the
+// payload is spooled to the outbox instead
+var publishTimeout = 10 * time.Second
+
+type buoyStatusMsg struct {
+	BuoyID string `json:"buoy_id"`
+	Status string `json:"status"` // online or offline
+	TS     string `json:"ts"`
+}
+
+func buoyStatusPayload(buoy, status string) []byte {
+	body, _ := json.Marshal(buoyStatusMsg{BuoyID: buoy, Status: status, TS: time.Now().UTC().Format(time.RFC3339)})
+	return body
+}
+
+// newBuoyClient starts a client that keeps (re)connecting to broker in the
+// background; onConnect runs after every successful (re)connect. With a
+// status topic the client publishes a retained "online" message there on
+// connect and registers "offline" as its LWT.
+func newBuoyClient(settings mqttutil.ClientSettings, broker, clientID, buoy, statusTopic string, onConnect func()) MQTT.Client {
+	opts := settings.NewClientOptions(broker, clientID)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	if !tcpNoDelay || linkSim.Enabled() {
+		opts.SetCustomOpenConnectionFn(mqttutil.OpenConnectionFn(mqttutil.DialOptions{NoDelay: tcpNoDelay, Link: linkSim}))
+	}
+	if statusTopic != "" {
+		opts.SetWill(statusTopic, string(buoyStatusPayload(buoy, "offline")), 1, true)
+	}
 
 	opts.OnConnect = func(c MQTT.Client) {
-		fmt.Printf("[MQTT] Connected to %s as %s\n", broker, clientID)
+		slog.Info("connected", "broker", broker, "client_id", clientID)
+		if statusTopic != "" {
+			c.Publish(statusTopic, 1, true, buoyStatusPayload(buoy, "online"))
+		}
+		onConnect()
 	}
 	opts.OnConnectionLost = func(c MQTT.Client, err error) {
-		fmt.Printf("[MQTT] Connection lost from %s: %v\n", broker, err)
+		slog.Warn("connection lost", "broker", broker, "err", err)
 	}
+	mqttutil.ApplyReconnectBackoff(opts, reconnectPolicy)
 
 	client := MQTT.NewClient(opts)
-	fmt.Printf("[MQTT] Dialing %s ...\n", broker)
-	token := client.Connect()
-	ok := token.Wait() && token.Error() == nil
-	if !ok {
-		return nil, token.Error()
+	slog.Info("dialing", "broker", broker)
+	if passWindow.Open(time.Now()) {
+		// with ConnectRetry this keeps trying in the background; give the
+		// first attempt a chance so the worker does not start by spooling
+		client.Connect().WaitTimeout(10 * time.Second)
 	}
-	return client, nil
+	return client
 }
 
-// Single-broker reconnect + publish loop.
-// It never rotates broker; it will keep retrying the same broker indefinitely.
-func sendWithReconnect(broker string, clientID, topic string, payload []byte) (MQTT.Client, error) {
+// buoyPublisher sends one buoy's payloads over a persistent connection and
+// spools them to an on-disk outbox while the broker is unreachable.
+type buoyPublisher struct {
+	buoy        string
+	topic       string
+	qos         byte
+	statusTopic string
+	client      MQTT.Client
+	coap        *coap.Client // set with -transport coap; client is nil then
+	box         *outbox.Dir
+	wake        chan struct{}
+	offAir      atomic.Bool // between pass windows
+}
+
+// connected reports whether payloads can be sent now. CoAP has no
+// connection, so it is always worth a try inside a pass window.
+func (p *buoyPublisher) connected() bool {
+	if p.offAir.Load() {
+		return false
+	}
+	return p.coap != nil || p.client.IsConnectionOpen()
+}
+
+// disconnect closes the broker connection, first publishing the retained
+// "offline" status, since a clean disconnect does not fire the LWT.
+func (p *buoyPublisher) disconnect() {
+	if p.statusTopic != "" && p.client.IsConnectionOpen() {
+		p.client.Publish(p.statusTopic, 1, true, buoyStatusPayload(p.buoy, "offline")).WaitTimeout(2 * time.Second)
+	}
+	p.client.Disconnect(250)
+}
+
+// setPass follows the -pass-window schedule: the broker connection is
+// dropped when a pass ends and re-established when the next one starts.
+// Payloads are spooled in between and flushed once connected again.
+func (p *buoyPublisher) setPass(open bool) {
+	p.offAir.Store(!open)
+	slog.Info("pass window", "buoy", p.buoy, "open", open, "until", passWindow.Next(time.Now()))
+	switch {
+	case p.client == nil:
+		if open {
+			p.notify()
+		}
+	case open:
+		p.client.Connect() // OnConnect wakes the flusher
+	default:
+		p.disconnect()
+	}
+}
+
+// publish sends payload and waits for the broker to acknowledge it. A
+// timed-out publish may still be delivered later by the client, so spooled
+// payloads are delivered at least once rather than exactly once.
+func (p *buoyPublisher) publish(payload []byte) error {
+	if p.coap != nil {
+		// the URI path is the topic, so the satellite routes it the same way
+		format := coap.FormatJSON
+		switch {
+		case naclPrivate != nil || payloadFormat == buoypb.FormatProto:
+			format = coap.FormatOctetStream
+		case payloadFormat == buoypb.FormatCBOR:
+			format = coap.FormatCBOR
+		}
+		_, err := p.coap.Post(p.topic, format, payload, publishTimeout)
+		return err
+	}
+	if p.offAir.Load() {
+		return errOutsidePass
+	}
+	if !p.client.IsConnectionOpen() {
+		return errBrokerUnavailable
+	}
+	token := p.client.Publish(p.topic, p.qos, false, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("no acknowledgement within %s", publishTimeout)
+	}
+	return token.Error()
+}
+
+// send publishes payload directly when nothing is waiting in the outbox,
+// otherwise (or when that fails) appends it to the outbox so the flusher
+// delivers it in order. It reports whether the payload was spooled.
+func (p *buoyPublisher) send(payload []byte) (bool, error) {
+	if p.box.Len() == 0 {
+		err := p.publish(payload)
+		if err == nil {
+			return false, nil
+		}
+		slog.Warn("publish failed, spooling to outbox", "buoy", p.buoy, "err", err)
+	}
+	if _, err := p.box.Put(payload); err != nil {
+		return false, err
+	}
+	p.notify()
+	return true, nil
+}
+
+func (p *buoyPublisher) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flush drains the outbox oldest-first whenever the connection is up. An
+// entry is deleted only after its publish is acknowledged; if the delete
+// fails the entry is remembered so it is not published a second time.
+func (p *buoyPublisher) flush() {
+	confirmed := make(map[string]bool)
+	tk := time.NewTicker(10 * time.Second)
+	defer tk.Stop()
 	for {
-		// connect with limited retries per cycle
-		var client MQTT.Client
-		var err error
-		for retry := 0; retry < maxRetry; retry++ {
-			client, err = connectToBroker(broker, clientID)
-			if err == nil {
-				break
-			}
-			fmt.Printf("[MQTT] Connect to %s failed (attempt %d/%d): %v\n", broker, retry+1, maxRetry, err)
-			time.Sleep(2 * time.Second)
+		select {
+		case <-p.wake:
+		case <-tk.C:
+		}
+		if p.box.Len() == 0 || !p.connected() {
+			continue
 		}
+		names, err := p.box.List()
 		if err != nil {
-			// after maxRetry failures, back off and try again (same broker)
-			fmt.Printf("[MQTT] Failed to connect to %s after %d retries, will retry the same broker...\n", broker, maxRetry)
-			time.Sleep(5 * time.Second)
+			slog.Error("list outbox failed", "buoy", p.buoy, "err", err)
 			continue
 		}
-
-		// publish with retries on the same connection
-		var pubErr error
-		for retry := 0; retry < maxRetry; retry++ {
-			token := client.Publish(topic, 0, false, payload)
-			if token.Wait() && token.Error() != nil {
-				fmt.Printf("[MQTT] Publish to %s failed (attempt %d/%d): %v\n", broker, retry+1, maxRetry, token.Error())
-				pubErr = token.Error()
-				time.Sleep(2 * time.Second)
+		flushed := 0
+		for _, name := range names {
+			if confirmed[name] {
+				if err := p.box.Remove(name); err == nil {
+					delete(confirmed, name)
+				}
 				continue
 			}
-			pubErr = nil
-			break
+			payload, err := p.box.Read(name)
+			if err != nil {
+				slog.Error("read outbox entry failed", "buoy", p.buoy, "entry", name, "err", err)
+				break
+			}
+			if err := p.publish(payload); err != nil {
+				slog.Warn("outbox publish failed", "buoy", p.buoy, "err", err)
+				break
+			}
+			if err := p.box.Remove(name); err != nil {
+				slog.Error("remove outbox entry failed", "buoy", p.buoy, "entry", name, "err", err)
+				confirmed[name] = true
+			}
+			flushed++
 		}
-
-		if pubErr == nil {
-			return client, nil
+		if flushed > 0 {
+			slog.Info("flushed outbox", "buoy", p.buoy, "flushed", flushed, "left", p.box.Len())
 		}
+	}
+}
+
+// hasSpooled reports whether a buoy outbox directory holds any entries.
+func hasSpooled(dir string) bool {
+	if _, err := os.Stat(dir); err != nil {
+		return false
+	}
+	box, err := outbox.Open(dir)
+	return err == nil && box.Len() > 0
+}
 
-		// publish still failed after retries -> disconnect and retry the same broker
-		fmt.Printf("[MQTT] Publish to %s failed after %d retries, will reconnect same broker...\n", broker, maxRetry)
-		client.Disconnect(250)
-		time.Sleep(3 * time.Second)
+// encodePayload builds the message for one (compressed) npz file in
+// payloadFormat, sealing the data first when a pre-shared key is set.
+func encodePayload(buoy, filename string, data []byte, messageID string) ([]byte, error) {
+	sendTime := float64(refClock.Now().UnixNano()) / 1e9
+	var encryption string
+	if pskKeyID != "" {
+		sealed, err := pskKeys.Seal(pskKeyID, filename, data)
+		if err != nil {
+			return nil, err
+		}
+		data, encryption = sealed, psk.Algorithm
+	}
+	if payloadFormat != buoypb.FormatJSON {
+		o := &buoypb.Observation{
+			BuoyID:     buoy,
+			Filename:   filename,
+			Data:       data,
+			SendTime:   sendTime,
+			MessageID:  messageID,
+			Encryption: encryption,
+		}
+		if dataCompression != codec.CompressionNone {
+			o.Compression = dataCompression
+		}
+		if encryption != "" {
+			o.KeyID = pskKeyID
+		}
+		if payloadFormat == buoypb.FormatCBOR {
+			return o.MarshalCBOR()
+		}
+		return o.Marshal(), nil
+	}
+	payloadStruct := map[string]interface{}{
+		"buoy_id":    buoy,
+		"filename":   filename,
+		"data":       dataEncoding.EncodeToString(data),
+		"send_time":  sendTime,
+		"message_id": messageID,
 	}
+	if dataCompression != codec.CompressionNone {
+		payloadStruct["compression"] = dataCompression
+	}
+	if encryption != "" {
+		payloadStruct["encryption"] = encryption
+		payloadStruct["key_id"] = pskKeyID
+	}
+	return json.Marshal(payloadStruct)
 }
 
-func buoyWorker(buoy string, files []string, clientID, topic string, intervalSec int, broker string, wg *sync.WaitGroup) {
+func buoyWorker(buoy string, files []string, src fileSource, topic string, opts workerOptions, wg *sync.WaitGroup) {
 	defer wg.Done()
+	stagger.Start(opts.index, opts.startDelay, opts.startJitter)
+	clientID, broker, intervalSec := opts.clientID, opts.broker, opts.intervalSec
+	moveSent := opts.moveSent
+	mover, _ := src.(sentMover)
+	if mover == nil {
+		moveSent = false
+	}
+	box, err := outbox.Open(filepath.Join(opts.outboxDir, buoy))
+	if err != nil {
+		slog.Error("open outbox failed, worker exiting", "buoy", buoy, "err", err)
+		return
+	}
+	limits := opts.outboxLimit
+	limits.OnEvict = func(name string) {
+		slog.Warn("outbox full, dropped oldest entry", "buoy", buoy, "entry", name)
+	}
+	box.SetLimits(limits)
+	if n := box.Len(); n > 0 {
+		slog.Info("messages waiting in outbox", "buoy", buoy, "count", n, "bytes", box.Size())
+	}
+	var signer *signing.Signer
+	if signingKeyDir != "" {
+		keyPath := filepath.Join(signingKeyDir, buoy+".key")
+		key, created, err := signing.LoadOrGenerate(keyPath)
+		if err != nil {
+			slog.Error("load signing key failed, worker exiting", "buoy", buoy, "err", err)
+			return
+		}
+		if created {
+			slog.Info("generated signing key; give the .pub file to the satellite", "buoy", buoy, "public_key", keyPath+".pub")
+		}
+		signer = &signing.Signer{KeyID: buoy, Key: key}
+	}
+	var bb *benchBuoy
+	if bench != nil {
+		bb = bench.buoy(buoy)
+	}
+	pub := &buoyPublisher{buoy: buoy, topic: topic, qos: opts.qos, box: box, wake: make(chan struct{}, 1)}
+	if opts.statusTopic != "" {
+		pub.statusTopic = strings.TrimSuffix(opts.statusTopic, "/") + "/" + buoy
+	}
+	pub.offAir.Store(!passWindow.Open(time.Now()))
+	if opts.coapServer != "" {
+		if pub.coap, err = coap.Dial(opts.coapServer); err != nil {
+			slog.Error("CoAP setup failed, worker exiting", "buoy", buoy, "err", err)
+			return
+		}
+		pub.coap.BlockSize = opts.coapBlock
+		defer pub.coap.Close()
+		pub.notify()
+	} else {
+		settings := brokerSettings
+		if tlsClientCertDir != "" {
+			// one TLS identity per buoy, for broker ACLs keyed on the certificate
+			certFile, keyFile := mqttutil.DeviceCertFiles(tlsClientCertDir, buoy)
+			if settings.TLS, err = mqttutil.WithClientCert(brokerSettings.TLS, certFile, keyFile); err != nil {
+				slog.Error("load buoy client certificate failed, worker exiting", "buoy", buoy, "err", err)
+				return
+			}
+			slog.Info("using buoy client certificate", "buoy", buoy, "cn", mqttutil.ClientCertCN(settings.TLS))
+		}
+		pub.client = newBuoyClient(settings, broker, clientID+"_"+buoy, buoy, pub.statusTopic, pub.notify)
+		buoyClients.Store(buoy, pub.client)
+		defer func() {
+			buoyClients.Delete(buoy)
+			pub.disconnect()
+		}()
+	}
+	if passWindow.Enabled() {
+		stop := make(chan struct{})
+		defer close(stop)
+		go passWindow.Run(stop, pub.setPass)
+	}
+	go pub.flush()
 	idx := 0
 	for {
+		if len(files) == 0 {
+			// reachable when sent files are moved or deleted, or when the
+			// worker was only started to drain its outbox
+			if !opts.idleOnEmpty || mover == nil {
+				if box.Len() == 0 {
+					slog.Info("all files published, worker exiting", "buoy", buoy)
+					return
+				}
+				// deliver what is still spooled before exiting
+				time.Sleep(time.Duration(intervalSec) * time.Second)
+				continue
+			}
+			time.Sleep(time.Duration(intervalSec) * time.Second)
+			if keys, err := mover.ListFiles(buoy); err == nil {
+				files = keys
+			} else {
+				slog.Error("list files failed", "buoy", buoy, "err", err)
+			}
+			idx = 0
+			continue
+		}
 		filePath := files[idx]
-		fileData, err := ioutil.ReadFile(filePath)
+		fileData, err := src.ReadFile(filePath)
 		if err != nil {
-			fmt.Printf("[%s] Read npz %s failed: %v\n", buoy, filePath, err)
+			slog.Error("read npz failed", "buoy", buoy, "file", filePath, "err", err)
 			time.Sleep(time.Duration(intervalSec) * time.Second)
 			continue
 		}
 
-		payloadStruct := map[string]interface{}{
-			"buoy_id":   buoy,
-			"filename":  filepath.Base(filePath),
-			"data":      base64.StdEncoding.EncodeToString(fileData),
-			"send_time": float64(time.Now().UnixNano()) / 1e9,
+		// a stable ID per file lets the satellite de-dup without decoding the data
+		idHash := sha256.New()
+		idHash.Write([]byte(buoy + "/" + filepath.Base(filePath) + "\x00"))
+		idHash.Write(fileData)
+		messageID := hex.EncodeToString(idHash.Sum(nil))
+
+		if fileData, err = codec.Compress(fileData, dataCompression); err != nil {
+			slog.Error("compress failed", "buoy", buoy, "file", filePath, "err", err)
+			time.Sleep(time.Duration(intervalSec) * time.Second)
+			continue
 		}
-		payloadBytes, err := json.Marshal(payloadStruct)
+		payloadBytes, err := encodePayload(buoy, filepath.Base(filePath), fileData, messageID)
 		if err != nil {
-			fmt.Printf("[%s] JSON marshal failed: %v\n", buoy, err)
+			slog.Error("encode payload failed", "buoy", buoy, "err", err)
 			time.Sleep(time.Duration(intervalSec) * time.Second)
 			continue
 		}
+		if naclPrivate != nil {
+			if payloadBytes, err = nacl.Encrypt(payloadBytes, naclSatellitePublic, naclPrivate); err != nil {
+				slog.Error("encrypt failed", "buoy", buoy, "err", err)
+				time.Sleep(time.Duration(intervalSec) * time.Second)
+				continue
+			}
+		}
+		if signer != nil {
+			payloadBytes = signer.Sign(payloadBytes)
+		}
 
-		client, err := sendWithReconnect(broker, clientID+"_"+buoy, topic, payloadBytes)
+		sendStart := time.Now()
+		spooled, err := pub.send(payloadBytes)
+		if bb != nil && bb.record(len(payloadBytes), time.Since(sendStart), spooled, err) {
+			slog.Info("benchmark messages published, worker exiting", "buoy", buoy)
+			return
+		}
+		if errors.Is(err, outbox.ErrFull) {
+			// -outbox-overflow reject-new: hold this file until the flusher makes room
+			slog.Warn("outbox full, retrying", "buoy", buoy, "file", filePath)
+			time.Sleep(3 * time.Second)
+			continue
+		}
 		if err != nil {
-			// In current design, sendWithReconnect never returns error (it loops forever).
-			// But keep this log just in case we change behavior in future.
-			fmt.Printf("[%s] Broker unavailable, message failed: %v\n", buoy, err)
+			slog.Error("broker unavailable and outbox write failed", "buoy", buoy, "err", err)
 			time.Sleep(3 * time.Second)
 			continue
 		}
-		fmt.Printf("[%s] Sent %s\n", buoy, filePath)
-		client.Disconnect(250)
+		if spooled {
+			slog.Info("spooled to outbox", "buoy", buoy, "file", filePath)
+		} else if bb == nil {
+			slog.Info("sent", "buoy", buoy, "file", filePath)
+		}
 
+		if moveSent {
+			if err := mover.MarkSent(filePath); err != nil {
+				slog.Error("mark sent failed", "buoy", buoy, "file", filePath, "err", err)
+			} else {
+				files = append(files[:idx], files[idx+1:]...)
+				if opts.deleteSent {
+					// pick up files dropped into the folder since startup
+					if keys, err := mover.ListFiles(buoy); err == nil {
+						files = keys
+					}
+				}
+				if len(files) > 0 {
+					idx %= len(files)
+				}
+				if bb != nil {
+					bb.wait()
+				} else {
+					time.Sleep(opts.pace.Next())
+				}
+				continue
+			}
+		}
 		idx = (idx + 1) % len(files) // next file
-		time.Sleep(time.Duration(intervalSec) * time.Second)
+		if bb != nil {
+			bb.wait()
+		} else {
+			time.Sleep(opts.pace.Next())
+		}
 	}
 }
 
 func main() {
+	// the file can supply env-backed defaults, so it is read before the flags
+	fileCfg, err := config.LoadFile(config.FilePath(os.Args[1:], "CONFIG_FILE"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fileCfg.SetEnv()
+
 	var (
 		clientID   string
 		baseFolder string
@@ -153,21 +703,372 @@ func main() {
 	flag.StringVar(&clientID, "client_id", "EOS_publisher", "MQTT client id (base, will add _buoy)")
 	flag.StringVar(&baseFolder, "base_folder", "/root/app/sample_msg", "Base folder containing buoy folders")
 	flag.IntVar(&sleepSec, "interval", 1, "Sleep seconds for each buoy thread")
-	flag.StringVar(&brokerFlag, "broker", "", "Single broker URL (e.g. tcp://127.0.0.1:1883)")
+	var intervalDist string
+	var intervalJitter float64
+	flag.StringVar(&intervalDist, "interval-dist", getenvDefault("INTERVAL_DIST", pacing.Fixed), "Distribution of the wait between a buoy's publishes, with -interval as the mean: fixed, uniform (see -interval-jitter) or poisson (exponential waits, bursty like independent sensors); each buoy draws its own waits")
+	flag.Float64Var(&intervalJitter, "interval-jitter", 0.5, "For -interval-dist uniform, the largest deviation from -interval as a fraction of it (0 to 1)")
+	flag.StringVar(&brokerFlag, "broker", "", "Single broker URL (e.g. tcp://127.0.0.1:1883 or wss://broker.example.com/mqtt)")
+	var stickyCookie string
+	flag.DurationVar(&brokerSettings.KeepAlive, "keepalive", mqttutil.DefaultKeepAlive, "MQTT keepalive interval")
+	flag.DurationVar(&reconnectPolicy.Initial, "reconnect-initial-delay", reconnectPolicy.Initial, "Wait after a failed broker (re)connect attempt; doubles with every further failure")
+	flag.DurationVar(&reconnectPolicy.Max, "reconnect-max-delay", reconnectPolicy.Max, "Upper bound of the reconnect wait")
+	flag.Float64Var(&reconnectPolicy.Jitter, "reconnect-jitter", reconnectPolicy.Jitter, "Shorten each reconnect wait by a random fraction of up to this (0 to 1), so clients that lost the broker together do not retry in step")
+	flag.DurationVar(&publishTimeout, "publish-timeout", publishTimeout, "How long to wait for the broker to acknowledge a publish before spooling it")
+	flag.StringVar(&stickyCookie, "sticky-session-cookie", "", "Cookie name sent on WebSocket upgrades to pin reconnects to one broker backend")
+	var filePattern, fileExclude string
+	flag.BoolVar(&tcpNoDelay, "tcp-no-delay", tcpNoDelay, "Disable Nagle's algorithm on broker connections (Go's default); false re-enables it")
+	flag.BoolVar(&tcpNoDelay, "tcp-nagle-disabled", tcpNoDelay, "Alias for -tcp-no-delay")
+	var linkKbps float64
+	flag.Float64Var(&linkKbps, "link-bandwidth-kbps", 0, "Emulate a slow uplink: limit each buoy's outbound broker traffic to this many kbit/s (0 = unlimited)")
+	flag.IntVar(&linkSim.Burst, "link-burst-bytes", 0, "Bytes the emulated link may send at once before rate limiting (default: 1/10 s worth, at least 1500)")
+	flag.DurationVar(&linkSim.Delay, "link-delay", 0, "One-way delay added to outbound broker traffic (e.g. 600ms for a GEO hop)")
+	flag.DurationVar(&linkSim.Jitter, "link-jitter", 0, "Random +/- variation of -link-delay")
+	var passSpec string
+	var passOffset time.Duration
+	flag.StringVar(&passSpec, "pass-window", getenvDefault("PASS_WINDOW", ""), "Only stay connected during passes, as <window>/<period> (e.g. 8m/90m); payloads are spooled in between (empty: always connected)")
+	flag.DurationVar(&passOffset, "pass-window-offset", 0, "Shift the pass schedule; passes otherwise start at multiples of the period since the Unix epoch")
+	var startDelay, startJitter time.Duration
+	flag.DurationVar(&startDelay, "start-delay-per-buoy", 0, "Delay buoy N's start by N times this, to spread broker connects")
+	flag.DurationVar(&startJitter, "start-delay-jitter", 0, "Random +/- offset added to each buoy's start delay")
+	var base64Variant string
+	flag.StringVar(&dataCompression, "compress", getenvDefault("COMPRESS", codec.CompressionNone), "Compress npz data before encoding: none, gzip or zstd (the satellite decompresses transparently)")
+	flag.StringVar(&payloadFormat, "format", getenvDefault("FORMAT", buoypb.FormatJSON), "Payload format: json (base64 data), proto (Observation) or cbor (a map like the JSON one); both binary formats send the data as raw bytes, about 25% smaller")
+	flag.StringVar(&base64Variant, "base64-variant", "standard", "Base64 alphabet for the data field: standard or url-safe")
+	flag.StringVar(&filePattern, "file-pattern", "*.npz", "Glob on file names to publish")
+	flag.StringVar(&fileExclude, "file-exclude-pattern", "", "Glob on file names to skip (applied after -file-pattern)")
+	var outboxDir string
+	qos, err := strconv.Atoi(getenvDefault("MQTT_QOS", "1"))
+	if err != nil {
+		fmt.Println("Invalid MQTT_QOS:", os.Getenv("MQTT_QOS"))
+		os.Exit(2)
+	}
+	flag.StringVar(&outboxDir, "outbox", getenvDefault("OUTBOX_DIR", "outbox"), "Directory where payloads are spooled per buoy while the broker is unreachable")
+	var outboxLimit outbox.Limits
+	var outboxOverflow string
+	flag.IntVar(&outboxLimit.MaxEntries, "outbox-max-messages", defaultOutboxLimits.MaxEntries, "Most payloads spooled per buoy (0 = no limit)")
+	flag.Int64Var(&outboxLimit.MaxBytes, "outbox-max-bytes", defaultOutboxLimits.MaxBytes, "Most bytes spooled per buoy (0 = no limit)")
+	flag.StringVar(&outboxOverflow, "outbox-overflow", getenvDefault("OUTBOX_OVERFLOW", "drop-oldest"), "When a buoy outbox is full: drop-oldest evicts the oldest payloads, reject-new keeps retrying the current file until there is room")
+	flag.IntVar(&qos, "qos", qos, "Publish QoS (0, 1 or 2)")
+	var transport, coapServer string
+	var coapBlock int
+	flag.StringVar(&transport, "transport", getenvDefault("TRANSPORT", "mqtt"), "How payloads reach the satellite: mqtt (via the broker) or coap (confirmable POSTs to -coap-server)")
+	flag.StringVar(&coapServer, "coap-server", getenvDefault("COAP_SERVER", "127.0.0.1:5683"), "Satellite CoAP ingress (host:port) for -transport coap")
+	flag.IntVar(&coapBlock, "coap-block-size", 1024, "CoAP block size for payloads larger than one block (16 to 1024, a power of two)")
+	var topic, topicPattern string
+	flag.StringVar(&topic, "topic", getenvDefault("BUOY_TOPIC", "buoy_sensors_data"), "Publish topic; may be a per-buoy template such as buoy/{buoy_id}/observations ({buoy_id} is the buoy folder, {region} the level above it)")
+	flag.StringVar(&topicPattern, "topic-metadata-pattern", getenvDefault("TOPIC_METADATA_PATTERN", ""), "Per-buoy topic pattern, e.g. sensors/{region}/{buoy_id}/npz (default: single shared topic)")
+	var s3cfg s3source.Config
+	var s3MoveSent bool
+	flag.StringVar(&s3cfg.Endpoint, "s3-endpoint", getenvDefault("S3_ENDPOINT", ""), "S3-compatible endpoint URL (e.g. http://minio:9000)")
+	flag.StringVar(&s3cfg.Bucket, "s3-bucket", getenvDefault("S3_BUCKET", ""), "Bucket holding <prefix>/<buoy>/*.npz; enables S3 mode instead of base_folder")
+	flag.StringVar(&s3cfg.Prefix, "s3-prefix", getenvDefault("S3_PREFIX", ""), "Key prefix containing the buoy folders")
+	flag.StringVar(&s3cfg.AccessKey, "s3-access-key", getenvDefault("S3_ACCESS_KEY", ""), "S3 access key")
+	flag.StringVar(&s3cfg.SecretKey, "s3-secret-key", getenvDefault("S3_SECRET_KEY", ""), "S3 secret key")
+	flag.BoolVar(&s3MoveSent, "s3-move-sent", false, "Move each published object to <prefix>/sent/ instead of looping over it")
+	var deleteAfterPublish, idleOnEmpty bool
+	flag.BoolVar(&deleteAfterPublish, "file-delete-after-publish", false, "Delete each local npz file once it has been published instead of looping over it")
+	flag.BoolVar(&idleOnEmpty, "idle-on-empty", false, "With -file-delete-after-publish, keep polling an emptied buoy folder for new files instead of exiting the worker")
+	var naclEnabled bool
+	var satellitePubFile, publisherPrivFile string
+	flag.BoolVar(&naclEnabled, "enable-nacl-encryption", false, "Encrypt payloads for the satellite with NaCl box")
+	flag.StringVar(&satellitePubFile, "satellite-pubkey-file", getenvDefault("SATELLITE_PUBKEY_FILE", ""), "Satellite public key file (base64)")
+	flag.StringVar(&publisherPrivFile, "publisher-privkey-file", getenvDefault("PUBLISHER_PRIVKEY_FILE", "publisher.key"), "Publisher private key file; a key pair is generated here (public half in <file>.pub) if missing")
+	var pskInline, pskFile string
+	flag.StringVar(&pskInline, "psk-keys", getenvDefault("PSK_KEYS", ""), "Pre-shared AES-256 keys as comma-separated id:base64key entries; the npz data is sealed with AES-256-GCM so the broker cannot read it")
+	flag.StringVar(&pskFile, "psk-keys-file", getenvDefault("PSK_KEYS_FILE", ""), "File of pre-shared keys, one id:base64key per line")
+	flag.StringVar(&pskKeyID, "psk-key-id", getenvDefault("PSK_KEY_ID", ""), "Pre-shared key to seal with (default: the only key given)")
+	var ntpServer string
+	var ntpInterval time.Duration
+	flag.StringVar(&ntpServer, "ntp-server", getenvDefault("NTP_SERVER", ""), "Stamp send_time from this NTP server's clock (measured offset applied to the local clock), so latencies on hosts using the same server are not skewed by clock drift")
+	flag.DurationVar(&ntpInterval, "ntp-interval", 5*time.Minute, "How often to re-measure the -ntp-server offset")
+	flag.StringVar(&signingKeyDir, "signing-key-dir", getenvDefault("SIGNING_KEY_DIR", ""), "Sign every message with the buoy's Ed25519 key <dir>/<buoy>.key, generated (with <buoy>.key.pub for the satellite) if missing")
+	var benchCount int
+	var benchRate float64
+	var benchWarmup time.Duration
+	var benchReportPath string
+	flag.IntVar(&benchCount, "bench-count", 0, "Benchmark mode: publish this many measured messages per buoy (cycling through its files), then print a report and exit; 0 runs normally")
+	flag.Float64Var(&benchRate, "bench-rate", 0, "Benchmark target rate in messages per second per buoy, replacing -interval (0: as fast as the broker acknowledges)")
+	flag.DurationVar(&benchWarmup, "bench-warmup", 0, "Benchmark warm-up; messages published before it ends are not measured")
+	flag.StringVar(&benchReportPath, "bench-report", "", "Also write the benchmark report as JSON to this file")
+	var statusTopic string
+	flag.StringVar(&statusTopic, "status-topic", getenvDefault("BUOY_STATUS_TOPIC", "buoys/status"), "Availability topic; <topic>/<buoy> holds a retained online/offline message with offline as the LWT (empty disables)")
+	var brokerCreds mqttutil.Credentials
+	flag.StringVar(&brokerCreds.Username, "mqtt-username", getenvDefault("MQTT_USERNAME", ""), "Broker username (default: anonymous)")
+	flag.StringVar(&brokerCreds.Password, "mqtt-password", getenvDefault("MQTT_PASSWORD", ""), "Broker password")
+	flag.StringVar(&brokerCreds.File, "mqtt-credentials-file", getenvDefault("MQTT_CREDENTIALS_FILE", ""), "File with the broker username on the first line and password on the second")
+	var tlsFiles mqttutil.TLSFiles
+	flag.StringVar(&tlsFiles.CAFile, "tls-ca-cert", getenvDefault("MQTT_TLS_CA_CERT", ""), "CA certificate (PEM) for ssl:// and wss:// brokers (default: system roots)")
+	flag.StringVar(&tlsFiles.CertFile, "tls-client-cert", getenvDefault("MQTT_TLS_CLIENT_CERT", ""), "Client certificate (PEM) for mutual TLS")
+	flag.StringVar(&tlsFiles.KeyFile, "tls-client-key", getenvDefault("MQTT_TLS_CLIENT_KEY", ""), "Client private key (PEM) for mutual TLS")
+	flag.StringVar(&tlsClientCertDir, "tls-client-cert-dir", getenvDefault("MQTT_TLS_CLIENT_CERT_DIR", ""), "Directory of per-buoy client certificates for mutual TLS, <buoy>.crt and <buoy>.key, so each buoy connects with its own identity (instead of -tls-client-cert)")
+	flag.BoolVar(&tlsFiles.InsecureSkipVerify, "tls-insecure-skip-verify", getenvDefault("MQTT_TLS_INSECURE", "") == "true", "Do not verify the broker certificate (testing only)")
+	var logLevel, logFormat string
+	flag.StringVar(&logLevel, "log-level", getenvDefault("LOG_LEVEL", "info"), "Minimum log level: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log-format", getenvDefault("LOG_FORMAT", "text"), "Log output: text or json (one object per line)")
+	var healthAddr string
+	flag.StringVar(&healthAddr, "health-addr", getenvDefault("HEALTH_ADDR", ""), "Serve /healthz and /readyz on this address (e.g. :8080; empty disables)")
+	var exportConfig bool
+	flag.BoolVar(&exportConfig, "export-config", false, "Print the effective configuration as JSON (secrets redacted) and exit")
+	flag.BoolVar(&exportConfig, "C", false, "Shorthand for -export-config")
+	flag.String("config", getenvDefault("CONFIG_FILE", ""), "YAML or TOML file with settings; keys are flag names, UPPER_CASE keys set env variables")
 	flag.Parse()
+	if err := fileCfg.Apply(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := logging.Setup(os.Stdout, logLevel, logFormat); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if err := reconnectPolicy.Validate(); err != nil {
+		slog.Error("invalid -reconnect-* settings", "err", err)
+		os.Exit(2)
+	}
+	if passWindow, err = passwindow.Parse(passSpec); err != nil {
+		slog.Error("invalid -pass-window", "err", err)
+		os.Exit(2)
+	}
+	passWindow.Offset = passOffset
+	if passWindow.Enabled() {
+		now := time.Now()
+		slog.Info("pass window schedule", "window", passWindow.Window, "period", passWindow.Period, "open", passWindow.Open(now), "next_change", passWindow.Next(now))
+	}
+	linkSim.Rate = linkKbps * 1000 / 8
+	if linkSim.Enabled() {
+		slog.Info("emulating slow link", "kbps", linkKbps, "delay", linkSim.Delay, "jitter", linkSim.Jitter)
+	}
+	if stickyCookie != "" {
+		brokerSettings.Headers = sticky.Header(stickyCookie, sticky.NewValue())
+	}
+	if tlsClientCertDir != "" && tlsFiles.CertFile != "" {
+		slog.Error("-tls-client-cert-dir and -tls-client-cert are mutually exclusive")
+		os.Exit(2)
+	}
+	if brokerSettings.TLS, err = mqttutil.LoadTLSConfig(tlsFiles); err != nil {
+		slog.Error("invalid TLS settings", "err", err)
+		os.Exit(2)
+	}
+	if brokerSettings.Auth, err = brokerCreds.Load(); err != nil {
+		slog.Error("invalid broker credentials", "err", err)
+		os.Exit(2)
+	}
 
 	// Determine single broker: flag > env(BROKER) > default
 	broker := strings.TrimSpace(brokerFlag)
 	if broker == "" {
 		broker = getenvDefault("BROKER", "tcp://127.0.0.1:1883")
 	}
-	fmt.Printf("[Startup] Broker: %s\n", broker)
+	if exportConfig {
+		cfg := config.FromFlags(flag.CommandLine)
+		cfg["broker"] = broker
+		if err := config.Export(cfg); err != nil {
+			slog.Error("export config failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	slog.Info("starting", "broker", broker)
+	if healthAddr != "" {
+		h := health.New()
+		h.Ready("broker", checkBrokers)
+		go func() {
+			if err := h.ListenAndServe(healthAddr); err != nil {
+				slog.Error("health server stopped", "err", err)
+			}
+		}()
+	}
+
+	for _, p := range []string{filePattern, fileExclude} {
+		if err := filefilter.Validate(p); err != nil {
+			slog.Error("invalid file pattern", "err", err)
+			os.Exit(2)
+		}
+	}
+	if naclEnabled {
+		peer, err := nacl.LoadKey(satellitePubFile)
+		if err != nil {
+			slog.Error("load satellite public key failed", "err", err)
+			os.Exit(2)
+		}
+		_, priv, created, err := nacl.LoadOrGenerate(publisherPrivFile)
+		if err != nil {
+			slog.Error("load publisher key failed", "err", err)
+			os.Exit(2)
+		}
+		if created {
+			slog.Info("generated publisher key pair; share the .pub file with the satellite", "key", publisherPrivFile, "public_key", publisherPrivFile+".pub")
+		}
+		naclSatellitePublic, naclPrivate = peer, priv
+	}
+	if ntpServer != "" {
+		refClock = &clocksync.Clock{Server: ntpServer, Interval: ntpInterval}
+		report := func(offset, rtt time.Duration, err error) {
+			if err != nil {
+				slog.Warn("clock offset probe failed", "server", ntpServer, "err", err)
+				return
+			}
+			slog.Info("clock offset measured", "server", ntpServer, "offset", offset, "rtt", rtt)
+		}
+		refClock.Start(report)
+	}
+	if benchCount < 0 || benchRate < 0 || benchWarmup < 0 {
+		slog.Error("-bench-count, -bench-rate and -bench-warmup must not be negative")
+		os.Exit(2)
+	}
+	if benchCount > 0 {
+		bench = newBenchmark(benchCount, benchRate, benchWarmup)
+	}
+	if signingKeyDir != "" {
+		if err := os.MkdirAll(signingKeyDir, 0700); err != nil {
+			slog.Error("create signing key dir failed", "err", err)
+			os.Exit(2)
+		}
+	}
+	if pskInline != "" || pskFile != "" {
+		keys, err := psk.Load(pskInline, pskFile)
+		if err != nil {
+			slog.Error("load pre-shared keys failed", "err", err)
+			os.Exit(2)
+		}
+		if pskKeyID == "" && len(keys) == 1 {
+			pskKeyID = keys.IDs()[0]
+		}
+		if _, ok := keys[pskKeyID]; !ok {
+			slog.Error("-psk-key-id must name one of the pre-shared keys", "value", pskKeyID, "keys", keys.IDs())
+			os.Exit(2)
+		}
+		pskKeys = keys
+	} else if pskKeyID != "" {
+		slog.Error("-psk-key-id needs -psk-keys or -psk-keys-file")
+		os.Exit(2)
+	}
+	if !buoypb.ValidPayloadFormat(payloadFormat) {
+		slog.Error("invalid -format (want json, proto or cbor)", "value", payloadFormat)
+		os.Exit(2)
+	}
+	if !codec.ValidCompression(dataCompression) || dataCompression == "" {
+		slog.Error("invalid -compress (want none, gzip or zstd)", "value", dataCompression)
+		os.Exit(2)
+	}
+	switch base64Variant {
+	case "standard":
+	case "url-safe":
+		dataEncoding = base64.URLEncoding
+	default:
+		slog.Error("invalid -base64-variant", "value", base64Variant)
+		os.Exit(2)
+	}
+
+	if outboxLimit.MaxEntries < 0 || outboxLimit.MaxBytes < 0 {
+		slog.Error("invalid -outbox-max-messages or -outbox-max-bytes (want >= 0)", "messages", outboxLimit.MaxEntries, "bytes", outboxLimit.MaxBytes)
+		os.Exit(2)
+	}
+	if outboxLimit.Policy, err = outbox.ParsePolicy(outboxOverflow); err != nil {
+		slog.Error("invalid -outbox-overflow", "err", err)
+		os.Exit(2)
+	}
+	if qos < 0 || qos > 2 {
+		slog.Error("invalid -qos", "value", qos)
+		os.Exit(2)
+	}
+	switch transport {
+	case "mqtt":
+		coapServer = ""
+	case "coap":
+		if coapBlock < 16 || coapBlock > 1024 || coapBlock&(coapBlock-1) != 0 {
+			slog.Error("invalid -coap-block-size", "value", coapBlock)
+			os.Exit(2)
+		}
+	default:
+		slog.Error("invalid -transport (want mqtt or coap)", "value", transport)
+		os.Exit(2)
+	}
+	opts := workerOptions{
+		clientID:    clientID,
+		broker:      broker,
+		intervalSec: sleepSec,
+		pace:        pacing.Interval{Dist: intervalDist, Mean: time.Duration(sleepSec) * time.Second, Jitter: intervalJitter},
+		moveSent:    s3MoveSent || deleteAfterPublish,
+		deleteSent:  deleteAfterPublish,
+		// moved S3 objects are replaced by new uploads, so S3 workers always wait
+		idleOnEmpty: idleOnEmpty || s3cfg.Bucket != "",
+		outboxDir:   outboxDir,
+		outboxLimit: outboxLimit,
+		qos:         byte(qos),
+		statusTopic: statusTopic,
+		startDelay:  startDelay,
+		startJitter: startJitter,
+		coapServer:  coapServer,
+		coapBlock:   coapBlock,
+	}
+	if err := opts.pace.Validate(); err != nil {
+		slog.Error("invalid publish interval", "err", err)
+		os.Exit(2)
+	}
+	if s3cfg.Bucket != "" && deleteAfterPublish {
+		slog.Error("-file-delete-after-publish only applies to base_folder mode, use -s3-move-sent")
+		os.Exit(2)
+	}
+
+	var parser topicparse.Parser
+	if topicPattern == "" && strings.Contains(topic, "{") {
+		topicPattern = topic
+	}
+	if topicPattern != "" {
+		if _, err := parser.Wildcard(topicPattern); err != nil {
+			slog.Error("invalid topic pattern", "err", err)
+			os.Exit(2)
+		}
+	}
+
+	if s3cfg.Bucket != "" {
+		s3src, err := s3source.New(s3cfg)
+		if err != nil {
+			slog.Error("S3 source init failed", "err", err)
+			os.Exit(1)
+		}
+		src := filteredS3Source{Source: s3src, include: filePattern, exclude: fileExclude}
+		buoys, err := src.ListBuoys()
+		if err != nil {
+			slog.Error("list buoys in bucket failed", "err", err)
+			os.Exit(1)
+		}
+		var wg sync.WaitGroup
+		buoyCnt := 0
+		for _, buoy := range buoys {
+			keys, err := src.ListFiles(buoy)
+			if err != nil {
+				slog.Error("list files failed", "buoy", buoy, "err", err)
+				continue
+			}
+			pubTopic, err := buoyTopic(&parser, topicPattern, topic, path.Join(s3cfg.Bucket, s3cfg.Prefix, buoy))
+			if err != nil {
+				slog.Error("invalid topic pattern", "err", err)
+				os.Exit(2)
+			}
+			if len(keys) > 0 {
+				wg.Add(1)
+				opts.index = buoyCnt
+				go buoyWorker(buoy, keys, src, pubTopic, opts, &wg)
+				buoyCnt++
+			}
+		}
+		if buoyCnt == 0 {
+			slog.Warn("no buoy prefixes with npz objects found, exiting")
+			return
+		}
+		wg.Wait()
+		if bench != nil {
+			printBenchReport(bench.report(), benchReportPath)
+		}
+		return
+	}
 
-	topic := "buoy_sensors_data"
 	buoyDirs, err := os.ReadDir(baseFolder)
 	if err != nil {
-		fmt.Println("Failed to read sample_msg dir:", err)
-		return
+		slog.Error("read base folder failed", "err", err)
+		os.Exit(1)
 	}
 
 	var wg sync.WaitGroup
@@ -175,33 +1076,36 @@ func main() {
 	for _, d := range buoyDirs {
 		if d.IsDir() {
 			dirPath := filepath.Join(baseFolder, d.Name())
-			files, err := os.ReadDir(dirPath)
+			fullPaths, err := listLocalFiles(dirPath, filePattern, fileExclude)
 			if err != nil {
-				fmt.Printf("Read dir %s failed: %v\n", dirPath, err)
+				slog.Error("read dir failed", "dir", dirPath, "err", err)
 				continue
 			}
-			var npzList []string
-			for _, f := range files {
-				if !f.IsDir() && filepath.Ext(f.Name()) == ".npz" {
-					npzList = append(npzList, f.Name())
-				}
-			}
-			sort.Strings(npzList)
-			fullPaths := make([]string, len(npzList))
-			for i, fn := range npzList {
-				fullPaths[i] = filepath.Join(dirPath, fn)
+			pubTopic, err := buoyTopic(&parser, topicPattern, topic, filepath.ToSlash(dirPath))
+			if err != nil {
+				slog.Error("invalid topic pattern", "err", err)
+				os.Exit(2)
 			}
-			if len(fullPaths) > 0 {
+			// also start buoys whose files are gone but still have spooled messages
+			if len(fullPaths) > 0 || hasSpooled(filepath.Join(outboxDir, d.Name())) {
 				wg.Add(1)
-				go buoyWorker(d.Name(), fullPaths, clientID, topic, sleepSec, broker, &wg)
+				opts.index = buoyCnt
+				var src fileSource = localSource{}
+				if deleteAfterPublish {
+					src = localDir{dir: dirPath, include: filePattern, exclude: fileExclude}
+				}
+				go buoyWorker(d.Name(), fullPaths, src, pubTopic, opts, &wg)
 				buoyCnt++
 			}
 		}
 	}
 
 	if buoyCnt == 0 {
-		fmt.Println("No buoy folders with npz files found, exit.")
+		slog.Warn("no buoy folders with npz files found, exiting")
 		return
 	}
 	wg.Wait()
+	if bench != nil {
+		printBenchReport(bench.report(), benchReportPath)
+	}
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"cloudletsapps/internal/outbox"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestDefaultOutboxLimits(t *testing.T) {
+	if defaultOutboxLimits.MaxEntries <= 0 || defaultOutboxLimits.MaxBytes <= 0 {
+		t.Errorf("default outbox limits %+v leave the outbox unbounded", defaultOutboxLimits)
+	}
+}
+
+// TestOutboxFull sends payloads while the broker is down, so each one is
+// spooled, until the outbox limit is hit.
+func TestOutboxFull(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    outbox.Limits
+		sends     int
+		wantFull  int      // sends failing with ErrFull
+		wantKept  []string // payloads left in the outbox, oldest first
+		wantEvict int
+	}{
+		{"drop oldest by count", outbox.Limits{MaxEntries: 3}, 5, 0, []string{"p2", "p3", "p4"}, 2},
+		{"drop oldest by size", outbox.Limits{MaxBytes: 4}, 5, 0, []string{"p3", "p4"}, 3},
+		{"reject new by count", outbox.Limits{MaxEntries: 3, Policy: outbox.RejectNew}, 5, 2, []string{"p0", "p1", "p2"}, 0},
+		{"reject new by size", outbox.Limits{MaxBytes: 4, Policy: outbox.RejectNew}, 5, 3, []string{"p0", "p1"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			box, err := outbox.Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			evicted := 0
+			tt.limits.OnEvict = func(string) { evicted++ }
+			box.SetLimits(tt.limits)
+			// never connected, so every publish fails and is spooled
+			client := MQTT.NewClient(MQTT.NewClientOptions().AddBroker("tcp://127.0.0.1:1"))
+			pub := &buoyPublisher{buoy: "b1", topic: "t", qos: 1, client: client, box: box, wake: make(chan struct{}, 1)}
+
+			full := 0
+			for i := range tt.sends {
+				spooled, err := pub.send([]byte(fmt.Sprintf("p%d", i)))
+				switch {
+				case errors.Is(err, outbox.ErrFull):
+					full++
+				case err != nil:
+					t.Fatalf("send %d: %v", i, err)
+				case !spooled:
+					t.Fatalf("send %d published with the broker down", i)
+				}
+			}
+			if full != tt.wantFull {
+				t.Errorf("%d sends rejected, want %d", full, tt.wantFull)
+			}
+			if evicted != tt.wantEvict {
+				t.Errorf("%d entries evicted, want %d", evicted, tt.wantEvict)
+			}
+			names, err := box.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, name := range names {
+				b, _ := box.Read(name)
+				kept = append(kept, string(b))
+			}
+			if fmt.Sprint(kept) != fmt.Sprint(tt.wantKept) {
+				t.Errorf("outbox holds %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBroker acknowledges CONNECT and QoS 1 PUBLISH packets and records
+// the topic and payload of each publish.
+type fakeBroker struct {
+	ln net.Listener
+
+	mu        sync.Mutex
+	published map[string][]byte // filename -> payload
+}
+
+func startBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, published: map[string][]byte{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) url() string { return "tcp://" + b.ln.Addr().String() }
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header, body, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH: topic, packet id when QoS > 0, payload
+			n := int(body[0])<<8 | int(body[1])
+			rest := body[2+n:]
+			if qos := header >> 1 & 3; qos > 0 {
+				conn.Write([]byte{0x40, 2, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			var msg struct {
+				Filename string `json:"filename"`
+			}
+			json.Unmarshal(rest, &msg)
+			b.mu.Lock()
+			b.published[msg.Filename] = rest
+			b.mu.Unlock()
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func readPacket(r io.Reader) (header byte, body []byte, err error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, nil, err
+	}
+	header = b[0]
+	n, shift := 0, 0
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n |= int(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body = make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func TestDeleteAfterPublish(t *testing.T) {
+	b := startBroker(t)
+	dir := t.TempDir()
+	names := []string{"a.npz", "b.npz", "c.npz"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("npz "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := localDir{dir: dir, include: "*.npz"}
+	files, err := src.ListFiles("b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := workerOptions{
+		clientID:   "test",
+		broker:     b.url(),
+		moveSent:   true,
+		deleteSent: true,
+		outboxDir:  t.TempDir(),
+		qos:        1,
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go buoyWorker("b1", files, src, "buoys/b1/npz", opts, &wg)
+	exited := make(chan struct{})
+	go func() { wg.Wait(); close(exited) }()
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		t.Fatal("worker did not exit once the folder was empty")
+	}
+
+	left, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 0 {
+		t.Errorf("%d files left after publishing", len(left))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, name := range names {
+		if _, ok := b.published[name]; !ok {
+			t.Errorf("%s deleted without being published", name)
+		}
+	}
+	if len(b.published) != len(names) {
+		t.Errorf("published %d files, want %d", len(b.published), len(names))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"cloudletsapps/internal/config"
+	"cloudletsapps/internal/logging"
+	"cloudletsapps/internal/mqttutil"
+	"cloudletsapps/internal/predictout"
+	"cloudletsapps/internal/rawcache"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// reprocess reruns prediction post-processing over raw predict.py output
+// cached by the satellite (--predict-output-cache-dir). Results are
+// printed as header/data line pairs and can be republished.
+
+func getenvDefault(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// reprocess runs each cached output through the satellite's
+// post-processing, filling in the rest of the row from tmpl, and writes
+// the result to out and, if publish is not nil, republishes it. It
+// returns how many entries failed.
+func reprocess(entries []rawcache.Entry, tmpl predictout.Row, out io.Writer, publish func(msg string) error) (failed int) {
+	for _, e := range entries {
+		raw, err := os.ReadFile(e.Path)
+		if err != nil {
+			slog.Warn("reprocess failed", "file", e.Path, "err", err)
+			failed++
+			continue
+		}
+		header, data, err := predictout.Extract(string(raw))
+		if err != nil {
+			slog.Warn("reprocess failed", "file", e.Path, "err", err)
+			failed++
+			continue
+		}
+		// the original latencies are not cached; the cache timestamp stands in for send_time
+		row := tmpl
+		row.BuoyID, row.Header, row.Data = e.BuoyID, header, data
+		row.SendTime = float64(e.TS.UnixNano()) / 1e9
+		msg := row.Message()
+		fmt.Fprintln(out, msg)
+		if publish != nil {
+			if err := publish(msg); err != nil {
+				slog.Warn("publish failed", "file", e.Path, "err", err)
+				failed++
+			}
+		}
+	}
+	return failed
+}
+
+func main() {
+	// the file can supply env-backed defaults, so it is read before the flags
+	fileCfg, err := config.LoadFile(config.FilePath(os.Args[1:], "CONFIG_FILE"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fileCfg.SetEnv()
+
+	var cacheDir, buoyID, brokerFlag, clientID, topic, nodeID, modelVersion string
+	var publish bool
+	flag.StringVar(&cacheDir, "cache-dir", getenvDefault("PREDICT_OUTPUT_CACHE_DIR", ""), "Directory written by the satellite's --predict-output-cache-dir")
+	flag.StringVar(&buoyID, "buoy", "", "Only reprocess this buoy (default: all)")
+	flag.BoolVar(&publish, "publish", false, "Republish each result to the broker")
+	flag.StringVar(&brokerFlag, "broker", "", "Broker URL (e.g. tcp://127.0.0.1:1883 or wss://broker.example.com/mqtt)")
+	flag.StringVar(&clientID, "client_id", "marine_reprocess", "MQTT client id")
+	flag.StringVar(&topic, "topic", getenvDefault("PUB_TOPIC", "buoy_sensors_data_prediction"), "Topic to republish results on")
+	flag.StringVar(&nodeID, "node-id", getenvDefault("NODE_ID", "reprocess"), "Node-ID column value for reprocessed rows")
+	flag.StringVar(&modelVersion, "predict-model-version", getenvDefault("MODEL_VERSION", ""), "Model-Version column value for reprocessed rows")
+	var brokerCreds mqttutil.Credentials
+	flag.StringVar(&brokerCreds.Username, "mqtt-username", getenvDefault("MQTT_USERNAME", ""), "Broker username (default: anonymous)")
+	flag.StringVar(&brokerCreds.Password, "mqtt-password", getenvDefault("MQTT_PASSWORD", ""), "Broker password")
+	flag.StringVar(&brokerCreds.File, "mqtt-credentials-file", getenvDefault("MQTT_CREDENTIALS_FILE", ""), "File with the broker username on the first line and password on the second")
+	var tlsFiles mqttutil.TLSFiles
+	flag.StringVar(&tlsFiles.CAFile, "tls-ca-cert", getenvDefault("MQTT_TLS_CA_CERT", ""), "CA certificate (PEM) for ssl:// and wss:// brokers (default: system roots)")
+	flag.StringVar(&tlsFiles.CertFile, "tls-client-cert", getenvDefault("MQTT_TLS_CLIENT_CERT", ""), "Client certificate (PEM) for mutual TLS")
+	flag.StringVar(&tlsFiles.KeyFile, "tls-client-key", getenvDefault("MQTT_TLS_CLIENT_KEY", ""), "Client private key (PEM) for mutual TLS")
+	flag.BoolVar(&tlsFiles.InsecureSkipVerify, "tls-insecure-skip-verify", getenvDefault("MQTT_TLS_INSECURE", "") == "true", "Do not verify the broker certificate (testing only)")
+	var logLevel, logFormat string
+	flag.StringVar(&logLevel, "log-level", getenvDefault("LOG_LEVEL", "info"), "Minimum log level: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log-format", getenvDefault("LOG_FORMAT", "text"), "Log output: text or json (one object per line)")
+	flag.String("config", getenvDefault("CONFIG_FILE", ""), "YAML or TOML file with settings; keys are flag names, UPPER_CASE keys set env variables")
+	flag.Parse()
+	if err := fileCfg.Apply(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := logging.Setup(os.Stderr, logLevel, logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if cacheDir == "" {
+		slog.Error("-cache-dir is required")
+		os.Exit(2)
+	}
+	store := rawcache.Store{Dir: cacheDir}
+	entries, err := store.List(buoyID)
+	if err != nil {
+		slog.Error("list cache failed", "err", err)
+		os.Exit(1)
+	}
+
+	var client MQTT.Client
+	if publish {
+		broker := strings.TrimSpace(brokerFlag)
+		if broker == "" {
+			broker = getenvDefault("BROKER", "tcp://127.0.0.1:1883")
+		}
+		var settings mqttutil.ClientSettings
+		if settings.TLS, err = mqttutil.LoadTLSConfig(tlsFiles); err != nil {
+			slog.Error("invalid TLS settings", "err", err)
+			os.Exit(2)
+		}
+		if settings.Auth, err = brokerCreds.Load(); err != nil {
+			slog.Error("invalid broker credentials", "err", err)
+			os.Exit(2)
+		}
+		client = MQTT.NewClient(settings.NewClientOptions(broker, clientID))
+		if token := client.Connect(); token.Wait() && token.Error() != nil {
+			slog.Error("connect failed", "err", token.Error())
+			os.Exit(1)
+		}
+	}
+
+	var republish func(msg string) error
+	if client != nil {
+		republish = func(msg string) error {
+			token := client.Publish(topic, 0, false, msg)
+			if token.WaitTimeout(3 * time.Second) {
+				return token.Error()
+			}
+			return nil
+		}
+	}
+	failed := reprocess(entries, predictout.Row{NodeID: nodeID, ModelVersion: modelVersion}, os.Stdout, republish)
+	if client != nil {
+		client.Disconnect(250)
+	}
+	slog.Info("done", "files", len(entries), "failed", failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
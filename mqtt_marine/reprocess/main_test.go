@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/predictout"
+	"cloudletsapps/internal/rawcache"
+)
+
+// TestReprocess caches predict.py output the way the satellite does and
+// runs it back through reprocess.
+func TestReprocess(t *testing.T) {
+	store := rawcache.Store{Dir: t.TempDir()}
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	outputs := []struct {
+		buoy, output string
+	}{
+		{"b1", "2024 I tensorflow/core: loaded\nHs,Tp\n1.5,8\n"},
+		{"b1", "Traceback (most recent call last):\n"},
+		{"b2", "Hs,Tp\n2.5,9\n"},
+	}
+	for i, o := range outputs {
+		if _, err := store.Save(o.buoy, ts.Add(time.Duration(i)*time.Second), o.output); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	var published []string
+	failed := reprocess(entries, predictout.Row{NodeID: "reprocess", ModelVersion: "v2"}, &out, func(msg string) error {
+		published = append(published, msg)
+		return nil
+	})
+	if failed != 1 {
+		t.Errorf("%d failed, want the output without CSV", failed)
+	}
+	header := "Buoy-station,Hs,Tp,Observation-to-Reception-LATENCY,Observation-to-Inference-LATENCY,send_time,Node-ID,Model-Version"
+	want := []string{
+		header + "\nb1,1.5,8,0,0,1709294400.000000,reprocess,v2",
+		header + "\nb2,2.5,9,0,0,1709294402.000000,reprocess,v2",
+	}
+	if strings.Join(published, "\n") != strings.Join(want, "\n") {
+		t.Errorf("published\n%s\nwant\n%s", strings.Join(published, "\n"), strings.Join(want, "\n"))
+	}
+	if out.String() != strings.Join(want, "\n")+"\n" {
+		t.Errorf("printed\n%s", out.String())
+	}
+
+	// without publishing only the output is written; publish errors count as failures
+	out.Reset()
+	if failed := reprocess(entries[2:], predictout.Row{}, &out, nil); failed != 0 || !strings.HasPrefix(out.String(), "Buoy-station,") {
+		t.Errorf("print only: %d failed, output %q", failed, out.String())
+	}
+	if failed := reprocess(entries[2:], predictout.Row{}, &out, func(string) error { return errors.New("down") }); failed != 1 {
+		t.Errorf("publish error: %d failed", failed)
+	}
+}
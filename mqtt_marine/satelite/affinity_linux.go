@@ -0,0 +1,25 @@
+//go:build linux
+
+package main
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	registerFeature("cpu_affinity", func() bool { return len(workerCPUs) > 0 })
+}
+
+// pinCurrentWorker locks the calling goroutine to its OS thread and
+// restricts that thread to cpus. The thread stays locked for the
+// goroutine's lifetime, so this is only for long-lived worker goroutines.
+func pinCurrentWorker(cpus []int) error {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	for _, c := range cpus {
+		set.Set(c)
+	}
+	return unix.SchedSetaffinity(0, &set)
+}
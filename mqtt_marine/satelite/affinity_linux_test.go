@@ -0,0 +1,78 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// allowedCPUs parses Cpus_allowed_list ("0-3,6") from a proc status file.
+func allowedCPUs(status string) ([]int, error) {
+	data, err := os.ReadFile(status)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		list, ok := strings.CutPrefix(line, "Cpus_allowed_list:")
+		if !ok {
+			continue
+		}
+		var cpus []int
+		for _, r := range strings.Split(strings.TrimSpace(list), ",") {
+			lo, hi, isRange := strings.Cut(r, "-")
+			a, err := strconv.Atoi(lo)
+			if err != nil {
+				return nil, err
+			}
+			b := a
+			if isRange {
+				if b, err = strconv.Atoi(hi); err != nil {
+					return nil, err
+				}
+			}
+			for c := a; c <= b; c++ {
+				cpus = append(cpus, c)
+			}
+		}
+		return cpus, nil
+	}
+	return nil, fmt.Errorf("no Cpus_allowed_list in %s", status)
+}
+
+func TestPinCurrentWorker(t *testing.T) {
+	available, err := allowedCPUs("/proc/self/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := available[len(available)-1]
+
+	// affinity is per thread, so the worker reads its own thread's status
+	// rather than the process-wide /proc/self/status
+	type result struct {
+		allowed []int
+		err     error
+	}
+	done := make(chan result)
+	go func() {
+		// the goroutine exits locked, so its pinned thread is discarded
+		var r result
+		if r.err = pinCurrentWorker([]int{cpu}); r.err == nil {
+			r.allowed, r.err = allowedCPUs("/proc/thread-self/status")
+		}
+		done <- r
+	}()
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if len(r.allowed) != 1 || r.allowed[0] != cpu {
+		t.Errorf("worker allowed on CPUs %v, want only CPU %d", r.allowed, cpu)
+	}
+	if after, err := allowedCPUs("/proc/thread-self/status"); err != nil || len(after) != len(available) {
+		t.Errorf("test goroutine's thread restricted to %v (%v)", after, err)
+	}
+}
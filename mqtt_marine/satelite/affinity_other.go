@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// CPU pinning needs sched_setaffinity, which is Linux-only.
+func pinCurrentWorker(cpus []int) error {
+	return errors.New("CPU affinity is only supported on Linux")
+}
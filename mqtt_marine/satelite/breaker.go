@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"cloudletsapps/internal/breaker"
+	"cloudletsapps/internal/inference"
+)
+
+// Failures in a row before predict.py calls are short-circuited
+// (--inference-breaker-failures, 0 disables) and how long they stay
+// short-circuited before a probe (--inference-breaker-cooldown)
+var (
+	breakerFailures = 5
+	breakerCooldown = 30 * time.Second
+)
+
+// Circuit breaker around predict.py and the inference server; nil when
+// disabled or with the in-process ONNX backend
+var inferenceBreaker *breaker.Breaker
+
+// Result published instead of a prediction while the breaker is open, so
+// consumers see every message answered without waiting out the timeout
+const fallbackResult = "Prediction\nPredictionUnavailable"
+
+func startInferenceBreaker() {
+	inferenceBreaker = breaker.New(breakerFailures, breakerCooldown)
+	inferenceBreaker.OnChange = func(from, to breaker.State) {
+		breakerState.Set(float64(to))
+		switch to {
+		case breaker.Open:
+			slog.Error("inference failing; short-circuiting predictions", "from", from, "cooldown", breakerCooldown)
+		case breaker.HalfOpen:
+			slog.Info("probing inference")
+		case breaker.Closed:
+			slog.Info("inference recovered")
+		}
+	}
+}
+
+// allowInference returns breaker.ErrOpen while predictions are
+// short-circuited.
+func allowInference() error {
+	if inferenceBreaker == nil {
+		return nil
+	}
+	err := inferenceBreaker.Allow()
+	if err != nil {
+		predictionsShortCircuited.Inc()
+	}
+	return err
+}
+
+// recordInference feeds the outcome of a model call to the breaker. Only
+// backend errors (timeouts, crashes, a failing interpreter or server)
+// count as failures: an input the model rejects means the backend answered,
+// and a call canceled by shutdown says nothing about it.
+func recordInference(err error) {
+	switch {
+	case inferenceBreaker == nil:
+	case errors.Is(err, context.Canceled):
+		inferenceBreaker.Cancel()
+	case err == nil || errors.Is(err, inference.ErrBadInput):
+		inferenceBreaker.Success()
+	default:
+		inferenceBreaker.Failure()
+	}
+}
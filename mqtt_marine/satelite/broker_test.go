@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeBroker speaks just enough MQTT 3.1.1 for the satellite to connect
+// and subscribe. The first withheld SUBSCRIBEs go unanswered; open and
+// peak count simultaneous connections.
+type fakeBroker struct {
+	ln       net.Listener
+	withheld atomic.Int32
+	connects atomic.Int32
+	subs     atomic.Int32
+	open     atomic.Int32
+	peak     atomic.Int32
+}
+
+func startBroker(t *testing.T, withheld int32) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln}
+	b.withheld.Store(withheld)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) url() string { return "tcp://" + b.ln.Addr().String() }
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	n := b.open.Add(1)
+	defer b.open.Add(-1)
+	for p := b.peak.Load(); n > p && !b.peak.CompareAndSwap(p, n); p = b.peak.Load() {
+	}
+	for {
+		kind, body, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		switch kind {
+		case 1: // CONNECT
+			b.connects.Add(1)
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 8: // SUBSCRIBE: packet id, then (topic, qos) pairs
+			b.subs.Add(1)
+			if b.withheld.Add(-1) >= 0 {
+				continue
+			}
+			ack := []byte{0x90, 2, body[0], body[1]}
+			for rest := body[2:]; len(rest) >= 2; {
+				n := int(rest[0])<<8 | int(rest[1])
+				ack = append(ack, rest[2+n])
+				ack[1]++
+				rest = rest[3+n:]
+			}
+			conn.Write(ack)
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func readPacket(r io.Reader) (kind byte, body []byte, err error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, nil, err
+	}
+	kind = b[0] >> 4
+	n, shift := 0, 0
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n |= int(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body = make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return kind, body, err
+}
+
+// useBroker points connectAndSubscribeLocal at b with short timeouts.
+func useBroker(t *testing.T, b *fakeBroker) {
+	oldURL, oldTimeout, oldPolicy := brokerURL, subscribeTimeout, reconnectPolicy
+	t.Cleanup(func() { brokerURL, subscribeTimeout, reconnectPolicy = oldURL, oldTimeout, oldPolicy })
+	brokerURL = b.url()
+	subscribeTimeout = 100 * time.Millisecond
+	reconnectPolicy = backoff.Policy{Initial: time.Millisecond, Max: time.Millisecond}
+}
+
+func TestSubscribeTimeoutRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		withheld     int32
+		wantErr      bool
+		wantConnects int32
+	}{
+		{"suback in time", 0, false, 1},
+		{"suback delayed once", 1, false, 2},
+		{"never acknowledged", maxRetry, true, maxRetry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := startBroker(t, tt.withheld)
+			useBroker(t, b)
+			c, err := connectAndSubscribeLocal("test", []string{"sensors/+/npz"}, func(MQTT.Client, MQTT.Message) {})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if c != nil {
+				c.Disconnect(0)
+			}
+			if got := b.connects.Load(); got != tt.wantConnects {
+				t.Errorf("%d connects, want %d", got, tt.wantConnects)
+			}
+			if got := b.subs.Load(); got != tt.wantConnects {
+				t.Errorf("%d SUBSCRIBE packets, want one per connect", got)
+			}
+		})
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	const attempts, limit = 10, 3
+	b := startBroker(t, 0)
+	useBroker(t, b)
+	connSlots = make(chan struct{}, limit)
+	t.Cleanup(func() { connSlots = nil })
+
+	connected := make(chan MQTT.Client, attempts)
+	for range attempts {
+		go func() {
+			c, err := connectAndSubscribeLocal("test", []string{"t"}, func(MQTT.Client, MQTT.Message) {})
+			if err != nil {
+				t.Error(err)
+			}
+			connected <- c
+		}()
+	}
+
+	// hold connections until the limit is reached, then close them all
+	var held []MQTT.Client
+	for done := 0; done < attempts; done++ {
+		select {
+		case c := <-connected:
+			held = append(held, c)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%d of %d connected; waiters never got a slot", done, attempts)
+		}
+		if n := activeConnections.Load(); n > limit {
+			t.Fatalf("%d active connections, limit %d", n, limit)
+		}
+		if len(held) < limit && done < attempts-1 {
+			continue
+		}
+		for _, c := range held {
+			c.Disconnect(0)
+		}
+		// wait for the broker to see them go, so peak only counts live ones
+		for b.open.Load() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		for range held {
+			releaseConnSlot()
+		}
+		held = held[:0]
+	}
+	if p := b.peak.Load(); p != limit {
+		t.Errorf("peak of %d simultaneous connections, want %d", p, limit)
+	}
+	if n := b.connects.Load(); n != attempts {
+		t.Errorf("%d connects, want %d", n, attempts)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log/slog"
+
+	"cloudletsapps/internal/coap"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// serveCoAP accepts buoy payloads as CoAP POSTs on addr (--coap-addr)
+// and hands them to ingest like messages from the broker, with the URI
+// path as the topic. A payload that cannot be queued is answered with
+// 5.03, so the publisher spools and retries it.
+func serveCoAP(addr string, ingest func(MQTT.Message) error) {
+	srv := &coap.Server{
+		MaxBodySize: int(maxQueuedBytes),
+		Handler: func(path string, payload []byte) coap.Code {
+			if err := ingest(&localMessage{topic: path, payload: payload}); err != nil {
+				return coap.ServiceUnavailable
+			}
+			return coap.Changed
+		},
+	}
+	slog.Info("CoAP ingress listening", "addr", addr)
+	if err := srv.ListenAndServe(addr); err != nil {
+		slog.Error("CoAP ingress stopped", "err", err)
+	}
+}
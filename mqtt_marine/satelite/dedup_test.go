@@ -0,0 +1,206 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func jsonPayload(t *testing.T, fields map[string]any) []byte {
+	t.Helper()
+	b, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func resetDedup() {
+	msgMutex.Lock()
+	defer msgMutex.Unlock()
+	processedMessages = make(map[dedupKey]*list.Element)
+	processedOrder = list.New()
+}
+
+func TestMessageKey(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString([]byte("npz bytes"))
+	withID := jsonPayload(t, map[string]any{"buoy_id": "b1", "filename": "f.npz", "data": data, "send_time": 1, "message_id": "m-1"})
+	withIDResent := jsonPayload(t, map[string]any{"buoy_id": "b1", "filename": "f.npz", "data": data, "send_time": 2, "message_id": "m-1"})
+	badField := []byte(`{"buoy_id":"b1","send_time":"soon","message_id":"m-1"}`)
+	noID := jsonPayload(t, map[string]any{"buoy_id": "b1", "filename": "f.npz", "data": data, "send_time": 1})
+	noIDResent := jsonPayload(t, map[string]any{"buoy_id": "b1", "filename": "f.npz", "data": data, "send_time": 2})
+
+	tests := []struct {
+		name    string
+		a, b    []byte
+		full    bool
+		wantOK  bool
+		sameKey bool
+	}{
+		{"message_id without decoding", withID, withIDResent, false, true, true},
+		{"message_id despite a bad field", withID, badField, false, true, true},
+		{"no message_id left to the worker", noID, noIDResent, false, false, true},
+		{"no message_id keyed by data", noID, noIDResent, true, true, true},
+		{"unparsable keyed by raw bytes", []byte("junk"), []byte("junk2"), true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ka, ok := messageKey(tt.a, tt.full)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			kb, _ := messageKey(tt.b, tt.full)
+			if (ka == kb) != tt.sameKey {
+				t.Errorf("same key = %v, want %v", ka == kb, tt.sameKey)
+			}
+		})
+	}
+}
+
+func TestClaimJob(t *testing.T) {
+	resetDedup()
+	defer resetDedup()
+	msg := func(p []byte) MQTT.Message { return &localMessage{topic: "t", payload: p} }
+
+	// the handler claimed this one by its message_id
+	j := &predictionJob{payload: predictionPayload{MessageID: "m-1"}}
+	if !claimJob(j, msg(nil)) || j.key != messageIDKey("m-1") {
+		t.Fatal("message_id job not taken as claimed")
+	}
+	if len(processedMessages) != 0 {
+		t.Fatal("message_id claimed twice")
+	}
+
+	p := predictionPayload{BuoyID: "b1", Filename: "f.npz"}
+	first := &predictionJob{payload: p, key: contentKey(&p, []byte("npz"))}
+	again := &predictionJob{payload: p, key: contentKey(&p, []byte("npz"))}
+	if !claimJob(first, msg([]byte("a"))) {
+		t.Fatal("first message rejected")
+	}
+	if claimJob(again, msg([]byte("b"))) {
+		t.Fatal("re-send with the same data accepted")
+	}
+
+	// a message that failed before its data was decoded
+	broken := &predictionJob{}
+	if !claimJob(broken, msg([]byte("junk"))) || claimJob(&predictionJob{}, msg([]byte("junk"))) {
+		t.Fatal("broken messages not keyed by their raw bytes")
+	}
+}
+
+func TestClaimMessageIndex(t *testing.T) {
+	defer func(n int) { payloadHashIndexSize = n }(payloadHashIndexSize)
+	key := func(s string) dedupKey { return sha256.Sum256([]byte(s)) }
+	type step struct {
+		payload string
+		want    bool // claimed, i.e. not a duplicate
+	}
+	tests := []struct {
+		name  string
+		limit int
+		steps []step
+	}{
+		{"unbounded", 0, []step{{"a", true}, {"b", true}, {"a", false}, {"c", true}, {"b", false}}},
+		{"evicts least recently seen", 3, []step{
+			{"a", true}, {"b", true}, {"c", true},
+			{"a", false}, // a is now the most recent
+			{"d", true},  // evicts b
+			{"b", true},  // evicts c
+			{"a", false},
+			{"c", true},
+		}},
+		{"size one", 1, []step{{"a", true}, {"a", false}, {"b", true}, {"a", true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetDedup()
+			defer resetDedup()
+			payloadHashIndexSize = tt.limit
+			for i, s := range tt.steps {
+				if got := claimMessage(key(s.payload)); got != s.want {
+					t.Fatalf("step %d: claimMessage(%q) = %v, want %v", i, s.payload, got, s.want)
+				}
+			}
+			if tt.limit > 0 && (len(processedMessages) > tt.limit || processedOrder.Len() != len(processedMessages)) {
+				t.Errorf("index holds %d keys in a list of %d, limit %d", len(processedMessages), processedOrder.Len(), tt.limit)
+			}
+		})
+	}
+}
+
+// SHA-256 collisions cannot be found by brute force, so this finds two
+// payloads whose keys agree on a 16-bit prefix and keys the index by that
+// prefix alone, standing in for a collision. The second payload is then
+// taken as a duplicate of the first: it is dropped, nothing else in the
+// index changes and nothing panics.
+func TestClaimMessageCollision(t *testing.T) {
+	resetDedup()
+	defer resetDedup()
+	seen := map[[2]byte]string{}
+	var a, b string
+	for i := 0; a == ""; i++ {
+		p := fmt.Sprint("payload-", i)
+		sum := sha256.Sum256([]byte(p))
+		prefix := [2]byte{sum[0], sum[1]}
+		if q, ok := seen[prefix]; ok {
+			a, b = q, p
+		}
+		seen[prefix] = p
+	}
+	if sha256.Sum256([]byte(a)) == sha256.Sum256([]byte(b)) {
+		t.Fatalf("%q and %q share a full SHA-256", a, b)
+	}
+	weak := func(s string) dedupKey {
+		sum := sha256.Sum256([]byte(s))
+		return dedupKey{sum[0], sum[1]}
+	}
+
+	if !claimMessage(weak(a)) || !claimMessage(weak("other")) {
+		t.Fatal("distinct payloads rejected")
+	}
+	if claimMessage(weak(b)) {
+		t.Errorf("colliding payload %q claimed next to %q", b, a)
+	}
+	if len(processedMessages) != 2 || claimMessage(weak("other")) {
+		t.Error("collision disturbed the other entries")
+	}
+	// with the full hash the two are told apart
+	if !claimMessage(sha256.Sum256([]byte(a))) || !claimMessage(sha256.Sum256([]byte(b))) {
+		t.Error("full SHA-256 keys of different payloads collided")
+	}
+}
+
+// BenchmarkDedupIndex compares holding 10,000 payloads of 100 KB in the
+// index by their bytes (as before --payload-hash-index) with holding
+// their SHA-256. Run with -benchmem; the string keys need about 1 GB.
+func BenchmarkDedupIndex(b *testing.B) {
+	const entries, size = 10000, 100 << 10
+	payload := make([]byte, size)
+	b.Run("payload keys", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			index := make(map[string]time.Time)
+			for i := range entries {
+				binary.BigEndian.PutUint64(payload, uint64(i))
+				index[string(payload)] = time.Time{}
+			}
+		}
+	})
+	b.Run("sha256 keys", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			index := make(map[dedupKey]time.Time)
+			for i := range entries {
+				binary.BigEndian.PutUint64(payload, uint64(i))
+				index[sha256.Sum256(payload)] = time.Time{}
+			}
+		}
+	})
+}
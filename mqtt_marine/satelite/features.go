@@ -0,0 +1,41 @@
+package main
+
+import "sort"
+
+// feature is an optional capability announced with --advertise-capability.
+// It is registered by the file that provides it, so a build without that
+// file (onnx_ort.go, affinity_linux.go) never lists it, and enabled reports
+// whether this run turned it on.
+type feature struct {
+	name    string
+	enabled func() bool
+}
+
+var features []feature
+
+func registerFeature(name string, enabled func() bool) {
+	features = append(features, feature{name, enabled})
+}
+
+// The features main.go provides, each on when its state is set up.
+func init() {
+	registerFeature("sqlite_dedup", func() bool { return dedupDB != nil })
+	registerFeature("tls_ocsp", func() bool { return tlsOCSPCheck })
+	registerFeature("topic_metadata", func() bool { return topicPattern != "" })
+	registerFeature("data_summary", func() bool { return summaryTopic != "" })
+	registerFeature("inference_server", func() bool { return inferenceClient != nil })
+	registerFeature("inference_workers", func() bool { return inferencePool != nil })
+}
+
+// enabledFeatures returns the sorted names of the compiled-in features
+// this run has enabled.
+func enabledFeatures() []string {
+	names := []string{}
+	for _, f := range features {
+		if f.enabled() {
+			names = append(names, f.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+
+	"cloudletsapps/internal/dedupdb"
+	"cloudletsapps/internal/inference"
+)
+
+func TestEnabledFeatures(t *testing.T) {
+	if got := enabledFeatures(); len(got) != 0 {
+		t.Errorf("default configuration announces %v", got)
+	}
+
+	oldDedup, oldOCSP, oldSummary, oldPool, oldCPUs := dedupDB, tlsOCSPCheck, summaryTopic, inferencePool, workerCPUs
+	t.Cleanup(func() {
+		dedupDB, tlsOCSPCheck, summaryTopic, inferencePool, workerCPUs = oldDedup, oldOCSP, oldSummary, oldPool, oldCPUs
+	})
+	dedupDB = &dedupdb.Store{}
+	tlsOCSPCheck = true
+	summaryTopic = "satellite/summary"
+	inferencePool = &inference.Pool{}
+	workerCPUs = []int{0}
+
+	want := []string{"data_summary", "inference_workers", "sqlite_dedup", "tls_ocsp"}
+	if runtime.GOOS == "linux" {
+		want = []string{"cpu_affinity", "data_summary", "inference_workers", "sqlite_dedup", "tls_ocsp"}
+	}
+	if got := enabledFeatures(); !reflect.DeepEqual(got, want) {
+		t.Errorf("enabledFeatures() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloudletsapps/internal/health"
+)
+
+// Workers stuck on one message longer than this fail /healthz (--health-worker-stall)
+var workerStallTimeout = 5 * time.Minute
+
+// Start of the message each worker is predicting, in Unix ns; 0 while idle
+var workerBusy sync.Map // worker name -> *atomic.Int64
+
+func trackWorker(name string) *atomic.Int64 {
+	busy := new(atomic.Int64)
+	workerBusy.Store(name, busy)
+	return busy
+}
+
+// checkWorkers fails when a worker has been inside one prediction for
+// longer than workerStallTimeout.
+func checkWorkers() error {
+	now := time.Now().UnixNano()
+	var stuck []string
+	workerBusy.Range(func(k, v any) bool {
+		if since := v.(*atomic.Int64).Load(); since != 0 && time.Duration(now-since) > workerStallTimeout {
+			stuck = append(stuck, k.(string))
+		}
+		return true
+	})
+	if len(stuck) > 0 {
+		sort.Strings(stuck)
+		return fmt.Errorf("stalled for more than %s: %s", workerStallTimeout, strings.Join(stuck, ", "))
+	}
+	return nil
+}
+
+// checkBroker fails while the broker connection is down, except between
+// pass windows when that is intended.
+func checkBroker() error {
+	if !passWindow.Open(time.Now()) {
+		return nil
+	}
+	clientMutex.RLock()
+	c := globalClient
+	clientMutex.RUnlock()
+	if c == nil || !c.IsConnectionOpen() {
+		return errors.New("not connected")
+	}
+	return nil
+}
+
+func checkPredictScript() error {
+	if _, err := exec.LookPath(pythonBin); err != nil {
+		return err
+	}
+	_, err := os.Stat(predictScript)
+	return err
+}
+
+// serveHealth exposes /healthz (worker liveness) and /readyz (broker
+// connection and, unless the ONNX backend is in use, predict.py
+// availability) on addr.
+func serveHealth(addr string) {
+	h := health.New()
+	h.Live("workers", checkWorkers)
+	h.Ready("broker", checkBroker)
+	h.Ready("shutdown", checkShutdown)
+	if onnxModel == nil {
+		h.Ready("predict_script", checkPredictScript)
+	}
+	if inferenceClient != nil {
+		h.Ready("inference_server", inferenceClient.Err)
+	}
+	if inferencePool != nil {
+		h.Ready("inference_workers", inferencePool.Err)
+	}
+	slog.Info("serving health checks", "addr", addr)
+	if err := h.ListenAndServe(addr); err != nil {
+		slog.Error("health server stopped", "err", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// httpIngest accepts buoy payloads over HTTP for buoys that cannot reach
+// the broker (--http-ingest-addr). The body is exactly what would be
+// published on the input topic. POST /ingest uses the default topic;
+// POST /ingest/<topic> names it, for per-buoy topic patterns.
+type httpIngest struct {
+	defaultTopic string // empty: the topic must be in the path
+	token        string // required bearer token; empty allows anyone
+	maxBody      int64
+	ingest       func(MQTT.Message) error
+}
+
+func (h *httpIngest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.token != "" {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	topic := strings.Trim(strings.TrimPrefix(r.URL.Path, "/ingest"), "/")
+	if topic == "" {
+		topic = h.defaultTopic
+	}
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		http.Error(w, "POST to /ingest/<topic>", http.StatusNotFound)
+		return
+	}
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "read failed", http.StatusBadRequest)
+		}
+		return
+	}
+	if len(payload) == 0 {
+		http.Error(w, "empty payload", http.StatusBadRequest)
+		return
+	}
+	if err := h.ingest(&localMessage{topic: topic, payload: payload}); err != nil {
+		// same as an overloaded broker: the buoy keeps the payload and retries
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "queue full", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveHTTPIngest runs the HTTP ingestion endpoint, over TLS when certFile
+// and keyFile are set.
+func serveHTTPIngest(addr, certFile, keyFile string, h *httpIngest) {
+	mux := http.NewServeMux()
+	mux.Handle("/ingest", h)
+	mux.Handle("/ingest/", h)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		// slow uplinks: allow large payloads time to arrive
+		ReadTimeout: 5 * time.Minute,
+	}
+	slog.Info("HTTP ingest listening", "addr", addr, "tls", certFile != "")
+	var err error
+	if certFile != "" {
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	slog.Error("HTTP ingest stopped", "err", err)
+}
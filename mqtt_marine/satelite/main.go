@@ -1,18 +1,65 @@
 package main
 
 import (
+	"container/list"
 	"context"
-	"encoding/base64"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"cloudletsapps/internal/anomalydetect"
+	"cloudletsapps/internal/backoff"
+	"cloudletsapps/internal/bloomdedup"
+	"cloudletsapps/internal/breaker"
+	"cloudletsapps/internal/buoypb"
+	"cloudletsapps/internal/capability"
+	"cloudletsapps/internal/clocksync"
+	"cloudletsapps/internal/codec"
+	"cloudletsapps/internal/config"
+	"cloudletsapps/internal/dedupdb"
+	"cloudletsapps/internal/eventhook"
+	"cloudletsapps/internal/inference"
+	"cloudletsapps/internal/linksim"
+	"cloudletsapps/internal/logging"
+	"cloudletsapps/internal/mqttutil"
+	"cloudletsapps/internal/nacl"
+	"cloudletsapps/internal/ocsp"
+	"cloudletsapps/internal/outbox"
+	"cloudletsapps/internal/passwindow"
+	"cloudletsapps/internal/payloadschema"
+	"cloudletsapps/internal/predictout"
+	"cloudletsapps/internal/probe"
+	"cloudletsapps/internal/psk"
+	"cloudletsapps/internal/rawcache"
+	"cloudletsapps/internal/resultcache"
+	"cloudletsapps/internal/resultdb"
+	"cloudletsapps/internal/rtttracker"
+	"cloudletsapps/internal/sampling"
+	"cloudletsapps/internal/signing"
+	"cloudletsapps/internal/snimap"
+	"cloudletsapps/internal/sticky"
+	"cloudletsapps/internal/summarizer"
+	"cloudletsapps/internal/topicparse"
+	"cloudletsapps/internal/topicroute"
+
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 )
 
@@ -28,14 +75,194 @@ var msgChan = make(chan MQTT.Message, 128)
 var globalClient MQTT.Client
 var clientMutex sync.RWMutex
 var workerDone = make(chan struct{})
-var workerHeartbeat = make(chan struct{}, 1)
 
-// Message de-dup
-var processedMessages = make(map[string]time.Time)
+// Prediction workers sharing msgChan (--workers)
+var workerCount = 1
+
+// Last time each worker picked up a message, for the watchdog
+var workerBeats sync.Map // worker name -> time.Time
+
+// Queue memory bound (--max-queued-bytes)
+var maxQueuedBytes int64 = 512 << 20
+var queuedBytes atomic.Int64
+var droppedMessages atomic.Int64
+
+// Revocation check of the broker certificate (--tls-ocsp-stapling)
+var tlsOCSPCheck bool
+
+// Raw predict.py output kept for reprocessing (--predict-output-cache-dir)
+var rawCache *rawcache.Store
+
+// SQLite archive of every published result (--sqlite-archive); nil when disabled
+var resultArchive *resultdb.Store
+
+// Expected predict.py columns (--prediction-schema-file); nil skips validation
+var predictionSchema *predictout.Schema
+
+// Last published results per (buoy, file) (--result-deduplication-window)
+var recentResults *resultcache.RecentResultsCache
+
+// Model output by NPZ content (--prediction-cache-size); nil runs the model for every message
+var predictionCache *resultcache.PredictionCache
+
+// End-to-end payload encryption (--enable-nacl-encryption); nil keys mean plain JSON
+var naclPublisherPublic, naclPrivate *nacl.Key
+
+// Pre-shared keys for payloads whose data the publisher sealed (--psk-keys);
+// nil fails every encrypted payload
+var pskKeys psk.Keyring
+
+var errNoPSK = errors.New("payload is encrypted but no pre-shared keys are configured")
+
+// Public keys of the devices allowed to publish (--verify-keys-dir); nil
+// accepts messages without checking signatures
+var payloadSigners signing.Keyring
+
+// What happens to a message that fails the signature check (--signature-policy)
+var signaturePolicy = signing.PolicyReject
+
+// Signs published prediction results (--signing-key-file); nil leaves them unsigned
+var resultSigner *signing.Signer
+
+var errSignerMismatch = errors.New("signing key does not belong to the payload's buoy")
+
+// Reference clock for reception and inference timestamps (--ntp-server);
+// nil uses the local clock
+var refClock *clocksync.Clock
+
+// Failed predictions are reported here when set (--prediction-error-topic)
+var predictionErrorTopic string
+
+// Schema every payload must match before it is decoded (--payload-validation);
+// nil skips the check
+var payloadValidator *payloadschema.Validator
+
+// Where rejected payloads are reported (--payload-reject-topic); empty uses predictionErrorTopic
+var payloadRejectTopic string
+
+// Anomaly alerts (--anomaly-flag-topic)
+var anomalyTopic string
+var anomalyField string
+var anomalyThreshold float64
+
+// Semaphore bounding simultaneous broker connections
+// (--max-client-connections); nil means no limit
+var connSlots chan struct{}
+var activeConnections atomic.Int64
+
+// How long to wait for SUBACK before reconnecting (--subscribe-timeout)
+var subscribeTimeout = 15 * time.Second
+
+// TCP_NODELAY on the broker connection (--tcp-no-delay)
+var tcpNoDelay = true
+
+// Emulated link applied to the broker connection (--link-*)
+var linkSim linksim.Config
+
+// Contact windows outside of which the broker connection is dropped (--pass-window)
+var passWindow passwindow.Schedule
+
+// JSON file mapping broker URLs to TLS server names (--broker-sni-routing-map)
+var sniMapPath string
+
+// Periodic aggregate statistics (--data-summary-topic)
+var summary = summarizer.New()
+var summaryTopic string
+
+// Active connectivity probe (--connect-probe-interval); 0 relies on keepalive
+var connectProbeInterval time.Duration
+var probeCancel context.CancelFunc
+var probeMutex sync.Mutex
+
+// Python predict timeout: base + size_MB * per_MB, capped at max
+var predictTimeoutBaseMs int64 = 5000
+var predictTimeoutPerMBMs int64 = 1000
+var predictTimeoutMaxMs int64 = 120000
+
+// Broker connection settings: sticky-session header, TLS (--tls-*) and
+// credentials (--mqtt-*)
+var brokerSettings mqttutil.ClientSettings
+
+// Wait between failed broker (re)connect attempts (-reconnect-*)
+var reconnectPolicy = backoff.DefaultPolicy
+
+// Scratch directory for decoded NPZ files (--tmp-dir)
+var npzTmpDir = "/tmp/mqtt_npz"
+
+// Wait for a result publish before giving up (--publish-timeout)
+var resultPublishTimeout = 3 * time.Second
+
+// Topic prediction results are published to (PUB_TOPIC)
+var resultTopic string
+
+// Encoding of published results (--format); payloads are accepted in either
+var resultFormat = buoypb.FormatJSON
+
+// Retained availability topic <status-topic>/<node_id> (--status-topic);
+// the broker publishes "offline" there as our LWT. Empty disables.
+var statusTopic string
+
+// Durable session (--persistent-session): fixed client ID and
+// CleanSession=false, so the broker queues QoS>0 messages while we are away
+var persistentSession bool
+
+// QoS of the input subscription (--qos) and of published results (--result-qos)
+var subscribeQoS, resultQoS byte
+
+// Reconnect events (--reconnect-notify-topic)
+var reconnectCount atomic.Int64
+var reconnectNotifier *eventhook.MQTTNotifier
+
+// Per-buoy worker isolation (--worker-isolate-buoy)
+var isolateBuoys bool
+var buoyQueues = make(map[string]chan MQTT.Message)
+var buoyQueuesMutex sync.RWMutex
+
+// Share of messages that get per-message logs (--message-sampling-rate)
+var logSampler = sampling.Sampler{Rate: 1}
+
+// Identifies this satellite in logs and published results (--node-id)
+var nodeID string
+
+// Model version stamped on every result (--predict-model-version)
+var modelVersion string
+
+// CPUs the prediction workers are pinned to (--worker-cpu-affinity)
+var workerCPUs []int
+
+// Worker restart pacing (see startWorker)
+var workerRestartInitialDelay = 1 * time.Second
+var workerRestartMaxDelay = 60 * time.Second
+
+// Message de-dup, keyed on a SHA-256 of the message identity (see
+// messageKey) so large NPZ payloads are not kept in memory. processedOrder
+// is least recently seen first; with --payload-hash-index > 0 those
+// entries are evicted beyond that size.
+type dedupKey [32]byte
+
+type dedupEntry struct {
+	key dedupKey
+	ts  time.Time
+}
+
+var processedMessages = make(map[dedupKey]*list.Element)
+var processedOrder = list.New()
+var payloadHashIndexSize int
 var msgMutex sync.RWMutex
 var messageID = 0
 var msgIDMutex sync.Mutex
 
+// Topic metadata (--topic-metadata-pattern), e.g. sensors/{region}/{buoy_id}/npz
+var topicPattern string
+var topicParser topicparse.Parser
+
+// Optional SQLite-backed de-dup (--sqlite-dedup); nil means in-memory map
+var dedupDB *dedupdb.Store
+
+// Optional Bloom filter de-dup (--dedup-bloom-filter); may skip a small
+// fraction of unique messages as false positives
+var dedupBloom *bloomdedup.Filter
+
 func getenvDefault(k, def string) string {
 	if v := os.Getenv(k); v != "" {
 		return v
@@ -50,366 +277,2056 @@ func generateMessageID() int {
 	return messageID
 }
 
+// messageKey identifies a message by the publisher's message_id. Without
+// one the key needs the decoded data: with full set it is computed here
+// (contentKey, or a hash of the raw bytes for payloads that cannot be
+// parsed); otherwise ok is false and the worker, which decodes the data
+// anyway, claims the message (see claimJob).
+func messageKey(payload []byte, full bool) (key dedupKey, ok bool) {
+	body, err := openPayload(payload)
+	if err != nil {
+		return sha256.Sum256(payload), full
+	}
+	var p predictionPayload
+	err = decodePayload(body, &p)
+	// JSON with a bad field still yields its message_id, to the worker too
+	if p.MessageID != "" {
+		return messageIDKey(p.MessageID), true
+	}
+	if err != nil {
+		return sha256.Sum256(payload), full
+	}
+	if !full {
+		return key, false
+	}
+	npzBytes, err := p.npz()
+	if err != nil {
+		return sha256.Sum256(payload), true
+	}
+	return contentKey(&p, npzBytes), true
+}
+
+func messageIDKey(id string) dedupKey {
+	return sha256.Sum256([]byte("message_id\x00" + id))
+}
+
+// contentKey identifies a message without a message_id by buoy_id/filename
+// and the decoded NPZ bytes, so a re-send of the same file matches even
+// though send_time changes on every publish.
+func contentKey(p *predictionPayload, npzBytes []byte) dedupKey {
+	h := sha256.New()
+	h.Write([]byte(p.BuoyID + "/" + p.Filename + "\x00"))
+	h.Write(npzBytes)
+	var key dedupKey
+	h.Sum(key[:0])
+	return key
+}
+
+// claimJob claims the message of j unless the MQTT handler already did,
+// keyed by its data once preparePrediction has decoded it and by its raw
+// bytes otherwise. It reports false for a duplicate.
+func claimJob(j *predictionJob, msg MQTT.Message) bool {
+	if j.payload.MessageID != "" {
+		// claimed by the handler, which got the same message_id
+		j.key, j.claimed = messageIDKey(j.payload.MessageID), true
+		return true
+	}
+	if j.key == (dedupKey{}) {
+		j.key = sha256.Sum256(msg.Payload())
+	}
+	j.claimed = claimMessage(j.key)
+	return j.claimed
+}
+
+// Upper bound on a decompressed NPZ (--max-decompressed-bytes)
+var maxDecompressedBytes int64 = 256 << 20
+
+// How long a message is remembered for de-dup (--dedup-ttl / DEDUP_TTL)
+var dedupWindow = 5 * time.Minute
+
+// Serialises check-and-mark for the SQLite and Bloom filter back ends
+var dedupClaimMutex sync.Mutex
+
+// Messages claimed but not yet handled, with the SQLite back end; their
+// keys are only persisted once handled (see completeMessage)
+var pendingMessages = make(map[dedupKey]time.Time)
+
 func cleanupOldMessages() {
+	cutoff := time.Now().Add(-dedupWindow)
+	if dedupDB != nil {
+		if _, err := dedupDB.Cleanup(cutoff); err != nil {
+			slog.Error("dedup cleanup failed", "err", err)
+		}
+		// claims of messages lost to a crashed worker or an overflow drop
+		dedupClaimMutex.Lock()
+		for key, ts := range pendingMessages {
+			if ts.Before(cutoff) {
+				delete(pendingMessages, key)
+			}
+		}
+		dedupClaimMutex.Unlock()
+		return
+	}
+	if dedupBloom != nil {
+		dedupBloom.Rotate(time.Now())
+		return
+	}
 	msgMutex.Lock()
 	defer msgMutex.Unlock()
-	cutoff := time.Now().Add(-5 * time.Minute)
-	for key, ts := range processedMessages {
-		if ts.Before(cutoff) {
-			delete(processedMessages, key)
+	for e := processedOrder.Front(); e != nil; e = processedOrder.Front() {
+		entry := e.Value.(dedupEntry)
+		if !entry.ts.Before(cutoff) {
+			break
 		}
+		processedOrder.Remove(e)
+		delete(processedMessages, entry.key)
 	}
 }
 
-func isMessageProcessed(payload string) bool {
-	msgMutex.RLock()
-	defer msgMutex.RUnlock()
-	_, exists := processedMessages[payload]
-	return exists
-}
-
-func markMessageProcessed(payload string) {
+// claimMessage records key and reports whether it was new. Check and insert
+// happen under one lock so concurrent handlers cannot both accept the same
+// message.
+func claimMessage(key dedupKey) bool {
+	if dedupDB != nil {
+		dedupClaimMutex.Lock()
+		defer dedupClaimMutex.Unlock()
+		if _, ok := pendingMessages[key]; ok {
+			return false
+		}
+		seen, err := dedupDB.Seen(hex.EncodeToString(key[:]))
+		if err != nil {
+			slog.Error("dedup lookup failed", "err", err)
+		}
+		if seen {
+			return false
+		}
+		pendingMessages[key] = time.Now()
+		return true
+	}
+	if dedupBloom != nil {
+		dedupClaimMutex.Lock()
+		defer dedupClaimMutex.Unlock()
+		if dedupBloom.Seen(key[:]) {
+			return false
+		}
+		dedupBloom.Add(key[:])
+		return true
+	}
 	msgMutex.Lock()
 	defer msgMutex.Unlock()
-	processedMessages[payload] = time.Now()
+	if e, exists := processedMessages[key]; exists {
+		// keep the entry ordered by last sighting, so repeats stay suppressed
+		e.Value = dedupEntry{key: key, ts: time.Now()}
+		processedOrder.MoveToBack(e)
+		return false
+	}
+	processedMessages[key] = processedOrder.PushBack(dedupEntry{key: key, ts: time.Now()})
+	for payloadHashIndexSize > 0 && processedOrder.Len() > payloadHashIndexSize {
+		oldest := processedOrder.Front()
+		processedOrder.Remove(oldest)
+		delete(processedMessages, oldest.Value.(dedupEntry).key)
+		dedupEvictions.Inc()
+	}
+	return true
+}
+
+// completeMessage persists key once its message has been handled. Keys
+// are not written at claim time, so a message whose prediction was cut
+// short by a restart is processed again when the broker redelivers it.
+func completeMessage(key dedupKey) {
+	if dedupDB == nil {
+		return
+	}
+	dedupClaimMutex.Lock()
+	defer dedupClaimMutex.Unlock()
+	delete(pendingMessages, key)
+	if err := dedupDB.Mark(hex.EncodeToString(key[:]), time.Now()); err != nil {
+		slog.Error("dedup insert failed", "err", err)
+	}
+}
+
+func dedupCacheSize() int {
+	if dedupDB != nil {
+		n, _ := dedupDB.Count()
+		return n
+	}
+	if dedupBloom != nil {
+		return dedupBloom.Len()
+	}
+	msgMutex.RLock()
+	defer msgMutex.RUnlock()
+	return len(processedMessages)
 }
 
 // -------------------------------------------------------------------
 // Connect to local broker and subscribe
 // -------------------------------------------------------------------
-func connectAndSubscribeLocal(clientID, subTopic string, handler MQTT.MessageHandler) (MQTT.Client, error) {
-	for retry := 0; retry < maxRetry; retry++ {
-		fmt.Printf("[MQTT] Connecting to %s (attempt %d/%d)\n", brokerURL, retry+1, maxRetry)
+// openBrokerConn replaces paho's dialer when the connection needs more
+// than it offers: the TLS server name from the SNI routing map, an OCSP
+// check of the broker certificate before MQTT starts, TCP_NODELAY control,
+// link emulation and RTT tracking. A failure fails the connect attempt, which sends us
+// through the normal retry/reconnect path.
+func openBrokerConn(uri *url.URL, options MQTT.ClientOptions) (net.Conn, error) {
+	d := mqttutil.DialOptions{NoDelay: tcpNoDelay, Link: linkSim}
+	if sniMapPath != "" {
+		d.ServerName = func(uri *url.URL) (string, error) {
+			sni, err := snimap.Lookup(uri.String(), sniMapPath)
+			if err != nil {
+				slog.Error("SNI map lookup failed", "broker", uri.String(), "err", err)
+			}
+			return sni, err
+		}
+	}
+	if tlsOCSPCheck {
+		d.VerifyConn = func(conn *tls.Conn) error {
+			err := ocsp.Check(conn)
+			if err != nil {
+				slog.Error("OCSP check failed", "host", uri.Host, "err", err)
+			}
+			return err
+		}
+	}
+	conn, err := mqttutil.Dial(uri, options, d)
+	if err != nil {
+		return nil, err
+	}
+	if brokerLatencyMeasure {
+		return rtttracker.Wrap(conn, recordBrokerRTT), nil
+	}
+	return conn, nil
+}
+
+func connectAndSubscribeLocal(clientID string, subTopics []string, handler MQTT.MessageHandler) (MQTT.Client, error) {
+	retry := mqttutil.Retry{
+		Attempts: maxRetry,
+		Backoff:  reconnectPolicy.New(),
+		OnError: func(attempt int, err error) {
+			slog.Warn("connect attempt failed", "attempt", attempt, "max", maxRetry, "err", err)
+		},
+	}
+	c, err := mqttutil.ConnectWithRetry(retry, func(attempt int) (MQTT.Client, error) {
+		if now := time.Now(); !passWindow.Open(now) {
+			slog.Info("waiting for pass window", "opens_at", passWindow.Next(now))
+			passWindow.Wait()
+		}
+		slog.Info("connecting", "broker", brokerURL, "attempt", attempt, "max", maxRetry)
+		acquireConnSlot()
+		c, err := mqttutil.ConnectAndSubscribeAll(localClientOptions(clientID), subTopics, subscribeQoS, handler, subscribeTimeout)
+		if err != nil {
+			releaseConnSlot()
+			return nil, err
+		}
+		slog.Info("connected and subscribed", "topic", strings.Join(subTopics, ","), "broker", brokerURL)
+		return c, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("local broker unreachable at %s: %w", brokerURL, err)
+	}
+	return c, nil
+}
 
-		uniqueClientID := fmt.Sprintf("%s_%d", clientID, time.Now().UnixNano())
+// localClientOptions builds the options for one connect attempt.
+func localClientOptions(clientID string) *MQTT.ClientOptions {
+	uniqueClientID := fmt.Sprintf("%s_%d", clientID, time.Now().UnixNano())
+	if persistentSession {
+		// the broker keys the stored session on the client ID
+		uniqueClientID = clientID
+	}
 
-		opts := MQTT.NewClientOptions().AddBroker(brokerURL)
-		opts.SetClientID(uniqueClientID)
-		opts.SetKeepAlive(5 * time.Second)
-		opts.SetPingTimeout(3 * time.Second)
-		opts.SetCleanSession(true)
+	opts := brokerSettings.NewClientOptions(brokerURL, uniqueClientID)
+	opts.SetCleanSession(!persistentSession)
+	if tlsOCSPCheck || sniMapPath != "" || brokerLatencyMeasure || !tcpNoDelay || linkSim.Enabled() {
+		opts.SetCustomOpenConnectionFn(openBrokerConn)
+	}
 
-		opts.OnConnectionLost = func(client MQTT.Client, err error) {
-			fmt.Printf("[MQTT] Connection lost: %v\n", err)
-			select {
-			case lostChan <- struct{}{}:
-			default:
-			}
-		}
-		opts.OnReconnecting = func(MQTT.Client, *MQTT.ClientOptions) {
-			fmt.Println("[MQTT] Reconnecting...")
+	opts.OnConnectionLost = func(client MQTT.Client, err error) {
+		slog.Warn("connection lost", "err", err)
+		select {
+		case lostChan <- struct{}{}:
+		default:
 		}
-		opts.OnConnect = func(MQTT.Client) {
-			fmt.Println("[MQTT] Connected (OnConnect)")
+	}
+	opts.OnReconnecting = func(MQTT.Client, *MQTT.ClientOptions) {
+		slog.Info("reconnecting")
+	}
+	if statusTopic != "" {
+		opts.SetWill(statusTopic, string(statusPayload("offline")), 1, true)
+	}
+	opts.OnConnect = func(c MQTT.Client) {
+		slog.Info("connected")
+		if statusTopic != "" {
+			c.Publish(statusTopic, 1, true, statusPayload("online"))
 		}
+		wakeResultFlush()
+	}
+	return opts
+}
 
-		c := MQTT.NewClient(opts)
-		token := c.Connect()
-		if ok := token.Wait() && token.Error() == nil; ok {
-			fmt.Printf("[MQTT] Subscribing to %s\n", subTopic)
-			t2 := c.Subscribe(subTopic, 0, handler)
-			if t2.Wait() && t2.Error() == nil {
-				fmt.Printf("[MQTT] Connected & subscribed to %s via %s\n", subTopic, brokerURL)
-				return c, nil
-			}
-			fmt.Printf("[MQTT] Subscribe failed: %v\n", t2.Error())
-			c.Disconnect(250)
-			break
+// acquireConnSlot waits until fewer than --max-client-connections broker
+// connections are open and claims one; it is a no-op without a limit.
+func acquireConnSlot() {
+	if connSlots == nil {
+		return
+	}
+	for {
+		select {
+		case connSlots <- struct{}{}:
+			activeConnections.Add(1)
+			return
+		default:
+			slog.Warn("at --max-client-connections; waiting", "open", activeConnections.Load())
+			time.Sleep(time.Second)
 		}
-		fmt.Printf("[MQTT] Connect failed (attempt %d/%d): %v\n", retry+1, maxRetry, token.Error())
-		time.Sleep(2 * time.Second)
 	}
-	return nil, fmt.Errorf("local broker unreachable at %s", brokerURL)
 }
 
-func startReconnectLoopLocal(clientID, subTopic string, handler MQTT.MessageHandler, client *MQTT.Client) {
-	go func() {
-		for range lostChan {
-			fmt.Println("[MQTT] Lost connection. Attempting reconnect...")
+// releaseConnSlot frees a slot claimed by acquireConnSlot once its
+// connection is closed or abandoned.
+func releaseConnSlot() {
+	if connSlots == nil {
+		return
+	}
+	select {
+	case <-connSlots:
+		activeConnections.Add(-1)
+	default:
+	}
+}
+
+// startProbe (re)starts the connectivity probe for c, stopping any probe
+// bound to a previous client. A failed probe is treated as a lost connection.
+func startProbe(c MQTT.Client) {
+	if connectProbeInterval <= 0 {
+		return
+	}
+	probeMutex.Lock()
+	defer probeMutex.Unlock()
+	if probeCancel != nil {
+		probeCancel()
+	}
+	var ctx context.Context
+	ctx, probeCancel = context.WithCancel(context.Background())
+	probe.Prober{}.Start(ctx, c, connectProbeInterval, func() {
+		slog.Warn("probe failed; treating connection as lost")
+		select {
+		case lostChan <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// followPassWindow drops the broker connection whenever a pass ends; the
+// reconnect loop then waits for the next pass before connecting again.
+// Results published in between go through the normal retry path.
+func followPassWindow() {
+	passWindow.Run(nil, func(open bool) {
+		if open {
+			return
+		}
+		slog.Info("pass window closed; disconnecting", "next_pass", passWindow.Next(time.Now()))
+		clientMutex.RLock()
+		if globalClient != nil && statusTopic != "" && globalClient.IsConnectionOpen() {
+			globalClient.Publish(statusTopic, 1, true, statusPayload("offline")).WaitTimeout(2 * time.Second)
+		}
+		clientMutex.RUnlock()
+		select {
+		case lostChan <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func startReconnectLoopLocal(clientID string, subTopics []string, handler MQTT.MessageHandler, client *MQTT.Client) {
+	mqttutil.AutoResubscribe(mqttutil.Resubscriber{
+		Lost: lostChan,
+		Connect: func() (MQTT.Client, error) {
+			return connectAndSubscribeLocal(clientID, subTopics, handler)
+		},
+		OnLost: func() {
+			slog.Warn("lost connection, attempting reconnect")
 			clientMutex.Lock()
+			defer clientMutex.Unlock()
 			if *client != nil && (*client).IsConnected() {
 				(*client).Disconnect(250)
 			}
+			if *client != nil {
+				releaseConnSlot()
+			}
+		},
+		OnReconnect: func(newClient MQTT.Client) {
+			clientMutex.Lock()
+			*client = newClient
+			globalClient = newClient
 			clientMutex.Unlock()
-
-			for {
-				newClient, err := connectAndSubscribeLocal(clientID, subTopic, handler)
-				if err != nil {
-					fmt.Println("[MQTT] Reconnect failed; retry in 5s:", err)
-					time.Sleep(5 * time.Second)
-					continue
-				}
-				clientMutex.Lock()
-				*client = newClient
-				globalClient = newClient
-				clientMutex.Unlock()
-				fmt.Println("[MQTT] Reconnected successfully.")
-				break
+			startProbe(newClient)
+			attempt := reconnectCount.Add(1)
+			if reconnectNotifier != nil {
+				reconnectNotifier.Notify("reconnect", attempt)
 			}
-		}
-	}()
+			slog.Info("reconnected")
+		},
+		OnError: func(err error) {
+			slog.Warn("reconnect failed; retrying", "err", err)
+		},
+		Backoff: reconnectPolicy.New(),
+	})
 }
 
 // -------------------------------------------------------------------
 // Worker
 // -------------------------------------------------------------------
-func startWorker() {
+// startWorker runs a worker on queue until ctx is canceled, restarting it
+// with backoff when it panics.
+func startWorker(ctx context.Context, name string, queue chan MQTT.Message) {
+	restartBackoff := backoff.New(workerRestartInitialDelay, workerRestartMaxDelay)
 	go func() {
+		if len(workerCPUs) > 0 {
+			if err := pinCurrentWorker(workerCPUs); err != nil {
+				slog.Warn("CPU affinity not applied", "worker", name, "cpus", workerCPUs, "err", err)
+			} else {
+				slog.Info("pinned to CPUs", "worker", name, "cpus", workerCPUs)
+			}
+		}
+		busy := trackWorker(name)
 		workerID := 0
-		for {
+		for ctx.Err() == nil {
 			workerID++
-			fmt.Printf("[Worker] Starting instance #%d\n", workerID)
+			slog.Info("starting worker instance", "worker", name, "instance", workerID)
 			func() {
 				defer func() {
+					busy.Store(0)
 					if r := recover(); r != nil {
-						fmt.Printf("[Worker #%d] PANIC recovered: %v\n", workerID, r)
+						slog.Error("worker panic recovered", "worker", name, "instance", workerID, "panic", r)
+					}
+					if ctx.Err() != nil {
+						return
 					}
 					select {
 					case workerDone <- struct{}{}:
 					default:
 					}
-					fmt.Printf("[Worker #%d] exited, will restart...\n", workerID)
+					slog.Warn("worker exited, will restart", "worker", name, "instance", workerID)
 				}()
 
-				for msg := range msgChan {
+				for {
+					var msg MQTT.Message
+					var ok bool
 					select {
-					case workerHeartbeat <- struct{}{}:
-					default:
+					case <-ctx.Done():
+						return
+					case msg, ok = <-queue:
 					}
-					handlePrediction(msg)
+					if !ok {
+						return
+					}
+					workerBeats.Store(name, time.Now())
+					// busy while the batch fills, so a shutdown drain never
+					// sees an empty queue and idle workers while it does
+					busy.Store(time.Now().UnixNano())
+					batch := collectBatch(msg, queue)
+					handlePredictions(ctx, batch)
+					busy.Store(0)
+					restartBackoff.Reset()
 				}
 			}()
-			time.Sleep(1 * time.Second)
+			if ctx.Err() != nil {
+				break
+			}
+			delay := restartBackoff.Next()
+			slog.Info("restarting worker", "worker", name, "delay", delay)
+			time.Sleep(delay)
 		}
+		slog.Info("worker stopped", "worker", name)
 	}()
 }
 
-// -------------------------------------------------------------------
-// Main
-// -------------------------------------------------------------------
-func main() {
-	subTopic := getenvDefault("SUB_TOPIC", "buoy_sensors_data")
-	pubTopic := getenvDefault("PUB_TOPIC", "buoy_sensors_data_prediction")
-	saveDir := getenvDefault("SAVE_DIR", "/root/bin/msg_box")
-	clientID := getenvDefault("CLIENT_ID", "marine_satelite")
-
-	fmt.Printf("[Startup] ClientID=%s Broker=%s SUB=%s PUB=%s\n", clientID, brokerURL, subTopic, pubTopic)
-
-	if err := os.MkdirAll(saveDir, 0755); err != nil {
-		fmt.Println("[Startup] mkdir failed:", err)
-		return
-	}
-
-	// periodic dedup cleanup
-	go func() {
-		tk := time.NewTicker(1 * time.Minute)
-		defer tk.Stop()
-		for range tk.C {
-			cleanupOldMessages()
+// workerBeatSummary lists when each worker last picked up a message,
+// e.g. "Worker 1=12:00:01 Worker 2=never".
+func workerBeatSummary() string {
+	var parts []string
+	workerBusy.Range(func(k, _ any) bool {
+		beat := "never"
+		if t, ok := workerBeats.Load(k); ok {
+			beat = t.(time.Time).Format(time.TimeOnly)
 		}
-	}()
+		parts = append(parts, k.(string)+"="+beat)
+		return true
+	})
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
 
-	// simple watchdog
-	go func() {
-		lastExit := time.Now()
-		rest := 0
-		lastBeat := time.Now()
-		for {
-			select {
-			case <-workerDone:
-				rest++
-				lastExit = time.Now()
-			case <-workerHeartbeat:
-				lastBeat = time.Now()
-			case <-time.After(15 * time.Second):
-				fmt.Printf("[Watchdog] alive=%s buf=%d cache=%d lastBeat=%s restarts=%d lastExit=%s\n",
-					time.Now().Format(time.RFC3339Nano),
-					len(msgChan), len(processedMessages),
-					lastBeat.Format(time.RFC3339), rest, lastExit.Format(time.RFC3339))
+// collectBatch adds up to batchSize-1 more messages from queue to first,
+// waiting at most batchWait for them to arrive.
+func collectBatch(first MQTT.Message, queue chan MQTT.Message) []MQTT.Message {
+	batch := []MQTT.Message{first}
+	if batchSize <= 1 {
+		return batch
+	}
+	timer := time.NewTimer(batchWait)
+	defer timer.Stop()
+	for len(batch) < batchSize {
+		select {
+		case msg, ok := <-queue:
+			if !ok {
+				return batch
 			}
+			batch = append(batch, msg)
+		case <-timer.C:
+			return batch
 		}
-	}()
-
-	startWorker()
+	}
+	return batch
+}
 
-	// handler with dedup
-	handler := func(_ MQTT.Client, msg MQTT.Message) {
-		msgID := generateMessageID()
-		payload := string(msg.Payload())
-		fmt.Printf("[Handler #%d] msg on %s, size=%d bytes\n", msgID, msg.Topic(), len(payload))
+// buoyQueue returns the dedicated queue for buoyID (--worker-isolate-buoy),
+// creating it and its worker, which runs until ctx is canceled, on first use.
+func buoyQueue(ctx context.Context, buoyID string) chan MQTT.Message {
+	buoyQueuesMutex.RLock()
+	q, ok := buoyQueues[buoyID]
+	buoyQueuesMutex.RUnlock()
+	if ok {
+		return q
+	}
+	buoyQueuesMutex.Lock()
+	defer buoyQueuesMutex.Unlock()
+	if q, ok := buoyQueues[buoyID]; ok {
+		return q
+	}
+	q = make(chan MQTT.Message, cap(msgChan))
+	buoyQueues[buoyID] = q
+	startWorker(ctx, "Worker "+buoyID, q)
+	return q
+}
 
-		if isMessageProcessed(payload) {
-			fmt.Printf("[Handler #%d] DUP detected, skipping\n", msgID)
-			return
+// messageBuoyID extracts the buoy a message belongs to, from the topic when
+// a metadata pattern is configured and from the JSON payload otherwise.
+func messageBuoyID(msg MQTT.Message) string {
+	if topicPattern != "" {
+		if meta, err := topicParser.Parse(msg.Topic(), topicPattern); err == nil && meta["buoy_id"] != "" {
+			return meta["buoy_id"]
 		}
-		markMessageProcessed(payload)
+	}
+	var p predictionPayload
+	if body, err := openPayload(msg.Payload()); err == nil {
+		_ = decodePayload(body, &p)
+	}
+	return p.BuoyID
+}
 
-		select {
-		case msgChan <- msg:
-			fmt.Printf("[Handler #%d] queued; buf=%d\n", msgID, len(msgChan))
-		default:
-			fmt.Printf("[Handler #%d] buffer full; dropping\n", msgID)
-		}
+// openPayload strips the signature envelope, if any (see verifySignature),
+// decrypts a NaCl-sealed message (--enable-nacl-encryption) and returns
+// plain payloads unchanged.
+func openPayload(payload []byte) ([]byte, error) {
+	payload = signing.Unwrap(payload)
+	if naclPrivate == nil {
+		return payload, nil
 	}
+	return nacl.Decrypt(payload, naclPublisherPublic, naclPrivate)
+}
 
-	// initial connect to local broker
-	var client MQTT.Client
-	c, err := connectAndSubscribeLocal(clientID, subTopic, handler)
+// verifySignature checks a message against payloadSigners and returns the
+// signing device, which is empty if the check failed but only flagged.
+func verifySignature(topic string, payload []byte) (string, error) {
+	_, keyID, err := payloadSigners.Verify(payload)
 	if err != nil {
-		fmt.Println("[MQTT] Initial connect failed:", err)
-		return
+		return "", signatureFailed(topic, keyID, err)
 	}
-	client = c
-	clientMutex.Lock()
-	globalClient = c
-	clientMutex.Unlock()
-	startReconnectLoopLocal(clientID, subTopic, handler, &client)
+	return keyID, nil
+}
 
-	// wait for signals
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
-	fmt.Println("Exiting satellite.")
+// signatureFailed counts and logs a failed signature check. It returns
+// err under the reject policy and nil under the flag policy, where the
+// message is handled as usual.
+func signatureFailed(topic, keyID string, err error) error {
+	signatureFailures.WithLabelValues(signatureFailureReason(err)).Inc()
+	if signaturePolicy == signing.PolicyFlag {
+		slog.Warn("signature check failed; handling message anyway", "topic", topic, "key_id", keyID, "err", err)
+		return nil
+	}
+	slog.Warn("signature check failed; message rejected", "topic", topic, "key_id", keyID, "err", err)
+	return err
+}
 
-	clientMutex.RLock()
-	if globalClient != nil {
-		globalClient.Disconnect(250)
+// signatureFailureReason is the signatureFailures label for err.
+func signatureFailureReason(err error) string {
+	switch err {
+	case signing.ErrUnsigned:
+		return "unsigned"
+	case signing.ErrUnknownKey:
+		return "unknown_key"
+	case signing.ErrBadSignature:
+		return "bad_signature"
 	}
-	clientMutex.RUnlock()
+	return "key_mismatch"
+}
+
+// isSignatureError reports whether err rejected a message for its signature.
+func isSignatureError(err error) bool {
+	return errors.Is(err, signing.ErrUnsigned) || errors.Is(err, signing.ErrUnknownKey) ||
+		errors.Is(err, signing.ErrBadSignature) || errors.Is(err, errSignerMismatch)
 }
 
 // -------------------------------------------------------------------
-// ML prediction + publish
+// Main
 // -------------------------------------------------------------------
-func handlePrediction(msg MQTT.Message) {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Printf("[Worker] PANIC in handlePrediction: %v\n", r)
-		}
+func main() {
+	// the file can supply env-backed defaults, so it is read before the flags
+	fileCfg, err := config.LoadFile(config.FilePath(os.Args[1:], "CONFIG_FILE"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fileCfg.SetEnv()
+	brokerURL = getenvDefault("BROKER_URL", brokerURL)
+
+	flag.DurationVar(&workerRestartInitialDelay, "worker-restart-initial-delay", workerRestartInitialDelay, "Delay before the first worker restart after a crash")
+	flag.DurationVar(&workerRestartMaxDelay, "worker-restart-max-delay", workerRestartMaxDelay, "Upper bound for the exponential worker restart delay")
+	sqliteDedup := flag.Bool("sqlite-dedup", getenvDefault("SQLITE_DEDUP", "") == "true", "Persist de-dup keys in SQLite so they survive restarts (default on with --persistent-session)")
+	flag.IntVar(&payloadHashIndexSize, "payload-hash-index", 0, "Cap the in-memory de-dup index at this many payload hashes, evicting the oldest (0 = no cap)")
+	bloomDedup := flag.Bool("dedup-bloom-filter", false, "De-dup with a Bloom filter instead of the hash index (constant memory, occasional false positives)")
+	bloomItems := flag.Uint("bloom-expected-items", 100000, "Messages per de-dup window the Bloom filter is sized for")
+	bloomFPRate := flag.Float64("bloom-fp-rate", 0.001, "Target Bloom filter false-positive rate")
+	dedupTTL := flag.String("dedup-ttl", getenvDefault("DEDUP_TTL", dedupWindow.String()), "How long a message is remembered for de-dup, e.g. 90s or 10m")
+	dedupDBPath := flag.String("dedup-db-path", "", "SQLite de-dup database path (default <SAVE_DIR>/dedup.db)")
+	flag.Int64Var(&maxQueuedBytes, "max-queued-bytes", maxQueuedBytes, "Treat the queue as full once this many payload bytes are waiting (see --queue-overflow)")
+	archivePath := flag.String("sqlite-archive", getenvDefault("SQLITE_ARCHIVE", ""), "Also write every prediction to this SQLite database for later bulk sync (empty disables)")
+	rawCacheDir := flag.String("predict-output-cache-dir", getenvDefault("PREDICT_OUTPUT_CACHE_DIR", ""), "Keep raw predict.py output under <dir>/<buoy_id>/ for offline reprocessing")
+	schemaFile := flag.String("prediction-schema-file", getenvDefault("PREDICTION_SCHEMA_FILE", ""), "JSON file listing the expected predict.py output columns; mismatching results are discarded")
+	resultDedupWindow := flag.Duration("result-deduplication-window", 0, "Skip publishing a result identical to the one published for the same buoy/file within this window (0 disables)")
+	defaultPredCache, _ := strconv.Atoi(getenvDefault("PREDICTION_CACHE_SIZE", "0"))
+	predCacheSize := flag.Int("prediction-cache-size", defaultPredCache, "Remember the model output for this many distinct NPZ inputs and reuse it when the same data arrives again (0 disables)")
+	predCacheTTL := flag.Duration("prediction-cache-ttl", 10*time.Minute, "How long a cached model output is reused (0: until evicted)")
+	flag.StringVar(&predictionErrorTopic, "prediction-error-topic", getenvDefault("PREDICTION_ERROR_TOPIC", ""), "Publish a JSON report (QoS 1) for every message that fails prediction")
+	flag.StringVar(&resultFormat, "format", getenvDefault("FORMAT", buoypb.FormatJSON), "Message format of published results: json (CSV text) or proto (PredictionResult); payloads are accepted as JSON, protobuf or CBOR")
+	validatePayloads := flag.Bool("payload-validation", getenvDefault("PAYLOAD_VALIDATION", "false") == "true", "Check every payload against a JSON Schema before decoding it and reject those that do not match")
+	payloadSchemaFile := flag.String("payload-schema-file", getenvDefault("PAYLOAD_SCHEMA_FILE", ""), "JSON Schema for --payload-validation (default: the built-in buoy payload schema)")
+	flag.StringVar(&payloadRejectTopic, "payload-reject-topic", getenvDefault("PAYLOAD_REJECT_TOPIC", ""), "Publish a JSON report (QoS 1) for every rejected payload here (default: --prediction-error-topic)")
+	flag.StringVar(&anomalyTopic, "anomaly-flag-topic", getenvDefault("ANOMALY_FLAG_TOPIC", ""), "Publish an alert here when a prediction's anomaly score exceeds the threshold")
+	flag.StringVar(&anomalyField, "anomaly-field", "", "CSV column of the prediction output holding the anomaly score")
+	flag.Float64Var(&anomalyThreshold, "anomaly-threshold", 0, "Alert when the anomaly score is greater than this value")
+	cpuAffinity := flag.String("worker-cpu-affinity", getenvDefault("WORKER_CPU_AFFINITY", ""), "Comma-separated CPU IDs to pin prediction workers to (Linux only)")
+	maxConns := flag.Int("max-client-connections", 0, "Wait before connecting while this many broker connections are open (0 = no limit)")
+	flag.DurationVar(&subscribeTimeout, "subscribe-timeout", subscribeTimeout, "Disconnect and retry if the broker does not acknowledge the subscription in time")
+	flag.BoolVar(&tcpNoDelay, "tcp-no-delay", tcpNoDelay, "Disable Nagle's algorithm on the broker connection (Go's default); false re-enables it")
+	flag.BoolVar(&tcpNoDelay, "tcp-nagle-disabled", tcpNoDelay, "Alias for --tcp-no-delay")
+	linkKbps := flag.Float64("link-bandwidth-kbps", 0, "Emulate a slow link: limit outbound broker traffic (results, status, metrics) to this many kbit/s (0 = unlimited)")
+	flag.IntVar(&linkSim.Burst, "link-burst-bytes", 0, "Bytes the emulated link may send at once before rate limiting (default: 1/10 s worth, at least 1500)")
+	flag.DurationVar(&linkSim.Delay, "link-delay", 0, "One-way delay added to outbound broker traffic (e.g. 600ms for a GEO hop)")
+	flag.DurationVar(&linkSim.Jitter, "link-jitter", 0, "Random +/- variation of --link-delay")
+	passSpec := flag.String("pass-window", getenvDefault("PASS_WINDOW", ""), "Only stay connected to the broker during passes, as <window>/<period> (e.g. 8m/90m; empty: always connected)")
+	passOffset := flag.Duration("pass-window-offset", 0, "Shift the pass schedule; passes otherwise start at multiples of the period since the Unix epoch")
+	flag.BoolVar(&brokerLatencyMeasure, "broker-latency-measure", false, "Measure broker round-trip time from keepalive PINGREQ/PINGRESP")
+	onnxModelPath := flag.String("onnx-model", getenvDefault("ONNX_MODEL", ""), "Run this exported ONNX model in process instead of predict.py (needs a build with -tags onnx)")
+	onnxLib := flag.String("onnx-runtime-lib", getenvDefault("ONNXRUNTIME_LIB", ""), "Path to the onnxruntime shared library (default: the loader's search path)")
+	inferenceSocket := flag.String("inference-socket", getenvDefault("INFERENCE_SOCKET", ""), "Send predictions to a persistent inference server on this Unix socket instead of starting predict.py per message")
+	inferenceServer := flag.Bool("inference-server", getenvDefault("INFERENCE_SERVER", "") == "true", "Start and supervise the inference server on --inference-socket")
+	inferenceHealth := flag.Duration("inference-health-interval", 30*time.Second, "Ping the inference server at this interval; a failed ping restarts a supervised server")
+	defaultInferenceWorkers, _ := strconv.Atoi(getenvDefault("INFERENCE_WORKERS", "0"))
+	inferenceWorkers := flag.Int("inference-workers", defaultInferenceWorkers, "Keep this many predict.py processes running and send them predictions over stdin/stdout instead of starting predict.py per message; they run in parallel with --workers or --batch-size above 1 (0 disables)")
+	defaultBatch, _ := strconv.Atoi(getenvDefault("BATCH_SIZE", "1"))
+	flag.IntVar(&batchSize, "batch-size", defaultBatch, "Predict up to this many queued messages in one model call (1 = one call per message)")
+	flag.DurationVar(&batchWait, "batch-wait", batchWait, "How long a worker waits for more messages to fill a batch")
+	flag.IntVar(&breakerFailures, "inference-breaker-failures", breakerFailures, "Short-circuit predictions to PredictionUnavailable after this many failed or timed-out model calls in a row (0 disables)")
+	flag.DurationVar(&breakerCooldown, "inference-breaker-cooldown", breakerCooldown, "How long predictions stay short-circuited before one is let through to probe recovery")
+	healthAddr := flag.String("health-addr", getenvDefault("HEALTH_ADDR", ""), "Serve /healthz and /readyz on this address (e.g. :8080; empty disables)")
+	flag.DurationVar(&workerStallTimeout, "health-worker-stall", workerStallTimeout, "Report unhealthy when a worker spends longer than this on one message")
+	metricsAddr := flag.String("metrics-addr", getenvDefault("METRICS_ADDR", ""), "Serve Prometheus metrics on this address at /metrics (e.g. :9100; empty disables)")
+	flag.StringVar(&sniMapPath, "broker-sni-routing-map", getenvDefault("BROKER_SNI_ROUTING_MAP", ""), "JSON file mapping broker URLs to the TLS server name to present (re-read on change)")
+	flag.BoolVar(&tlsOCSPCheck, "tls-ocsp-stapling", false, "Verify the broker certificate is not revoked (OCSP) before connecting over TLS")
+	flag.StringVar(&summaryTopic, "data-summary-topic", getenvDefault("DATA_SUMMARY_TOPIC", ""), "Publish periodic aggregate statistics to this topic (e.g. satellite/summary)")
+	summaryInterval := flag.Duration("data-summary-interval", 60*time.Second, "Interval between summary messages")
+	flag.DurationVar(&connectProbeInterval, "connect-probe-interval", 0, "Actively probe the broker connection at this interval (0 = keepalive only)")
+	flag.Int64Var(&predictTimeoutBaseMs, "predict-timeout-base-ms", predictTimeoutBaseMs, "Base timeout for predict.py, in ms")
+	flag.Int64Var(&predictTimeoutPerMBMs, "predict-timeout-per-mb-ms", predictTimeoutPerMBMs, "Extra predict.py timeout per MB of NPZ input, in ms")
+	flag.Int64Var(&predictTimeoutMaxMs, "predict-timeout-max-ms", predictTimeoutMaxMs, "Upper bound for the predict.py timeout, in ms")
+	flag.DurationVar(&brokerSettings.KeepAlive, "keepalive", mqttutil.DefaultKeepAlive, "MQTT keepalive interval")
+	flag.DurationVar(&reconnectPolicy.Initial, "reconnect-initial-delay", reconnectPolicy.Initial, "Wait after a failed broker (re)connect attempt; doubles with every further failure")
+	flag.DurationVar(&reconnectPolicy.Max, "reconnect-max-delay", reconnectPolicy.Max, "Upper bound of the reconnect wait")
+	flag.Float64Var(&reconnectPolicy.Jitter, "reconnect-jitter", reconnectPolicy.Jitter, "Shorten each reconnect wait by a random fraction of up to this (0 to 1), so clients that lost the broker together do not retry in step")
+	flag.DurationVar(&resultPublishTimeout, "publish-timeout", resultPublishTimeout, "How long to wait for a prediction result to be acknowledged")
+	flag.Int64Var(&maxDecompressedBytes, "max-decompressed-bytes", maxDecompressedBytes, "Reject compressed payloads that expand beyond this many bytes")
+	flag.StringVar(&npzTmpDir, "tmp-dir", getenvDefault("TMP_DIR", npzTmpDir), "Directory for decoded NPZ files handed to predict.py")
+	stickyCookie := flag.String("sticky-session-cookie", "", "Cookie name sent on WebSocket upgrades to pin reconnects to one broker backend")
+	reconnectTopic := flag.String("reconnect-notify-topic", getenvDefault("RECONNECT_NOTIFY_TOPIC", ""), "Publish a reconnect event here after every reconnect (e.g. satellite/<id>/events)")
+	defaultWorkers, _ := strconv.Atoi(getenvDefault("WORKERS", "1"))
+	flag.IntVar(&workerCount, "workers", defaultWorkers, "Number of workers predicting from the shared queue; with more than one, a slow prediction no longer blocks other buoys")
+	flag.StringVar(&queueOverflow, "queue-overflow", getenvDefault("QUEUE_OVERFLOW", overflowDropNew), "What to do when the queue or --max-queued-bytes is full: drop-new, drop-oldest, block or spill")
+	flag.DurationVar(&queueBlockTimeout, "queue-block-timeout", queueBlockTimeout, "With --queue-overflow block, drop the message after waiting this long for room")
+	spillPath := flag.String("queue-spill-dir", getenvDefault("QUEUE_SPILL_DIR", "/tmp/mqtt_spill"), "With --queue-overflow spill, where messages wait for room in the queue")
+	spillMaxBytes := flag.Int64("queue-spill-max-bytes", 1<<30, "Drop new messages once the spill directory holds this many bytes (0 = no limit)")
+	flag.BoolVar(&isolateBuoys, "worker-isolate-buoy", false, "Give every buoy its own queue and worker so a slow prediction only delays that buoy")
+	flag.StringVar(&nodeID, "node-id", getenvDefault("NODE_ID", ""), "Node identifier stamped on logs and results (default: hostname)")
+	flag.StringVar(&modelVersion, "predict-model-version", getenvDefault("MODEL_VERSION", ""), "Model version stamped on each result as the Model-Version column")
+	metricsTopic := flag.String("publish-metrics-topic", getenvDefault("PUBLISH_METRICS_TOPIC", ""), "Publish cumulative worker metrics as JSON to this topic")
+	metricsInterval := flag.Duration("metrics-publish-interval", 30*time.Second, "Interval between metrics messages")
+	advertise := flag.Bool("advertise-capability", false, "Publish a retained capabilities document to satellite/<CLIENT_ID>/capabilities at startup")
+	coapAddr := flag.String("coap-addr", getenvDefault("COAP_ADDR", ""), "Also accept buoy payloads as CoAP POSTs on this UDP address (e.g. :5683; empty disables); the URI path is used as the topic")
+	httpIngestAddr := flag.String("http-ingest-addr", getenvDefault("HTTP_INGEST_ADDR", ""), "Also accept buoy payloads as HTTP POSTs to /ingest[/<topic>] on this address, for buoys that cannot reach the broker (e.g. :8443; empty disables)")
+	httpIngestToken := flag.String("http-ingest-token", getenvDefault("HTTP_INGEST_TOKEN", ""), "Bearer token HTTP ingest clients must send (empty allows anyone)")
+	httpIngestCert := flag.String("http-ingest-tls-cert", getenvDefault("HTTP_INGEST_TLS_CERT", ""), "Serve HTTP ingest over TLS with this certificate (PEM)")
+	httpIngestKey := flag.String("http-ingest-tls-key", getenvDefault("HTTP_INGEST_TLS_KEY", ""), "Private key (PEM) for --http-ingest-tls-cert")
+	flag.StringVar(&topicPattern, "topic-metadata-pattern", getenvDefault("TOPIC_METADATA_PATTERN", ""), "Read buoy metadata from the topic, e.g. sensors/{region}/{buoy_id}/npz (overrides SUB_TOPIC)")
+	routeSpec := flag.String("routes", getenvDefault("ROUTES", ""), "Subscribe to several topic filters and route each to predict, archive (store the raw payload) or drop, as comma-separated filter=action entries, first match wins (in a config file, a list under routes:); replaces SUB_TOPIC")
+	flag.StringVar(&routeArchiveDir, "route-archive-dir", getenvDefault("ROUTE_ARCHIVE_DIR", ""), "Where archive routes store raw payloads, by topic (default: SAVE_DIR/archive)")
+	flag.Float64Var(&logSampler.Rate, "message-sampling-rate", 1, "Fraction (0.0-1.0) of messages that get detailed per-message logs; errors are always logged")
+	npzHistogram := flag.Bool("npz-size-histogram", false, "Track received NPZ sizes; printed by the watchdog and exported as mqtt_npz_size_bytes")
+	naclEnabled := flag.Bool("enable-nacl-encryption", false, "Expect payloads sealed with NaCl box by the publisher")
+	publisherPubFile := flag.String("publisher-pubkey-file", getenvDefault("PUBLISHER_PUBKEY_FILE", ""), "Publisher public key file (base64)")
+	satellitePrivFile := flag.String("satellite-privkey-file", getenvDefault("SATELLITE_PRIVKEY_FILE", "satellite.key"), "Satellite private key file; a key pair is generated here (public half in <file>.pub) if missing")
+	pskInline := flag.String("psk-keys", getenvDefault("PSK_KEYS", ""), "Pre-shared AES-256 keys as comma-separated id:base64key entries, for payloads whose data the publisher sealed with -psk-keys")
+	pskFile := flag.String("psk-keys-file", getenvDefault("PSK_KEYS_FILE", ""), "File of pre-shared keys, one id:base64key per line")
+	verifyKeysDir := flag.String("verify-keys-dir", getenvDefault("VERIFY_KEYS_DIR", ""), "Check every payload's Ed25519 signature against the device public keys here (<buoy>.pub or <buoy>.key.pub)")
+	flag.StringVar(&signaturePolicy, "signature-policy", getenvDefault("SIGNATURE_POLICY", signing.PolicyReject), "With --verify-keys-dir, what to do with unsigned, forged or altered payloads: reject (report to --payload-reject-topic) or flag (log, count and predict anyway)")
+	signingKeyFile := flag.String("signing-key-file", getenvDefault("SIGNING_KEY_FILE", ""), "Sign prediction results with this Ed25519 key, generated (with <file>.pub for subscribers) if missing")
+	ntpServer := flag.String("ntp-server", getenvDefault("NTP_SERVER", ""), "Measure the clock offset to this NTP server and use it to correct latencies; the offset is recorded in each result (Clock-Offset-ms). Point publishers and subscribers at the same server")
+	ntpInterval := flag.Duration("ntp-interval", 5*time.Minute, "How often to re-measure the --ntp-server offset")
+	defaultQoS, _ := strconv.Atoi(getenvDefault("MQTT_QOS", "0"))
+	subQoS := flag.Int("qos", defaultQoS, "QoS (0, 1 or 2) of the input subscription")
+	resultQoSDefault, _ := strconv.Atoi(getenvDefault("RESULT_QOS", "1"))
+	pubQoS := flag.Int("result-qos", resultQoSDefault, "QoS (0, 1 or 2) of published prediction results; 0 cannot detect lost results")
+	flag.IntVar(&resultPublishRetries, "result-publish-retries", resultPublishRetries, "Retry an unacknowledged result publish this many times, with backoff, before giving up")
+	storeAndForward := flag.Bool("result-store-and-forward", getenvDefault("RESULT_STORE_AND_FORWARD", "true") == "true", "Store results that cannot be published in <SAVE_DIR>/results_outbox and deliver them in order once the broker is back (false: give up after --result-publish-retries)")
+	resultOutboxMax := flag.Int64("result-outbox-max-bytes", 0, "Drop the oldest stored results once the result outbox holds this many bytes (0 = no limit)")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "On SIGTERM/SIGINT, how long to wait for queued messages to be predicted and results acknowledged before disconnecting (a second signal exits at once)")
+	resultMaxInflight := flag.Int("result-max-inflight", cap(resultInflight), "Result publishes that may wait for acknowledgement at once; workers wait when it is full")
+	flag.BoolVar(&persistentSession, "persistent-session", getenvDefault("PERSISTENT_SESSION", "") == "true", "Keep a durable broker session under the plain client ID so messages sent while the satellite restarts are delivered afterwards (needs --qos 1 or 2)")
+	statusBase := flag.String("status-topic", getenvDefault("STATUS_TOPIC", "satellite/status"), "Availability topic; <topic>/<node_id> holds a retained online/offline message with offline as the LWT (empty disables)")
+	var brokerCreds mqttutil.Credentials
+	flag.StringVar(&brokerCreds.Username, "mqtt-username", getenvDefault("MQTT_USERNAME", ""), "Broker username (default: anonymous)")
+	flag.StringVar(&brokerCreds.Password, "mqtt-password", getenvDefault("MQTT_PASSWORD", ""), "Broker password")
+	flag.StringVar(&brokerCreds.File, "mqtt-credentials-file", getenvDefault("MQTT_CREDENTIALS_FILE", ""), "File with the broker username on the first line and password on the second")
+	var tlsFiles mqttutil.TLSFiles
+	flag.StringVar(&tlsFiles.CAFile, "tls-ca-cert", getenvDefault("MQTT_TLS_CA_CERT", ""), "CA certificate (PEM) for ssl:// and wss:// brokers (default: system roots)")
+	flag.StringVar(&tlsFiles.CertFile, "tls-client-cert", getenvDefault("MQTT_TLS_CLIENT_CERT", ""), "Client certificate (PEM) for mutual TLS")
+	flag.StringVar(&tlsFiles.KeyFile, "tls-client-key", getenvDefault("MQTT_TLS_CLIENT_KEY", ""), "Client private key (PEM) for mutual TLS")
+	flag.BoolVar(&tlsFiles.InsecureSkipVerify, "tls-insecure-skip-verify", getenvDefault("MQTT_TLS_INSECURE", "") == "true", "Do not verify the broker certificate (testing only)")
+	logLevel := flag.String("log-level", getenvDefault("LOG_LEVEL", "info"), "Minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", getenvDefault("LOG_FORMAT", "text"), "Log output: text or json (one object per line)")
+	var exportConfig bool
+	flag.BoolVar(&exportConfig, "export-config", false, "Print the effective configuration as JSON (secrets redacted) and exit")
+	flag.BoolVar(&exportConfig, "C", false, "Shorthand for --export-config")
+	flag.String("config", getenvDefault("CONFIG_FILE", ""), "YAML or TOML file with settings; keys are flag names, UPPER_CASE keys set env variables")
+	flag.Parse()
+	if err := fileCfg.Apply(flag.CommandLine); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if err := logging.Setup(os.Stdout, *logLevel, *logFormat); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := reconnectPolicy.Validate(); err != nil {
+		slog.Error("invalid --reconnect-* settings", "err", err)
+		return
+	}
+	if passWindow, err = passwindow.Parse(*passSpec); err != nil {
+		slog.Error("invalid --pass-window", "err", err)
+		return
+	}
+	passWindow.Offset = *passOffset
+	if passWindow.Enabled() {
+		now := time.Now()
+		slog.Info("pass window schedule", "window", passWindow.Window, "period", passWindow.Period, "open", passWindow.Open(now), "next_change", passWindow.Next(now))
+	}
+	linkSim.Rate = *linkKbps * 1000 / 8
+	if linkSim.Enabled() {
+		slog.Info("emulating slow link", "kbps", *linkKbps, "delay", linkSim.Delay, "jitter", linkSim.Jitter)
+	}
+
+	for name, q := range map[string]int{"qos": *subQoS, "result-qos": *pubQoS} {
+		if q < 0 || q > 2 {
+			slog.Error("invalid QoS (want 0, 1 or 2)", "flag", name, "value", q)
+			return
+		}
+	}
+	subscribeQoS, resultQoS = byte(*subQoS), byte(*pubQoS)
+	if *resultMaxInflight < 1 {
+		slog.Error("--result-max-inflight must be at least 1", "value", *resultMaxInflight)
+		return
+	}
+	resultInflight = make(chan struct{}, *resultMaxInflight)
+	if persistentSession && subscribeQoS == 0 {
+		slog.Warn("--persistent-session with --qos 0; the broker does not queue QoS 0 messages for offline clients")
+	}
+	if persistentSession && !*sqliteDedup && !*bloomDedup {
+		explicit := false
+		flag.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "sqlite-dedup" })
+		if !explicit {
+			// redelivered messages must not be predicted twice after a restart
+			*sqliteDedup = true
+		}
+	}
+
+	ttl, err := time.ParseDuration(*dedupTTL)
+	if err != nil || ttl <= 0 {
+		slog.Error("invalid --dedup-ttl", "value", *dedupTTL)
+		return
+	}
+	dedupWindow = ttl
+
+	for _, f := range strings.Split(*cpuAffinity, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		cpu, err := strconv.Atoi(f)
+		if err != nil || cpu < 0 {
+			slog.Error("invalid --worker-cpu-affinity entry", "value", f)
+			return
+		}
+		workerCPUs = append(workerCPUs, cpu)
+	}
+
+	if *naclEnabled {
+		peer, err := nacl.LoadKey(*publisherPubFile)
+		if err != nil {
+			slog.Error("load publisher public key failed", "err", err)
+			return
+		}
+		_, priv, created, err := nacl.LoadOrGenerate(*satellitePrivFile)
+		if err != nil {
+			slog.Error("load satellite key failed", "err", err)
+			return
+		}
+		if created {
+			slog.Info("generated satellite key pair; share the .pub file with publishers", "key", *satellitePrivFile, "public_key", *satellitePrivFile+".pub")
+		}
+		naclPublisherPublic, naclPrivate = peer, priv
+	}
+
+	if *pskInline != "" || *pskFile != "" {
+		keys, err := psk.Load(*pskInline, *pskFile)
+		if err != nil {
+			slog.Error("load pre-shared keys failed", "err", err)
+			return
+		}
+		pskKeys = keys
+		slog.Info("pre-shared keys loaded", "keys", keys.IDs())
+	}
+
+	if *verifyKeysDir != "" {
+		if !signing.ValidPolicy(signaturePolicy) {
+			slog.Error("invalid --signature-policy (want reject or flag)", "value", signaturePolicy)
+			return
+		}
+		keys, err := signing.LoadKeyDir(*verifyKeysDir)
+		if err != nil {
+			slog.Error("load device public keys failed", "err", err)
+			return
+		}
+		if len(keys) == 0 {
+			slog.Warn("no device public keys found; every payload will fail the signature check", "dir", *verifyKeysDir)
+		}
+		payloadSigners = keys
+		slog.Info("verifying payload signatures", "devices", len(keys), "policy", signaturePolicy)
+	}
+
+	if *ntpServer != "" {
+		refClock = &clocksync.Clock{Server: *ntpServer, Interval: *ntpInterval}
+		enableClockOffsetGauge()
+		report := func(offset, rtt time.Duration, err error) {
+			if err != nil {
+				slog.Warn("clock offset probe failed", "server", *ntpServer, "err", err)
+				return
+			}
+			slog.Info("clock offset measured", "server", *ntpServer, "offset", offset, "rtt", rtt)
+		}
+		refClock.Start(report)
+	}
+
+	if *resultDedupWindow > 0 {
+		recentResults = resultcache.NewRecentResultsCache(100, *resultDedupWindow)
+	}
+	if *predCacheSize > 0 {
+		predictionCache = resultcache.NewPredictionCache(*predCacheSize, *predCacheTTL)
+	}
+
+	if *maxConns > 0 {
+		connSlots = make(chan struct{}, *maxConns)
+	}
+
+	if *npzHistogram {
+		enableNPZSizeHistogram()
+	}
+
+	if *schemaFile != "" {
+		schema, err := predictout.LoadSchema(*schemaFile)
+		if err != nil {
+			slog.Error("prediction schema load failed", "err", err)
+			return
+		}
+		predictionSchema = schema
+	}
+	if !buoypb.ValidResultFormat(resultFormat) {
+		slog.Error("invalid --format (want json or proto)", "value", resultFormat)
+		return
+	}
+	if *validatePayloads {
+		v, err := payloadschema.Load(*payloadSchemaFile)
+		if err != nil {
+			slog.Error("payload schema load failed", "err", err)
+			return
+		}
+		payloadValidator = v
+	}
+
+	if *rawCacheDir != "" {
+		rawCache = &rawcache.Store{Dir: *rawCacheDir}
+	}
+
+	if *stickyCookie != "" {
+		brokerSettings.Headers = sticky.Header(*stickyCookie, sticky.NewValue())
+	}
+	if brokerSettings.TLS, err = mqttutil.LoadTLSConfig(tlsFiles); err != nil {
+		slog.Error("invalid TLS settings", "err", err)
+		return
+	}
+	if brokerSettings.Auth, err = brokerCreds.Load(); err != nil {
+		slog.Error("invalid broker credentials", "err", err)
+		return
+	}
+
+	subTopic := getenvDefault("SUB_TOPIC", "buoy_sensors_data")
+	if topicPattern == "" && strings.Contains(subTopic, "{") {
+		// a template such as buoy/{buoy_id}/observations: subscribe to
+		// buoy/+/observations and take the buoy ID from each topic
+		topicPattern = subTopic
+	}
+	if topicPattern != "" {
+		wildcard, err := topicParser.Wildcard(topicPattern)
+		if err != nil {
+			slog.Error("invalid topic pattern", "err", err)
+			return
+		}
+		subTopic = wildcard
+	}
+	subTopics := []string{subTopic}
+	if routes, err = topicroute.Parse(*routeSpec); err != nil {
+		slog.Error("invalid --routes", "err", err)
+		return
+	}
+	if routes != nil {
+		subTopics = routes.Filters()
+	}
+	pubTopic := getenvDefault("PUB_TOPIC", "buoy_sensors_data_prediction")
+	resultTopic = pubTopic
+	saveDir := getenvDefault("SAVE_DIR", "/root/bin/msg_box")
+	if routeArchiveDir == "" {
+		routeArchiveDir = filepath.Join(saveDir, "archive")
+	}
+	clientID := getenvDefault("CLIENT_ID", "marine_satelite")
+
+	initNodeID(clientID)
+	if *statusBase != "" {
+		statusTopic = strings.TrimSuffix(*statusBase, "/") + "/" + nodeID
+	}
+	if *signingKeyFile != "" {
+		key, created, err := signing.LoadOrGenerate(*signingKeyFile)
+		if err != nil {
+			slog.Error("load signing key failed", "err", err)
+			return
+		}
+		if created {
+			slog.Info("generated signing key; give the .pub file to subscribers as <node-id>.pub", "key", *signingKeyFile, "public_key", *signingKeyFile+".pub")
+		}
+		// results are signed as the node, so subscribers know which satellite sent them
+		resultSigner = &signing.Signer{KeyID: nodeID, Key: key}
+	}
+
+	if exportConfig {
+		cfg := config.FromFlags(flag.CommandLine)
+		cfg["node-id"] = nodeID
+		cfg["BROKER_URL"] = brokerURL
+		cfg["SUB_TOPIC"] = subTopic
+		cfg["PUB_TOPIC"] = pubTopic
+		cfg["SAVE_DIR"] = saveDir
+		cfg["CLIENT_ID"] = clientID
+		if err := config.Export(cfg); err != nil {
+			slog.Error("export config failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	slog.Info("starting", "model_version", modelVersion, "client_id", clientID, "broker", brokerURL, "sub_topic", strings.Join(subTopics, ","), "pub_topic", pubTopic)
+
+	if err := os.MkdirAll(saveDir, 0755); err != nil {
+		slog.Error("mkdir failed", "err", err)
+		return
+	}
+
+	// canceled at shutdown to stop the workers, predictions and result
+	// publishes still running
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *storeAndForward {
+		dir := filepath.Join(saveDir, "results_outbox")
+		d, err := outbox.Open(dir)
+		if err != nil {
+			slog.Error("open result outbox failed", "dir", dir, "err", err)
+			return
+		}
+		d.SetLimits(outbox.Limits{
+			MaxBytes: *resultOutboxMax,
+			Policy:   outbox.DropOldest,
+			OnEvict: func(name string) {
+				resultsLost.Inc()
+				slog.Warn("result outbox full; dropped oldest result", "entry", name)
+			},
+		})
+		resultOutbox = d
+		if n := d.Len(); n > 0 {
+			slog.Info("results stored by a previous run are waiting", "count", n, "dir", dir)
+		}
+		go flushResults(ctx)
+	}
+
+	if *sqliteDedup {
+		path := *dedupDBPath
+		if path == "" {
+			path = filepath.Join(saveDir, "dedup.db")
+		}
+		db, err := dedupdb.Open(path)
+		if err != nil {
+			slog.Error("dedup db open failed", "err", err)
+			return
+		}
+		defer db.Close()
+		dedupDB = db
+		slog.Info("SQLite de-dup enabled", "path", path)
+	}
+	if *archivePath != "" {
+		db, err := resultdb.Open(*archivePath)
+		if err != nil {
+			slog.Error("result archive open failed", "err", err)
+			return
+		}
+		defer db.Close()
+		resultArchive = db
+		slog.Info("SQLite result archive enabled", "path", *archivePath)
+	}
+
+	if *bloomDedup {
+		if dedupDB != nil {
+			slog.Error("--dedup-bloom-filter and --sqlite-dedup are mutually exclusive")
+			return
+		}
+		if *bloomFPRate <= 0 || *bloomFPRate >= 1 || *bloomItems == 0 {
+			slog.Error("invalid Bloom filter settings: need bloom-expected-items > 0 and 0 < bloom-fp-rate < 1")
+			return
+		}
+		dedupBloom = bloomdedup.New(*bloomItems, *bloomFPRate, dedupWindow)
+		slog.Info("Bloom filter de-dup enabled", "expected_items", *bloomItems, "fp_rate", *bloomFPRate)
+	}
+
+	// periodic dedup cleanup
+	go func() {
+		tk := time.NewTicker(1 * time.Minute)
+		defer tk.Stop()
+		for range tk.C {
+			cleanupOldMessages()
+		}
 	}()
 
-	recvTime := time.Now().UnixNano() / 1e6
-	type Payload struct {
-		BuoyID   string  `json:"buoy_id"`
-		Filename string  `json:"filename"`
-		Data     string  `json:"data"`
-		SendTime float64 `json:"send_time"`
+	// simple watchdog
+	go func() {
+		lastExit := time.Now()
+		rest := 0
+		for {
+			select {
+			case <-workerDone:
+				rest++
+				lastExit = time.Now()
+			case <-time.After(15 * time.Second):
+				slog.Info("watchdog",
+					"buf", queueDepth(), "bytes", queuedBytes.Load(), "dropped", droppedMessages.Load(),
+					"cache", dedupCacheSize(), "last_beat", workerBeatSummary(),
+					"restarts", rest, "last_exit", lastExit.Format(time.RFC3339))
+				if npzSizes != nil {
+					slog.Info("watchdog npz sizes", "histogram", npzSizes.String())
+				}
+			}
+		}
+	}()
+
+	if *onnxModelPath != "" {
+		if newONNXSession == nil {
+			slog.Error("--onnx-model needs a satellite built with -tags onnx")
+			return
+		}
+		if *inferenceSocket != "" || *inferenceWorkers > 0 {
+			slog.Error("--onnx-model, --inference-socket and --inference-workers are mutually exclusive")
+			return
+		}
+		m, err := newONNXSession(*onnxModelPath, *onnxLib)
+		if err != nil {
+			slog.Error("load ONNX model failed", "err", err)
+			return
+		}
+		onnxModel = m
+		defer onnxModel.Close()
+		slog.Info("ONNX model loaded", "model", *onnxModelPath)
+	}
+	if *inferenceSocket != "" && *inferenceWorkers > 0 {
+		slog.Error("--inference-socket and --inference-workers are mutually exclusive")
+		return
+	}
+	if *inferenceWorkers > 0 {
+		inferencePool = &inference.Pool{
+			Command: []string{pythonBin, predictScript, "--worker"},
+			Env:     pythonEnv,
+			Size:    *inferenceWorkers,
+		}
+		inferencePool.Start()
+		defer inferencePool.Close()
+	}
+	if *inferenceSocket != "" {
+		inferenceClient = &inference.Client{Socket: *inferenceSocket, Env: pythonEnv}
+		if *inferenceServer {
+			inferenceClient.Command = []string{pythonBin, inferenceServerScript, "--socket", *inferenceSocket, "--script", predictScript}
+		}
+		inferenceClient.Start()
+		inferenceClient.HealthCheck(*inferenceHealth, 5*time.Second)
+		defer inferenceClient.Close()
+	} else if *inferenceServer {
+		slog.Error("--inference-server needs --inference-socket")
+		return
+	}
+	if breakerFailures > 0 && onnxModel == nil {
+		startInferenceBreaker()
+	}
+
+	if err := validOverflowPolicy(queueOverflow); err != nil {
+		slog.Error("invalid --queue-overflow", "err", err)
+		return
+	}
+	if queueOverflow == overflowSpill {
+		d, err := outbox.Open(*spillPath)
+		if err != nil {
+			slog.Error("open spill dir failed", "dir", *spillPath, "err", err)
+			return
+		}
+		d.SetLimits(outbox.Limits{MaxBytes: *spillMaxBytes, Policy: outbox.RejectNew})
+		spillDir = d
+		if n := d.Len(); n > 0 {
+			slog.Info("draining messages spilled by a previous run", "count", n, "dir", *spillPath)
+		}
+		go drainSpill(ctx)
 	}
-	var payload Payload
-	if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
-		fmt.Printf("[Worker] JSON error: %v\n", err)
+	if statusTopic != "" {
+		go reportDrops(15 * time.Second)
+	}
+
+	if workerCount < 1 {
+		slog.Error("--workers must be at least 1", "workers", workerCount)
 		return
 	}
+	for i := 1; i <= workerCount; i++ {
+		name := "Worker"
+		if workerCount > 1 {
+			name = fmt.Sprintf("Worker %d", i)
+		}
+		startWorker(ctx, name, msgChan)
+	}
+
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+	if *healthAddr != "" {
+		go serveHealth(*healthAddr)
+	}
 
-	npzBytes, err := base64.StdEncoding.DecodeString(payload.Data)
+	if *metricsTopic != "" {
+		go func() {
+			tk := time.NewTicker(*metricsInterval)
+			defer tk.Stop()
+			for range tk.C {
+				body, err := metricsPayload()
+				if err != nil {
+					continue
+				}
+				clientMutex.RLock()
+				c := globalClient
+				clientMutex.RUnlock()
+				if c == nil || !c.IsConnected() {
+					continue
+				}
+				c.Publish(*metricsTopic, 0, false, body)
+			}
+		}()
+	}
+
+	if summaryTopic != "" {
+		go func() {
+			tk := time.NewTicker(*summaryInterval)
+			defer tk.Stop()
+			for range tk.C {
+				msg := summary.Summary()
+				msg.NodeID = nodeID
+				body, err := json.Marshal(msg)
+				if err != nil {
+					continue
+				}
+				clientMutex.RLock()
+				c := globalClient
+				clientMutex.RUnlock()
+				if c == nil || !c.IsConnected() {
+					slog.Warn("client not connected; skip summary publish")
+					continue
+				}
+				c.Publish(summaryTopic, 0, false, body)
+			}
+		}()
+	}
+
+	// ingest de-dups and queues a message from the broker or the CoAP
+	// ingress; it fails only when the message was dropped
+	ingest := func(msg MQTT.Message) error {
+		msgID := generateMessageID()
+		messagesReceived.Inc()
+		verbose := logSampler.ShouldLog()
+		if verbose {
+			slog.Info("message received", "msg_id", msgID, "topic", msg.Topic(), "size", len(msg.Payload()))
+		}
+		action := routeAction(msg)
+		routedMessages.WithLabelValues(action).Inc()
+		if action == topicroute.Drop {
+			if verbose {
+				slog.Info("dropped by route", "msg_id", msgID, "topic", msg.Topic())
+			}
+			return nil
+		}
+
+		// only a message_id is cheap to key here; other messages are claimed
+		// by the worker, except archived ones, which never reach it
+		key, ok := messageKey(msg.Payload(), action == topicroute.Archive)
+		if ok && !claimMessage(key) {
+			dedupHits.Inc()
+			if verbose {
+				slog.Info("duplicate, skipping", "msg_id", msgID)
+			}
+			return nil
+		}
+		if action == topicroute.Archive {
+			path, err := archiveMessage(msgID, msg)
+			if err != nil {
+				slog.Error("archive message failed", "msg_id", msgID, "topic", msg.Topic(), "err", err)
+				return err
+			}
+			if verbose {
+				slog.Info("archived", "msg_id", msgID, "path", path)
+			}
+			return nil
+		}
+
+		queue := msgChan
+		if isolateBuoys {
+			queue = buoyQueue(ctx, messageBuoyID(msg))
+		}
+		if err := enqueue(queue, msg); err != nil {
+			droppedMessages.Add(1)
+			slog.Warn("queue full; dropping", "msg_id", msgID, "policy", queueOverflow,
+				"buf", len(queue), "bytes", queuedBytes.Load(), "limit", maxQueuedBytes, "err", err)
+			return err
+		}
+		if verbose {
+			slog.Info("queued", "msg_id", msgID, "buf", len(queue), "bytes", queuedBytes.Load())
+		}
+		return nil
+	}
+	handler := func(_ MQTT.Client, msg MQTT.Message) {
+		ingest(msg)
+	}
+	// the other ingress paths stop taking messages once shutdown begins
+	ingestExternal := func(msg MQTT.Message) error {
+		if shuttingDown.Load() {
+			return errShuttingDown
+		}
+		return ingest(msg)
+	}
+	if *coapAddr != "" {
+		go serveCoAP(*coapAddr, ingestExternal)
+	}
+	if *httpIngestAddr != "" {
+		if (*httpIngestCert == "") != (*httpIngestKey == "") {
+			slog.Error("--http-ingest-tls-cert and --http-ingest-tls-key go together")
+			return
+		}
+		h := &httpIngest{token: *httpIngestToken, maxBody: maxQueuedBytes, ingest: ingestExternal}
+		if topicPattern == "" {
+			h.defaultTopic = subTopic
+		}
+		go serveHTTPIngest(*httpIngestAddr, *httpIngestCert, *httpIngestKey, h)
+	}
+
+	if *reconnectTopic != "" {
+		reconnectNotifier = &eventhook.MQTTNotifier{
+			Topic:       *reconnectTopic,
+			SatelliteID: clientID,
+			Client: func() MQTT.Client {
+				clientMutex.RLock()
+				defer clientMutex.RUnlock()
+				return globalClient
+			},
+		}
+	}
+
+	// initial connect to local broker
+	var client MQTT.Client
+	c, err := connectAndSubscribeLocal(clientID, subTopics, handler)
 	if err != nil {
-		fmt.Printf("[Worker] b64 error: %v\n", err)
+		slog.Error("initial connect failed", "err", err)
 		return
 	}
+	client = c
+	clientMutex.Lock()
+	globalClient = c
+	clientMutex.Unlock()
+	startReconnectLoopLocal(clientID, subTopics, handler, &client)
+	startProbe(c)
+	go followPassWindow()
 
-	tmpDir := "/tmp/mqtt_npz"
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
-		fmt.Printf("[Worker] MkdirAll error: %v\n", err)
+	if *advertise {
+		capability.MaxPayloadBytes = maxQueuedBytes
+		capTopic := fmt.Sprintf("satellite/%s/capabilities", clientID)
+		enabled := enabledFeatures()
+		if err := capability.Announce(c, capTopic, enabled); err != nil {
+			slog.Error("capability announce failed", "err", err)
+		} else {
+			slog.Info("capabilities announced", "topic", capTopic, "features", enabled)
+		}
+	}
+
+	// wait for signals
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	s := <-sig
+	slog.Info("shutting down", "signal", s)
+	go func() {
+		<-sig
+		slog.Warn("second signal; exiting without draining")
+		os.Exit(1)
+	}()
+	shutdown(cancel, subTopics)
+	slog.Info("exiting")
+}
+
+// -------------------------------------------------------------------
+// ML prediction + publish
+// -------------------------------------------------------------------
+// predictionPayload is the message a publisher sends, as JSON, as a
+// protobuf Observation or as CBOR.
+type predictionPayload struct {
+	BuoyID      string  `json:"buoy_id"`
+	Filename    string  `json:"filename"`
+	Data        string  `json:"data"` // base64, in JSON payloads
+	Compression string  `json:"compression"`
+	SendTime    float64 `json:"send_time"`
+	MessageID   string  `json:"message_id"`
+	Encryption  string  `json:"encryption"`
+	KeyID       string  `json:"key_id"`
+
+	binary []byte // the data of a protobuf or CBOR payload
+}
+
+// decodePayload parses a decrypted payload in any of the formats, telling
+// them apart by the first byte.
+func decodePayload(body []byte, p *predictionPayload) error {
+	if buoypb.IsJSON(body) {
+		return json.Unmarshal(body, p)
+	}
+	var o buoypb.Observation
+	if buoypb.IsCBOR(body) {
+		if err := o.UnmarshalCBOR(body); err != nil {
+			return fmt.Errorf("cbor: %w", err)
+		}
+	} else if err := o.Unmarshal(body); err != nil {
+		return fmt.Errorf("protobuf: %w", err)
+	}
+	*p = predictionPayload{
+		BuoyID:      o.BuoyID,
+		Filename:    o.Filename,
+		Compression: o.Compression,
+		SendTime:    o.SendTime,
+		MessageID:   o.MessageID,
+		Encryption:  o.Encryption,
+		KeyID:       o.KeyID,
+		binary:      o.Data,
+	}
+	return nil
+}
+
+// schemaDoc is a binary payload as the JSON document the payload schema
+// describes. Binary data has no base64 form to check, so a valid stand-in
+// keeps the rest of the schema in force.
+func (p *predictionPayload) schemaDoc() map[string]any {
+	doc := map[string]any{
+		"buoy_id":     p.BuoyID,
+		"filename":    p.Filename,
+		"compression": p.Compression,
+		"send_time":   p.SendTime,
+		"message_id":  p.MessageID,
+	}
+	if p.Encryption != "" {
+		doc["encryption"] = p.Encryption
+	}
+	if p.KeyID != "" {
+		doc["key_id"] = p.KeyID
+	}
+	if len(p.binary) > 0 {
+		doc["data"] = "AA=="
+	}
+	return doc
+}
+
+// npz returns the payload's NPZ bytes: base64-decoded for JSON, opened
+// with the pre-shared key if sealed, and decompressed.
+func (p *predictionPayload) npz() ([]byte, error) {
+	b := p.binary
+	if b == nil {
+		var err error
+		if b, err = codec.AutoDecodeBase64(p.Data); err != nil {
+			return nil, fmt.Errorf("base64: %w", err)
+		}
+	}
+	if p.Encryption != "" {
+		if pskKeys == nil {
+			return nil, errNoPSK
+		}
+		plain, err := pskKeys.Open(p.Encryption, p.KeyID, p.Filename, b)
+		if err != nil {
+			return nil, err
+		}
+		b = plain
+	}
+	return codec.Decompress(b, p.Compression, maxDecompressedBytes)
+}
+
+// predictionJob carries one message through handlePredictions.
+type predictionJob struct {
+	payload     predictionPayload
+	key         dedupKey
+	claimed     bool // key is claimed (see claimJob)
+	duplicate   bool
+	verbose     bool
+	recvTime    int64 // ms
+	tmpPath     string
+	npzSize     int64
+	predErr     error
+	predLatency time.Duration
+	inputKey    resultcache.Key
+	cached      bool   // output came from predictionCache; no model run
+	output      string // when cached
+}
+
+// done records the outcome of the job and reports failures.
+func (j *predictionJob) done() {
+	completeMessage(j.key)
+	summary.Record(j.payload.BuoyID, j.predLatency, j.predErr)
+	if j.predErr == nil {
+		return
+	}
+	topic := predictionErrorTopic
+	var rejected *payloadschema.Error
+	if (errors.As(j.predErr, &rejected) || isSignatureError(j.predErr)) && payloadRejectTopic != "" {
+		topic = payloadRejectTopic
+	}
+	if topic != "" {
+		publishPredictionError(topic, j.payload.BuoyID, j.payload.Filename, j.predErr)
+	}
+}
+
+// handlePredictions decodes msgs, runs the model once over all of them
+// (--batch-size), except those whose data is in predictionCache, and
+// publishes one result per message. The model gets the
+// summed per-message timeouts; ctx cancels the model run and the result
+// publishes.
+func handlePredictions(ctx context.Context, msgs []MQTT.Message) {
+	for _, msg := range msgs {
+		queuedBytes.Add(-int64(len(msg.Payload())))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("panic in handlePredictions", "panic", r)
+		}
+	}()
+
+	var jobs []*predictionJob
+	var paths []string
+	var timeout time.Duration
+	for _, msg := range msgs {
+		j := preparePrediction(msg)
+		if !j.claimed && !j.duplicate && !claimJob(j, msg) {
+			j.duplicate = true
+		}
+		if j.duplicate {
+			dedupHits.Inc()
+			if j.verbose {
+				slog.Info("duplicate, skipping", "topic", msg.Topic(), "buoy", j.payload.BuoyID)
+			}
+			continue
+		}
+		if j.predErr != nil {
+			j.done()
+			continue
+		}
+		if j.cached {
+			finishPrediction(ctx, j, j.output, nil)
+			j.done()
+			continue
+		}
+		jobs = append(jobs, j)
+		paths = append(paths, j.tmpPath)
+		timeout += predictTimeout(j.npzSize)
+	}
+	if len(jobs) == 0 {
 		return
 	}
-	tmpPath := fmt.Sprintf("%s/%s", tmpDir, payload.Filename)
-	if err := os.WriteFile(tmpPath, npzBytes, 0644); err != nil {
-		fmt.Printf("[Worker] WriteFile error: %v\n", err)
+
+	inferStart := time.Now()
+	predictCtx, cancel := context.WithTimeout(ctx, timeout)
+	results, errs := runPredictBatch(predictCtx, paths)
+	cancel()
+	// each message is charged its share of the model call, so counts stay
+	// per message and the sums add up to the time spent in the model
+	perFile := time.Since(inferStart) / time.Duration(len(jobs))
+	for i, j := range jobs {
+		summary.RecordInference(perFile)
+		predictionLatency.Observe(perFile.Seconds())
+		if predictionCache != nil && errs[i] == nil {
+			predictionCache.Put(j.inputKey, results[i])
+		}
+		finishPrediction(ctx, j, results[i], errs[i])
+		j.done()
+	}
+}
+
+// preparePrediction decodes msg and writes its NPZ to the tmp dir, or
+// takes the output from predictionCache. On failure the returned job has
+// predErr set; a message found to be a duplicate has duplicate set.
+func preparePrediction(msg MQTT.Message) *predictionJob {
+	j := &predictionJob{recvTime: refClock.Now().UnixNano() / 1e6, verbose: logSampler.ShouldLog()}
+	payload := &j.payload
+
+	var signer string
+	if payloadSigners != nil {
+		var err error
+		if signer, err = verifySignature(msg.Topic(), msg.Payload()); err != nil {
+			j.predErr = err
+			// best effort, so the report can name the buoy and file
+			if body, err := openPayload(msg.Payload()); err == nil {
+				_ = decodePayload(body, payload)
+			}
+			return j
+		}
+	}
+	body, err := openPayload(msg.Payload())
+	if err != nil {
+		slog.Error("decrypt failed", "err", err)
+		j.predErr = fmt.Errorf("decrypt: %w", err)
+		return j
+	}
+	if buoypb.IsJSON(body) && payloadValidator != nil {
+		if err := payloadValidator.Validate(body); err != nil {
+			payloadsRejected.Inc()
+			slog.Warn("payload rejected", "topic", msg.Topic(), "err", err)
+			j.predErr = err
+			// best effort, so the report can name the buoy and file
+			_ = json.Unmarshal(body, payload)
+			return j
+		}
+	}
+	if err := decodePayload(body, payload); err != nil {
+		slog.Error("payload decode failed", "err", err)
+		j.predErr = fmt.Errorf("decode: %w", err)
+		return j
+	}
+	if !buoypb.IsJSON(body) && payloadValidator != nil {
+		if err := payloadValidator.ValidateDoc(payload.schemaDoc()); err != nil {
+			payloadsRejected.Inc()
+			slog.Warn("payload rejected", "topic", msg.Topic(), "err", err)
+			j.predErr = err
+			return j
+		}
+	}
+	if topicPattern != "" {
+		meta, err := topicParser.Parse(msg.Topic(), topicPattern)
+		if err != nil {
+			slog.Error("topic metadata failed", "topic", msg.Topic(), "err", err)
+			j.predErr = fmt.Errorf("topic metadata: %w", err)
+			return j
+		}
+		if id := meta["buoy_id"]; id != "" {
+			payload.BuoyID = id
+		}
+		if j.verbose {
+			slog.Info("topic metadata", "region", meta["region"], "buoy", payload.BuoyID)
+		}
+	}
+	// checked after the topic metadata, which decides the buoy
+	if signer != "" && signer != payload.BuoyID {
+		// a device may only publish for its own buoy
+		if err := signatureFailed(msg.Topic(), signer, errSignerMismatch); err != nil {
+			j.predErr = err
+			return j
+		}
+	}
+
+	npzBytes, err := payload.npz()
+	if err != nil {
+		slog.Error("decode data failed", "buoy", payload.BuoyID, "err", err)
+		j.predErr = fmt.Errorf("data: %w", err)
+		return j
+	}
+	if payload.MessageID == "" {
+		j.key = contentKey(payload, npzBytes)
+	}
+	if !claimJob(j, msg) {
+		j.duplicate = true
+		return j
+	}
+	recordNPZSize(len(npzBytes))
+	j.npzSize = int64(len(npzBytes))
+	if predictionCache != nil {
+		j.inputKey = resultcache.KeyOf(npzBytes)
+		if j.output, j.cached = predictionCache.Get(j.inputKey); j.cached {
+			predictionCacheHits.Inc()
+			return j
+		}
+		predictionCacheMisses.Inc()
+	}
+
+	tmpDir := npzTmpDir
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		slog.Error("create tmp dir failed", "err", err)
+		j.predErr = fmt.Errorf("tmp dir: %w", err)
+		return j
+	}
+	if j.tmpPath, err = writeTmpNPZ(tmpDir, payload.Filename, npzBytes); err != nil {
+		slog.Error("write tmp file failed", "err", err)
+		j.predErr = fmt.Errorf("tmp file: %w", err)
+		return j
+	}
+	return j
+}
+
+// writeTmpNPZ writes data to a new file in dir and returns its path. The
+// name keeps the payload's file name for debugging but is unique per call,
+// since buoys reuse file names and concurrent jobs must not share a file,
+// and cannot leave dir whatever the (untrusted) name holds.
+func writeTmpNPZ(dir, filename string, data []byte) (string, error) {
+	base := strings.ReplaceAll(filepath.Base(filename), "*", "_")
+	if base == "." || base == string(filepath.Separator) {
+		base = "payload.npz"
+	}
+	f, err := os.CreateTemp(dir, "*_"+base)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// finishPrediction turns the model output for j into a result row and
+// publishes it until ctx is canceled.
+func finishPrediction(ctx context.Context, j *predictionJob, pyResult string, err error) {
+	payload := j.payload
+	verbose := j.verbose
+	tmpPath := j.tmpPath
+
+	if errors.Is(err, context.Canceled) {
+		// shutting down: no result for a prediction that did not finish
+		slog.Warn("prediction canceled", "buoy", payload.BuoyID, "file", payload.Filename)
+		j.predErr = fmt.Errorf("predict: %w", err)
+		_ = os.Remove(tmpPath)
 		return
 	}
 
 	latencyReception := int64(0)
 	if payload.SendTime > 0 {
-		latencyReception = recvTime - int64(payload.SendTime*1000)
+		latencyReception = j.recvTime - int64(payload.SendTime*1000)
 	}
 
-	pyResult, err := runPythonPredict(tmpPath)
-	if err != nil {
-		fmt.Printf("[Worker] ML prediction failed: %v\n", err)
+	unavailable := errors.Is(err, breaker.ErrOpen)
+	if unavailable {
+		// published as is; the breaker logs when it opens
+		j.predErr = fmt.Errorf("predict: %w", err)
+	} else if err != nil {
+		slog.Error("ML prediction failed", "buoy", payload.BuoyID, "err", err)
 		pyResult = "PredictionError"
+		j.predErr = fmt.Errorf("predict: %w", err)
+	} else if rawCache != nil {
+		if _, err := rawCache.Save(payload.BuoyID, time.Now(), pyResult); err != nil {
+			slog.Warn("raw output cache failed", "buoy", payload.BuoyID, "err", err)
+		}
 	}
-	nowMs := time.Now().UnixNano() / 1e6
+	nowMs := refClock.Now().UnixNano() / 1e6
 	latencyInference := int64(0)
 	if payload.SendTime > 0 {
 		latencyInference = nowMs - int64(payload.SendTime*1000)
 	}
+	j.predLatency = time.Duration(latencyInference) * time.Millisecond
 
-	pyLines := strings.Split(strings.TrimSpace(pyResult), "\n")
-	var csvLines []string
-	for _, line := range pyLines {
-		line = strings.TrimSpace(line)
-		if line == "" ||
-			strings.Contains(line, "tensorflow") ||
-			strings.Contains(line, "cudart") ||
-			strings.Contains(line, "dlerror") ||
-			strings.Contains(line, "libcudart") ||
-			strings.Contains(line, "GPU") ||
-			strings.Contains(line, "CUDA") ||
-			strings.Contains(line, "stream_executor") ||
-			strings.Contains(line, "dso_loader") ||
-			strings.Contains(line, "Could not load dynamic library") ||
-			strings.Contains(line, "Ignore above") {
-			continue
+	header, data, err := predictout.Extract(pyResult)
+	if err != nil {
+		slog.Warn("no valid CSV lines in result", "buoy", payload.BuoyID)
+		_ = os.Remove(tmpPath)
+		if j.predErr == nil {
+			j.predErr = err
+		}
+		return
+	}
+	if predictionSchema != nil && j.predErr == nil {
+		if err := predictionSchema.Validate(header, data); err != nil {
+			slog.Warn("discarding result", "buoy", payload.BuoyID, "err", err)
+			_ = os.Remove(tmpPath)
+			j.predErr = err
+			return
 		}
-		csvLines = append(csvLines, line)
 	}
-	if len(csvLines) < 2 {
-		fmt.Printf("[Worker] No valid CSV lines in result\n")
+	row := predictout.Row{
+		BuoyID:           payload.BuoyID,
+		Header:           header,
+		Data:             data,
+		LatencyReception: latencyReception,
+		LatencyInference: latencyInference,
+		SendTime:         payload.SendTime,
+		NodeID:           nodeID,
+		ModelVersion:     modelVersion,
+	}
+	if refClock != nil {
+		// 0 until the first probe succeeds; the column stays put either way
+		offset, _ := refClock.Offset()
+		row.ClockOffset, row.HasClockOffset = offset.Milliseconds(), true
+	}
+	finalHeader, finalData := row.Lines()
+	sendMsg := resultMessage(row)
+
+	if !unavailable && recentResults != nil && recentResults.CheckAndStore(payload.BuoyID, payload.Filename, header+"\n"+data) {
+		slog.Warn("duplicate result; not publishing again", "buoy", payload.BuoyID, "file", payload.Filename)
 		_ = os.Remove(tmpPath)
 		return
 	}
-	header := csvLines[0]
-	data := csvLines[1]
-	finalHeader := "Buoy-station," + header + ",Observation-to-Reception-LATENCY,Observation-to-Inference-LATENCY,send_time"
-	finalData := fmt.Sprintf("%s,%s,%d,%d,%.6f", payload.BuoyID, data, latencyReception, latencyInference, payload.SendTime)
-	sendMsg := finalHeader + "\n" + finalData
 
-	// publish result
+	if resultArchive != nil && !unavailable {
+		_, err := resultArchive.Insert(resultdb.Record{
+			BuoyID:             payload.BuoyID,
+			Filename:           payload.Filename,
+			SendTime:           payload.SendTime,
+			ReceivedAt:         time.UnixMilli(j.recvTime),
+			PredictedAt:        time.UnixMilli(nowMs),
+			NodeID:             nodeID,
+			ModelVersion:       modelVersion,
+			NPZSize:            j.npzSize,
+			Header:             finalHeader,
+			Data:               finalData,
+			LatencyReceptionMs: latencyReception,
+			LatencyInferenceMs: latencyInference,
+		})
+		if err != nil {
+			slog.Error("archive result failed", "buoy", payload.BuoyID, "err", err)
+		}
+	}
+
+	// publish result; a full inflight window holds up the worker until
+	// shutdown, when the result is stored or given up like a canceled publish
+	select {
+	case resultInflight <- struct{}{}:
+	case <-ctx.Done():
+		if resultOutbox != nil {
+			storeResult(resultTopic, payload.BuoyID, sendMsg)
+		} else {
+			resultsLost.Inc()
+			slog.Error("publish canceled by shutdown; result lost", "buoy", payload.BuoyID)
+		}
+		_ = os.Remove(tmpPath)
+		return
+	}
 	go func() {
 		defer func() {
+			<-resultInflight
 			if r := recover(); r != nil {
-				fmt.Printf("[Publisher] PANIC: %v\n", r)
+				slog.Error("panic in result publisher", "panic", r)
 			}
 		}()
-		clientMutex.RLock()
-		client := globalClient
-		clientMutex.RUnlock()
-		if client != nil && client.IsConnected() {
-			done := make(chan bool, 1)
-			go func() {
-				token := client.Publish(getenvDefault("PUB_TOPIC", "buoy_sensors_data_prediction"), 0, false, sendMsg)
-				_ = token.Wait()
-				if token.Error() == nil {
-					fmt.Println("[Worker] Published prediction result")
-				} else {
-					fmt.Printf("[Worker] Publish error: %v\n", token.Error())
-				}
-				done <- true
-			}()
-			select {
-			case <-done:
-			case <-time.After(3 * time.Second):
-				fmt.Println("[Worker] Publish timeout (3s)")
-			}
-		} else {
-			fmt.Println("[Worker] Client not connected; skip publish")
+		publishResult(ctx, resultTopic, payload.BuoyID, sendMsg, verbose)
+		if anomalyTopic != "" && anomalyField != "" {
+			clientMutex.RLock()
+			client := globalClient
+			clientMutex.RUnlock()
+			publishAnomaly(client, payload.BuoyID, payload.Filename, finalHeader, finalData)
 		}
 	}()
 	_ = os.Remove(tmpPath)
 }
 
-func runPythonPredict(npzPath string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	cmd := exec.CommandContext(ctx, "python", "/root/app/rouge_wave_model/predict.py", npzPath)
-	cmd.Env = append(os.Environ(),
-		"TF_CPP_MIN_LOG_LEVEL=3",
-		"TF_ENABLE_ONEDNN_OPTS=0",
-	)
+// publishAnomaly sends an alert to --anomaly-flag-topic when the row's
+// anomaly score is above --anomaly-threshold.
+func publishAnomaly(client MQTT.Client, buoyID, filename, header, data string) {
+	score, anomalous, err := anomalydetect.Check(strings.Split(header, ","), strings.Split(data, ","), anomalyField, anomalyThreshold)
+	if err != nil {
+		slog.Warn("anomaly check skipped", "buoy", buoyID, "err", err)
+		return
+	}
+	if !anomalous || client == nil || !client.IsConnected() {
+		return
+	}
+	body, err := json.Marshal(anomalydetect.Alert{
+		BuoyID:       buoyID,
+		AnomalyScore: score,
+		TS:           time.Now().UTC().Format(time.RFC3339),
+		Filename:     filename,
+	})
+	if err != nil {
+		return
+	}
+	slog.Warn("anomaly score above threshold; alerting", "buoy", buoyID, "score", score, "threshold", anomalyThreshold)
+	client.Publish(anomalyTopic, 1, false, body)
+}
+
+// initNodeID defaults nodeID (--node-id) to the hostname, or clientID if
+// that is unknown, and tags every log line with it so logs from several
+// satellites can be merged.
+func initNodeID(clientID string) {
+	if nodeID == "" {
+		if h, err := os.Hostname(); err == nil {
+			nodeID = h
+		} else {
+			nodeID = clientID
+		}
+	}
+	slog.SetDefault(slog.Default().With("node_id", nodeID))
+}
+
+// statusMsg is the retained status message on statusTopic, also set as
+// the last will with status offline.
+type statusMsg struct {
+	NodeID  string `json:"node_id"`
+	Status  string `json:"status"` // online or offline
+	TS      string `json:"ts"`
+	Dropped int64  `json:"dropped_total"`
+	Spilled int64  `json:"spilled_total,omitempty"`
+}
+
+func statusPayload(status string) []byte {
+	body, _ := json.Marshal(statusMsg{
+		NodeID:  nodeID,
+		Status:  status,
+		TS:      time.Now().UTC().Format(time.RFC3339),
+		Dropped: droppedMessages.Load(),
+		Spilled: spilledMessages.Load(),
+	})
+	return body
+}
+
+// predictionErrorMsg is published to --prediction-error-topic for every
+// message that did not produce a result.
+type predictionErrorMsg struct {
+	BuoyID   string `json:"buoy_id"`
+	Error    string `json:"error"`
+	Filename string `json:"filename"`
+	TS       string `json:"ts"`
+}
+
+func publishPredictionError(topic, buoyID, filename string, predErr error) {
+	clientMutex.RLock()
+	client := globalClient
+	clientMutex.RUnlock()
+	if client == nil || !client.IsConnected() {
+		return
+	}
+	body, err := json.Marshal(predictionErrorMsg{
+		BuoyID:   buoyID,
+		Error:    predErr.Error(),
+		Filename: filename,
+		TS:       time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return
+	}
+	client.Publish(topic, 1, false, body)
+}
+
+// resultMessage renders row as published: the CSV header and data line,
+// or a PredictionResult with --format proto, signed with --signing-key-file.
+func resultMessage(row predictout.Row) string {
+	msg := row.Message()
+	if resultFormat == buoypb.FormatProto {
+		msg = string(buoypb.NewResult(row).Marshal())
+	}
+	if resultSigner != nil {
+		msg = string(resultSigner.Sign([]byte(msg)))
+	}
+	return msg
+}
+
+// predictTimeout scales the predict.py timeout with the input size.
+func predictTimeout(sizeBytes int64) time.Duration {
+	ms := predictTimeoutBaseMs + int64(float64(sizeBytes)/1e6*float64(predictTimeoutPerMBMs))
+	if predictTimeoutMaxMs > 0 && ms > predictTimeoutMaxMs {
+		ms = predictTimeoutMaxMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Interpreter and model entry point used for every prediction, and the
+// server that keeps predict.py loaded (--inference-server).
+const (
+	pythonBin             = "python"
+	predictScript         = "/root/app/rouge_wave_model/predict.py"
+	inferenceServerScript = "/root/app/rouge_wave_model/inference_server.py"
+
+	// predict.py's exit status when it cannot read the NPZ it was given
+	predictExitBadInput = 2
+)
+
+// Persistent inference server (--inference-socket); nil forks predict.py per message
+var inferenceClient *inference.Client
+
+// Long-lived predict.py processes (--inference-workers); nil forks predict.py per message
+var inferencePool *inference.Pool
+
+// Messages predicted together (--batch-size) and how long a worker waits to fill a batch (--batch-wait)
+var (
+	batchSize = 1
+	batchWait = 50 * time.Millisecond
+)
+
+// pythonEnv quiets TensorFlow's startup logging.
+var pythonEnv = []string{"TF_CPP_MIN_LOG_LEVEL=3", "TF_ENABLE_ONEDNN_OPTS=0"}
+
+// runPredictBatch runs the model over several NPZ files in one call, so
+// TensorFlow starts once per batch, and returns one output and error per
+// path. ctx's deadline covers the whole batch. While the inference breaker
+// is open every path gets fallbackResult and breaker.ErrOpen.
+func runPredictBatch(ctx context.Context, paths []string) ([]string, []error) {
+	results := make([]string, len(paths))
+	errs := make([]error, len(paths))
+	if onnxModel != nil {
+		for i, p := range paths {
+			results[i], errs[i] = runPredict(ctx, p)
+		}
+		return results, errs
+	}
+	if err := allowInference(); err != nil {
+		for i := range paths {
+			results[i], errs[i] = fallbackResult, err
+		}
+		return results, errs
+	}
+	if len(paths) == 1 {
+		results[0], errs[0] = runPredict(ctx, paths[0])
+		recordInference(errs[0])
+		return results, errs
+	}
+
+	var outs []inference.Result
+	var err error
+	if inferenceClient != nil {
+		outs, err = inferenceClient.PredictBatch(ctx, paths)
+	} else if inferencePool != nil {
+		outs, err = inferencePool.PredictBatch(ctx, paths)
+	} else {
+		outs, err = execPredictBatch(ctx, paths)
+	}
+	backendErr := err
+	for i := range paths {
+		switch {
+		case errors.Is(err, inference.ErrTimeout) || errors.Is(err, context.DeadlineExceeded):
+			results[i], errs[i] = "PredictionTimeout", err
+		case err != nil:
+			results[i], errs[i] = "PredictionError", err
+		case outs[i].Err() != nil:
+			results[i], errs[i] = "PredictionError", outs[i].Err()
+			if backendErr == nil && !errors.Is(errs[i], inference.ErrBadInput) {
+				backendErr = errs[i]
+			}
+		default:
+			results[i] = outs[i].Output
+		}
+	}
+	// classified like the single-file path: rejected inputs do not count
+	recordInference(backendErr)
+	return results, errs
+}
+
+// execPredictBatch runs inference_server.py --batch over paths.
+func execPredictBatch(ctx context.Context, paths []string) ([]inference.Result, error) {
+	args := append([]string{inferenceServerScript, "--script", predictScript, "--batch"}, paths...)
+	cmd := exec.CommandContext(ctx, pythonBin, args...)
+	cmd.Env = append(os.Environ(), pythonEnv...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	// TensorFlow may write to stdout too; the results are the last line
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	var outs []inference.Result
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &outs); err != nil {
+		return nil, fmt.Errorf("batch output: %w", err)
+	}
+	if len(outs) != len(paths) {
+		return nil, fmt.Errorf("batch output: %d results for %d files", len(outs), len(paths))
+	}
+	return outs, nil
+}
+
+// runPredict runs the model on npzPath with the configured backend: the
+// in-process ONNX model, the inference server, the predict.py workers, or
+// a fresh predict.py.
+func runPredict(ctx context.Context, npzPath string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "PredictionError", err
+	}
+	if onnxModel != nil {
+		out, err := runONNXPredict(npzPath)
+		if err != nil {
+			return "PredictionError", err
+		}
+		return out, nil
+	}
+	if inferenceClient != nil || inferencePool != nil {
+		var out string
+		var err error
+		if inferenceClient != nil {
+			out, err = inferenceClient.Predict(ctx, npzPath)
+		} else {
+			out, err = inferencePool.Predict(ctx, npzPath)
+		}
+		if errors.Is(err, inference.ErrTimeout) {
+			return "PredictionTimeout", err
+		}
+		if err != nil {
+			return "PredictionError", err
+		}
+		return out, nil
+	}
+	cmd := exec.CommandContext(ctx, pythonBin, predictScript, npzPath)
+	cmd.Env = append(os.Environ(), pythonEnv...)
 	out, err := cmd.CombinedOutput()
 	if ctx.Err() == context.DeadlineExceeded {
 		return "PredictionTimeout", ctx.Err()
 	}
+	if ctx.Err() != nil {
+		return "PredictionError", ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == predictExitBadInput {
+		return "PredictionError", fmt.Errorf("%w (%v)", inference.ErrBadInput, err)
+	}
 	if err != nil {
 		return "PredictionError", err
 	}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"cloudletsapps/internal/stats"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Broker round-trip time from keepalive pings (--broker-latency-measure)
+var brokerLatencyMeasure bool
+var lastBrokerRTT atomic.Int64 // nanoseconds; 0 until the first measurement
+
+var brokerRTTGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "mqtt_broker_rtt_ms",
+	Help: "Round-trip time of the last MQTT PINGREQ/PINGRESP exchange with the broker.",
+})
+
+// Message flow counters and the prediction latency histogram.
+var (
+	messagesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "satellite_messages_received_total",
+		Help: "MQTT messages delivered to the satellite handler.",
+	})
+	dedupHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "satellite_dedup_hits_total",
+		Help: "Messages skipped because they were already seen within the de-dup window.",
+	})
+	dedupEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "satellite_dedup_evictions_total",
+		Help: "De-dup entries evicted early to stay within --payload-hash-index.",
+	})
+	predictionLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "satellite_prediction_duration_seconds",
+		Help:    "Time spent running the prediction script per message; a batch's duration is divided among its messages.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+	publishFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "satellite_publish_failures_total",
+		Help: "Failed prediction result publish attempts, by reason (error, timeout, not_connected).",
+	}, []string{"reason"})
+	resultsLost = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "satellite_results_lost_total",
+		Help: "Prediction results given up after --result-publish-retries, or dropped from a full result outbox.",
+	})
+	payloadsRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "satellite_payloads_rejected_total",
+		Help: "Payloads that failed --payload-validation and were not decoded.",
+	})
+	signatureFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "satellite_signature_failures_total",
+		Help: "Payloads that failed --verify-keys-dir signature checks, by reason (unsigned, unknown_key, bad_signature, key_mismatch).",
+	}, []string{"reason"})
+	resultsStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "satellite_results_stored_total",
+		Help: "Prediction results stored for later delivery because the broker was unreachable.",
+	})
+	routedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "satellite_routed_messages_total",
+		Help: "Received messages by the --routes action taken (predict, archive, drop).",
+	}, []string{"action"})
+	breakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "satellite_inference_breaker_state",
+		Help: "State of the inference circuit breaker (0 closed, 1 half-open, 2 open).",
+	})
+	predictionsShortCircuited = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "satellite_predictions_short_circuited_total",
+		Help: "Messages answered with PredictionUnavailable because the inference circuit breaker was open.",
+	})
+	predictionCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "satellite_prediction_cache_hits_total",
+		Help: "Messages whose NPZ data was in the --prediction-cache-size cache and skipped inference.",
+	})
+	predictionCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "satellite_prediction_cache_misses_total",
+		Help: "Messages whose NPZ data was not in the --prediction-cache-size cache.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(brokerRTTGauge, messagesReceived, dedupHits, dedupEvictions, predictionLatency, publishFailures, resultsLost, resultsStored, payloadsRejected, signatureFailures, routedMessages, breakerState, predictionsShortCircuited, predictionCacheHits, predictionCacheMisses)
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "satellite_dedup_cache_entries",
+			Help: "Messages remembered for de-dup.",
+		}, func() float64 { return float64(dedupCacheSize()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "satellite_dedup_hit_ratio",
+			Help: "Share of received messages skipped as duplicates since startup.",
+		}, dedupHitRatio),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "satellite_queue_depth",
+			Help: "Messages waiting for a worker, across all queues.",
+		}, func() float64 { return float64(queueDepth()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "satellite_queue_bytes",
+			Help: "Payload bytes waiting for a worker (bounded by --max-queued-bytes).",
+		}, func() float64 { return float64(queuedBytes.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "satellite_messages_dropped_total",
+			Help: "Messages dropped because a queue or the byte limit was full (see --queue-overflow).",
+		}, func() float64 { return float64(droppedMessages.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "satellite_messages_spilled_total",
+			Help: "Messages written to the spill directory because the queue was full.",
+		}, func() float64 { return float64(spilledMessages.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "satellite_spill_depth",
+			Help: "Messages waiting in the spill directory.",
+		}, func() float64 {
+			if spillDir == nil {
+				return 0
+			}
+			return float64(spillDir.Len())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "satellite_result_outbox_depth",
+			Help: "Prediction results stored and waiting for the broker.",
+		}, func() float64 {
+			if resultOutbox == nil {
+				return 0
+			}
+			return float64(resultOutbox.Len())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "satellite_results_inflight",
+			Help: "Prediction results published but not yet acknowledged, including ones waiting to retry.",
+		}, func() float64 { return float64(resultsInflight.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "satellite_prediction_cache_entries",
+			Help: "Model outputs held in the --prediction-cache-size cache.",
+		}, func() float64 {
+			if predictionCache == nil {
+				return 0
+			}
+			return float64(predictionCache.Len())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "satellite_inference_worker_restarts_total",
+			Help: "predict.py workers (--inference-workers) started again after exiting or being killed on a timeout.",
+		}, func() float64 {
+			if inferencePool == nil {
+				return 0
+			}
+			return float64(inferencePool.Restarts())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "satellite_reconnects_total",
+			Help: "Successful reconnects to the broker after a lost connection.",
+		}, func() float64 { return float64(reconnectCount.Load()) }),
+	)
+}
+
+func dedupHitRatio() float64 {
+	var received, hits dto.Metric
+	if messagesReceived.Write(&received) != nil || dedupHits.Write(&hits) != nil {
+		return 0
+	}
+	if received.GetCounter().GetValue() == 0 {
+		return 0
+	}
+	return hits.GetCounter().GetValue() / received.GetCounter().GetValue()
+}
+
+// queueDepth is the number of messages waiting in msgChan and, with
+// --worker-isolate-buoy, in every per-buoy queue.
+func queueDepth() int {
+	n := len(msgChan)
+	buoyQueuesMutex.RLock()
+	for _, q := range buoyQueues {
+		n += len(q)
+	}
+	buoyQueuesMutex.RUnlock()
+	return n
+}
+
+// Decoded NPZ size distribution (--npz-size-histogram); nil when disabled
+var npzSizes *stats.Histogram
+var npzSizeHistogram prometheus.Histogram
+
+func enableNPZSizeHistogram() {
+	npzSizes = stats.NewHistogram(stats.SizeBounds)
+	buckets := make([]float64, len(stats.SizeBounds))
+	for i, b := range stats.SizeBounds {
+		buckets[i] = float64(b)
+	}
+	npzSizeHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mqtt_npz_size_bytes",
+		Help:    "Size of decoded NPZ payloads received by the satellite.",
+		Buckets: buckets,
+	})
+	prometheus.MustRegister(npzSizeHistogram)
+}
+
+func recordNPZSize(n int) {
+	if npzSizes == nil {
+		return
+	}
+	npzSizes.Observe(int64(n))
+	npzSizeHistogram.Observe(float64(n))
+}
+
+func recordBrokerRTT(d time.Duration) {
+	lastBrokerRTT.Store(int64(d))
+	brokerRTTGauge.Set(brokerRTTMs())
+}
+
+func brokerRTTMs() float64 {
+	ms := float64(lastBrokerRTT.Load()) / float64(time.Millisecond)
+	return math.Round(ms*1000) / 1000
+}
+
+// metricsPayload renders the --publish-metrics-topic document: the
+// summarizer's totals plus the current queue depth and broker RTT.
+func metricsPayload() ([]byte, error) {
+	m := summary.Metrics()
+	m.QueueDepth = len(msgChan)
+	m.BrokerRTTMs = brokerRTTMs()
+	return json.Marshal(m)
+}
+
+// serveMetrics exposes the Prometheus registry on addr at /metrics.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	slog.Info("serving metrics", "addr", addr, "path", "/metrics")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("metrics server stopped", "err", err)
+	}
+}
+
+// enableClockOffsetGauge exports the --ntp-server offset.
+func enableClockOffsetGauge() {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "satellite_clock_offset_seconds",
+		Help: "Measured offset of the local clock from --ntp-server (server time minus local time).",
+	}, func() float64 {
+		offset, _ := refClock.Offset()
+		return offset.Seconds()
+	}))
+}
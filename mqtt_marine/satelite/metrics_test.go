@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"cloudletsapps/internal/summarizer"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestMetricsPayload(t *testing.T) {
+	defer func(s *summarizer.Summarizer, q chan MQTT.Message) { summary, msgChan = s, q }(summary, msgChan)
+	defer lastBrokerRTT.Store(0)
+	summary = summarizer.New()
+	msgChan = make(chan MQTT.Message, 8)
+
+	for _, failed := range []bool{false, false, true} {
+		var err error
+		if failed {
+			err = errors.New("predict.py exited 1")
+		}
+		summary.Record("b1", 100*time.Millisecond, err)
+	}
+	summary.RecordInference(30 * time.Millisecond)
+	summary.RecordInference(50 * time.Millisecond)
+	msgChan <- &localMessage{topic: "t"}
+	msgChan <- &localMessage{topic: "t"}
+	lastBrokerRTT.Store(int64(12500 * time.Microsecond))
+
+	body, err := metricsPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got summarizer.MetricsMsg
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.PredictionsTotal != 3 || got.ErrorsTotal != 1 || got.QueueDepth != 2 || got.AvgInferenceMs != 40 || got.BrokerRTTMs != 12.5 {
+		t.Errorf("metrics message %s", body)
+	}
+	if got.UptimeSeconds < 0 || got.UptimeSeconds > 60 {
+		t.Errorf("uptime %v", got.UptimeSeconds)
+	}
+}
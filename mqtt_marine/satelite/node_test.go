@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestNodeID(t *testing.T) {
+	defer func(id string, logger *slog.Logger) { nodeID = id; slog.SetDefault(logger) }(nodeID, slog.Default())
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip("no hostname:", err)
+	}
+
+	tests := []struct {
+		name string
+		flag string
+		want string
+	}{
+		{"flag", "sat-7", "sat-7"},
+		{"hostname", "", host},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+			nodeID = tt.flag
+			initNodeID("client-1")
+			if nodeID != tt.want {
+				t.Fatalf("nodeID = %q, want %q", nodeID, tt.want)
+			}
+
+			slog.Info("prediction published", "buoy", "b1")
+			var line map[string]any
+			if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+				t.Fatalf("log output %q: %v", logs.String(), err)
+			}
+			if line["node_id"] != tt.want {
+				t.Errorf("log line %s has no node_id %q", logs.Bytes(), tt.want)
+			}
+
+			var status statusMsg
+			if err := json.Unmarshal(statusPayload("online"), &status); err != nil {
+				t.Fatal(err)
+			}
+			if status.NodeID != tt.want || status.Status != "online" {
+				t.Errorf("status message %+v", status)
+			}
+		})
+	}
+}
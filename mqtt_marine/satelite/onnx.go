@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"cloudletsapps/internal/npz"
+)
+
+// onnxSession runs an exported rogue-wave model in process.
+type onnxSession interface {
+	Run(input []float32) ([]float32, error)
+	Close()
+}
+
+// Opens an ONNX model; set by onnx_ort.go, nil unless built with -tags onnx
+var newONNXSession func(modelPath, libPath string) (onnxSession, error)
+
+// In-process ONNX model (--onnx-model); nil uses predict.py
+var onnxModel onnxSession
+
+// rogueWaveSamples is the input length the rogue-wave LSTM was trained on.
+const rogueWaveSamples = 1536
+
+// rogueWaveInput mirrors predict.py's preprocessing: take the zdisp
+// array (or zdisp_norw, or the first array) and divide it by the
+// significant wave height, 4 * std.
+func rogueWaveInput(npzBytes []byte) ([]float32, error) {
+	arrays, names, err := npz.Read(npzBytes)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := arrays["zdisp"]
+	if !ok {
+		a, ok = arrays["zdisp_norw"]
+	}
+	if !ok {
+		if len(names) == 0 {
+			return nil, errors.New("npz holds no arrays")
+		}
+		a = arrays[names[0]]
+	}
+	if len(a.Data) != rogueWaveSamples {
+		return nil, fmt.Errorf("want %d samples, got %d", rogueWaveSamples, len(a.Data))
+	}
+	var mean, sq float64
+	for _, v := range a.Data {
+		mean += v
+	}
+	mean /= float64(len(a.Data))
+	for _, v := range a.Data {
+		sq += (v - mean) * (v - mean)
+	}
+	hs := 4 * math.Sqrt(sq/float64(len(a.Data)))
+	if hs == 0 {
+		return nil, errors.New("zero wave height")
+	}
+	in := make([]float32, len(a.Data))
+	for i, v := range a.Data {
+		in[i] = float32(v / hs)
+	}
+	return in, nil
+}
+
+// rogueWaveCSV formats the model output like predict.py does.
+func rogueWaveCSV(out []float32) (string, error) {
+	if len(out) != 2 {
+		return "", fmt.Errorf("want 2 model outputs, got %d", len(out))
+	}
+	// predict.py applies softmax on top of the model's own
+	hi := math.Max(float64(out[0]), float64(out[1]))
+	e0, e1 := math.Exp(float64(out[0])-hi), math.Exp(float64(out[1])-hi)
+	norw, rw := e0/(e0+e1), e1/(e0+e1)
+	waveType := "non-rogue wave"
+	if rw > norw {
+		waveType = "rogue wave"
+	}
+	return fmt.Sprintf("norw_prob,rw_prob,wave_type_prediction\n%.6f,%.6f,%s\n", norw, rw, waveType), nil
+}
+
+func runONNXPredict(npzPath string) (string, error) {
+	npzBytes, err := os.ReadFile(npzPath)
+	if err != nil {
+		return "", err
+	}
+	in, err := rogueWaveInput(npzBytes)
+	if err != nil {
+		return "", fmt.Errorf("onnx input: %w", err)
+	}
+	out, err := onnxModel.Run(in)
+	if err != nil {
+		return "", fmt.Errorf("onnx: %w", err)
+	}
+	return rogueWaveCSV(out)
+}
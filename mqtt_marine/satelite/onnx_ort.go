@@ -0,0 +1,78 @@
+//go:build onnx
+
+package main
+
+import (
+	"errors"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+func init() {
+	registerFeature("onnx_inference", func() bool { return onnxModel != nil })
+	newONNXSession = openORTSession
+}
+
+// ortSession keeps one onnxruntime session with preallocated tensors;
+// Run copies each input in and serialises calls.
+type ortSession struct {
+	mu      sync.Mutex
+	session *ort.AdvancedSession
+	input   *ort.Tensor[float32]
+	output  *ort.Tensor[float32]
+}
+
+func openORTSession(modelPath, libPath string) (onnxSession, error) {
+	if libPath != "" {
+		ort.SetSharedLibraryPath(libPath)
+	}
+	if err := ort.InitializeEnvironment(); err != nil {
+		return nil, err
+	}
+	inputs, outputs, err := ort.GetInputOutputInfo(modelPath)
+	if err != nil {
+		ort.DestroyEnvironment()
+		return nil, err
+	}
+	if len(inputs) != 1 || len(outputs) != 1 {
+		ort.DestroyEnvironment()
+		return nil, errors.New("model must have exactly one input and one output")
+	}
+	s := &ortSession{}
+	if s.input, err = ort.NewEmptyTensor[float32](ort.NewShape(1, rogueWaveSamples, 1)); err == nil {
+		if s.output, err = ort.NewEmptyTensor[float32](ort.NewShape(1, 2)); err == nil {
+			s.session, err = ort.NewAdvancedSession(modelPath,
+				[]string{inputs[0].Name}, []string{outputs[0].Name},
+				[]ort.Value{s.input}, []ort.Value{s.output}, nil)
+		}
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ortSession) Run(input []float32) ([]float32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copy(s.input.GetData(), input)
+	if err := s.session.Run(); err != nil {
+		return nil, err
+	}
+	return append([]float32(nil), s.output.GetData()...), nil
+}
+
+func (s *ortSession) Close() {
+	if s.session != nil {
+		s.session.Destroy()
+	}
+	if s.output != nil {
+		s.output.Destroy()
+	}
+	if s.input != nil {
+		s.input.Destroy()
+	}
+	ort.DestroyEnvironment()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"cloudletsapps/internal/outbox"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// What the handler does when a queue or --max-queued-bytes is full (--queue-overflow)
+const (
+	overflowDropNew    = "drop-new"    // drop the incoming message
+	overflowDropOldest = "drop-oldest" // drop the longest-waiting message to make room
+	overflowBlock      = "block"       // hold the MQTT handler until there is room or the timeout passes
+	overflowSpill      = "spill"       // write the message to --queue-spill-dir and queue it later
+)
+
+var queueOverflow = overflowDropNew
+
+// How long the block policy waits for room (--queue-block-timeout)
+var queueBlockTimeout = 5 * time.Second
+
+// Spilled messages waiting for room in the queues (--queue-spill-dir)
+var spillDir *outbox.Dir
+var spilledMessages atomic.Int64
+
+var errQueueFull = errors.New("queue full")
+
+func validOverflowPolicy(p string) error {
+	switch p {
+	case overflowDropNew, overflowDropOldest, overflowBlock, overflowSpill:
+		return nil
+	}
+	return fmt.Errorf("unknown queue overflow policy %q (want drop-new, drop-oldest, block or spill)", p)
+}
+
+// tryEnqueue queues msg if both queue and the byte limit have room.
+func tryEnqueue(queue chan MQTT.Message, msg MQTT.Message) bool {
+	size := int64(len(msg.Payload()))
+	if queuedBytes.Add(size) > maxQueuedBytes {
+		queuedBytes.Add(-size)
+		return false
+	}
+	select {
+	case queue <- msg:
+		return true
+	default:
+		queuedBytes.Add(-size)
+		return false
+	}
+}
+
+// enqueue hands msg to queue, applying queueOverflow when it is full.
+// It returns errQueueFull when the message had to be dropped.
+func enqueue(queue chan MQTT.Message, msg MQTT.Message) error {
+	switch queueOverflow {
+	case overflowSpill:
+		// keep arrival order: once anything is spilled, new messages queue behind it
+		if spillDir.Len() == 0 && tryEnqueue(queue, msg) {
+			return nil
+		}
+		return spill(msg)
+	case overflowBlock:
+		deadline := time.Now().Add(queueBlockTimeout)
+		for !tryEnqueue(queue, msg) {
+			if time.Now().After(deadline) {
+				return errQueueFull
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	case overflowDropOldest:
+		for !tryEnqueue(queue, msg) {
+			select {
+			case old := <-queue:
+				queuedBytes.Add(-int64(len(old.Payload())))
+				droppedMessages.Add(1)
+				slog.Warn("queue full; dropped oldest message", "topic", old.Topic())
+			default:
+				// nothing left to evict; msg alone exceeds the byte limit
+				return errQueueFull
+			}
+		}
+		return nil
+	}
+	if tryEnqueue(queue, msg) {
+		return nil
+	}
+	return errQueueFull
+}
+
+// spill writes msg to the spill directory as its topic, a newline and
+// the payload.
+func spill(msg MQTT.Message) error {
+	var buf bytes.Buffer
+	buf.WriteString(msg.Topic())
+	buf.WriteByte('\n')
+	buf.Write(msg.Payload())
+	if _, err := spillDir.Put(buf.Bytes()); err != nil {
+		if errors.Is(err, outbox.ErrFull) {
+			return errQueueFull
+		}
+		return err
+	}
+	spilledMessages.Add(1)
+	return nil
+}
+
+// localMessage is a message that did not come from the broker: read back
+// from the spill directory or received over CoAP.
+type localMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *localMessage) Duplicate() bool   { return false }
+func (m *localMessage) Qos() byte         { return 0 }
+func (m *localMessage) Retained() bool    { return false }
+func (m *localMessage) Topic() string     { return m.topic }
+func (m *localMessage) MessageID() uint16 { return 0 }
+func (m *localMessage) Payload() []byte   { return m.payload }
+func (m *localMessage) Ack()              {}
+
+// drainSpill moves spilled messages, oldest first, back into the queues
+// as they make room, until ctx is canceled. Entries left from a previous
+// run are drained too.
+func drainSpill(ctx context.Context) {
+	for ctx.Err() == nil {
+		names, err := spillDir.List()
+		if err != nil {
+			slog.Error("list spill dir failed", "err", err)
+		}
+		for _, name := range names {
+			data, err := spillDir.Read(name)
+			if err != nil {
+				slog.Error("read spilled message failed", "file", name, "err", err)
+				_ = spillDir.Remove(name)
+				continue
+			}
+			topic, payload, _ := bytes.Cut(data, []byte{'\n'})
+			if int64(len(payload)) > maxQueuedBytes {
+				droppedMessages.Add(1)
+				slog.Warn("spilled message exceeds the byte limit; dropping", "file", name)
+				_ = spillDir.Remove(name)
+				continue
+			}
+			msg := &localMessage{topic: string(topic), payload: payload}
+			queue := msgChan
+			if isolateBuoys {
+				queue = buoyQueue(ctx, messageBuoyID(msg))
+			}
+			if !tryEnqueue(queue, msg) {
+				break
+			}
+			if err := spillDir.Remove(name); err != nil {
+				slog.Error("remove spilled message failed", "file", name, "err", err)
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// reportDrops republishes the retained status message whenever the
+// dropped or spilled counts have changed, so they can be watched over MQTT.
+func reportDrops(interval time.Duration) {
+	var lastDropped, lastSpilled int64
+	for range time.Tick(interval) {
+		dropped, spilled := droppedMessages.Load(), spilledMessages.Load()
+		if dropped == lastDropped && spilled == lastSpilled {
+			continue
+		}
+		clientMutex.RLock()
+		c := globalClient
+		clientMutex.RUnlock()
+		if c == nil || !c.IsConnectionOpen() {
+			continue
+		}
+		c.Publish(statusTopic, 1, true, statusPayload("online"))
+		lastDropped, lastSpilled = dropped, spilled
+	}
+}
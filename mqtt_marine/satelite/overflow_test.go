@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func sized(n int) MQTT.Message {
+	return &localMessage{topic: "t", payload: []byte(strings.Repeat("x", n))}
+}
+
+// The byte limit and the queue's capacity each fill the queue on their own.
+func TestEnqueueLimits(t *testing.T) {
+	defer func(limit int64, policy string) {
+		maxQueuedBytes, queueOverflow = limit, policy
+		queuedBytes.Store(0)
+	}(maxQueuedBytes, queueOverflow)
+
+	tests := []struct {
+		name      string
+		policy    string
+		capacity  int
+		limit     int64
+		sizes     []int
+		wantErrs  []bool
+		wantQueue []int // sizes left in the queue
+	}{
+		{"bytes before count", overflowDropNew, 10, 100, []int{40, 40, 40, 20},
+			[]bool{false, false, true, false}, []int{40, 40, 20}},
+		{"count before bytes", overflowDropNew, 2, 1 << 20, []int{10, 10, 10},
+			[]bool{false, false, true}, []int{10, 10}},
+		{"message over the limit", overflowDropNew, 10, 100, []int{101},
+			[]bool{true}, nil},
+		{"drop oldest frees bytes", overflowDropOldest, 10, 100, []int{40, 40, 60},
+			[]bool{false, false, false}, []int{40, 60}},
+		{"drop oldest cannot fit", overflowDropOldest, 10, 100, []int{40, 150},
+			[]bool{false, true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxQueuedBytes, queueOverflow = tt.limit, tt.policy
+			queuedBytes.Store(0)
+			queue := make(chan MQTT.Message, tt.capacity)
+			for i, n := range tt.sizes {
+				err := enqueue(queue, sized(n))
+				if (err != nil) != tt.wantErrs[i] {
+					t.Fatalf("message %d (%d bytes): err = %v", i, n, err)
+				}
+				if err != nil && !errors.Is(err, errQueueFull) {
+					t.Fatalf("message %d: err = %v, want errQueueFull", i, err)
+				}
+			}
+			var left []int
+			var total int64
+			for len(queue) > 0 {
+				n := len((<-queue).Payload())
+				left = append(left, n)
+				total += int64(n)
+			}
+			if len(left) != len(tt.wantQueue) {
+				t.Fatalf("queue holds %v, want %v", left, tt.wantQueue)
+			}
+			for i := range left {
+				if left[i] != tt.wantQueue[i] {
+					t.Fatalf("queue holds %v, want %v", left, tt.wantQueue)
+				}
+			}
+			if queuedBytes.Load() != total {
+				t.Errorf("queuedBytes = %d, want %d", queuedBytes.Load(), total)
+			}
+		})
+	}
+}
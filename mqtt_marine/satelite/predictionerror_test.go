@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+type published struct {
+	topic string
+	qos   byte
+	body  []byte
+}
+
+// recordingClient captures publishes; the rest of MQTT.Client is unused.
+type recordingClient struct {
+	MQTT.Client
+	mu   sync.Mutex
+	msgs []published
+}
+
+func (c *recordingClient) IsConnected() bool { return true }
+
+func (c *recordingClient) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, published{topic, qos, payload.([]byte)})
+	return &MQTT.DummyToken{}
+}
+
+// useClient installs c as the global client for the test.
+func useClient(t *testing.T, c MQTT.Client) {
+	clientMutex.Lock()
+	old := globalClient
+	globalClient = c
+	clientMutex.Unlock()
+	t.Cleanup(func() {
+		clientMutex.Lock()
+		globalClient = old
+		clientMutex.Unlock()
+	})
+}
+
+func TestPredictionErrorTopic(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		wantBuoy  string
+		wantError string
+	}{
+		{"malformed json", `{"buoy_id":"b1",`, "", "decode:"},
+		{"bad base64", `{"buoy_id":"b1","filename":"a.npz","data":"@@@"}`, "b1", "data: base64:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetDedup()
+			old := predictionErrorTopic
+			predictionErrorTopic = "buoys/errors"
+			t.Cleanup(func() { predictionErrorTopic = old })
+			c := &recordingClient{}
+			useClient(t, c)
+
+			handlePredictions(context.Background(), []MQTT.Message{&localMessage{topic: "buoys/b1/npz", payload: []byte(tt.payload)}})
+
+			if len(c.msgs) != 1 {
+				t.Fatalf("%d messages published, want 1: %v", len(c.msgs), c.msgs)
+			}
+			m := c.msgs[0]
+			if m.topic != "buoys/errors" || m.qos != 1 {
+				t.Errorf("published to %q at QoS %d, want buoys/errors at 1", m.topic, m.qos)
+			}
+			var report predictionErrorMsg
+			if err := json.Unmarshal(m.body, &report); err != nil {
+				t.Fatalf("report %s: %v", m.body, err)
+			}
+			if !strings.HasPrefix(report.Error, tt.wantError) {
+				t.Errorf("error = %q, want prefix %q", report.Error, tt.wantError)
+			}
+			if report.BuoyID != tt.wantBuoy || report.TS == "" {
+				t.Errorf("report = %+v, want buoy %q and a timestamp", report, tt.wantBuoy)
+			}
+		})
+	}
+}
+
+func TestPredictionErrorTopicDisabled(t *testing.T) {
+	resetDedup()
+	c := &recordingClient{}
+	useClient(t, c)
+	handlePredictions(context.Background(), []MQTT.Message{&localMessage{topic: "t", payload: []byte("{")}})
+	if len(c.msgs) != 0 {
+		t.Errorf("published %v without --prediction-error-topic", c.msgs)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloudletsapps/internal/backoff"
+	"cloudletsapps/internal/outbox"
+)
+
+// Attempts after the first before a result is given up (--result-publish-retries)
+var resultPublishRetries = 5
+
+// Bounds result publishes waiting for an acknowledgement (--result-max-inflight);
+// workers block when it is full
+var resultInflight = make(chan struct{}, 100)
+var resultsInflight atomic.Int64
+
+// Results waiting for the broker, oldest first (--result-store-and-forward);
+// nil discards results that cannot be published
+var resultOutbox *outbox.Dir
+var resultFlushWake = make(chan struct{}, 1)
+
+var (
+	errNotConnected   = errors.New("not connected")
+	errPublishTimeout = errors.New("publish not acknowledged in time")
+)
+
+// publishFailureReason is the publishFailures label for err.
+func publishFailureReason(err error) string {
+	switch err {
+	case errNotConnected:
+		return "not_connected"
+	case errPublishTimeout:
+		return "timeout"
+	}
+	return "error"
+}
+
+// publishResultOnce publishes body to the result topic and waits for the
+// broker to acknowledge it (at QoS 1 or 2), or for ctx to be canceled.
+func publishResultOnce(ctx context.Context, topic, body string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	clientMutex.RLock()
+	client := globalClient
+	clientMutex.RUnlock()
+	if client == nil || !client.IsConnectionOpen() {
+		return errNotConnected
+	}
+	token := client.Publish(topic, resultQoS, false, body)
+	timer := time.NewTimer(resultPublishTimeout)
+	defer timer.Stop()
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-timer.C:
+		return errPublishTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// publishResult publishes a prediction result, retrying with backoff
+// until it is acknowledged or resultPublishRetries is used up. With a
+// result outbox, a result that cannot be published (or arrives while
+// older ones are still waiting) is stored for flushResults instead. It
+// reports whether the result was delivered or stored. Once ctx is
+// canceled the result is stored or, without an outbox, given up. The
+// caller holds a resultInflight slot.
+func publishResult(ctx context.Context, topic, buoyID, body string, verbose bool) bool {
+	resultsInflight.Add(1)
+	defer resultsInflight.Add(-1)
+	if resultOutbox != nil && resultOutbox.Len() > 0 {
+		// keep order: queue behind the results already waiting
+		return storeResult(topic, buoyID, body)
+	}
+	delay := backoff.New(time.Second, 30*time.Second)
+	for attempt := 0; ; attempt++ {
+		err := publishResultOnce(ctx, topic, body)
+		if err == nil {
+			if verbose {
+				slog.Info("published prediction result", "buoy", buoyID, "attempts", attempt+1)
+			}
+			return true
+		}
+		if ctx.Err() != nil {
+			if resultOutbox != nil {
+				return storeResult(topic, buoyID, body)
+			}
+			resultsLost.Inc()
+			slog.Error("publish canceled by shutdown; result lost", "buoy", buoyID, "attempts", attempt+1)
+			return false
+		}
+		publishFailures.WithLabelValues(publishFailureReason(err)).Inc()
+		if resultOutbox != nil && (err == errNotConnected || attempt >= resultPublishRetries) {
+			return storeResult(topic, buoyID, body)
+		}
+		if attempt >= resultPublishRetries {
+			resultsLost.Inc()
+			slog.Error("publish failed; giving up", "buoy", buoyID, "attempts", attempt+1, "err", err)
+			return false
+		}
+		d := delay.Next()
+		slog.Warn("publish failed; retrying", "buoy", buoyID, "attempt", attempt+1, "delay", d, "err", err)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// storeResult appends a result to the outbox as its topic, a newline and
+// the body. The body is kept as built, so the delivered result carries
+// the timestamps and latencies of the original prediction.
+func storeResult(topic, buoyID, body string) bool {
+	if _, err := resultOutbox.Put([]byte(topic + "\n" + body)); err != nil {
+		resultsLost.Inc()
+		slog.Error("store result failed; result lost", "buoy", buoyID, "err", err)
+		return false
+	}
+	resultsStored.Inc()
+	slog.Warn("result stored for later delivery", "buoy", buoyID, "waiting", resultOutbox.Len())
+	wakeResultFlush()
+	return true
+}
+
+func wakeResultFlush() {
+	select {
+	case resultFlushWake <- struct{}{}:
+	default:
+	}
+}
+
+// flushResults delivers stored results oldest-first whenever the broker
+// connection is up, including results left by a previous run. An entry
+// is removed only once its publish is acknowledged; if the removal fails
+// it is remembered so it is not published twice. It returns when ctx is
+// canceled.
+func flushResults(ctx context.Context) {
+	confirmed := make(map[string]bool)
+	tk := time.NewTicker(10 * time.Second)
+	defer tk.Stop()
+	for {
+		select {
+		case <-resultFlushWake:
+		case <-tk.C:
+		case <-ctx.Done():
+			return
+		}
+		if resultOutbox.Len() == 0 {
+			continue
+		}
+		names, err := resultOutbox.List()
+		if err != nil {
+			slog.Error("list result outbox failed", "err", err)
+			continue
+		}
+		flushed := 0
+		for _, name := range names {
+			if confirmed[name] {
+				if err := resultOutbox.Remove(name); err == nil {
+					delete(confirmed, name)
+				}
+				continue
+			}
+			data, err := resultOutbox.Read(name)
+			if err != nil {
+				slog.Error("read stored result failed", "entry", name, "err", err)
+				break
+			}
+			topic, body, ok := strings.Cut(string(data), "\n")
+			if !ok {
+				slog.Error("stored result is malformed; dropping", "entry", name)
+				_ = resultOutbox.Remove(name)
+				continue
+			}
+			if err := publishResultOnce(ctx, topic, body); err != nil {
+				if err != errNotConnected && ctx.Err() == nil {
+					publishFailures.WithLabelValues(publishFailureReason(err)).Inc()
+					slog.Warn("stored result publish failed", "err", err)
+				}
+				break
+			}
+			if err := resultOutbox.Remove(name); err != nil {
+				slog.Error("remove stored result failed", "entry", name, "err", err)
+				confirmed[name] = true
+			}
+			flushed++
+		}
+		if flushed > 0 {
+			slog.Info("flushed stored results", "flushed", flushed, "left", resultOutbox.Len())
+		}
+	}
+}
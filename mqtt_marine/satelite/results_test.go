@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"cloudletsapps/internal/buoypb"
+	"cloudletsapps/internal/predictout"
+	"cloudletsapps/internal/signing"
+)
+
+func TestResultMessageModelVersion(t *testing.T) {
+	defer func(f string, s *signing.Signer) { resultFormat, resultSigner = f, s }(resultFormat, resultSigner)
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := predictout.Row{BuoyID: "b1", Header: "Hs,Tp", Data: "1.5,8", SendTime: 1, NodeID: "sat-1", ModelVersion: "wave-v2.3"}
+
+	tests := []struct {
+		name   string
+		format string
+		signer *signing.Signer
+	}{
+		{"csv", buoypb.FormatJSON, nil},
+		{"protobuf", buoypb.FormatProto, nil},
+		{"signed", buoypb.FormatJSON, &signing.Signer{KeyID: "sat-1", Key: key}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resultFormat, resultSigner = tt.format, tt.signer
+			msg := []byte(resultMessage(row))
+			if tt.signer != nil {
+				payload, _, err := signing.Keyring{"sat-1": key.Public().(ed25519.PublicKey)}.Verify(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				msg = payload
+			}
+			header, data := string(msg), ""
+			if tt.format == buoypb.FormatProto {
+				var res buoypb.PredictionResult
+				if err := res.Unmarshal(msg); err != nil {
+					t.Fatal(err)
+				}
+				header, data = res.Row().Lines()
+			} else {
+				header, data, _ = strings.Cut(header, "\n")
+			}
+			names, values := strings.Split(header, ","), strings.Split(data, ",")
+			for i, name := range names {
+				if name == "Model-Version" {
+					if i >= len(values) || values[i] != "wave-v2.3" {
+						t.Errorf("Model-Version column wrong in %q", data)
+					}
+					return
+				}
+			}
+			t.Errorf("no Model-Version column in %q", header)
+		})
+	}
+}
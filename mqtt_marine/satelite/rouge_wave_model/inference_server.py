@@ -0,0 +1,121 @@
+"""Long-lived inference server for the satellite (--inference-server).
+
+Imports predict.py once and answers requests on a Unix socket, so
+TensorFlow and the model are loaded once instead of for every message.
+The protocol is one JSON object per line:
+
+    {"npz_path": "/tmp/mqtt_npz/x.npz"}  ->  {"output": "<predict.py stdout>"}
+                                              {"error": "...", "bad_input": true}
+    {"npz_paths": ["a.npz", "b.npz"]}    ->  {"outputs": [{"output": ...}, {"error": ...}]}
+    {"ping": true}                       ->  {"ok": true}
+
+Requests are handled one at a time; the satellite serialises its calls.
+
+With --batch, the files given on the command line are predicted once and
+the "outputs" list is printed as the last line of stdout, for satellites
+batching without a running server (--batch-size).
+"""
+import argparse
+import contextlib
+import functools
+import importlib.util
+import io
+import json
+import os
+import socket
+import sys
+import traceback
+
+
+def load_predict(path):
+    spec = importlib.util.spec_from_file_location("predict", path)
+    module = importlib.util.module_from_spec(spec)
+    spec.loader.exec_module(module)
+    # predict.py loads the model inside main(); keep each model in memory
+    if hasattr(module, "keras"):
+        module.keras.models.load_model = functools.lru_cache(maxsize=None)(
+            module.keras.models.load_model)
+    return module
+
+
+# predict.py's exit status for an NPZ it cannot read
+EXIT_BAD_INPUT = 2
+
+
+class InputRejected(RuntimeError):
+    pass
+
+
+def run(module, npz_path):
+    out = io.StringIO()
+    argv = sys.argv
+    sys.argv = [module.__file__, npz_path]
+    try:
+        with contextlib.redirect_stdout(out):
+            module.main()
+    except SystemExit as e:
+        if e.code == EXIT_BAD_INPUT:
+            raise InputRejected("predict.py rejected %s" % npz_path)
+        if e.code not in (None, 0):
+            raise RuntimeError("predict.py exited with status %s" % e.code)
+    finally:
+        sys.argv = argv
+    return out.getvalue()
+
+
+def handle(module, line):
+    try:
+        req = json.loads(line)
+    except ValueError as e:
+        return {"error": "bad request: %s" % e}
+    if req.get("ping"):
+        return {"ok": True}
+    if "npz_paths" in req:
+        return {"outputs": [predict_one(module, p) for p in req["npz_paths"]]}
+    npz_path = req.get("npz_path")
+    if not npz_path:
+        return {"error": "missing npz_path"}
+    return predict_one(module, npz_path)
+
+
+def predict_one(module, npz_path):
+    try:
+        return {"output": run(module, npz_path)}
+    except InputRejected as e:
+        return {"error": str(e), "bad_input": True}
+    except Exception as e:
+        traceback.print_exc(file=sys.stderr)
+        return {"error": str(e)}
+
+
+def main():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--socket")
+    parser.add_argument("--script", required=True, help="path to predict.py")
+    parser.add_argument("--batch", nargs="+", metavar="NPZ", help="predict these files and exit")
+    args = parser.parse_args()
+    if not args.socket and not args.batch:
+        parser.error("one of --socket or --batch is required")
+
+    module = load_predict(args.script)
+    if args.batch:
+        outputs = [predict_one(module, p) for p in args.batch]
+        print(json.dumps(outputs), flush=True)
+        return
+
+    with contextlib.suppress(FileNotFoundError):
+        os.unlink(args.socket)
+    srv = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+    srv.bind(args.socket)
+    srv.listen(1)
+    print("inference server ready on %s" % args.socket, file=sys.stderr, flush=True)
+    while True:
+        conn, _ = srv.accept()
+        with conn, conn.makefile("rwb") as f:
+            for line in f:
+                f.write(json.dumps(handle(module, line)).encode() + b"\n")
+                f.flush()
+
+
+if __name__ == "__main__":
+    main()
@@ -1,3 +1,6 @@
+import functools
+import json
+import struct
 import sys
 import numpy as np
 from tensorflow import keras
@@ -7,31 +10,45 @@ os.environ['TF_CPP_MIN_LOG_LEVEL'] = '3'  # 禁用所有 TensorFlow 日志
 import tensorflow as tf
 tf.get_logger().setLevel('ERROR')  # 只显示错误
 
-def main():
-    if len(sys.argv) < 2:
-        print("Usage: python predict.py <npz_path>")
-        sys.exit(1)
+# 输入文件无法读取或形状不对时的退出码
+EXIT_BAD_INPUT = 2
 
-    npz_path = sys.argv[1]
 
-    # 加载 npz 文件
-    data = np.load(npz_path)
-    # 自动识别 key
-    if "zdisp" in data:
-        zdisp = data["zdisp"]
-    elif "zdisp_norw" in data:
-        zdisp = data["zdisp_norw"]
-    else:
-        zdisp = data[list(data.keys())[0]]
+class InputError(Exception):
+    """The NPZ file cannot be read or does not hold a wave record."""
 
-    # 归一化
-    significant_wave_height = 4 * np.std(zdisp)
-    zdisp_norm = zdisp / significant_wave_height
-    zdisp_norm = zdisp_norm.reshape(1, 1536, 1)
 
+@functools.lru_cache(maxsize=None)
+def load_model():
     file_str = "/root/app/rouge_wave_model/RWs_H_g_2p2_tadv_1min"
     LSTM_save_name = "best_LSTM_" + "RWs_H_g_2p2_tadv_1min" + ".h5"
-    model = keras.models.load_model(file_str + '/' + LSTM_save_name)
+    return keras.models.load_model(file_str + '/' + LSTM_save_name)
+
+
+def load_input(npz_path):
+    try:
+        # 加载 npz 文件
+        data = np.load(npz_path)
+        # 自动识别 key
+        if "zdisp" in data:
+            zdisp = data["zdisp"]
+        elif "zdisp_norw" in data:
+            zdisp = data["zdisp_norw"]
+        else:
+            zdisp = data[list(data.keys())[0]]
+
+        # 归一化
+        significant_wave_height = 4 * np.std(zdisp)
+        zdisp_norm = zdisp / significant_wave_height
+        return zdisp_norm.reshape(1, 1536, 1)
+    except Exception as e:
+        raise InputError("%s: %s" % (type(e).__name__, e)) from e
+
+
+def predict(npz_path):
+    zdisp_norm = load_input(npz_path)
+
+    model = load_model()
 
     pred = model.predict(zdisp_norm, verbose=0)
     prob = softmax(pred, axis=-1)  # 多加一步保险
@@ -41,8 +58,73 @@ def main():
     wave_type_idx = int(np.argmax(prob, axis=-1)[0])
     wave_type_str = "non-rogue wave" if wave_type_idx == 0 else "rogue wave"
 
-    print("norw_prob,rw_prob,wave_type_prediction")
-    print(f"{norw_prob:.6f},{rw_prob:.6f},{wave_type_str}")
+    return ("norw_prob,rw_prob,wave_type_prediction\n"
+            f"{norw_prob:.6f},{rw_prob:.6f},{wave_type_str}\n")
+
+
+def read_frame(f):
+    head = f.read(4)
+    if len(head) < 4:
+        return None
+    (n,) = struct.unpack(">I", head)
+    body = f.read(n)
+    if len(body) < n:
+        return None
+    return body
+
+
+def write_frame(f, obj):
+    body = json.dumps(obj).encode()
+    f.write(struct.pack(">I", len(body)) + body)
+    f.flush()
+
+
+def serve():
+    """Worker mode (--worker) for the satellite's --inference-workers.
+
+    Reads frames from stdin until it closes and answers each on stdout.
+    A frame is a 4-byte big-endian length followed by that many bytes of
+    JSON:
+
+        {"npz_path": "/tmp/mqtt_npz/x.npz"}  ->  {"output": "<csv>"}
+                                                  {"error": "..."}
+
+    "bad_input": true marks errors caused by the file rather than the
+    model.
+
+    stdout only carries frames: everything else written to it, including
+    TensorFlow's own logging, is sent to stderr.
+    """
+    out = os.fdopen(os.dup(1), "wb")
+    os.dup2(2, 1)
+    inp = sys.stdin.buffer
+    while True:
+        body = read_frame(inp)
+        if body is None:
+            return
+        try:
+            resp = {"output": predict(json.loads(body)["npz_path"])}
+        except InputError as e:
+            resp = {"error": str(e), "bad_input": True}
+        except Exception as e:
+            resp = {"error": "%s: %s" % (type(e).__name__, e)}
+        write_frame(out, resp)
+
+
+def main():
+    if len(sys.argv) < 2:
+        print("Usage: python predict.py <npz_path> | --worker")
+        sys.exit(1)
+
+    if sys.argv[1] == "--worker":
+        serve()
+        return
+
+    try:
+        print(predict(sys.argv[1]), end="")
+    except InputError as e:
+        print("input rejected: %s" % e, file=sys.stderr)
+        sys.exit(EXIT_BAD_INPUT)
 
 if __name__ == "__main__":
     main()
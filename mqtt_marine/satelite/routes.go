@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloudletsapps/internal/topicroute"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Topic routing table (--routes); nil predicts everything on SUB_TOPIC
+var routes topicroute.Table
+
+// Where archive routes store raw payloads (--route-archive-dir)
+var routeArchiveDir string
+
+// routeAction returns what to do with msg. Topics no route matches (only
+// possible through the CoAP and HTTP ingress) are dropped.
+func routeAction(msg MQTT.Message) string {
+	if routes == nil {
+		return topicroute.Predict
+	}
+	r, ok := routes.Match(msg.Topic())
+	if !ok {
+		return topicroute.Drop
+	}
+	return r.Action
+}
+
+// archiveMessage stores the raw payload of msg as
+// <dir>/<topic levels>/<timestamp>_<msgID>.bin and returns the path.
+func archiveMessage(msgID int, msg MQTT.Message) (string, error) {
+	levels := strings.Split(msg.Topic(), "/")
+	for i, l := range levels {
+		if l == "" || l == "." || l == ".." || strings.ContainsAny(l, `\`) {
+			levels[i] = "_"
+		}
+	}
+	dir := filepath.Join(append([]string{routeArchiveDir}, levels...)...)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s_%d.bin", time.Now().UTC().Format("20060102T150405.000000000Z"), msgID))
+	if err := os.WriteFile(path, msg.Payload(), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// How long SIGTERM/SIGINT waits for queued messages and result publishes
+// before disconnecting anyway (--shutdown-timeout)
+var shutdownTimeout = 30 * time.Second
+
+// Set once shutdown has begun; the CoAP and HTTP ingress then refuse
+// messages so their clients retry elsewhere or later
+var shuttingDown atomic.Bool
+
+var errShuttingDown = errors.New("shutting down")
+
+// checkShutdown fails /readyz once shutdown has begun.
+func checkShutdown() error {
+	if shuttingDown.Load() {
+		return errShuttingDown
+	}
+	return nil
+}
+
+// workersIdle reports whether no worker is handling a message.
+func workersIdle() bool {
+	idle := true
+	workerBusy.Range(func(_, v any) bool {
+		idle = v.(*atomic.Int64).Load() == 0
+		return idle
+	})
+	return idle
+}
+
+// drained reports whether every received message has been predicted and
+// its result acknowledged by the broker (or stored for the next run).
+func drained() bool {
+	return queueDepth() == 0 && workersIdle() && len(resultInflight) == 0
+}
+
+// shutdown stops taking messages, lets the workers finish the queued ones
+// and the result publishes complete, bounded by shutdownTimeout, then
+// cancels what is still running, reports the satellite offline and
+// disconnects. Messages still spilled to --queue-spill-dir and results in
+// the result outbox stay on disk for the next run.
+func shutdown(cancel context.CancelFunc, subTopics []string) {
+	shuttingDown.Store(true)
+	deadline := time.Now().Add(shutdownTimeout)
+
+	clientMutex.RLock()
+	c := globalClient
+	clientMutex.RUnlock()
+	if c != nil && c.IsConnectionOpen() {
+		// the broker may still deliver what it sent before the UNSUBACK;
+		// the handler queues those as usual
+		if t := c.Unsubscribe(subTopics...); !t.WaitTimeout(5 * time.Second) {
+			slog.Warn("unsubscribe timed out")
+		} else if err := t.Error(); err != nil {
+			slog.Warn("unsubscribe failed", "err", err)
+		}
+	}
+
+	slog.Info("draining", "queued", queueDepth(), "result_publishes", len(resultInflight), "timeout", shutdownTimeout)
+	start := time.Now()
+	for {
+		if drained() {
+			slog.Info("drained", "took", time.Since(start).Round(time.Millisecond))
+			break
+		}
+		if time.Now().After(deadline) {
+			slog.Warn("shutdown timeout reached; canceling pending work",
+				"queued", queueDepth(), "workers_idle", workersIdle(), "result_publishes", len(resultInflight))
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cancel()
+	// canceled predictions and publishes return quickly; give them a
+	// moment to store their results
+	for wait := time.Now().Add(2 * time.Second); time.Now().Before(wait) && !(workersIdle() && len(resultInflight) == 0); {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	clientMutex.RLock()
+	defer clientMutex.RUnlock()
+	if globalClient != nil {
+		// a clean disconnect does not fire the LWT, so report it ourselves
+		if statusTopic != "" {
+			globalClient.Publish(statusTopic, 1, true, statusPayload("offline")).WaitTimeout(2 * time.Second)
+		}
+		globalClient.Disconnect(250)
+	}
+}
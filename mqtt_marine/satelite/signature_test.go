@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"cloudletsapps/internal/signing"
+)
+
+func TestVerifySignaturePolicy(t *testing.T) {
+	defer func(k signing.Keyring, p string) { payloadSigners, signaturePolicy = k, p }(payloadSigners, signaturePolicy)
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadSigners = signing.Keyring{"pub-1": pub}
+	payload := []byte(`{"buoy_id":"b1","filename":"a.npz"}`)
+	signed := (&signing.Signer{KeyID: "pub-1", Key: key}).Sign(payload)
+	altered := append([]byte{}, signed...)
+	altered[len(altered)-1] ^= 1
+	_, stranger, _ := ed25519.GenerateKey(nil)
+	unknown := (&signing.Signer{KeyID: "pub-9", Key: stranger}).Sign(payload)
+
+	tests := []struct {
+		name       string
+		msg        []byte
+		wantErr    error
+		wantSigner string
+	}{
+		{"valid", signed, nil, "pub-1"},
+		{"altered", altered, signing.ErrBadSignature, ""},
+		{"unsigned", payload, signing.ErrUnsigned, ""},
+		{"unknown key", unknown, signing.ErrUnknownKey, ""},
+	}
+	for _, policy := range []string{signing.PolicyReject, signing.PolicyFlag} {
+		signaturePolicy = policy
+		for _, tt := range tests {
+			t.Run(policy+"/"+tt.name, func(t *testing.T) {
+				signer, err := verifySignature("buoys/b1/npz", tt.msg)
+				wantErr := tt.wantErr
+				if policy == signing.PolicyFlag {
+					wantErr = nil
+				}
+				if !errors.Is(err, wantErr) || (err != nil) != (wantErr != nil) {
+					t.Errorf("err = %v, want %v", err, wantErr)
+				}
+				if err != nil && !isSignatureError(err) {
+					t.Errorf("isSignatureError(%v) = false", err)
+				}
+				if signer != tt.wantSigner {
+					t.Errorf("signer = %q, want %q", signer, tt.wantSigner)
+				}
+			})
+		}
+	}
+
+	// whatever the check says, the payload inside is what gets handled
+	for _, msg := range [][]byte{signed, payload} {
+		if got, err := openPayload(msg); err != nil || string(got) != string(payload) {
+			t.Errorf("openPayload = %q, %v", got, err)
+		}
+	}
+}
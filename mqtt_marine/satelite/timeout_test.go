@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPredictTimeout(t *testing.T) {
+	base, perMB, maxMs := predictTimeoutBaseMs, predictTimeoutPerMBMs, predictTimeoutMaxMs
+	t.Cleanup(func() { predictTimeoutBaseMs, predictTimeoutPerMBMs, predictTimeoutMaxMs = base, perMB, maxMs })
+
+	tests := []struct {
+		name  string
+		size  int64
+		maxMs int64
+		want  time.Duration
+	}{
+		{"empty", 0, 120000, 5 * time.Second},
+		{"1 MB", 1e6, 120000, 6 * time.Second},
+		{"10 MB", 10e6, 120000, 15 * time.Second},
+		{"100 MB", 100e6, 120000, 105 * time.Second},
+		{"capped", 200e6, 120000, 120 * time.Second},
+		{"uncapped", 200e6, 0, 205 * time.Second},
+		{"fraction of a MB", 1.5e6, 120000, 6500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			predictTimeoutBaseMs, predictTimeoutPerMBMs, predictTimeoutMaxMs = 5000, 1000, tt.maxMs
+			if got := predictTimeout(tt.size); got != tt.want {
+				t.Errorf("predictTimeout(%d) = %v, want %v", tt.size, got, tt.want)
+			}
+		})
+	}
+}
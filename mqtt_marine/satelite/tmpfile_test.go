@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteTmpNPZ(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		suffix   string
+	}{
+		{"plain", "buoy_001.npz", "_buoy_001.npz"},
+		{"parent escape", "../../etc/x.npz", "_x.npz"},
+		{"absolute", "/etc/passwd", "_passwd"},
+		{"empty", "", "_payload.npz"},
+		{"wildcard", "a*b.npz", "_a_b.npz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a, err := writeTmpNPZ(dir, tt.filename, []byte("first"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := writeTmpNPZ(dir, tt.filename, []byte("second"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a == b {
+				t.Fatalf("two jobs with file name %q share %s", tt.filename, a)
+			}
+			for path, want := range map[string]string{a: "first", b: "second"} {
+				if filepath.Dir(path) != dir {
+					t.Errorf("%s is outside %s", path, dir)
+				}
+				if !strings.HasSuffix(path, tt.suffix) {
+					t.Errorf("%s does not end in %s", path, tt.suffix)
+				}
+				if got, _ := os.ReadFile(path); string(got) != want {
+					t.Errorf("%s holds %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
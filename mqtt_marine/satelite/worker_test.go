@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// panicMessage crashes the worker that picks it up.
+type panicMessage struct{ localMessage }
+
+func (m *panicMessage) Payload() []byte { panic("injected") }
+
+// The restart delay doubles with every crash up to the maximum and starts
+// over once a message has been handled.
+func TestWorkerRestartBackoff(t *testing.T) {
+	defer func(initial, max time.Duration) {
+		workerRestartInitialDelay, workerRestartMaxDelay = initial, max
+	}(workerRestartInitialDelay, workerRestartMaxDelay)
+	workerRestartInitialDelay, workerRestartMaxDelay = 30*time.Millisecond, 120*time.Millisecond
+	resetDedup()
+	defer resetDedup()
+	defer queuedBytes.Store(0)
+
+	queue := make(chan MQTT.Message)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startWorker(ctx, "restart test", queue)
+
+	// each send returns once a worker instance has taken the message
+	var last time.Time
+	send := func(msg MQTT.Message) time.Duration {
+		queue <- msg
+		now := time.Now()
+		gap := now.Sub(last)
+		last = now
+		return gap
+	}
+	crash := &panicMessage{}
+	send(crash)
+	tests := []struct {
+		name string
+		msg  MQTT.Message
+		want time.Duration // restart delay before the message is taken
+	}{
+		{"first restart", crash, 30 * time.Millisecond},
+		{"doubled", crash, 60 * time.Millisecond},
+		{"doubled again", crash, 120 * time.Millisecond},
+		{"capped", crash, 120 * time.Millisecond},
+		{"handled after restart", &localMessage{topic: "t", payload: []byte("junk")}, 120 * time.Millisecond},
+		{"same instance", crash, 0},
+		{"reset", crash, 30 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		gap := send(tt.msg)
+		if gap < tt.want*9/10 || gap > tt.want+50*time.Millisecond {
+			t.Errorf("%s: taken after %v, want about %v", tt.name, gap, tt.want)
+		}
+	}
+}
+
+// blockingMessage holds up the worker that reads its payload until
+// released, like a slow prediction.
+type blockingMessage struct {
+	localMessage
+	release chan struct{}
+}
+
+func (m *blockingMessage) Payload() []byte { <-m.release; return m.payload }
+
+// signalingMessage reports when a worker reads its payload.
+type signalingMessage struct {
+	localMessage
+	once  sync.Once
+	taken chan struct{}
+}
+
+func (m *signalingMessage) Payload() []byte {
+	m.once.Do(func() { close(m.taken) })
+	return m.payload
+}
+
+func TestIsolatedBuoys(t *testing.T) {
+	resetDedup()
+	defer resetDedup()
+	defer queuedBytes.Store(0)
+	defer func() {
+		buoyQueuesMutex.Lock()
+		buoyQueues = make(map[string]chan MQTT.Message)
+		buoyQueuesMutex.Unlock()
+	}()
+
+	tests := []struct {
+		name      string
+		isolate   bool
+		wantDelay bool // whether buoy B waits for buoy A's slow message
+	}{
+		{"isolated", true, false},
+		{"shared worker", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			shared := make(chan MQTT.Message, 8)
+			startWorker(ctx, "shared", shared)
+			queue := func(buoyID string) chan MQTT.Message {
+				if tt.isolate {
+					return buoyQueue(ctx, buoyID)
+				}
+				return shared
+			}
+
+			slow := &blockingMessage{localMessage{topic: "t", payload: []byte("junk")}, make(chan struct{})}
+			afterSlow := &signalingMessage{localMessage: localMessage{topic: "t", payload: []byte("junk")}, taken: make(chan struct{})}
+			other := &signalingMessage{localMessage: localMessage{topic: "t", payload: []byte("junk")}, taken: make(chan struct{})}
+			queue("A-" + tt.name) <- slow
+			queue("A-" + tt.name) <- afterSlow
+			queue("B-" + tt.name) <- other
+
+			select {
+			case <-other.taken:
+				if tt.wantDelay {
+					t.Error("buoy B handled while buoy A's prediction was running")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if !tt.wantDelay {
+					t.Error("buoy B delayed by buoy A's slow prediction")
+				}
+			}
+			select {
+			case <-afterSlow.taken:
+				t.Error("buoy A's next message overtook its slow one")
+			default:
+			}
+
+			close(slow.release)
+			for _, m := range []*signalingMessage{afterSlow, other} {
+				select {
+				case <-m.taken:
+				case <-time.After(5 * time.Second):
+					t.Fatal("message not handled after the slow one finished")
+				}
+			}
+		})
+	}
+}
+
+func TestMessageBuoyID(t *testing.T) {
+	defer func(pattern string) { topicPattern = pattern }(topicPattern)
+	tests := []struct {
+		name    string
+		pattern string
+		topic   string
+		payload []byte
+		want    string
+	}{
+		{"from payload", "", "sensors/x/npz", jsonPayload(t, map[string]any{"buoy_id": "b1"}), "b1"},
+		{"from topic", "sensors/{buoy_id}/npz", "sensors/b2/npz", jsonPayload(t, map[string]any{"buoy_id": "b1"}), "b2"},
+		{"topic mismatch", "sensors/{buoy_id}/npz", "other/b2", jsonPayload(t, map[string]any{"buoy_id": "b1"}), "b1"},
+		{"unparseable", "", "t", []byte("junk"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topicPattern = tt.pattern
+			if got := messageBuoyID(&localMessage{topic: tt.topic, payload: tt.payload}); got != tt.want {
+				t.Errorf("messageBuoyID = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInvalidFloatField(t *testing.T) {
+	header := strings.Split("Buoy-station,send_time,Prediction-LATENCY,Wave-height", ",")
+	fields := []string{"Prediction-LATENCY", "Wave-height"}
+	tests := []struct {
+		name      string
+		row       string
+		wantField string
+	}{
+		{"valid", "b1,1700000000.5,12.5,3", ""},
+		{"exponent and spaces", "b1,1,1e3, -0.25 ", ""},
+		{"NaN", "b1,1,NaN,3", "Prediction-LATENCY"},
+		{"inf", "b1,1,12,inf", "Wave-height"},
+		{"negative inf", "b1,1,-Inf,3", "Prediction-LATENCY"},
+		{"error text", "b1,1,12,error", "Wave-height"},
+		{"empty", "b1,1,,3", "Prediction-LATENCY"},
+		{"short row", "b1,1,12", "Wave-height"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, bad := invalidFloatField(header, strings.Split(tt.row, ","), fields)
+			if bad != (tt.wantField != "") || field != tt.wantField {
+				t.Errorf("invalidFloatField = %q, %v, want %q", field, bad, tt.wantField)
+			}
+		})
+	}
+	if _, bad := invalidFloatField(header, []string{"b1", "x"}, nil); bad {
+		t.Error("row rejected with no fields to validate")
+	}
+}
+
+func TestRejectInvalidRow(t *testing.T) {
+	header := []string{"Buoy-station", "Prediction-LATENCY"}
+	fields := []string{"Prediction-LATENCY"}
+	tests := []struct {
+		name        string
+		value       string
+		invalidFile bool // write rejects to invalid_<station>.csv
+		wantReject  bool
+	}{
+		{"valid", "12.5", true, false},
+		{"NaN dropped", "NaN", false, true},
+		{"NaN to invalid file", "NaN", true, true},
+		{"inf to invalid file", "inf", true, true},
+		{"error to invalid file", "error", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			invalidDir := ""
+			if tt.invalidFile {
+				invalidDir = dir
+			}
+			before := rowsInvalidTotal.Load()
+			rejected := rejectInvalidRow("b1", header, []string{"b1", tt.value}, fields, invalidDir, 0)
+			if rejected != tt.wantReject {
+				t.Fatalf("rejected = %v, want %v", rejected, tt.wantReject)
+			}
+			if got := rowsInvalidTotal.Load() - before; (got == 1) != tt.wantReject {
+				t.Errorf("rows_invalid_total rose by %d", got)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "invalid_b1.csv"))
+			switch {
+			case tt.wantReject && tt.invalidFile:
+				if want := "Buoy-station,Prediction-LATENCY\nb1," + tt.value + "\n"; string(data) != want {
+					t.Errorf("invalid_b1.csv = %q, want %q", data, want)
+				}
+			case !os.IsNotExist(err):
+				t.Errorf("invalid_b1.csv written: %q, %v", data, err)
+			}
+		})
+	}
+}
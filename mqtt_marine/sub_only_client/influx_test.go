@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfluxPoint(t *testing.T) {
+	header := strings.Split("Buoy-station,Node-ID,Model-Version,send_time,Prediction-LATENCY,Wave-height,Prediction", ",")
+	tests := []struct {
+		name string
+		row  string
+		want string
+	}{
+		{"full row", "b1,sat-1,v3,1700000000.5,120,3.25,Rogue",
+			`predictions,model_version=v3,node_id=sat-1,station=b1 Prediction="Rogue",Prediction-LATENCY=120,Wave-height=3.25,send_time=1.7000000005e+09 1700000000123`},
+		{"integers stay floats", "b1,sat-1,v3,1,2,3,4",
+			`predictions,model_version=v3,node_id=sat-1,station=b1 Prediction=4,Prediction-LATENCY=2,Wave-height=3,send_time=1 1700000000123`},
+		{"empty values skipped", "b1,,v3,1, ,3,ok",
+			`predictions,model_version=v3,station=b1 Prediction="ok",Wave-height=3,send_time=1 1700000000123`},
+		{"short row", "b1,sat-1,v3,1",
+			`predictions,model_version=v3,node_id=sat-1,station=b1 send_time=1 1700000000123`},
+		{"escaped", "buoy 1,sat-1,v3,1,2,3,say \"hi\"",
+			`predictions,model_version=v3,node_id=sat-1,station=buoy\ 1 Prediction="say \"hi\"",Prediction-LATENCY=2,Wave-height=3,send_time=1 1700000000123`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := influxPoint("predictions", header, strings.Split(tt.row, ","), time.UnixMilli(1700000000123))
+			if got := p.Line(); got != tt.want {
+				t.Errorf("line %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
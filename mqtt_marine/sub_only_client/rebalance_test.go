@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"cloudletsapps/internal/rebalance"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// subscriptionClient tracks subscriptions and publishes; the rest of
+// MQTT.Client is unused.
+type subscriptionClient struct {
+	MQTT.Client
+	subs      map[string]bool
+	published map[string][][]byte
+}
+
+func newSubscriptionClient() *subscriptionClient {
+	return &subscriptionClient{subs: map[string]bool{}, published: map[string][][]byte{}}
+}
+
+func (c *subscriptionClient) Subscribe(topic string, qos byte, h MQTT.MessageHandler) MQTT.Token {
+	c.subs[topic] = true
+	return nil
+}
+
+func (c *subscriptionClient) Unsubscribe(topics ...string) MQTT.Token {
+	for _, t := range topics {
+		delete(c.subs, t)
+	}
+	return nil
+}
+
+func (c *subscriptionClient) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	c.published[topic] = append(c.published[topic], payload.([]byte))
+	return nil
+}
+
+func (c *subscriptionClient) topics() []string {
+	var out []string
+	for t := range c.subs {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+type assignMessage struct {
+	MQTT.Message
+	payload []byte
+}
+
+func (m assignMessage) Payload() []byte { return m.payload }
+
+// TestRebalanceSubscribers runs three subscribers through the assignments
+// the coordinator computes for six topics, then through the rebalance
+// after one of them leaves.
+func TestRebalanceSubscribers(t *testing.T) {
+	topics := []string{"buoys/1", "buoys/2", "buoys/3", "buoys/4", "buoys/5", "buoys/6"}
+	ids := []string{"sub-a", "sub-b", "sub-c"}
+	subs := map[string]*topicRebalancer{}
+	clients := map[string]*subscriptionClient{}
+	for _, id := range ids {
+		subs[id] = &topicRebalancer{base: "coord", group: rebalance.DefaultGroup, subscriberID: id, topics: topics}
+		clients[id] = newSubscriptionClient()
+		subs[id].onConnect(clients[id])
+		var join rebalance.JoinMsg
+		if sent := clients[id].published[rebalance.JoinTopic("coord")]; len(sent) != 1 || json.Unmarshal(sent[0], &join) != nil ||
+			join.SubscriberID != id || !reflect.DeepEqual(join.Topics, topics) {
+			t.Fatalf("%s join messages %q", id, sent)
+		}
+	}
+
+	deliver := func(members map[string][]string) {
+		for id, assigned := range rebalance.Assign(members) {
+			body, _ := json.Marshal(rebalance.AssignMsg{SubscriberID: id, Topics: assigned})
+			subs[id].handleAssign(clients[id], assignMessage{payload: body})
+		}
+	}
+	data := func(id string) []string {
+		var out []string
+		for _, topic := range clients[id].topics() {
+			if topic != rebalance.RejoinTopic("coord") {
+				out = append(out, topic)
+			}
+		}
+		return out
+	}
+
+	deliver(map[string][]string{"sub-a": topics, "sub-b": topics, "sub-c": topics})
+	want := map[string][]string{
+		"sub-a": {"buoys/1", "buoys/4"},
+		"sub-b": {"buoys/2", "buoys/5"},
+		"sub-c": {"buoys/3", "buoys/6"},
+	}
+	for id, w := range want {
+		if got := data(id); !reflect.DeepEqual(got, w) {
+			t.Errorf("%s subscribed to %v, want %v", id, got, w)
+		}
+	}
+
+	deliver(map[string][]string{"sub-a": topics, "sub-c": topics})
+	var all []string
+	for _, id := range []string{"sub-a", "sub-c"} {
+		if got := data(id); len(got) != 3 {
+			t.Errorf("%s subscribed to %v after sub-b left", id, got)
+		}
+		all = append(all, data(id)...)
+	}
+	sort.Strings(all)
+	if !reflect.DeepEqual(all, topics) {
+		t.Errorf("topics covered after a leave: %v", all)
+	}
+
+	// a reconnect (clean session) restores the assignment
+	fresh := newSubscriptionClient()
+	subs["sub-a"].onConnect(fresh)
+	clients["sub-a"] = fresh
+	if got := data("sub-a"); len(got) != 3 {
+		t.Errorf("after reconnect sub-a subscribed to %v", got)
+	}
+}